@@ -104,3 +104,20 @@ func NotFound(w http.ResponseWriter, message string) {
 		"message": message,
 	})
 }
+
+// Sends an error response with a machine readable error code
+// that clients can act on;
+//
+//	{
+//		"code": <Error code eg. STALE_TIMESTAMP>,
+//		"message": <Your message goes here>
+//	}
+func ErrorWithCode(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"code":    code,
+		"message": message,
+	})
+}
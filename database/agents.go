@@ -0,0 +1,886 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// Time a customer has to confirm a cash-out
+	CASH_OUT_WINDOW time.Duration = 10 * time.Minute
+)
+
+var (
+	ErrAgentExists            = errors.New("user is already registered as an agent")
+	ErrNotEligibleAgent       = errors.New("only users with the 'user' role can be registered as agents")
+	ErrAgentTransactionClosed = errors.New("agent transaction is no longer pending")
+)
+
+type Agent struct {
+	Id           int64  `json:"-"`
+	UserId       int    `json:"user_id"`
+	AgentNumber  string `json:"agent_number"`
+	BusinessName string `json:"business_name"`
+	FloatWallet  string `json:"float_wallet"`
+	Status       string `json:"status"` // One of active or suspended
+	CreatedAt    string `json:"created_at"`
+}
+
+// A commission tier. Cash-ins or cash-outs of amounts between
+// MinAmount and MaxAmount (inclusive) earn the agent Commission
+type AgentCommission struct {
+	Id         int64   `json:"id"`
+	Operation  string  `json:"operation"` // One of cash_in or cash_out
+	MinAmount  float64 `json:"min_amount"`
+	MaxAmount  float64 `json:"max_amount"`
+	Commission float64 `json:"commission"`
+}
+
+type AgentTransaction struct {
+	Id                   int64   `json:"-"`
+	AgentTransactionCode string  `json:"agent_transaction_code"`
+	AgentNumber          string  `json:"agent_number"`
+	BusinessName         string  `json:"business_name"`
+	FloatWallet          string  `json:"float_wallet"`
+	Operation            string  `json:"operation"` // One of cash_in or cash_out
+	CustomerWallet       string  `json:"customer_wallet"`
+	Amount               float64 `json:"amount"`
+
+	// One of pending, completed, declined or expired
+	Status string `json:"status"`
+
+	// Transfer between the customer and the agent's float
+	TransactionCode string  `json:"transaction_code,omitempty"`
+	Commission      float64 `json:"commission"`
+
+	// Empty until the commission is paid into the agent's float
+	CommissionTransactionCode string `json:"commission_transaction_code,omitempty"`
+
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Summary of an agent's activity on a single day
+type AgentStatement struct {
+	AgentNumber string `json:"agent_number"`
+	Day         string `json:"day"`
+
+	// Float wallet balance at the start and end of the day
+	OpeningFloat float64 `json:"opening_float"`
+	ClosingFloat float64 `json:"closing_float"`
+
+	CashInCount      int     `json:"cash_in_count"`
+	CashInAmount     float64 `json:"cash_in_amount"`
+	CashOutCount     int     `json:"cash_out_count"`
+	CashOutAmount    float64 `json:"cash_out_amount"`
+	CommissionEarned float64 `json:"commission_earned"`
+
+	Transactions []*AgentTransaction `json:"transactions"`
+}
+
+func generateAgentNumber() string {
+	return fmt.Sprintf("%06d", 100000+rand.IntN(900000))
+}
+
+// Registers a user as an agent. Creates the agent's float wallet
+// and grants the user the 'agent' role
+func CreateAgent(userId int, businessName string, registeredBy int) (*Agent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var role string
+
+	query := "SELECT role FROM users WHERE id = ? FOR UPDATE"
+	err = tx.QueryRow(query, userId).Scan(&role)
+	if err != nil {
+		return nil, err
+	}
+	if role != "user" {
+		return nil, ErrNotEligibleAgent
+	}
+
+	// Float wallets start empty; agents top them up
+	// with transfers from their own wallets
+	floatWallet := generateWalletAddress(agentFloat)
+
+	query = `
+		INSERT INTO wallets(
+			wallet_address,
+			wallet_name,
+			initial_deposit,
+			total_owners,
+			required_signatures
+		) VALUES(?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, floatWallet, "AGENT FLOAT", 0.0, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	query = "INSERT INTO wallet_owners(wallet_address, user_id) VALUES(?, ?)"
+	_, err = tx.Exec(query, floatWallet, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	agentNumber := generateAgentNumber()
+
+	query = `
+		INSERT INTO agents(user_id, agent_number, business_name, float_wallet)
+		VALUES(?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, userId, agentNumber, businessName, floatWallet)
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return nil, ErrAgentExists
+		}
+		return nil, err
+	}
+
+	_, err = changeUserRole(tx, userId, "agent", registeredBy, "Registered as agent")
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetAgentByNumber(agentNumber)
+}
+
+const agentColumns = `
+	id,
+	user_id,
+	agent_number,
+	business_name,
+	float_wallet,
+	status,
+	created_at
+`
+
+func scanAgent(row interface{ Scan(...any) error }) (*Agent, error) {
+	var a Agent
+
+	err := row.Scan(
+		&a.Id,
+		&a.UserId,
+		&a.AgentNumber,
+		&a.BusinessName,
+		&a.FloatWallet,
+		&a.Status,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Fetches the agent registered to a user.
+// Error returned might be [sql.ErrNoRows]
+func GetAgentByUserId(userId int) (*Agent, error) {
+	query := "SELECT " + agentColumns + " FROM agents WHERE user_id = ?"
+	return scanAgent(db.QueryRow(query, userId))
+}
+
+// Error returned might be [sql.ErrNoRows]
+func GetAgentByNumber(agentNumber string) (*Agent, error) {
+	query := "SELECT " + agentColumns + " FROM agents WHERE agent_number = ?"
+	return scanAgent(db.QueryRow(query, agentNumber))
+}
+
+func GetAgents() ([]*Agent, error) {
+	query := "SELECT " + agentColumns + " FROM agents ORDER BY id DESC"
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agents := []*Agent{}
+
+	for rows.Next() {
+		agent, err := scanAgent(rows)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents, agent)
+	}
+	return agents, rows.Err()
+}
+
+// Suspends or re-activates an agent. Suspended agents cannot
+// make cash-ins or cash-outs
+func SetAgentStatus(agentNumber string, status string) error {
+	query := "UPDATE agents SET status = ? WHERE agent_number = ?"
+	_, err := db.Exec(query, status, agentNumber)
+	return err
+}
+
+func CreateAgentCommission(
+	operation string,
+	minAmount, maxAmount, commission float64,
+	effectiveFrom time.Time,
+	effectiveTo *time.Time, // Nullable
+) error {
+	query := `
+		INSERT INTO agent_commissions(
+			operation,
+			min_amount,
+			max_amount,
+			commission,
+			effective_from,
+			effective_to
+		)
+		VALUES(?, ?, ?, ?, ?, ?)
+	`
+	_, err := db.Exec(
+		query,
+		operation,
+		minAmount,
+		maxAmount,
+		commission,
+		effectiveFrom,
+		effectiveTo,
+	)
+	return err
+}
+
+// Fetches commission tiers currently in effect
+func GetAgentCommissions() ([]AgentCommission, error) {
+	query := `
+		SELECT id, operation, min_amount, max_amount, commission
+		FROM agent_commissions
+		WHERE effective_from <= NOW()
+		AND (effective_to > NOW() OR effective_to IS NULL)
+		ORDER BY operation, min_amount, id
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commissions := []AgentCommission{}
+
+	for rows.Next() {
+		var c AgentCommission
+
+		err = rows.Scan(
+			&c.Id,
+			&c.Operation,
+			&c.MinAmount,
+			&c.MaxAmount,
+			&c.Commission,
+		)
+		if err != nil {
+			return nil, err
+		}
+		commissions = append(commissions, c)
+	}
+	return commissions, rows.Err()
+}
+
+// Fetches the commission earned on an operation. Where tiers
+// overlap, the tier with the lowest min_amount applies.
+// Amounts without a commission tier earn nothing
+func getAgentCommission(operation string, amount float64) (float64, error) {
+	var commission float64
+
+	query := `
+		SELECT commission
+		FROM agent_commissions
+		WHERE operation = ?
+		AND ? BETWEEN min_amount AND max_amount
+		AND effective_from <= NOW()
+		AND (effective_to > NOW() OR effective_to IS NULL)
+		ORDER BY min_amount, id
+		LIMIT 1
+	`
+	err := db.QueryRow(query, operation, amount).Scan(&commission)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return commission, err
+}
+
+const agentTransactionColumns = `
+	t.id,
+	t.agent_transaction_code,
+	a.agent_number,
+	a.business_name,
+	a.float_wallet,
+	t.operation,
+	t.customer_wallet,
+	t.amount,
+	t.status,
+	t.transaction_code,
+	t.commission,
+	t.commission_transaction_code,
+	t.expires_at,
+	t.completed_at,
+	t.created_at
+`
+
+func scanAgentTransaction(row interface{ Scan(...any) error }) (*AgentTransaction, error) {
+	var (
+		t                         AgentTransaction
+		transactionCode           sql.NullString
+		commissionTransactionCode sql.NullString
+		expiresAt                 sql.NullString
+		completedAt               sql.NullString
+	)
+
+	err := row.Scan(
+		&t.Id,
+		&t.AgentTransactionCode,
+		&t.AgentNumber,
+		&t.BusinessName,
+		&t.FloatWallet,
+		&t.Operation,
+		&t.CustomerWallet,
+		&t.Amount,
+		&t.Status,
+		&transactionCode,
+		&t.Commission,
+		&commissionTransactionCode,
+		&expiresAt,
+		&completedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	t.TransactionCode = transactionCode.String
+	t.CommissionTransactionCode = commissionTransactionCode.String
+	t.ExpiresAt = expiresAt.String
+	t.CompletedAt = completedAt.String
+	return &t, nil
+}
+
+// Fetches agent transactions matching where, newest first.
+// A limit of 0 fetches all matching transactions
+func queryAgentTransactions(where string, limit int, args ...any) ([]*AgentTransaction, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM agent_transactions t
+		INNER JOIN agents a ON a.id = t.agent_id
+		WHERE %s
+		ORDER BY t.id DESC
+	`, agentTransactionColumns, where)
+
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transactions := []*AgentTransaction{}
+
+	for rows.Next() {
+		t, err := scanAgentTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	return transactions, rows.Err()
+}
+
+// Error returned might be [sql.ErrNoRows]
+func GetAgentTransaction(agentTransactionCode string) (*AgentTransaction, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM agent_transactions t
+		INNER JOIN agents a ON a.id = t.agent_id
+		WHERE t.agent_transaction_code = ?
+	`, agentTransactionColumns)
+
+	return scanAgentTransaction(db.QueryRow(query, agentTransactionCode))
+}
+
+// Fetches an agent's most recent transactions
+func GetAgentTransactions(agentId int64) ([]*AgentTransaction, error) {
+	return queryAgentTransactions("t.agent_id = ?", DEFAULT_PAGE_SIZE, agentId)
+}
+
+// Fetches cash-outs waiting on the user to confirm them
+func GetPendingCashOuts(userId int) ([]*AgentTransaction, error) {
+	return queryAgentTransactions(`
+		t.operation = 'cash_out'
+		AND t.status = 'pending'
+		AND t.expires_at > NOW()
+		AND t.customer_wallet IN (
+			SELECT wallet_address FROM wallet_owners WHERE user_id = ?
+		)`,
+		0, userId,
+	)
+}
+
+// Inserts a pending agent transaction within db transaction tx.
+// Agent transactions with a zero expiresIn never expire.
+// Returns the generated agent transaction code
+func insertAgentTransaction(
+	tx *sql.Tx,
+	agentId int64,
+	operation string,
+	customerWallet string,
+	amount float64,
+	expiresIn time.Duration,
+) (string, error) {
+	code := generateTransactionCode(agentTransfer)
+	seconds := int(expiresIn.Seconds())
+
+	query := `
+		INSERT INTO agent_transactions(
+			agent_transaction_code,
+			agent_id,
+			operation,
+			customer_wallet,
+			amount,
+			expires_at
+		) VALUES(?, ?, ?, ?, ?, IF(? > 0, NOW() + INTERVAL ? SECOND, NULL))
+	`
+	_, err := tx.Exec(query, code, agentId, operation, customerWallet, amount, seconds, seconds)
+	return code, err
+}
+
+// Completes an agent transaction once its transfer is confirmed,
+// within db transaction tx
+func completeAgentTransaction(tx *sql.Tx, agentTransactionCode string, transactionCode string) error {
+	var status string
+
+	query := "SELECT status FROM transactions WHERE transaction_code = ?"
+	err := tx.QueryRow(query, transactionCode).Scan(&status)
+	if err != nil {
+		return err
+	}
+
+	// Agent and customer wallets need a single signature,
+	// so the transfer is confirmed as soon as it is made
+	if status != "confirmed" {
+		return fmt.Errorf("transfer %v was not confirmed", transactionCode)
+	}
+
+	query = `
+		UPDATE agent_transactions
+		SET status = 'completed', transaction_code = ?
+		WHERE agent_transaction_code = ? AND status = 'pending'
+	`
+	result, err := tx.Exec(query, transactionCode, agentTransactionCode)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAgentTransactionClosed
+	}
+	return nil
+}
+
+// Deposits e-money from the agent's float into a customer's wallet
+// in exchange for cash. The transfer is signed by the agent
+func CashIn(
+	agent *Agent,
+	customerWallet string,
+	amount, fee float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+	payload *SignedPayload,
+) (*AgentTransaction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := insertAgentTransaction(tx, agent.Id, "cash_in", customerWallet, amount, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionCode, err := insertTransaction(
+		tx,
+		agent.UserId,
+		agent.FloatWallet, customerWallet,
+		amount, fee,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = completeAgentTransaction(tx, code, transactionCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetAgentTransaction(code)
+}
+
+// Asks a customer to confirm a withdrawal of cash from an agent.
+// Nothing moves until the customer signs the transfer
+func RequestCashOut(agent *Agent, customerWallet string, amount float64) (*AgentTransaction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	code, err := insertAgentTransaction(tx, agent.Id, "cash_out", customerWallet, amount, CASH_OUT_WINDOW)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetAgentTransaction(code)
+}
+
+// Moves funds from the customer's wallet into the agent's float
+// using the customer's signature.
+// Returns [ErrAgentTransactionClosed] if the cash-out is no longer pending
+func ConfirmCashOut(
+	userId int,
+	agentTransactionCode string,
+	fee float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+	payload *SignedPayload,
+) (*AgentTransaction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		customerWallet string
+		floatWallet    string
+		amount         float64
+		isOpen         bool
+	)
+
+	query := `
+		SELECT
+			t.customer_wallet,
+			a.float_wallet,
+			t.amount,
+			t.operation = 'cash_out' AND t.status = 'pending' AND t.expires_at > NOW()
+		FROM agent_transactions t
+		INNER JOIN agents a ON a.id = t.agent_id
+		WHERE t.agent_transaction_code = ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, agentTransactionCode).Scan(
+		&customerWallet,
+		&floatWallet,
+		&amount,
+		&isOpen,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !isOpen {
+		return nil, ErrAgentTransactionClosed
+	}
+
+	transactionCode, err := insertTransaction(
+		tx,
+		userId,
+		customerWallet, floatWallet,
+		amount, fee,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = completeAgentTransaction(tx, agentTransactionCode, transactionCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetAgentTransaction(agentTransactionCode)
+}
+
+// Declines a pending cash-out.
+// Returns [ErrAgentTransactionClosed] if the cash-out is no longer pending
+func DeclineCashOut(agentTransactionCode string) error {
+	query := `
+		UPDATE agent_transactions
+		SET status = 'declined'
+		WHERE agent_transaction_code = ?
+		AND operation = 'cash_out'
+		AND status = 'pending'
+	`
+	result, err := db.Exec(query, agentTransactionCode)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAgentTransactionClosed
+	}
+	return nil
+}
+
+// Expires cash-outs the customer did not confirm in time.
+// Returns the expired cash-outs
+func ExpireCashOuts() ([]*AgentTransaction, error) {
+	expired, err := queryAgentTransactions(`
+		t.operation = 'cash_out'
+		AND t.status = 'pending'
+		AND t.expires_at <= NOW()
+	`, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	expiredCashOuts := []*AgentTransaction{}
+
+	for _, t := range expired {
+		query := `
+			UPDATE agent_transactions
+			SET status = 'expired'
+			WHERE id = ? AND status = 'pending'
+		`
+		result, err := db.Exec(query, t.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		// Customer may have confirmed the cash-out in the meantime
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected == 0 {
+			continue
+		}
+
+		t.Status = "expired"
+		expiredCashOuts = append(expiredCashOuts, t)
+	}
+	return expiredCashOuts, nil
+}
+
+// Pays the commission earned on a completed agent transaction from
+// the fee revenue wallet into the agent's float.
+// Does nothing if the commission was already paid or none was earned
+func PayAgentCommission(t *AgentTransaction) (*AgentTransaction, error) {
+	if t.Status != "completed" || t.CommissionTransactionCode != "" {
+		return t, nil
+	}
+
+	commissionAmount, err := getAgentCommission(t.Operation, t.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if commissionAmount <= 0 {
+		return t, nil
+	}
+
+	systemUserId, err := getSystemUserId()
+	if err != nil {
+		return nil, err
+	}
+
+	feeWallet, err := GetSystemWallet(FEE_REVENUE_WALLET)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	signature, secretKeyHash, err := signSystemTransfer(feeWallet, t.FloatWallet, commissionAmount, 0, timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	commissionCode := generateTransactionCode(commission)
+
+	query := `
+	INSERT INTO transactions(
+		transaction_code,
+		sender,
+		receiver,
+		amount,
+		fee,
+		timestamp,
+		transaction_type
+	) VALUES(?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(
+		query,
+		commissionCode,
+		feeWallet,
+		t.FloatWallet,
+		commissionAmount,
+		0.0,
+		timestamp,
+		"commission",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionId, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+	INSERT INTO signatures(
+		transaction_id,
+		transaction_code,
+		user_id,
+		signature,
+		public_key_hash
+	) VALUES(?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, transactionId, commissionCode, *systemUserId, signature, secretKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		UPDATE agent_transactions
+		SET commission = ?, commission_transaction_code = ?
+		WHERE id = ? AND commission_transaction_code IS NULL
+	`
+	result, err = tx.Exec(query, commissionAmount, commissionCode, t.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		// Paid concurrently; roll back this payment
+		return GetAgentTransaction(t.AgentTransactionCode)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetAgentTransaction(t.AgentTransactionCode)
+}
+
+// Sums ledger postings on a wallet made before a point in time
+func getWalletBalanceAt(walletAddress string, at time.Time) (float64, error) {
+	var balance float64
+
+	query := `
+		SELECT COALESCE(SUM(IF(entry_type = 'credit', amount, -amount)), 0)
+		FROM ledger_entries
+		WHERE wallet_address = ? AND created_at < ?
+	`
+	err := db.QueryRow(query, walletAddress, at).Scan(&balance)
+	return balance, err
+}
+
+// Builds an agent's statement for the day starting at dayStart
+func GetAgentStatement(agent *Agent, dayStart time.Time) (*AgentStatement, error) {
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	transactions, err := queryAgentTransactions(
+		"t.agent_id = ? AND t.created_at >= ? AND t.created_at < ?",
+		0, agent.Id, dayStart, dayEnd,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := AgentStatement{
+		AgentNumber:  agent.AgentNumber,
+		Day:          dayStart.Format(time.DateOnly),
+		Transactions: transactions,
+	}
+
+	for _, t := range transactions {
+		if t.Status != "completed" {
+			continue
+		}
+
+		switch t.Operation {
+		case "cash_in":
+			statement.CashInCount++
+			statement.CashInAmount += t.Amount
+		case "cash_out":
+			statement.CashOutCount++
+			statement.CashOutAmount += t.Amount
+		}
+		statement.CommissionEarned += t.Commission
+	}
+
+	statement.OpeningFloat, err = getWalletBalanceAt(agent.FloatWallet, dayStart)
+	if err != nil {
+		return nil, err
+	}
+
+	statement.ClosingFloat, err = getWalletBalanceAt(agent.FloatWallet, dayEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statement, nil
+}
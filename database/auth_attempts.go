@@ -0,0 +1,207 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Auth actions whose failures are counted
+const (
+	AUTH_ACTION_LOGIN          string = "login"
+	AUTH_ACTION_PASSWORD_RESET string = "password_reset"
+
+	// Wrong transaction PINs entered by a logged in user
+	AUTH_ACTION_TRANSACTION_PIN string = "transaction_pin"
+)
+
+// What failed attempts are counted against
+const (
+	AUTH_SCOPE_ACCOUNT string = "account"
+	AUTH_SCOPE_IP      string = "ip"
+)
+
+// How failed attempts are punished
+type AttemptPolicy struct {
+	// Failures allowed before backoff starts
+	FreeAttempts int
+
+	// Wait after the first failure past the free ones.
+	// Doubles with each further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Failures after which the subject is locked out until unlocked.
+	// Zero disables lockout
+	LockoutAfter int
+
+	// Failures older than this are forgotten
+	Window time.Duration
+}
+
+// Returns how long to block a subject after its nth failure
+func (p AttemptPolicy) Backoff(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for range failures - p.FreeAttempts - 1 {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+type AuthAttempts struct {
+	Failures int
+
+	// Time left until the next attempt is allowed
+	RetryAfter time.Duration
+	LockedOut  bool
+}
+
+// Fetches the failed attempts counted against subject.
+// Subjects without failures return zero AuthAttempts
+func GetAuthAttempts(action, scope, subject string) (*AuthAttempts, error) {
+	query := `
+		SELECT
+			failures,
+			GREATEST(TIMESTAMPDIFF(SECOND, NOW(), COALESCE(locked_until, NOW())), 0),
+			locked_out_at IS NOT NULL
+		FROM auth_attempts
+		WHERE action= ? AND scope= ? AND subject= ?
+	`
+
+	var (
+		attempts   AuthAttempts
+		retryAfter int
+	)
+
+	err := db.QueryRow(query, action, scope, subject).Scan(
+		&attempts.Failures,
+		&retryAfter,
+		&attempts.LockedOut,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &attempts, nil
+		}
+		return nil, err
+	}
+
+	attempts.RetryAfter = time.Duration(retryAfter) * time.Second
+	return &attempts, nil
+}
+
+// Counts a failed attempt against subject and blocks it according
+// to policy. Returns the updated attempts and whether this failure
+// locked the subject out
+func RecordAuthFailure(action, scope, subject string, policy AttemptPolicy) (*AuthAttempts, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var (
+		failures     int
+		isRecent     bool
+		wasLockedOut bool
+	)
+
+	query := `
+		SELECT
+			failures,
+			last_failure_at > NOW() - INTERVAL ? SECOND,
+			locked_out_at IS NOT NULL
+		FROM auth_attempts
+		WHERE action= ? AND scope= ? AND subject= ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, int(policy.Window.Seconds()), action, scope, subject).Scan(
+		&failures,
+		&isRecent,
+		&wasLockedOut,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	if !isRecent && !wasLockedOut {
+		failures = 0
+	}
+	failures++
+
+	delay := policy.Backoff(failures)
+	lockedOut := wasLockedOut || (policy.LockoutAfter > 0 && failures >= policy.LockoutAfter)
+
+	query = `
+		INSERT INTO auth_attempts(
+			action,
+			scope,
+			subject,
+			failures,
+			last_failure_at,
+			locked_until,
+			locked_out_at
+		) VALUES(?, ?, ?, ?, NOW(), NOW() + INTERVAL ? SECOND, IF(?, NOW(), NULL))
+		ON DUPLICATE KEY UPDATE
+			failures= VALUES(failures),
+			last_failure_at= VALUES(last_failure_at),
+			locked_until= VALUES(locked_until),
+			locked_out_at= COALESCE(locked_out_at, VALUES(locked_out_at))
+	`
+	_, err = tx.Exec(
+		query,
+		action,
+		scope,
+		truncate(subject, 255),
+		failures,
+		int(delay.Seconds()),
+		lockedOut,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	attempts := AuthAttempts{
+		Failures:   failures,
+		RetryAfter: delay,
+		LockedOut:  lockedOut,
+	}
+	return &attempts, lockedOut && !wasLockedOut, nil
+}
+
+// Forgets failed attempts against subject, including any lockout.
+// Returns whether there were any
+func ClearAuthFailures(action, scope, subject string) (bool, error) {
+	query := "DELETE FROM auth_attempts WHERE action= ? AND scope= ? AND subject= ?"
+	result, err := db.Exec(query, action, scope, subject)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// Removes failed attempts older than maxAge that did not
+// lock their subject out
+func DeleteStaleAuthAttempts(maxAge time.Duration) (int64, error) {
+	query := `
+		DELETE FROM auth_attempts
+		WHERE locked_out_at IS NULL AND last_failure_at <= NOW() - INTERVAL ? SECOND
+	`
+	result, err := db.Exec(query, int(maxAge.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
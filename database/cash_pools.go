@@ -1,9 +1,6 @@
 package database
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
-	"fmt"
 	"log"
 	"sync"
 	"time"
@@ -151,6 +148,28 @@ func GetExpiredCashPools() ([]string, error) {
 	return cashPools, nil
 }
 
+// Returns wallet addresses that have paid into the cash pool
+func GetCashPoolContributors(cashPoolAddress string) ([]string, error) {
+	query := "SELECT DISTINCT sender FROM transactions WHERE receiver= ?"
+	rows, err := db.Query(query, cashPoolAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contributors := []string{}
+	var contributor string
+
+	for rows.Next() {
+		err = rows.Scan(&contributor)
+		if err != nil {
+			return nil, err
+		}
+		contributors = append(contributors, contributor)
+	}
+	return contributors, rows.Err()
+}
+
 type transaction struct {
 	transactionCode string
 	sender          string
@@ -190,10 +209,7 @@ func refundTransaction(wg *sync.WaitGroup, t transaction, failedRefundsChan chan
 		timestamp             string  = time.Now().Format(time.RFC3339)
 	)
 
-	// Sign payload
-	payload := fmt.Sprintf("%s|%s|%.2f|%.2f|%s", sender, receiver, amount, fee, timestamp)
-	payloadHash := sha256.Sum256([]byte(payload))
-	hmacSignature, secretKeyHash, err := signPayload(payloadHash[:])
+	signature, secretKeyHash, err := signSystemTransfer(sender, receiver, amount, fee, timestamp)
 	if err != nil {
 		failedRefundsChan <- failedRefund{
 			transaction: t,
@@ -210,8 +226,8 @@ func refundTransaction(wg *sync.WaitGroup, t transaction, failedRefundsChan chan
 		t.amount,
 		fee,
 		timestamp,
-		base64.StdEncoding.EncodeToString(hmacSignature),
-		base64.StdEncoding.EncodeToString(secretKeyHash),
+		signature,
+		secretKeyHash,
 	)
 	if err != nil {
 		failedRefundsChan <- failedRefund{
@@ -0,0 +1,383 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDisputeClosed = errors.New("dispute is no longer open")
+)
+
+type DisputeEvidence struct {
+	UserId    int    `json:"user_id"`
+	Note      string `json:"note"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Status change recorded in a dispute's audit trail
+type DisputeEvent struct {
+	UserId     int    `json:"user_id"`
+	FromStatus string `json:"from_status,omitempty"` // Empty when the dispute was opened
+	ToStatus   string `json:"to_status"`
+	Note       string `json:"note,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type Dispute struct {
+	Id              int64   `json:"-"`
+	DisputeCode     string  `json:"dispute_code"`
+	TransactionCode string  `json:"transaction_code"`
+	Sender          string  `json:"sender"`
+	Receiver        string  `json:"receiver"`
+	Amount          float64 `json:"amount"`
+	OpenedBy        int     `json:"opened_by"`
+	Reason          string  `json:"reason"`
+
+	// One of open, escalated, reversed, rejected or cancelled
+	Status string `json:"status"`
+
+	// Transaction code of the refund made when dispute was reversed
+	RefundTransactionCode string `json:"refund_transaction_code,omitempty"`
+	ResolutionNote        string `json:"resolution_note,omitempty"`
+
+	// Only fetched with a single dispute
+	Evidence []*DisputeEvidence `json:"evidence,omitempty"`
+	Events   []*DisputeEvent    `json:"events,omitempty"`
+
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Opens a dispute on a confirmed transfer made from one of the user's wallets
+func OpenDispute(userId int, transactionCode string, reason string) (*Dispute, error) {
+	disputeCode := generateTransactionCode(dispute)
+
+	query := `
+	INSERT INTO disputes(
+		dispute_code,
+		transaction_code,
+		opened_by,
+		reason
+	) VALUES(?, ?, ?, ?)`
+	_, err := db.Exec(
+		query,
+		disputeCode,
+		transactionCode,
+		userId,
+		reason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetDispute(disputeCode)
+}
+
+const disputeColumns = `
+	d.id,
+	d.dispute_code,
+	d.transaction_code,
+	d.sender,
+	d.receiver,
+	d.amount,
+	d.opened_by,
+	d.reason,
+	d.status,
+	COALESCE(d.refund_transaction_code, ''),
+	COALESCE(d.resolution_note, ''),
+	d.created_at,
+	d.updated_at
+`
+
+func scanDispute(row interface{ Scan(...any) error }) (*Dispute, error) {
+	var d Dispute
+
+	err := row.Scan(
+		&d.Id,
+		&d.DisputeCode,
+		&d.TransactionCode,
+		&d.Sender,
+		&d.Receiver,
+		&d.Amount,
+		&d.OpenedBy,
+		&d.Reason,
+		&d.Status,
+		&d.RefundTransactionCode,
+		&d.ResolutionNote,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Fetches a dispute along with its evidence and audit trail
+func GetDispute(disputeCode string) (*Dispute, error) {
+	query := "SELECT " + disputeColumns + " FROM disputes d WHERE d.dispute_code= ?"
+	d, err := scanDispute(db.QueryRow(query, disputeCode))
+	if err != nil {
+		return nil, err
+	}
+
+	d.Evidence, err = getDisputeEvidence(d.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Events, err = getDisputeEvents(d.Id)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func getDisputeEvidence(disputeId int64) ([]*DisputeEvidence, error) {
+	query := `
+		SELECT user_id, note, created_at
+		FROM dispute_evidence
+		WHERE dispute_id= ?
+		ORDER BY id
+	`
+	rows, err := db.Query(query, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	evidence := []*DisputeEvidence{}
+
+	for rows.Next() {
+		var e DisputeEvidence
+
+		if err := rows.Scan(&e.UserId, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, &e)
+	}
+	return evidence, rows.Err()
+}
+
+func getDisputeEvents(disputeId int64) ([]*DisputeEvent, error) {
+	query := `
+		SELECT
+			user_id,
+			COALESCE(from_status, ''),
+			to_status,
+			COALESCE(note, ''),
+			created_at
+		FROM dispute_events
+		WHERE dispute_id= ?
+		ORDER BY id
+	`
+	rows, err := db.Query(query, disputeId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*DisputeEvent{}
+
+	for rows.Next() {
+		var e DisputeEvent
+
+		err := rows.Scan(&e.UserId, &e.FromStatus, &e.ToStatus, &e.Note, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+func getDisputesWhere(condition string, args ...any) ([]*Dispute, error) {
+	query := "SELECT " + disputeColumns + " FROM disputes d WHERE " + condition +
+		" ORDER BY d.created_at DESC"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disputes := []*Dispute{}
+
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, d)
+	}
+	return disputes, rows.Err()
+}
+
+// Fetches disputes on transfers sent or received by the user's wallets
+func GetUserDisputes(userId int) ([]*Dispute, error) {
+	return getDisputesWhere(`
+		d.sender IN (SELECT wallet_address FROM wallet_owners WHERE user_id= ?)
+		OR d.receiver IN (SELECT wallet_address FROM wallet_owners WHERE user_id= ?)
+	`, userId, userId)
+}
+
+// Fetches disputes with the given status. Empty status fetches all disputes
+func GetDisputesByStatus(status string) ([]*Dispute, error) {
+	if status == "" {
+		return getDisputesWhere("TRUE")
+	}
+	return getDisputesWhere("d.status= ?", status)
+}
+
+func AddDisputeEvidence(disputeId int64, userId int, note string) error {
+	query := "INSERT INTO dispute_evidence(dispute_id, user_id, note) VALUES(?, ?, ?)"
+	_, err := db.Exec(query, disputeId, userId, note)
+	return err
+}
+
+// Moves a dispute to status to if its current status is one of from
+func updateDisputeStatus(disputeCode string, to string, userId int, note string, from ...string) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(from)), ", ")
+
+	query := fmt.Sprintf(`
+		UPDATE disputes
+		SET status= ?, updated_by= ?, resolution_note= NULLIF(?, '')
+		WHERE dispute_code= ?
+		AND status IN (%s)
+	`, placeholders)
+
+	args := []any{to, userId, note, disputeCode}
+	for _, status := range from {
+		args = append(args, status)
+	}
+
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDisputeClosed
+	}
+	return nil
+}
+
+// Declined by the receiver; the dispute waits on an admin's decision.
+// Returns [ErrDisputeClosed] if dispute is not open
+func EscalateDispute(disputeCode string, userId int, note string) error {
+	return updateDisputeStatus(disputeCode, "escalated", userId, note, "open")
+}
+
+// Withdrawn by the sender.
+// Returns [ErrDisputeClosed] if dispute has already been resolved
+func CancelDispute(disputeCode string, userId int, note string) error {
+	return updateDisputeStatus(disputeCode, "cancelled", userId, note, "open", "escalated")
+}
+
+// Closed by an admin without reversing the transfer.
+// Returns [ErrDisputeClosed] if dispute has already been resolved
+func RejectDispute(disputeCode string, userId int, note string) error {
+	return updateDisputeStatus(disputeCode, "rejected", userId, note, "open", "escalated")
+}
+
+// Reverses a disputed transfer by refunding its amount from the receiver
+// back to the sender. Transfer fees are not refunded.
+// The refund is signed by the system user, and is created and the dispute
+// resolved within the same db transaction, so a dispute can never be
+// reversed twice.
+// Returns [ErrDisputeClosed] if dispute has already been resolved
+func ReverseDispute(disputeCode string, userId int, note string) (*Transaction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		transactionCode string
+		sender          string
+		receiver        string
+		amount          float64
+	)
+
+	query := `
+		SELECT transaction_code, sender, receiver, amount
+		FROM disputes
+		WHERE dispute_code= ?
+		AND status IN ('open', 'escalated')
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, disputeCode).Scan(
+		&transactionCode,
+		&sender,
+		&receiver,
+		&amount,
+	)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDisputeClosed
+		}
+		return nil, err
+	}
+
+	systemUserId, err := getSystemUserId()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Flip sender and receiver to reverse funds
+	var (
+		fee       float64 = 0.0
+		timestamp string  = time.Now().Format(time.RFC3339)
+	)
+
+	signature, secretKeyHash, err := signSystemTransfer(receiver, sender, amount, fee, timestamp)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	refundCode, err := insertRefundTransaction(
+		tx,
+		*systemUserId,
+		transactionCode,
+		receiver, sender,
+		amount, fee,
+		timestamp,
+		signature,
+		secretKeyHash,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query = `
+		UPDATE disputes
+		SET
+			status= 'reversed',
+			refund_transaction_code= ?,
+			updated_by= ?,
+			resolution_note= NULLIF(?, '')
+		WHERE dispute_code= ?
+	`
+	_, err = tx.Exec(query, refundCode, userId, note, disputeCode)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetTransaction(refundCode)
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+type IdempotencyKey struct {
+	UserId         int
+	Key            string
+	RequestHash    string
+	Status         string // processing or completed
+	ResponseStatus int
+	ResponseBody   []byte
+	ExpiresAt      time.Time
+}
+
+// Reserves an idempotency key for a request.
+// Returns reserved=true if the key was free and is now held by the caller.
+// Otherwise returns the key's existing record, which may still be processing
+func ReserveIdempotencyKey(
+	userId int,
+	key string,
+	requestHash string,
+	expiresAt time.Time,
+) (*IdempotencyKey, bool, error) {
+	// Expired keys can be reused
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id= ? AND idempotency_key= ? AND expires_at <= NOW()
+	`
+	_, err := db.Exec(query, userId, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	query = `
+		INSERT IGNORE INTO idempotency_keys(
+			user_id,
+			idempotency_key,
+			request_hash,
+			expires_at
+		) VALUES(?, ?, ?, ?)
+	`
+	result, err := db.Exec(query, userId, key, requestHash, expiresAt.UTC())
+	if err != nil {
+		return nil, false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if affected == 1 {
+		return &IdempotencyKey{
+			UserId:      userId,
+			Key:         key,
+			RequestHash: requestHash,
+			Status:      "processing",
+			ExpiresAt:   expiresAt,
+		}, true, nil
+	}
+
+	existing, err := GetIdempotencyKey(userId, key)
+	return existing, false, err
+}
+
+func GetIdempotencyKey(userId int, key string) (*IdempotencyKey, error) {
+	k := IdempotencyKey{
+		UserId: userId,
+		Key:    key,
+	}
+
+	var (
+		responseStatus sql.NullInt64
+		responseBody   sql.NullString
+	)
+
+	query := `
+		SELECT request_hash, status, response_status, response_body, expires_at
+		FROM idempotency_keys
+		WHERE user_id= ? AND idempotency_key= ?
+	`
+	err := db.QueryRow(query, userId, key).Scan(
+		&k.RequestHash,
+		&k.Status,
+		&responseStatus,
+		&responseBody,
+		&k.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	k.ResponseStatus = int(responseStatus.Int64)
+	k.ResponseBody = []byte(responseBody.String)
+	return &k, nil
+}
+
+// Stores the response sent for a reserved idempotency key
+func CompleteIdempotencyKey(userId int, key string, responseStatus int, responseBody []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status= 'completed', response_status= ?, response_body= ?
+		WHERE user_id= ? AND idempotency_key= ?
+	`
+	_, err := db.Exec(query, responseStatus, string(responseBody), userId, key)
+	return err
+}
+
+// Frees a reserved idempotency key so that the request can be retried
+func ReleaseIdempotencyKey(userId int, key string) error {
+	query := "DELETE FROM idempotency_keys WHERE user_id= ? AND idempotency_key= ?"
+	_, err := db.Exec(query, userId, key)
+	return err
+}
+
+func DeleteExpiredIdempotencyKeys() (int64, error) {
+	result, err := db.Exec("DELETE FROM idempotency_keys WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
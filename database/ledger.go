@@ -0,0 +1,277 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A single debit or credit posting against a wallet.
+// Entries are written by the postLedgerEntry routine and are never
+// updated or deleted
+type LedgerEntry struct {
+	Id              int64   `json:"id"`
+	TransactionCode string  `json:"transaction_code"` // Empty for opening balances
+	WalletAddress   string  `json:"wallet_address"`
+	EntryType       string  `json:"entry_type"` // debit or credit
+	Amount          float64 `json:"amount"`
+
+	// One of opening_balance, transfer, refund, fee, commission,
+	// deposit or withdrawal
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// Fetches a wallet's most recent ledger entries, newest first
+func GetLedgerEntries(walletAddress string, limit int) ([]*LedgerEntry, error) {
+	if limit <= 0 {
+		limit = DEFAULT_PAGE_SIZE
+	}
+	if limit > MAX_PAGE_SIZE {
+		limit = MAX_PAGE_SIZE
+	}
+
+	query := `
+		SELECT
+			id,
+			COALESCE(transaction_code, ''),
+			wallet_address,
+			entry_type,
+			amount,
+			description,
+			created_at
+		FROM ledger_entries
+		WHERE wallet_address= ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, walletAddress, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*LedgerEntry{}
+
+	for rows.Next() {
+		var entry LedgerEntry
+
+		err := rows.Scan(
+			&entry.Id,
+			&entry.TransactionCode,
+			&entry.WalletAddress,
+			&entry.EntryType,
+			&entry.Amount,
+			&entry.Description,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Wallet whose materialised balance differs from the sum of its postings
+type BalanceMismatch struct {
+	WalletAddress string  `json:"wallet_address"`
+	Balance       float64 `json:"balance"`
+	LedgerBalance float64 `json:"ledger_balance"`
+}
+
+// Transaction whose debits and credits do not add up
+type UnbalancedTransaction struct {
+	TransactionCode string  `json:"transaction_code"`
+	Debits          float64 `json:"debits"`
+	Credits         float64 `json:"credits"`
+}
+
+// Wallet whose reserved funds differ from the total of its
+// pending transactions
+type ReservedMismatch struct {
+	WalletAddress string  `json:"wallet_address"`
+	Reserved      float64 `json:"reserved"`
+	Pending       float64 `json:"pending"`
+}
+
+type ReconciliationReport struct {
+	WalletsChecked         int                      `json:"wallets_checked"`
+	Mismatches             []*BalanceMismatch       `json:"mismatches"`
+	ReservedMismatches     []*ReservedMismatch      `json:"reserved_mismatches"`
+	UnbalancedTransactions []*UnbalancedTransaction `json:"unbalanced_transactions"`
+
+	// Confirmed transactions that were never posted to the ledger
+	UnpostedTransactions []string `json:"unposted_transactions"`
+
+	// Totals of all postings, opening balances included
+	TotalDebits  float64   `json:"total_debits"`
+	TotalCredits float64   `json:"total_credits"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// Ledger is consistent if debits equal credits and there are
+// no mismatches, unbalanced or unposted transactions
+func (report ReconciliationReport) Ok() bool {
+	return report.TotalDebits == report.TotalCredits &&
+		len(report.Mismatches) == 0 &&
+		len(report.ReservedMismatches) == 0 &&
+		len(report.UnbalancedTransactions) == 0 &&
+		len(report.UnpostedTransactions) == 0
+}
+
+// Proves that materialised wallet balances equal the sum of their
+// ledger postings, that every confirmed transaction is posted
+// as balanced debits and credits and that the ledger as a whole
+// balances.
+// All checks are made against the same consistent snapshot of the database
+func ReconcileLedger() (*ReconciliationReport, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := ReconciliationReport{
+		Mismatches:             []*BalanceMismatch{},
+		ReservedMismatches:     []*ReservedMismatch{},
+		UnbalancedTransactions: []*UnbalancedTransaction{},
+		UnpostedTransactions:   []string{},
+		CheckedAt:              time.Now().UTC(),
+	}
+
+	err = tx.QueryRow("SELECT COUNT(*) FROM wallet_balances").Scan(&report.WalletsChecked)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT
+			COALESCE(SUM(IF(entry_type = 'debit', amount, 0)), 0),
+			COALESCE(SUM(IF(entry_type = 'credit', amount, 0)), 0)
+		FROM ledger_entries
+	`
+	err = tx.QueryRow(query).Scan(&report.TotalDebits, &report.TotalCredits)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT wb.wallet_address, wb.balance, COALESCE(le.ledger_balance, 0)
+		FROM wallet_balances wb
+		LEFT JOIN (
+			SELECT
+				wallet_address,
+				SUM(IF(entry_type = 'credit', amount, -amount)) AS ledger_balance
+			FROM ledger_entries
+			GROUP BY wallet_address
+		) le ON le.wallet_address = wb.wallet_address
+		WHERE wb.balance <> COALESCE(le.ledger_balance, 0)
+	`
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var mismatch BalanceMismatch
+
+		err := rows.Scan(&mismatch.WalletAddress, &mismatch.Balance, &mismatch.LedgerBalance)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.Mismatches = append(report.Mismatches, &mismatch)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT wb.wallet_address, wb.reserved, COALESCE(p.pending, 0)
+		FROM wallet_balances wb
+		LEFT JOIN (
+			SELECT sender, SUM(amount + fee) AS pending
+			FROM transactions
+			WHERE status = 'pending'
+			GROUP BY sender
+		) p ON p.sender = wb.wallet_address
+		WHERE wb.reserved <> COALESCE(p.pending, 0)
+	`
+	rows, err = tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var mismatch ReservedMismatch
+
+		err := rows.Scan(&mismatch.WalletAddress, &mismatch.Reserved, &mismatch.Pending)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.ReservedMismatches = append(report.ReservedMismatches, &mismatch)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT
+			transaction_code,
+			SUM(IF(entry_type = 'debit', amount, 0)) AS debits,
+			SUM(IF(entry_type = 'credit', amount, 0)) AS credits
+		FROM ledger_entries
+		WHERE transaction_id IS NOT NULL
+		GROUP BY transaction_code
+		HAVING debits <> credits
+	`
+	rows, err = tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var unbalanced UnbalancedTransaction
+
+		err := rows.Scan(&unbalanced.TransactionCode, &unbalanced.Debits, &unbalanced.Credits)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		report.UnbalancedTransactions = append(report.UnbalancedTransactions, &unbalanced)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT t.transaction_code
+		FROM transactions t
+		WHERE t.status = 'confirmed'
+		AND NOT EXISTS (
+			SELECT 1 FROM ledger_entries le WHERE le.transaction_id = t.id
+		)
+	`
+	rows, err = tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionCode string
+
+		if err := rows.Scan(&transactionCode); err != nil {
+			return nil, err
+		}
+		report.UnpostedTransactions = append(report.UnpostedTransactions, transactionCode)
+	}
+
+	return &report, rows.Err()
+}
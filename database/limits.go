@@ -2,80 +2,190 @@ package database
 
 import (
 	"database/sql"
-	"errors"
-	"fmt"
 	"log"
+	"math"
+	"time"
 )
 
-// Gets total amount spent on a wallet for the past week, month and year.
-func getTotalAmountSpent(walletAddress string, period string) (float64, error) {
-	query := "CALL getTotalAmountSpent(?)"
+type Limit struct {
+	WalletAddress string  `json:"wallet_address"`
+	Period        string  `json:"period"`
+	Amount        float64 `json:"amount"`
+	Window        string  `json:"window"`   // rolling or calendar
+	Timezone      string  `json:"timezone"` // Used to align calendar windows
+
+	// Amount sent from the wallet within the period, and how much more
+	// can be sent before the limit is reached. Limits on the 'transaction'
+	// period apply to each transfer, so nothing counts towards them
+	Spent     float64 `json:"spent"`
+	Remaining float64 `json:"remaining"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// Returns when the limit's current window started.
+// Limits on the 'transaction' period have no window
+func (limit Limit) windowStart(now time.Time) (time.Time, bool) {
+	if limit.Window == "calendar" {
+		loc, err := time.LoadLocation(limit.Timezone)
+		if err != nil {
+			log.Printf("Invalid timezone '%v' on spending limit; %v\n", limit.Timezone, err)
+			loc = time.UTC
+		}
+
+		now = now.In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+		switch limit.Period {
+		case "day":
+			return today, true
+		case "week":
+			// Weeks start on Monday
+			daysSinceMonday := (int(now.Weekday()) + 6) % 7
+			return today.AddDate(0, 0, -daysSinceMonday), true
+		case "month":
+			return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc), true
+		case "year":
+			return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, loc), true
+		}
+		return time.Time{}, false
+	}
+
+	switch limit.Period {
+	case "day":
+		return now.AddDate(0, 0, -1), true
+	case "week":
+		return now.AddDate(0, 0, -7), true
+	case "month":
+		return now.AddDate(0, -1, 0), true
+	case "year":
+		return now.AddDate(-1, 0, 0), true
+	}
+	return time.Time{}, false
+}
+
+// Gets total amount spent on a wallet since a window started.
+// Only confirmed and pending transfers count, fees included
+func getTotalAmountSpent(walletAddress string, since time.Time) (float64, error) {
+	// Window is passed as an age so it is measured against
+	// the database's clock
+	seconds := int64(math.Ceil(time.Since(since).Seconds()))
+
+	var amount float64
+
+	query := "CALL getTotalAmountSpent(?, ?)"
+	err := db.QueryRow(query, walletAddress, seconds).Scan(&amount)
+	return amount, err
+}
+
+// Fetches all spending limits set on a wallet along with
+// the allowance remaining on each
+func GetLimits(walletAddress string) ([]Limit, error) {
+	query := `
+		SELECT wallet_address, period, amount, window_type, timezone, created_at
+		FROM limits
+		WHERE wallet_address = ?
+		ORDER BY FIELD(period, 'transaction', 'day', 'week', 'month', 'year')
+	`
 	rows, err := db.Query(query, walletAddress)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	var (
-		dbPeriod string
-		amount   float64
-	)
+	limits := []Limit{}
 
 	for rows.Next() {
+		var limit Limit
+
 		err = rows.Scan(
-			&dbPeriod,
-			&amount,
+			&limit.WalletAddress,
+			&limit.Period,
+			&limit.Amount,
+			&limit.Window,
+			&limit.Timezone,
+			&limit.CreatedAt,
 		)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
+		limits = append(limits, limit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	for i := range limits {
+		limit := &limits[i]
 
-		if dbPeriod == period {
-			return amount, nil
+		since, ok := limit.windowStart(now)
+		if ok {
+			limit.Spent, err = getTotalAmountSpent(walletAddress, since)
+			if err != nil {
+				return nil, err
+			}
 		}
+		limit.Remaining = math.Max(0, limit.Amount-limit.Spent)
 	}
-	return 0, fmt.Errorf("period %v not found", period)
+	return limits, nil
 }
 
+// Checks a new transfer against every spending limit set on the wallet.
+// newAmount should include the transfer's fee
 func IsWithinSpendingLimits(walletAddress string, newAmount float64) bool {
-	var (
-		period      string
-		limitAmount float64
-	)
-
-	query := "SELECT period, amount FROM limits WHERE wallet_address= ?"
-	row := db.QueryRow(query, walletAddress)
-	err := row.Scan(
-		&period,
-		&limitAmount,
-	)
+	limits, err := GetLimits(walletAddress)
 	if err != nil {
-		noLimitsSet := errors.Is(err, sql.ErrNoRows)
-		if noLimitsSet {
-			return true
-		}
-
 		log.Printf("Error checking spending limits; %v\n", err)
 		return false
 	}
 
-	amountSpent, err := getTotalAmountSpent(walletAddress, period)
-	if err != nil {
-		log.Printf("Error fetching total amount spent; %v\n", err)
-		return false
+	for _, limit := range limits {
+		if newAmount > limit.Remaining {
+			return false
+		}
 	}
-
-	withinLimits := (amountSpent + newAmount) <= limitAmount
-	return withinLimits
+	return true
 }
 
-func SetOrUpdateLimit(userId int, walletAddress string, period string, amount float64) error {
+// Sets the spending limit for a period, replacing any existing
+// limit on the same period
+func SetOrUpdateLimit(
+	userId int,
+	walletAddress string,
+	period string,
+	amount float64,
+	window string,
+	timezone string,
+) error {
 	query := `
-		INSERT INTO limits(user_id, wallet_address, period, amount)
-		VALUES(?, ?, ?, ?)
+		INSERT INTO limits(user_id, wallet_address, period, amount, window_type, timezone)
+		VALUES(?, ?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
-			period = VALUES(period),
-			amount = VALUES(amount);
+			user_id = VALUES(user_id),
+			amount = VALUES(amount),
+			window_type = VALUES(window_type),
+			timezone = VALUES(timezone);
 	`
-	_, err := db.Exec(query, userId, walletAddress, period, amount)
+	_, err := db.Exec(query, userId, walletAddress, period, amount, window, timezone)
 	return err
 }
+
+// Removes the spending limit for a period.
+// Returns sql.ErrNoRows if no limit is set on the period
+func DeleteLimit(walletAddress string, period string) error {
+	query := "DELETE FROM limits WHERE wallet_address = ? AND period = ?"
+	result, err := db.Exec(query, walletAddress, period)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
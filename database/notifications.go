@@ -0,0 +1,222 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Envelope every notification is sent in, both over the websocket
+// and from GET /notifications. Type names an event from the
+// notification catalogue and decides the shape of Data
+type Notification struct {
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	Id        int64           `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+	ReadAt    *time.Time      `json:"read_at,omitempty"`
+}
+
+// Filters applied when fetching a user's notifications.
+// Zero values are ignored
+type NotificationFilter struct {
+	// Fetch notifications after this id, oldest first.
+	// Used by reconnecting clients to catch up on missed notifications
+	Since int64
+
+	// Fetch notifications before this id, newest first
+	Before int64
+
+	Type       string
+	UnreadOnly bool
+	Limit      int
+}
+
+type NotificationPage struct {
+	Notifications []*Notification `json:"notifications"`
+
+	// Whether there are more notifications past the last one
+	HasMore bool `json:"has_more"`
+}
+
+// Saves a notification for each user.
+// Returns the notifications in the same order as userIds
+func CreateNotifications(userIds []int, version int, eventType string, data []byte) ([]*Notification, error) {
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// MySQL datetimes have second precision
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	notifications := []*Notification{}
+
+	for _, userId := range userIds {
+		query := `
+			INSERT INTO notifications(user_id, version, type, data, created_at)
+			VALUES(?, ?, ?, ?, ?)
+		`
+		result, err := tx.Exec(query, userId, version, eventType, string(data), createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, &Notification{
+			Version:   version,
+			Type:      eventType,
+			Id:        id,
+			CreatedAt: createdAt,
+			Data:      data,
+		})
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func GetNotifications(userId int, filter NotificationFilter) (*NotificationPage, error) {
+	conditions := []string{"user_id = ?"}
+	args := []any{userId}
+	order := "id DESC"
+
+	if filter.Since > 0 {
+		conditions = append(conditions, "id > ?")
+		args = append(args, filter.Since)
+		order = "id ASC"
+	}
+	if filter.Before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Before)
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, filter.Type)
+	}
+	if filter.UnreadOnly {
+		conditions = append(conditions, "read_at IS NULL")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DEFAULT_PAGE_SIZE
+	}
+	if limit > MAX_PAGE_SIZE {
+		limit = MAX_PAGE_SIZE
+	}
+
+	// Fetch one extra row to find out if there is a next page
+	query := fmt.Sprintf(`
+		SELECT id, version, type, data, read_at, created_at
+		FROM notifications
+		WHERE %s
+		ORDER BY %s
+		LIMIT %d
+	`, strings.Join(conditions, " AND "), order, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := NotificationPage{
+		Notifications: []*Notification{},
+	}
+
+	for rows.Next() {
+		var (
+			n      Notification
+			data   []byte
+			readAt sql.NullTime
+		)
+
+		err := rows.Scan(&n.Id, &n.Version, &n.Type, &data, &readAt, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		n.Data = data
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+
+		if len(page.Notifications) == limit {
+			page.HasMore = true
+			break
+		}
+		page.Notifications = append(page.Notifications, &n)
+	}
+	return &page, rows.Err()
+}
+
+// Marks one of the user's notifications as read.
+// Returns [sql.ErrNoRows] if the user has no such notification
+func MarkNotificationRead(userId int, notificationId int64) error {
+	query := `
+		UPDATE notifications SET read_at= COALESCE(read_at, NOW())
+		WHERE id= ? AND user_id= ?
+	`
+	result, err := db.Exec(query, notificationId, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// Rows already read are matched but not changed
+		var exists bool
+
+		query = "SELECT EXISTS(SELECT 1 FROM notifications WHERE id= ? AND user_id= ?)"
+		err = db.QueryRow(query, notificationId, userId).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+// Marks all the user's notifications up to and including
+// upToId as read. Zero marks all of them.
+// Returns how many were marked
+func MarkAllNotificationsRead(userId int, upToId int64) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at= NOW()
+		WHERE user_id= ? AND read_at IS NULL AND (? = 0 OR id <= ?)
+	`
+	result, err := db.Exec(query, userId, upToId, upToId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Removes notifications older than maxAge
+func DeleteOldNotifications(maxAge time.Duration) (int64, error) {
+	query := "DELETE FROM notifications WHERE created_at <= NOW() - INTERVAL ? SECOND"
+	result, err := db.Exec(query, int(maxAge.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
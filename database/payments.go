@@ -0,0 +1,447 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrPaymentClosed = errors.New("payment is no longer pending")
+)
+
+// A deposit or withdrawal made through an external payment rail.
+// Funds move in a transaction sharing the payment's code
+type Payment struct {
+	Id            int64   `json:"-"`
+	PaymentCode   string  `json:"payment_code"`
+	Operation     string  `json:"operation"` // One of deposit or withdrawal
+	Rail          string  `json:"rail"`
+	WalletAddress string  `json:"wallet_address"`
+	Amount        float64 `json:"amount"`
+
+	// Account on the rail, e.g. a phone number or bank account number
+	ExternalAccount string `json:"external_account"`
+
+	// One of pending, settled or failed
+	Status            string `json:"status"`
+	ProviderReference string `json:"provider_reference,omitempty"`
+	FailureReason     string `json:"failure_reason,omitempty"`
+	SettledAt         string `json:"settled_at,omitempty"`
+	CreatedAt         string `json:"created_at"`
+}
+
+const paymentColumns = `
+	id,
+	payment_code,
+	operation,
+	rail,
+	wallet_address,
+	amount,
+	external_account,
+	status,
+	provider_reference,
+	failure_reason,
+	settled_at,
+	created_at
+`
+
+func scanPayment(row interface{ Scan(...any) error }) (*Payment, error) {
+	var (
+		p                 Payment
+		providerReference sql.NullString
+		failureReason     sql.NullString
+		settledAt         sql.NullString
+	)
+
+	err := row.Scan(
+		&p.Id,
+		&p.PaymentCode,
+		&p.Operation,
+		&p.Rail,
+		&p.WalletAddress,
+		&p.Amount,
+		&p.ExternalAccount,
+		&p.Status,
+		&providerReference,
+		&failureReason,
+		&settledAt,
+		&p.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	p.ProviderReference = providerReference.String
+	p.FailureReason = failureReason.String
+	p.SettledAt = settledAt.String
+	return &p, nil
+}
+
+// Error returned might be [sql.ErrNoRows]
+func GetPayment(paymentCode string) (*Payment, error) {
+	query := fmt.Sprintf("SELECT %s FROM payments WHERE payment_code = ?", paymentColumns)
+	return scanPayment(db.QueryRow(query, paymentCode))
+}
+
+// Fetches the most recent deposits and withdrawals on wallets
+// owned by the user
+func GetPayments(userId int) ([]*Payment, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM payments
+		WHERE wallet_address IN (
+			SELECT wallet_address FROM wallet_owners WHERE user_id = ?
+		)
+		ORDER BY id DESC
+		LIMIT ?
+	`, paymentColumns)
+
+	rows, err := db.Query(query, userId, DEFAULT_PAGE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []*Payment{}
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+func insertPayment(
+	tx *sql.Tx,
+	userId int,
+	paymentCode string,
+	operation string,
+	rail string,
+	walletAddress string,
+	externalAccount string,
+	amount float64,
+) error {
+	query := `
+		INSERT INTO payments(
+			payment_code,
+			operation,
+			rail,
+			wallet_address,
+			external_account,
+			amount,
+			initiated_by
+		) VALUES(?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := tx.Exec(
+		query,
+		paymentCode,
+		operation,
+		rail,
+		walletAddress,
+		externalAccount,
+		amount,
+		userId,
+	)
+	return err
+}
+
+// Inserts a signed deposit or withdrawal transaction
+// within db transaction tx
+func insertPaymentTransaction(
+	tx *sql.Tx,
+	paymentCode string,
+	transactionTyp string,
+	sender, receiver string,
+	amount float64,
+	timestamp string,
+	signerId int,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+) error {
+	query := `
+		INSERT INTO transactions(
+			transaction_code,
+			sender,
+			receiver,
+			amount,
+			fee,
+			timestamp,
+			transaction_type
+		) VALUES(?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(
+		query,
+		paymentCode,
+		sender,
+		receiver,
+		amount,
+		0.0,
+		timestamp,
+		transactionTyp,
+	)
+	if err != nil {
+		return err
+	}
+
+	transactionId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO signatures(
+			transaction_id,
+			transaction_code,
+			user_id,
+			signature,
+			public_key_hash
+		) VALUES(?, ?, ?, ?, ?)`
+	_, err = tx.Exec(
+		query,
+		transactionId,
+		paymentCode,
+		signerId,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	return err
+}
+
+// Records a pending deposit. Funds are only credited
+// to the wallet once the rail settles it
+func CreateDeposit(
+	userId int,
+	rail string,
+	walletAddress string,
+	externalAccount string,
+	amount float64,
+) (*Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	paymentCode := generateTransactionCode(deposit)
+
+	err = insertPayment(tx, userId, paymentCode, "deposit", rail, walletAddress, externalAccount, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetPayment(paymentCode)
+}
+
+// Records a pending withdrawal signed by the user.
+// The wallet's funds are held until the rail settles or
+// fails the withdrawal
+func CreateWithdrawal(
+	userId int,
+	rail string,
+	walletAddress string,
+	externalAccount string,
+	amount float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+	payload *SignedPayload,
+) (*Payment, error) {
+	settlementWallet, err := GetSystemWallet(SETTLEMENT_WALLET)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentCode := generateTransactionCode(withdrawal)
+
+	err = insertPayment(tx, userId, paymentCode, "withdrawal", rail, walletAddress, externalAccount, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertPaymentTransaction(
+		tx,
+		paymentCode,
+		"withdrawal",
+		walletAddress, settlementWallet,
+		amount,
+		timestamp,
+		userId,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetPayment(paymentCode)
+}
+
+// Saves the reference the rail assigned to a submitted payment
+func SetProviderReference(paymentCode string, providerReference string) error {
+	query := "UPDATE payments SET provider_reference = ? WHERE payment_code = ?"
+	_, err := db.Exec(query, providerReference, paymentCode)
+	return err
+}
+
+// Locks a payment for the rest of db transaction tx.
+// Returns [ErrPaymentClosed] if the payment is no longer pending
+func lockPendingPayment(tx *sql.Tx, paymentCode string, rail string) (*Payment, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM payments
+		WHERE payment_code = ? AND rail = ?
+		FOR UPDATE
+	`, paymentColumns)
+
+	payment, err := scanPayment(tx.QueryRow(query, paymentCode, rail))
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != "pending" {
+		return nil, ErrPaymentClosed
+	}
+	return payment, nil
+}
+
+// Settles a pending payment reported as settled by its rail.
+// Deposits are credited to the wallet from the settlement wallet.
+// Withdrawals are confirmed, moving the held funds into the
+// settlement wallet
+func SettlePayment(paymentCode string, rail string, providerReference string) (*Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := lockPendingPayment(tx, paymentCode, rail)
+	if err != nil {
+		return nil, err
+	}
+
+	switch payment.Operation {
+	case "deposit":
+		err = insertDepositTransaction(tx, payment)
+	case "withdrawal":
+		query := `
+			UPDATE transactions
+			SET status = 'confirmed'
+			WHERE transaction_code = ? AND status = 'pending'
+		`
+		_, err = tx.Exec(query, paymentCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE payments
+		SET status = 'settled', provider_reference = COALESCE(NULLIF(?, ''), provider_reference)
+		WHERE id = ?
+	`
+	_, err = tx.Exec(query, providerReference, payment.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetPayment(paymentCode)
+}
+
+// Credits a settled deposit to its wallet with a transfer
+// signed by the system user
+func insertDepositTransaction(tx *sql.Tx, payment *Payment) error {
+	systemUserId, err := getSystemUserId()
+	if err != nil {
+		return err
+	}
+
+	settlementWallet, err := GetSystemWallet(SETTLEMENT_WALLET)
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+
+	signature, secretKeyHash, err := signSystemTransfer(settlementWallet, payment.WalletAddress, payment.Amount, 0, timestamp)
+	if err != nil {
+		return err
+	}
+
+	return insertPaymentTransaction(
+		tx,
+		payment.PaymentCode,
+		"deposit",
+		settlementWallet, payment.WalletAddress,
+		payment.Amount,
+		timestamp,
+		*systemUserId,
+		signature,
+		secretKeyHash,
+	)
+}
+
+// Fails a pending payment. A failed withdrawal's
+// transaction is rejected, releasing the held funds
+func FailPayment(paymentCode string, rail string, reason string) (*Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := lockPendingPayment(tx, paymentCode, rail)
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.Operation == "withdrawal" {
+		query := `
+			UPDATE transactions
+			SET status = 'rejected'
+			WHERE transaction_code = ? AND status = 'pending'
+		`
+		_, err = tx.Exec(query, paymentCode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := "UPDATE payments SET status = 'failed', failure_reason = ? WHERE id = ?"
+	_, err = tx.Exec(query, reason, payment.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetPayment(paymentCode)
+}
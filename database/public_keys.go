@@ -3,9 +3,12 @@ package database
 import (
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/base64"
+	"errors"
 
 	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+	"github.com/go-sql-driver/mysql"
 )
 
 func getPubKeyHash(b64EncodedPubKey string) (string, error) {
@@ -19,6 +22,26 @@ func getPubKeyHash(b64EncodedPubKey string) (string, error) {
 	return pubKeyHash, nil
 }
 
+var (
+	ErrPublicKeyExists      = errors.New("public key is already registered")
+	ErrPublicKeyNotEnrolled = errors.New("public key has not been enrolled")
+	ErrPublicKeyRevoked     = errors.New("public key has been revoked")
+	ErrLastPublicKey        = errors.New("cannot revoke the only trusted public key")
+)
+
+// A device's signing key
+type PublicKey struct {
+	PublicKeyHash string `json:"public_key_hash"`
+	DeviceName    string `json:"device_name,omitempty"`
+
+	// User agent of the device's most recent session
+	UserAgent      string `json:"user_agent,omitempty"`
+	ActiveSessions int    `json:"active_sessions"`
+	LastUsedAt     string `json:"last_used_at,omitempty"`
+	RevokedAt      string `json:"revoked_at,omitempty"`
+	CreatedAt      string `json:"created_at"`
+}
+
 func CreatePublicKey(email string, b64EncodedPubKey string) error {
 	pubKeyHash, err := getPubKeyHash(b64EncodedPubKey)
 	if err != nil {
@@ -38,8 +61,97 @@ func CreatePublicKey(email string, b64EncodedPubKey string) error {
 	return err
 }
 
+// Trusts a new device's key to sign the user's transactions.
+// Callers must first verify a signature over the new key made
+// by one of the user's trusted keys.
+// Returns [ErrPublicKeyExists] if the key is already registered
+func EnrollPublicKey(email string, b64EncodedPubKey string, deviceName string) error {
+	pubKeyHash, err := getPubKeyHash(b64EncodedPubKey)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO public_keys(email, public_key_hash, public_key, device_name)
+		VALUES(?, ?, ?, NULLIF(?, ''))
+	`
+	_, err = db.Exec(query, email, pubKeyHash, b64EncodedPubKey, deviceName)
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrPublicKeyExists
+		}
+		return err
+	}
+	return nil
+}
+
+// Checks that a key submitted at login is trusted for the user.
+// Users without any trusted keys have the key registered, so
+// accounts from before key enrollment can still log in.
+// Returns [ErrPublicKeyRevoked] or [ErrPublicKeyNotEnrolled] if
+// the key cannot be used
+func AuthorizeLoginKey(email string, b64EncodedPubKey string) error {
+	pubKeyHash, err := getPubKeyHash(b64EncodedPubKey)
+	if err != nil {
+		return err
+	}
+
+	var (
+		isRegistered bool
+		isRevoked    bool
+		activeKeys   int
+	)
+
+	query := `
+		SELECT
+			COALESCE(MAX(public_key_hash = ?), 0),
+			COALESCE(MAX(public_key_hash = ? AND revoked_at IS NOT NULL), 0),
+			COALESCE(SUM(revoked_at IS NULL), 0)
+		FROM public_keys
+		WHERE email = ?
+	`
+	err = db.QueryRow(query, pubKeyHash, pubKeyHash, email).Scan(
+		&isRegistered,
+		&isRevoked,
+		&activeKeys,
+	)
+	if err != nil {
+		return err
+	}
+
+	if isRevoked {
+		return ErrPublicKeyRevoked
+	}
+
+	if !isRegistered {
+		if activeKeys > 0 {
+			return ErrPublicKeyNotEnrolled
+		}
+
+		err = EnrollPublicKey(email, b64EncodedPubKey, "")
+		if err != nil {
+			if errors.Is(err, ErrPublicKeyExists) {
+				// Registered to another user
+				return ErrPublicKeyNotEnrolled
+			}
+			return err
+		}
+	}
+
+	return TouchPublicKey(email, pubKeyHash)
+}
+
+// Fetches a trusted public key. Revoked keys are not returned,
+// so signatures made with them fail verification.
+// Error returned might be [sql.ErrNoRows]
 func GetPublicKey(email, b64EncodedPubKeyHash string) (*ecdsa.PublicKey, error) {
-	query := "SELECT public_key FROM public_keys WHERE email= ? AND public_key_hash= ?"
+	query := `
+		SELECT public_key
+		FROM public_keys
+		WHERE email= ? AND public_key_hash= ? AND revoked_at IS NULL
+	`
 	row := db.QueryRow(query, email, b64EncodedPubKeyHash)
 
 	var b64EncodedPubKey string
@@ -57,13 +169,217 @@ func GetPublicKey(email, b64EncodedPubKeyHash string) (*ecdsa.PublicKey, error)
 	return encrypt.PemDecodePublicKey(pubKeyBytes)
 }
 
-func ChangePublicKey(email string, b64EncodedPubKey string) error {
+// Checks that a key the user signed with has not been revoked since
+func IsTrustedPublicKey(userId int, b64EncodedPubKeyHash string) (bool, error) {
+	var trusted bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM public_keys k
+			INNER JOIN users u ON u.email = k.email
+			WHERE u.id= ? AND k.public_key_hash= ? AND k.revoked_at IS NULL
+		)
+	`
+	err := db.QueryRow(query, userId, b64EncodedPubKeyHash).Scan(&trusted)
+	return trusted, err
+}
+
+// Records that a key was just used to log in or sign
+func TouchPublicKey(email, b64EncodedPubKeyHash string) error {
+	query := "UPDATE public_keys SET last_used_at= NOW() WHERE email= ? AND public_key_hash= ?"
+	_, err := db.Exec(query, email, b64EncodedPubKeyHash)
+	return err
+}
+
+// Fetches all keys registered by the user, including revoked ones,
+// newest first
+func GetPublicKeys(userId int, email string) ([]*PublicKey, error) {
+	query := `
+		SELECT
+			pk.public_key_hash,
+			COALESCE(pk.device_name, ''),
+			COALESCE((
+				SELECT s.user_agent
+				FROM sessions s
+				WHERE s.public_key_id = pk.id AND s.user_id = ?
+				ORDER BY s.last_used_at DESC
+				LIMIT 1
+			), ''),
+			(
+				SELECT COUNT(*)
+				FROM sessions s
+				WHERE s.public_key_id = pk.id
+					AND s.user_id = ?
+					AND s.revoked_at IS NULL
+					AND s.expires_at > NOW()
+			),
+			COALESCE(pk.last_used_at, ''),
+			COALESCE(pk.revoked_at, ''),
+			pk.created_at
+		FROM public_keys pk
+		WHERE pk.email = ?
+		ORDER BY pk.id DESC
+	`
+	rows, err := db.Query(query, userId, userId, email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*PublicKey{}
+
+	for rows.Next() {
+		var key PublicKey
+
+		err := rows.Scan(
+			&key.PublicKeyHash,
+			&key.DeviceName,
+			&key.UserAgent,
+			&key.ActiveSessions,
+			&key.LastUsedAt,
+			&key.RevokedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	return keys, rows.Err()
+}
+
+// Revokes one of the user's keys and logs out every session
+// on its device. Returns [sql.ErrNoRows] if the user has no such
+// trusted key and [ErrLastPublicKey] if it is their only one
+func RevokePublicKey(userId int, email string, b64EncodedPubKeyHash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock all of the user's trusted keys so concurrent
+	// revocations cannot leave them without any
+	query := `
+		SELECT id, public_key_hash = ?
+		FROM public_keys
+		WHERE email = ? AND revoked_at IS NULL
+		FOR UPDATE
+	`
+	rows, err := tx.Query(query, b64EncodedPubKeyHash, email)
+	if err != nil {
+		return err
+	}
+
+	var (
+		publicKeyId int64
+		activeKeys  int
+	)
+
+	for rows.Next() {
+		var (
+			id      int64
+			isMatch bool
+		)
+		if err := rows.Scan(&id, &isMatch); err != nil {
+			rows.Close()
+			return err
+		}
+		if isMatch {
+			publicKeyId = id
+		}
+		activeKeys++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if publicKeyId == 0 {
+		return sql.ErrNoRows
+	}
+	if activeKeys == 1 {
+		return ErrLastPublicKey
+	}
+
+	query = "UPDATE public_keys SET revoked_at = NOW() WHERE id = ?"
+	_, err = tx.Exec(query, publicKeyId)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE public_key_id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	_, err = tx.Exec(query, publicKeyId, userId)
+	if err != nil {
+		return err
+	}
+
+	// Standing orders were authorised by the key up front
+	query = `
+		UPDATE standing_orders
+		SET status = 'cancelled'
+		WHERE user_id = ? AND public_key_hash = ? AND status IN ('active', 'paused')
+	`
+	_, err = tx.Exec(query, userId, b64EncodedPubKeyHash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replaces all of the user's trusted keys with a new one, for
+// users who lost every enrolled device. Every old key and the
+// sessions signed in with them are revoked, and standing orders
+// signed with them are cancelled
+func RecoverPublicKey(userId int, email string, b64EncodedPubKey string, deviceName string) error {
 	pubKeyHash, err := getPubKeyHash(b64EncodedPubKey)
 	if err != nil {
 		return err
 	}
 
-	query := "UPDATE public_keys SET public_key_hash= ?, public_key= ? WHERE email= ?"
-	_, err = db.Exec(query, pubKeyHash, b64EncodedPubKey, email)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE public_keys SET revoked_at = NOW() WHERE email = ? AND revoked_at IS NULL"
+	_, err = tx.Exec(query, email)
+	if err != nil {
+		return err
+	}
+
+	query = "UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL"
+	_, err = tx.Exec(query, userId)
+	if err != nil {
+		return err
+	}
+
+	// Every standing order was signed with one of the revoked keys
+	query = "UPDATE standing_orders SET status = 'cancelled' WHERE user_id = ? AND status IN ('active', 'paused')"
+	_, err = tx.Exec(query, userId)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO public_keys(email, public_key_hash, public_key, device_name)
+		VALUES(?, ?, ?, NULLIF(?, ''))
+	`
+	_, err = tx.Exec(query, email, pubKeyHash, b64EncodedPubKey, deviceName)
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrPublicKeyExists
+		}
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,282 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRequestFundsClosed = errors.New("request for funds is no longer pending")
+)
+
+type RequestFundsResult struct {
+	TransactionCode string  `json:"transaction_code"`
+	Sender          string  `json:"sender"`
+	Receiver        string  `json:"receiver"`
+	Amount          float64 `json:"amount"`
+
+	// Time when transaction was initiated by client
+	Timestamp   string `json:"timestamp"`
+	Signature   string `json:"signature"`
+	PublicKeyId string `json:"public_key_hash"`
+
+	// One of pending, accepted, declined, cancelled or expired
+	Status string `json:"status"`
+
+	// Transaction code of the transfer made when request was accepted
+	PaymentTransactionCode string `json:"payment_transaction_code,omitempty"`
+
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Time when record was saved to database
+	CreatedAt string `json:"created_at"`
+}
+
+func CreateRequestFunds(
+	sender, receiver string,
+	amount float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+	expiresAt time.Time,
+	payload *SignedPayload,
+) (*RequestFundsResult, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionCode := generateTransactionCode(requestFunds)
+
+	query := `
+	INSERT INTO request_funds(
+		transaction_code,
+		sender,
+		receiver,
+		amount,
+		timestamp,
+		signature,
+		public_key_hash,
+		expires_at
+	) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(
+		query,
+		transactionCode,
+		sender,
+		receiver,
+		amount,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+		expiresAt.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetRequestFunds(transactionCode)
+}
+
+// Pending requests past their expiry date are reported as expired
+const requestFundsColumns = `
+	rf.transaction_code,
+	rf.sender,
+	rf.receiver,
+	rf.amount,
+	rf.timestamp,
+	rf.signature,
+	rf.public_key_hash,
+	IF(rf.status = 'pending' AND rf.expires_at <= NOW(), 'expired', rf.status) AS status,
+	COALESCE(rf.payment_transaction_code, ''),
+	rf.expires_at,
+	rf.created_at
+`
+
+func scanRequestFunds(row interface{ Scan(...any) error }) (*RequestFundsResult, error) {
+	var rf RequestFundsResult
+
+	err := row.Scan(
+		&rf.TransactionCode,
+		&rf.Sender,
+		&rf.Receiver,
+		&rf.Amount,
+		&rf.Timestamp,
+		&rf.Signature,
+		&rf.PublicKeyId,
+		&rf.Status,
+		&rf.PaymentTransactionCode,
+		&rf.ExpiresAt,
+		&rf.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &rf, nil
+}
+
+func GetRequestFunds(transactionCode string) (*RequestFundsResult, error) {
+	query := "SELECT " + requestFundsColumns + " FROM request_funds rf WHERE rf.transaction_code= ?"
+	return scanRequestFunds(db.QueryRow(query, transactionCode))
+}
+
+func getRequestFundsWhere(condition string, args ...any) ([]*RequestFundsResult, error) {
+	query := "SELECT " + requestFundsColumns + " FROM request_funds rf WHERE " + condition +
+		" ORDER BY rf.created_at DESC"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*RequestFundsResult{}
+
+	for rows.Next() {
+		rf, err := scanRequestFunds(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rf)
+	}
+	return results, rows.Err()
+}
+
+// Fetches requests asking the user to pay from one of their wallets
+func GetIncomingRequestFunds(userId int) ([]*RequestFundsResult, error) {
+	return getRequestFundsWhere(`
+		rf.sender IN (SELECT wallet_address FROM wallet_owners WHERE user_id= ?)
+	`, userId)
+}
+
+// Fetches requests made by the user into one of their wallets
+func GetOutgoingRequestFunds(userId int) ([]*RequestFundsResult, error) {
+	return getRequestFundsWhere(`
+		rf.receiver IN (SELECT wallet_address FROM wallet_owners WHERE user_id= ?)
+	`, userId)
+}
+
+// Pays a pending request for funds.
+// The transfer is created and the request marked as accepted within the same
+// db transaction, so a request can never be paid twice.
+// Returns [ErrRequestFundsClosed] if request is not pending or has expired.
+func AcceptRequestFunds(
+	userId int,
+	requestCode string,
+	fee float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+	payload *SignedPayload,
+) (*Transaction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	var (
+		sender   string
+		receiver string
+		amount   float64
+	)
+
+	query := `
+		SELECT sender, receiver, amount
+		FROM request_funds
+		WHERE transaction_code= ?
+		AND status= 'pending'
+		AND expires_at > NOW()
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, requestCode).Scan(
+		&sender,
+		&receiver,
+		&amount,
+	)
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRequestFundsClosed
+		}
+		return nil, err
+	}
+
+	transactionCode, err := insertTransaction(
+		tx,
+		userId,
+		sender, receiver,
+		amount, fee,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	query = `
+		UPDATE request_funds
+		SET status= 'accepted', payment_transaction_code= ?
+		WHERE transaction_code= ?
+	`
+	_, err = tx.Exec(query, transactionCode, requestCode)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetTransaction(transactionCode)
+}
+
+func closeRequestFunds(requestCode string, status string) error {
+	query := `
+		UPDATE request_funds
+		SET status= ?
+		WHERE transaction_code= ?
+		AND status= 'pending'
+		AND expires_at > NOW()
+	`
+	result, err := db.Exec(query, status, requestCode)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRequestFundsClosed
+	}
+	return nil
+}
+
+// Declined by the wallet being asked to pay
+func DeclineRequestFunds(requestCode string) error {
+	return closeRequestFunds(requestCode, "declined")
+}
+
+// Cancelled by the user who requested the funds
+func CancelRequestFunds(requestCode string) error {
+	return closeRequestFunds(requestCode, "cancelled")
+}
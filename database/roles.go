@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrRoleUnchanged = errors.New("user already has this role")
+	ErrSystemRole    = errors.New("system role cannot be granted or revoked")
+)
+
+// Permissions checked by route middleware
+const (
+	PERMISSION_FEES_WRITE     string = "fees:write"
+	PERMISSION_REVENUE_READ   string = "revenue:read"
+	PERMISSION_LEDGER_READ    string = "ledger:read"
+	PERMISSION_DISPUTES_READ  string = "disputes:read"
+	PERMISSION_DISPUTES_WRITE string = "disputes:write"
+	PERMISSION_ROLES_READ     string = "roles:read"
+	PERMISSION_ROLES_WRITE    string = "roles:write"
+	PERMISSION_AGENTS_READ    string = "agents:read"
+	PERMISSION_AGENTS_WRITE   string = "agents:write"
+	PERMISSION_AGENT_TRANSACT string = "agent:transact"
+	PERMISSION_SECURITY_READ  string = "security:read"
+	PERMISSION_SECURITY_WRITE string = "security:write"
+)
+
+type Role struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+// Role granted or revoked by an admin
+type RoleChange struct {
+	UserId    int    `json:"user_id"`
+	Email     string `json:"email"`
+	FromRole  string `json:"from_role"`
+	ToRole    string `json:"to_role"`
+	ChangedBy int    `json:"changed_by"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Checks whether a user's current role grants a permission.
+// The role is read from the database so changes apply immediately
+func HasPermission(userId int, permission string) bool {
+	var exists bool
+
+	query := `
+		SELECT EXISTS(
+			SELECT 1
+			FROM users u
+			INNER JOIN role_permissions p ON p.role = u.role
+			WHERE u.id = ? AND p.permission = ?
+		)
+	`
+	err := db.QueryRow(query, userId, permission).Scan(&exists)
+	if err != nil {
+		return false
+	}
+	return exists
+}
+
+// Fetches every role along with the permissions it grants
+func GetRoles() ([]*Role, error) {
+	query := `
+		SELECT role, permission
+		FROM role_permissions
+		ORDER BY role, permission
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role, permission string
+
+		err = rows.Scan(&role, &permission)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(roles) == 0 || roles[len(roles)-1].Role != role {
+			roles = append(roles, &Role{Role: role})
+		}
+
+		last := roles[len(roles)-1]
+		last.Permissions = append(last.Permissions, permission)
+	}
+	return roles, rows.Err()
+}
+
+// Changes a user's role and records the change in the audit trail.
+// Revoking a role is a change back to the 'user' role
+func ChangeUserRole(userId int, role string, changedBy int, reason string) (*RoleChange, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	change, err := changeUserRole(tx, userId, role, changedBy, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, tx.Commit()
+}
+
+// Changes a user's role and records the change within db transaction tx.
+// Caller is responsible for committing or rolling back tx
+func changeUserRole(tx *sql.Tx, userId int, role string, changedBy int, reason string) (*RoleChange, error) {
+	if role == "system" {
+		return nil, ErrSystemRole
+	}
+
+	change := RoleChange{
+		UserId:    userId,
+		ToRole:    role,
+		ChangedBy: changedBy,
+		Reason:    reason,
+	}
+
+	query := "SELECT email, role FROM users WHERE id = ? FOR UPDATE"
+	err := tx.QueryRow(query, userId).Scan(&change.Email, &change.FromRole)
+	if err != nil {
+		return nil, err
+	}
+
+	if change.FromRole == "system" {
+		return nil, ErrSystemRole
+	}
+	if change.FromRole == role {
+		return nil, ErrRoleUnchanged
+	}
+
+	_, err = tx.Exec("UPDATE users SET role = ? WHERE id = ?", role, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO role_changes(user_id, from_role, to_role, changed_by, reason)
+		VALUES(?, ?, ?, ?, NULLIF(?, ''))
+	`
+	result, err := tx.Exec(query, userId, change.FromRole, role, changedBy, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	changeId, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	query = "SELECT created_at FROM role_changes WHERE id = ?"
+	err = tx.QueryRow(query, changeId).Scan(&change.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// Fetches the most recent role changes, newest first
+func GetRoleChanges(limit int) ([]*RoleChange, error) {
+	query := `
+		SELECT
+			c.user_id,
+			u.email,
+			c.from_role,
+			c.to_role,
+			c.changed_by,
+			c.reason,
+			c.created_at
+		FROM role_changes c
+		INNER JOIN users u ON u.id = c.user_id
+		ORDER BY c.id DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*RoleChange{}
+
+	for rows.Next() {
+		var (
+			change RoleChange
+			reason sql.NullString
+		)
+
+		err = rows.Scan(
+			&change.UserId,
+			&change.Email,
+			&change.FromRole,
+			&change.ToRole,
+			&change.ChangedBy,
+			&reason,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		change.Reason = reason.String
+		changes = append(changes, &change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,88 @@
+package database
+
+// Security event types
+const (
+	EVENT_LOGIN_FAILED              string = "login_failed"
+	EVENT_LOGIN_THROTTLED           string = "login_throttled"
+	EVENT_ACCOUNT_LOCKED            string = "account_locked"
+	EVENT_ACCOUNT_UNLOCKED          string = "account_unlocked"
+	EVENT_ACCOUNT_UNLOCK_FAILED     string = "account_unlock_failed"
+	EVENT_PASSWORD_RESET_REQUESTED  string = "password_reset_requested"
+	EVENT_PASSWORD_RESET_FAILED     string = "password_reset_failed"
+	EVENT_PASSWORD_RESET            string = "password_reset"
+	EVENT_PIN_FAILED                string = "pin_failed"
+	EVENT_PIN_LOCKED                string = "pin_locked"
+	EVENT_PIN_CHANGED               string = "pin_changed"
+	EVENT_PIN_RESET                 string = "pin_reset"
+	EVENT_DEVICE_RECOVERY_REQUESTED string = "device_recovery_requested"
+	EVENT_DEVICE_RECOVERY_FAILED    string = "device_recovery_failed"
+	EVENT_DEVICE_RECOVERED          string = "device_recovered"
+)
+
+type SecurityEvent struct {
+	Id        int    `json:"id"`
+	EventType string `json:"event_type"`
+	Email     string `json:"email"`
+	IpAddress string `json:"ip_address"`
+	Details   string `json:"details,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func CreateSecurityEvent(eventType, email, ipAddress, details string) error {
+	query := `
+		INSERT INTO security_events(event_type, user_id, email, ip_address, details)
+		VALUES(?, (SELECT id FROM users WHERE email= ?), ?, ?, NULLIF(?, ''))
+	`
+	_, err := db.Exec(
+		query,
+		eventType,
+		email,
+		truncate(email, 255),
+		truncate(ipAddress, 45),
+		truncate(details, 255),
+	)
+	return err
+}
+
+// Fetches the most recent security events, newest first.
+// Pass an empty email to fetch events for all accounts
+func GetSecurityEvents(email string, limit int) ([]*SecurityEvent, error) {
+	query := `
+		SELECT
+			id,
+			event_type,
+			email,
+			COALESCE(ip_address, ''),
+			COALESCE(details, ''),
+			created_at
+		FROM security_events
+		WHERE ? = '' OR email= ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, email, email, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*SecurityEvent{}
+
+	for rows.Next() {
+		var event SecurityEvent
+
+		err := rows.Scan(
+			&event.Id,
+			&event.EventType,
+			&event.Email,
+			&event.IpAddress,
+			&event.Details,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
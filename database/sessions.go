@@ -0,0 +1,412 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	SESSION_ID_BYTES    int = 16
+	REFRESH_TOKEN_BYTES int = 32
+)
+
+var (
+	ErrSessionRevoked     = errors.New("session has been revoked or has expired")
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+)
+
+// A login on one of the user's devices
+type Session struct {
+	SessionId string `json:"session_id"`
+	UserId    int    `json:"-"`
+
+	// Hash of the device's public key registered at login
+	PublicKeyHash string `json:"public_key_hash"`
+	UserAgent     string `json:"user_agent"`
+	IpAddress     string `json:"ip_address"`
+	ExpiresAt     string `json:"expires_at"`
+	LastUsedAt    string `json:"last_used_at"`
+	CreatedAt     string `json:"created_at"`
+}
+
+func randomHex(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// Issues a new refresh token for a session within db transaction tx.
+// Returns the plaintext token; only its hash is stored
+func insertRefreshToken(tx *sql.Tx, sessionId string) (string, error) {
+	refreshToken, err := randomHex(REFRESH_TOKEN_BYTES)
+	if err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO refresh_tokens(session_id, token_hash) VALUES(?, ?)"
+	_, err = tx.Exec(query, sessionId, hashRefreshToken(refreshToken))
+	return refreshToken, err
+}
+
+// Starts a session for the device whose public key was registered
+// at login. Sessions expire after ttl unless refreshed.
+// Returns the session and its first refresh token
+func CreateSession(
+	user *User,
+	b64EncodedPubKey string,
+	userAgent string,
+	ipAddress string,
+	ttl time.Duration,
+) (*Session, string, error) {
+	pubKeyHash, err := getPubKeyHash(b64EncodedPubKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sessionId, err := randomHex(SESSION_ID_BYTES)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var publicKeyId int64
+
+	query := "SELECT id FROM public_keys WHERE email = ? AND public_key_hash = ?"
+	err = tx.QueryRow(query, user.Email, pubKeyHash).Scan(&publicKeyId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query = `
+		INSERT INTO sessions(
+			session_id,
+			user_id,
+			public_key_id,
+			user_agent,
+			ip_address,
+			expires_at
+		) VALUES(?, ?, ?, ?, ?, NOW() + INTERVAL ? SECOND)
+	`
+	_, err = tx.Exec(
+		query,
+		sessionId,
+		user.Id,
+		publicKeyId,
+		truncate(userAgent, 255),
+		truncate(ipAddress, 45),
+		int(ttl.Seconds()),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	refreshToken, err := insertRefreshToken(tx, sessionId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	session, err := GetSession(sessionId)
+	return session, refreshToken, err
+}
+
+// Whether the user is logging in from a device none of their
+// sessions were started from. A user's first login is not from a
+// new device. Devices are only remembered while they have sessions
+// that have not been cleaned up by [DeleteExpiredSessions]
+func IsNewLoginDevice(user *User, b64EncodedPubKey string) (bool, error) {
+	pubKeyHash, err := getPubKeyHash(b64EncodedPubKey)
+	if err != nil {
+		return false, err
+	}
+
+	var hasSessions, knownDevice bool
+
+	query := `
+		SELECT
+			COUNT(*) > 0,
+			COALESCE(SUM(pk.public_key_hash = ?), 0) > 0
+		FROM sessions s
+		LEFT JOIN public_keys pk ON pk.id = s.public_key_id
+		WHERE s.user_id = ?
+	`
+	err = db.QueryRow(query, pubKeyHash, user.Id).Scan(&hasSessions, &knownDevice)
+	if err != nil {
+		return false, err
+	}
+	return hasSessions && !knownDevice, nil
+}
+
+// Exchanges a refresh token for a new one and extends the session by ttl.
+// Reusing a refresh token revokes its session and returns
+// [ErrRefreshTokenReused]. Returns [ErrSessionRevoked] if the session
+// has been revoked or has expired and [sql.ErrNoRows] if the
+// token does not exist
+func RefreshSession(refreshToken string, ttl time.Duration) (*Session, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenId   int64
+		sessionId string
+		isUsed    bool
+		isActive  bool
+	)
+
+	query := `
+		SELECT
+			rt.id,
+			rt.session_id,
+			rt.used_at IS NOT NULL,
+			s.revoked_at IS NULL AND s.expires_at > NOW()
+		FROM refresh_tokens rt
+		INNER JOIN sessions s ON s.session_id = rt.session_id
+		WHERE rt.token_hash = ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, hashRefreshToken(refreshToken)).Scan(
+		&tokenId,
+		&sessionId,
+		&isUsed,
+		&isActive,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if !isActive {
+		return nil, "", ErrSessionRevoked
+	}
+
+	if isUsed {
+		query = "UPDATE sessions SET revoked_at = NOW() WHERE session_id = ?"
+		_, err = tx.Exec(query, sessionId)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	query = "UPDATE refresh_tokens SET used_at = NOW() WHERE id = ?"
+	_, err = tx.Exec(query, tokenId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query = `
+		UPDATE sessions
+		SET last_used_at = NOW(), expires_at = NOW() + INTERVAL ? SECOND
+		WHERE session_id = ?
+	`
+	_, err = tx.Exec(query, int(ttl.Seconds()), sessionId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	newRefreshToken, err := insertRefreshToken(tx, sessionId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	session, err := GetSession(sessionId)
+	return session, newRefreshToken, err
+}
+
+const sessionColumns = `
+	s.session_id,
+	s.user_id,
+	COALESCE(pk.public_key_hash, ''),
+	COALESCE(s.user_agent, ''),
+	COALESCE(s.ip_address, ''),
+	s.expires_at,
+	s.last_used_at,
+	s.created_at
+`
+
+func scanSession(row interface{ Scan(...any) error }) (*Session, error) {
+	var session Session
+
+	err := row.Scan(
+		&session.SessionId,
+		&session.UserId,
+		&session.PublicKeyHash,
+		&session.UserAgent,
+		&session.IpAddress,
+		&session.ExpiresAt,
+		&session.LastUsedAt,
+		&session.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Error returned might be [sql.ErrNoRows]
+func GetSession(sessionId string) (*Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions s
+		LEFT JOIN public_keys pk ON pk.id = s.public_key_id
+		WHERE s.session_id = ?
+	`
+	return scanSession(db.QueryRow(query, sessionId))
+}
+
+// Fetches a user's active sessions, most recently used first
+func GetSessions(userId int) ([]*Session, error) {
+	query := `
+		SELECT ` + sessionColumns + `
+		FROM sessions s
+		LEFT JOIN public_keys pk ON pk.id = s.public_key_id
+		WHERE s.user_id = ? AND s.revoked_at IS NULL AND s.expires_at > NOW()
+		ORDER BY s.last_used_at DESC
+	`
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// Fetches the user logged in to an active session.
+// Returns [ErrSessionRevoked] if the session has been revoked,
+// has expired or does not belong to the user
+func GetSessionUser(sessionId string, userId int) (*User, error) {
+	query := `
+		SELECT
+			u.id,
+			u.username,
+			u.email,
+			u.phone_no,
+			u.email_verified,
+			u.phone_verified,
+			u.role
+		FROM sessions s
+		INNER JOIN users u ON u.id = s.user_id
+		WHERE s.session_id = ?
+			AND s.user_id = ?
+			AND s.revoked_at IS NULL
+			AND s.expires_at > NOW()
+	`
+
+	var user User
+
+	err := db.QueryRow(query, sessionId, userId).Scan(
+		&user.Id,
+		&user.Username,
+		&user.Email,
+		&user.PhoneNo,
+		&user.EmailVerified,
+		&user.PhoneVerified,
+		&user.Role,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Revokes one of the user's active sessions.
+// Returns [sql.ErrNoRows] if the user has no such active session
+func RevokeSession(userId int, sessionId string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE session_id = ? AND user_id = ? AND revoked_at IS NULL
+	`
+	result, err := db.Exec(query, sessionId, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Revokes all of a user's active sessions.
+// Returns the number of sessions revoked
+func RevokeAllSessions(userId int) (int64, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	result, err := db.Exec(query, userId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Removes sessions that can no longer be refreshed,
+// along with their refresh tokens
+func DeleteExpiredSessions() (int64, error) {
+	query := `
+		DELETE s, rt
+		FROM sessions s
+		LEFT JOIN refresh_tokens rt ON rt.session_id = s.session_id
+		WHERE s.expires_at <= NOW() OR s.revoked_at IS NOT NULL
+	`
+	result, err := db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func truncate(value string, maxLen int) string {
+	if len(value) > maxLen {
+		return value[:maxLen]
+	}
+	return value
+}
@@ -1,10 +1,43 @@
 package database
 
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Raised by the verifySignature trigger in signatures.sql
+const ALREADY_SIGNED_MESSAGE string = "You have already signed this transaction"
+
+var (
+	ErrAlreadySigned = errors.New("transaction already signed by user")
+)
+
+// Checks if user has already signed a transaction
+func HasSignedTransaction(userId int, transactionCode string) bool {
+	var exists bool
+
+	query := "SELECT EXISTS(SELECT 1 FROM signatures WHERE user_id= ? AND transaction_code= ?)"
+
+	err := db.QueryRow(
+		query,
+		userId,
+		transactionCode,
+	).Scan(&exists)
+	if err != nil {
+		return false
+	}
+	return exists
+}
+
+// Adds a co-owner's signature to a pending transaction.
+// Returns [ErrAlreadySigned] if user has signed the transaction before
 func AddSignature(
 	userId int,
 	transactionCode string,
 	signature string,
 	pubKeyHash string,
+	payload *SignedPayload,
 ) error {
 	var transactionId int
 
@@ -16,6 +49,17 @@ func AddSignature(
 		return err
 	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		return err
+	}
+
 	query = `
 	INSERT INTO signatures(
 		user_id,
@@ -25,7 +69,7 @@ func AddSignature(
 		public_key_hash
 	) VALUES(?, ?, ?, ?, ?)`
 
-	_, err = db.Exec(
+	_, err = tx.Exec(
 		query,
 		userId,
 		transactionId,
@@ -33,5 +77,139 @@ func AddSignature(
 		signature,
 		pubKeyHash,
 	)
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY, or 1644 ER_SIGNAL_EXCEPTION
+		// if the verifySignature trigger saw the earlier signature first
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) {
+			if mysqlErr.Number == 1062 ||
+				(mysqlErr.Number == 1644 && mysqlErr.Message == ALREADY_SIGNED_MESSAGE) {
+				return ErrAlreadySigned
+			}
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// Records a co-owner's rejection of a pending transaction.
+// The transaction is rejected once enough owners reject it that the
+// wallet's required signatures can no longer be met
+func RejectTransaction(userId int, transactionCode string, reason string) error {
+	var transactionId int
+
+	query := "SELECT id FROM transactions WHERE transaction_code= ?"
+	err := db.QueryRow(query, transactionCode).Scan(
+		&transactionId,
+	)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO transaction_rejections(
+		user_id,
+		transaction_id,
+		transaction_code,
+		reason
+	) VALUES(?, ?, ?, NULLIF(?, ''))`
+
+	_, err = db.Exec(
+		query,
+		userId,
+		transactionId,
+		transactionCode,
+		reason,
+	)
 	return err
 }
+
+// Pending transaction waiting on signatures from a wallet's co-owners
+type PendingSignature struct {
+	Transaction
+	SignaturesCount    int `json:"signatures_count"`
+	RequiredSignatures int `json:"required_signatures"`
+	RejectionsCount    int `json:"rejections_count"`
+}
+
+// Fetches pending transactions from the user's wallets that the user
+// has neither signed nor rejected, oldest first
+func GetPendingSignatures(userId int) ([]*PendingSignature, error) {
+	query := `
+		SELECT
+			td.id,
+			td.transaction_code,
+			td.sender_username,
+			td.sender_phone,
+			td.sender_wallet_address,
+			td.receiver_username,
+			td.receiver_phone,
+			td.receiver_wallet_address,
+			td.amount,
+			td.fee,
+			td.status,
+			td.transaction_type,
+			td.timestamp,
+			td.signature,
+			td.public_key_hash,
+			td.created_at,
+			t.signatures_count,
+			w.required_signatures,
+			t.rejections_count
+		FROM transactions t
+		JOIN transaction_details td ON td.id = t.id
+		JOIN wallets w ON w.wallet_address = t.sender
+		JOIN wallet_owners wo ON wo.wallet_address = t.sender
+		WHERE wo.user_id = ?
+		AND t.status = 'pending'
+		AND t.transaction_type = 'transfer'
+		AND NOT EXISTS (
+			SELECT 1 FROM signatures s
+			WHERE s.transaction_id = t.id AND s.user_id = wo.user_id
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM transaction_rejections tr
+			WHERE tr.transaction_id = t.id AND tr.user_id = wo.user_id
+		)
+		ORDER BY t.created_at, t.id
+	`
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []*PendingSignature{}
+
+	for rows.Next() {
+		var p PendingSignature
+
+		err := rows.Scan(
+			&p.Id,
+			&p.TransactionCode,
+			&p.Sender.Username,
+			&p.Sender.PhoneNo,
+			&p.Sender.WalletAddress,
+			&p.Receiver.Username,
+			&p.Receiver.PhoneNo,
+			&p.Receiver.WalletAddress,
+			&p.Amount,
+			&p.Fee,
+			&p.Status,
+			&p.TransactionType,
+			&p.Timestamp,
+			&p.Signature,
+			&p.PublicKeyId,
+			&p.CreatedAt,
+			&p.SignaturesCount,
+			&p.RequiredSignatures,
+			&p.RejectionsCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, &p)
+	}
+
+	return pending, rows.Err()
+}
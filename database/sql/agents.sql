@@ -0,0 +1,183 @@
+DROP TABLE IF EXISTS `agents`;
+
+--
+-- Table structure for table `agents`
+--
+-- Agents exchange cash for e-money. Each agent holds a float wallet
+-- that customers' cash-ins are paid from and cash-outs are paid into
+--
+CREATE TABLE `agents` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  -- Short number customers use to identify the agent
+  `agent_number` varchar(10) NOT NULL,
+  `business_name` varchar(100) NOT NULL,
+  `float_wallet` varchar(255) NOT NULL,
+  `status` enum('active','suspended') NOT NULL DEFAULT 'active',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `agents`
+--
+ALTER TABLE `agents`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `user_id` (`user_id`),
+  ADD UNIQUE KEY `agent_number` (`agent_number`),
+  ADD UNIQUE KEY `float_wallet` (`float_wallet`);
+
+ALTER TABLE `agents`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+DROP TABLE IF EXISTS `agent_commissions`;
+
+--
+-- Table structure for table `agent_commissions`
+--
+-- Commission paid to agents for each cash-in and cash-out, by amount.
+-- Separate from transaction_fees, which customers pay.
+-- Commissions are paid out of the fee revenue wallet
+--
+CREATE TABLE `agent_commissions` (
+  `id` bigint NOT NULL,
+  `operation` enum('cash_in','cash_out') NOT NULL,
+  `min_amount` decimal(10,2) NOT NULL,
+  `max_amount` decimal(10,2) NOT NULL,
+  `commission` decimal(10,2) NOT NULL,
+  `effective_from` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `effective_to` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+CREATE TRIGGER `verifyAgentCommission` BEFORE INSERT ON `agent_commissions`
+FOR EACH ROW BEGIN
+    IF NEW.min_amount > NEW.max_amount THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Commission min_amount cannot be greater than max_amount';
+    END IF;
+
+    IF NEW.commission < 0 THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Commission cannot be negative';
+    END IF;
+END;
+
+--
+-- Indexes for table `agent_commissions`
+--
+ALTER TABLE `agent_commissions`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `operation` (`operation`, `min_amount`);
+
+ALTER TABLE `agent_commissions`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+DROP TABLE IF EXISTS `agent_transactions`;
+
+--
+-- Table structure for table `agent_transactions`
+--
+-- Cash-ins and cash-outs handled by an agent. Cash-ins complete
+-- immediately. Cash-outs wait for the customer to sign the transfer
+-- into the agent's float
+--
+CREATE TABLE `agent_transactions` (
+  `id` bigint NOT NULL,
+  `agent_transaction_code` varchar(25) NOT NULL,
+  `agent_id` bigint NOT NULL,
+  `operation` enum('cash_in','cash_out') NOT NULL,
+  `customer_wallet` varchar(255) NOT NULL,
+  `amount` decimal(10,2) NOT NULL,
+  `status` enum('pending','completed','declined','expired') NOT NULL DEFAULT 'pending',
+  -- Transfer between the customer and the agent's float
+  `transaction_code` varchar(25) DEFAULT NULL,
+  `commission` decimal(10,2) NOT NULL DEFAULT '0.00',
+  -- Empty until the commission is paid into the agent's float
+  `commission_transaction_code` varchar(25) DEFAULT NULL,
+  `expires_at` datetime DEFAULT NULL,
+  `completed_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Triggers `agent_transactions`
+--
+CREATE TRIGGER `verifyAgentTransaction` BEFORE INSERT ON `agent_transactions`
+FOR EACH ROW BEGIN
+    DECLARE var_float_wallet VARCHAR(255);
+    DECLARE var_agent_status VARCHAR(20);
+    DECLARE var_agent_user_id BIGINT;
+    DECLARE var_required_signatures TINYINT;
+
+    IF NEW.amount < 1.0 THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Minimum transferable amount is KSH 1.0';
+    END IF;
+
+    SELECT float_wallet, status, user_id
+    INTO var_float_wallet, var_agent_status, var_agent_user_id
+    FROM agents
+    WHERE id = NEW.agent_id;
+
+    IF var_agent_status IS NULL OR var_agent_status <> 'active' THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Agent is not active';
+    END IF;
+
+    IF NEW.customer_wallet = var_float_wallet THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Agents cannot transact on their own float';
+    END IF;
+
+    -- Moving money between the agent's float and their own wallets
+    -- would earn commission without serving any customer
+    IF EXISTS (
+        SELECT 1 FROM wallet_owners
+        WHERE wallet_address = NEW.customer_wallet AND user_id = var_agent_user_id
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Agents cannot transact on their own wallets';
+    END IF;
+
+    SELECT required_signatures
+    INTO var_required_signatures
+    FROM wallets
+    WHERE wallet_address = NEW.customer_wallet;
+
+    IF var_required_signatures IS NULL OR NEW.customer_wallet LIKE '33%' THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Customer wallet does NOT exist';
+    END IF;
+
+    -- Cash is handed over on the spot, so a cash-out cannot wait
+    -- on co-owners to sign
+    IF NEW.operation = 'cash_out' AND var_required_signatures > 1 THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Cash-out is not available for multi-signature wallets';
+    END IF;
+END;
+
+CREATE TRIGGER `verifyAgentTransactionUpdate` BEFORE UPDATE ON `agent_transactions`
+FOR EACH ROW BEGIN
+    IF OLD.status <> 'pending' AND NEW.status <> OLD.status THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Agent transaction is no longer pending';
+    END IF;
+
+    IF OLD.status = 'pending' AND NEW.status = 'completed' THEN
+        SET NEW.completed_at = NOW();
+    END IF;
+END;
+
+--
+-- Indexes for table `agent_transactions`
+--
+ALTER TABLE `agent_transactions`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `agent_transaction_code` (`agent_transaction_code`),
+  ADD KEY `agent_id` (`agent_id`, `created_at`),
+  ADD KEY `customer_wallet` (`customer_wallet`),
+  ADD KEY `status_expires_at` (`status`, `expires_at`);
+
+ALTER TABLE `agent_transactions`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
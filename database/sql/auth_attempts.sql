@@ -0,0 +1,78 @@
+DROP TABLE IF EXISTS `auth_attempts`;
+
+--
+-- Table structure for table `auth_attempts`
+--
+-- Failed attempts at an auth action, counted per account (email)
+-- and per client IP address. Each failure past a few free ones
+-- blocks the subject until locked_until, doubling the wait every
+-- time. Accounts with too many failed logins are locked out until
+-- unlocked by email or by an admin
+--
+CREATE TABLE `auth_attempts` (
+  `id` bigint NOT NULL,
+  `action` enum('login','password_reset','transaction_pin') NOT NULL,
+  `scope` enum('account','ip') NOT NULL,
+  -- Email for account scope, IP address for ip scope
+  `subject` varchar(255) NOT NULL,
+  `failures` int NOT NULL DEFAULT '0',
+  `last_failure_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `locked_until` datetime DEFAULT NULL,
+  `locked_out_at` datetime DEFAULT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `auth_attempts`
+--
+ALTER TABLE `auth_attempts`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `action_scope_subject` (`action`, `scope`, `subject`),
+  ADD KEY `last_failure_at` (`last_failure_at`);
+
+ALTER TABLE `auth_attempts`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+DROP TABLE IF EXISTS `security_events`;
+
+--
+-- Table structure for table `security_events`
+--
+-- Audit trail of failed and suspicious auth activity
+--
+CREATE TABLE `security_events` (
+  `id` bigint NOT NULL,
+  `event_type` enum(
+    'login_failed',
+    'login_throttled',
+    'account_locked',
+    'account_unlocked',
+    'account_unlock_failed',
+    'password_reset_requested',
+    'password_reset_failed',
+    'password_reset',
+    'pin_failed',
+    'pin_locked',
+    'pin_changed',
+    'pin_reset',
+    'device_recovery_requested',
+    'device_recovery_failed',
+    'device_recovered'
+  ) NOT NULL,
+  -- NULL if the email does not belong to any user
+  `user_id` bigint DEFAULT NULL,
+  `email` varchar(255) NOT NULL,
+  `ip_address` varchar(45) DEFAULT NULL,
+  `details` varchar(255) DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `security_events`
+--
+ALTER TABLE `security_events`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `user_id` (`user_id`),
+  ADD KEY `email` (`email`);
+
+ALTER TABLE `security_events`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
@@ -41,6 +41,13 @@ SET
 
 END IF;
 
+END;
+
+CREATE TRIGGER `openCashPoolBalance` AFTER INSERT ON `cash_pools` FOR EACH ROW BEGIN
+-- Cash pools start with an empty balance and no postings
+INSERT IGNORE INTO wallet_balances(wallet_address)
+VALUES(NEW.wallet_address);
+
 END
 
 --
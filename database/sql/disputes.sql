@@ -0,0 +1,217 @@
+DROP TABLE IF EXISTS `disputes`;
+
+--
+-- Table structure for table `disputes`
+--
+-- A sender disputes a confirmed transfer. The dispute is resolved by
+-- reversing the transfer, either voluntarily by the receiver or
+-- forcibly by an admin, or by an admin rejecting it
+--
+CREATE TABLE `disputes` (
+  `id` bigint NOT NULL,
+  `dispute_code` varchar(25) NOT NULL,
+  `transaction_code` varchar(25) NOT NULL,
+  -- Sender, receiver and amount are copied from the disputed transaction
+  `sender` varchar(255) NOT NULL DEFAULT '',
+  `receiver` varchar(255) NOT NULL DEFAULT '',
+  `amount` decimal(10,2) NOT NULL DEFAULT '0.00',
+  `opened_by` bigint NOT NULL,
+  `reason` varchar(255) NOT NULL,
+  -- open: waiting on the receiver
+  -- escalated: declined by the receiver, waiting on an admin
+  `status` enum(
+    'open',
+    'escalated',
+    'reversed',
+    'rejected',
+    'cancelled'
+  ) NOT NULL DEFAULT 'open',
+  -- Transaction code of the refund made when the dispute was reversed
+  `refund_transaction_code` varchar(25) DEFAULT NULL,
+  -- User who made the last status change
+  `updated_by` bigint DEFAULT NULL,
+  `resolution_note` varchar(255) DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+DROP TABLE IF EXISTS `dispute_evidence`;
+
+--
+-- Table structure for table `dispute_evidence`
+--
+CREATE TABLE `dispute_evidence` (
+  `id` bigint NOT NULL,
+  `dispute_id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  `note` text NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+DROP TABLE IF EXISTS `dispute_events`;
+
+--
+-- Table structure for table `dispute_events`
+--
+-- Audit trail of a dispute's status changes. Written by triggers only
+--
+CREATE TABLE `dispute_events` (
+  `id` bigint NOT NULL,
+  `dispute_id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  `from_status` varchar(20) DEFAULT NULL,
+  `to_status` varchar(20) NOT NULL,
+  `note` varchar(255) DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Triggers `disputes`
+--
+CREATE TRIGGER `verifyDispute` BEFORE INSERT ON `disputes`
+FOR EACH ROW BEGIN
+    DECLARE var_sender VARCHAR(255);
+    DECLARE var_receiver VARCHAR(255);
+    DECLARE var_amount DECIMAL(10,2);
+    DECLARE var_status VARCHAR(20);
+    DECLARE var_transaction_type VARCHAR(20);
+
+    SELECT sender, receiver, amount, status, transaction_type
+    INTO var_sender, var_receiver, var_amount, var_status, var_transaction_type
+    FROM transactions
+    WHERE transaction_code = NEW.transaction_code;
+
+    IF var_sender IS NULL THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = "We couldn't find the transaction you are trying to dispute";
+    END IF;
+
+    IF var_transaction_type <> 'transfer' OR var_status <> 'confirmed' THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Only confirmed transfers can be disputed';
+    END IF;
+
+    -- Only the sender's owners can dispute a transfer
+    IF NOT EXISTS (
+        SELECT 1
+        FROM wallet_owners
+        WHERE wallet_address = var_sender
+          AND user_id = NEW.opened_by
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'This wallet does not belong to you';
+    END IF;
+
+    IF EXISTS (
+        SELECT 1 FROM transactions WHERE refund_transaction_code = NEW.transaction_code
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Transaction has already been reversed';
+    END IF;
+
+    IF EXISTS (
+        SELECT 1
+        FROM disputes
+        WHERE transaction_code = NEW.transaction_code
+          AND status IN ('open', 'escalated')
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Transaction already has an open dispute';
+    END IF;
+
+    SET NEW.sender = var_sender;
+    SET NEW.receiver = var_receiver;
+    SET NEW.amount = var_amount;
+    SET NEW.status = 'open';
+    SET NEW.updated_by = NEW.opened_by;
+END;
+
+CREATE TRIGGER `verifyDisputeUpdate` BEFORE UPDATE ON `disputes`
+FOR EACH ROW BEGIN
+    IF OLD.status <> NEW.status THEN
+        -- Reversed, rejected and cancelled disputes are final
+        IF OLD.status NOT IN ('open', 'escalated') THEN
+            SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'Dispute has already been closed';
+        END IF;
+
+        IF NEW.status = 'open' THEN
+            SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'Disputes cannot be reopened';
+        END IF;
+
+        IF NEW.status = 'escalated' AND OLD.status <> 'open' THEN
+            SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'Only open disputes can be escalated';
+        END IF;
+
+        IF NEW.status = 'reversed' AND NEW.refund_transaction_code IS NULL THEN
+            SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'Reversed disputes require a refund transaction';
+        END IF;
+    END IF;
+END;
+
+CREATE TRIGGER `logDisputeOpened` AFTER INSERT ON `disputes`
+FOR EACH ROW BEGIN
+    INSERT INTO dispute_events(dispute_id, user_id, from_status, to_status, note)
+    VALUES(NEW.id, NEW.opened_by, NULL, NEW.status, NEW.reason);
+END;
+
+CREATE TRIGGER `logDisputeStatus` AFTER UPDATE ON `disputes`
+FOR EACH ROW BEGIN
+    IF OLD.status <> NEW.status THEN
+        INSERT INTO dispute_events(dispute_id, user_id, from_status, to_status, note)
+        VALUES(NEW.id, NEW.updated_by, OLD.status, NEW.status, NEW.resolution_note);
+    END IF;
+END;
+
+--
+-- Triggers `dispute_evidence`
+--
+CREATE TRIGGER `verifyDisputeEvidence` BEFORE INSERT ON `dispute_evidence`
+FOR EACH ROW BEGIN
+    IF NOT EXISTS (
+        SELECT 1
+        FROM disputes
+        WHERE id = NEW.dispute_id
+          AND status IN ('open', 'escalated')
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Evidence can only be added to open disputes';
+    END IF;
+END;
+
+--
+-- Indexes for table `disputes`
+--
+ALTER TABLE `disputes`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `dispute_code` (`dispute_code`),
+  ADD KEY `transaction_code` (`transaction_code`),
+  ADD KEY `sender` (`sender`),
+  ADD KEY `receiver` (`receiver`),
+  ADD KEY `status` (`status`);
+
+ALTER TABLE `disputes`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+--
+-- Indexes for table `dispute_evidence`
+--
+ALTER TABLE `dispute_evidence`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `dispute_id` (`dispute_id`);
+
+ALTER TABLE `dispute_evidence`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+--
+-- Indexes for table `dispute_events`
+--
+ALTER TABLE `dispute_events`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `dispute_id` (`dispute_id`);
+
+ALTER TABLE `dispute_events`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
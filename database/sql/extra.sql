@@ -2,7 +2,7 @@
 -- Indexes for table `limits`
 --
 ALTER TABLE `limits` ADD PRIMARY KEY (`id`),
-ADD UNIQUE KEY `wallet_address` (`wallet_address`),
+ADD UNIQUE KEY `wallet_address_period` (`wallet_address`, `period`),
 ADD KEY `fk_limits_user_id` (`user_id`);
 
 ALTER TABLE `limits` MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
@@ -10,13 +10,6 @@ ALTER TABLE `limits` MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
 ALTER TABLE `limits` ADD CONSTRAINT `fk_limits_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT,
 ADD CONSTRAINT `fk_limits_wallet_address` FOREIGN KEY (`wallet_address`) REFERENCES `wallets` (`wallet_address`) ON DELETE CASCADE ON UPDATE CASCADE;
 
---
--- Indexes for table `password_reset_tokens`
---
-ALTER TABLE `password_reset_tokens` ADD PRIMARY KEY (`id`);
-
-ALTER TABLE `password_reset_tokens` MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
-
 --
 -- Indexes for table `public_keys`
 --
@@ -32,6 +25,7 @@ ALTER TABLE `public_keys` ADD CONSTRAINT `fk_public_keys_email` FOREIGN KEY (`em
 -- Indexes for table `request_funds`
 --
 ALTER TABLE `request_funds` ADD PRIMARY KEY (`id`),
+ADD UNIQUE KEY `transaction_code` (`transaction_code`),
 ADD KEY `sender` (`sender`),
 ADD KEY `receiver` (`receiver`);
 
@@ -42,7 +36,8 @@ ALTER TABLE `request_funds` MODIFY `id` int NOT NULL AUTO_INCREMENT;
 --
 ALTER TABLE `users` ADD PRIMARY KEY (`id`),
 ADD UNIQUE KEY `email` (`email`),
-ADD UNIQUE KEY `phone_no` (`phone_no`);
+ADD KEY `phone_no` (`phone_no`),
+ADD UNIQUE KEY `verified_phone_no` (`verified_phone_no`);
 
 ALTER TABLE `users` MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
 
@@ -81,4 +76,11 @@ ALTER TABLE `signatures` MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
 --
 ALTER TABLE `transactions` MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
 
-ALTER TABLE `transactions` ADD UNIQUE (`refund_transaction_code`);
\ No newline at end of file
+ALTER TABLE `transactions` ADD UNIQUE (`refund_transaction_code`);
+
+-- Transaction history is paginated by wallet, created_at and id
+ALTER TABLE `transactions` ADD KEY `sender_created_at` (`sender`, `created_at`, `id`),
+ADD KEY `receiver_created_at` (`receiver`, `created_at`, `id`);
+
+-- Fee revenue is reported per fee tier
+ALTER TABLE `transactions` ADD KEY `fee_tier_id` (`fee_tier_id`);
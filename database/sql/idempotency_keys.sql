@@ -0,0 +1,33 @@
+DROP TABLE IF EXISTS `idempotency_keys`;
+
+--
+-- Table structure for table `idempotency_keys`
+--
+-- Responses to money-moving requests, stored against the client's
+-- Idempotency-Key so that retried requests are not processed twice
+--
+CREATE TABLE `idempotency_keys` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  `idempotency_key` varchar(255) NOT NULL,
+  `request_hash` char(64) NOT NULL,
+  `status` enum('processing','completed') NOT NULL DEFAULT 'processing',
+  `response_status` smallint DEFAULT NULL,
+  `response_body` mediumtext,
+  `expires_at` datetime NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `idempotency_keys`
+--
+ALTER TABLE `idempotency_keys`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `user_idempotency_key` (`user_id`, `idempotency_key`),
+  ADD KEY `expires_at` (`expires_at`);
+
+ALTER TABLE `idempotency_keys`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+ALTER TABLE `idempotency_keys`
+  ADD CONSTRAINT `fk_idempotency_keys_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS `ledger_entries`;
+
+--
+-- Table structure for table `ledger_entries`
+--
+-- Every confirmed transaction is posted as a set of debit and credit
+-- entries whose amounts add up to the same total.
+-- Opening balances (initial deposits) are the only entries without
+-- a transaction. Each is balanced by a debit against the opening
+-- equity system wallet.
+--
+CREATE TABLE `ledger_entries` (
+  `id` bigint NOT NULL,
+  `transaction_id` bigint DEFAULT NULL,
+  `transaction_code` varchar(25) DEFAULT NULL,
+  `wallet_address` varchar(255) NOT NULL,
+  `entry_type` enum('debit','credit') NOT NULL,
+  `amount` decimal(10,2) NOT NULL,
+  `description` enum('opening_balance','transfer','refund','fee','commission','deposit','withdrawal') NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `ledger_entries`
+--
+ALTER TABLE `ledger_entries`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `wallet_address` (`wallet_address`, `created_at`),
+  ADD KEY `transaction_id` (`transaction_id`);
+
+ALTER TABLE `ledger_entries`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
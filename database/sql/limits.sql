@@ -1,10 +1,23 @@
 
 DROP TABLE IF EXISTS `limits`;
+
+--
+-- Table structure for table `limits`
+--
+-- A wallet may have one limit per period. Limits on the 'transaction'
+-- period cap the amount of a single transfer.
+-- Rolling windows cover the last day, week, month or year; calendar
+-- windows start at midnight, Monday, the 1st or January 1st in the
+-- limit's timezone
+--
 CREATE TABLE `limits` (
   `id` bigint NOT NULL,
   `user_id` bigint NOT NULL,
   `wallet_address` varchar(255) NOT NULL,
-  `period` enum('week','month','year') NOT NULL,
+  `period` enum('transaction','day','week','month','year') NOT NULL,
   `amount` decimal(10,2) NOT NULL,
+  `window_type` enum('rolling','calendar') NOT NULL DEFAULT 'rolling',
+  -- IANA timezone name used to align calendar windows
+  `timezone` varchar(64) NOT NULL DEFAULT 'UTC',
   `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS `notifications`;
+
+--
+-- Table structure for table `notifications`
+--
+-- Every notification sent to a user, so that users who were
+-- offline or lost their connection can fetch what they missed.
+-- Ids increase with every notification and are used as cursors
+--
+CREATE TABLE `notifications` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  -- Version of the notification envelope
+  `version` smallint NOT NULL,
+  -- Event from the notification catalogue; decides the shape of data
+  `type` varchar(50) NOT NULL,
+  `data` json NOT NULL,
+  `read_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `notifications`
+--
+ALTER TABLE `notifications`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `user_id_id` (`user_id`, `id`),
+  ADD KEY `user_id_type` (`user_id`, `type`),
+  ADD KEY `created_at` (`created_at`);
+
+ALTER TABLE `notifications`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
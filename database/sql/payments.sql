@@ -0,0 +1,69 @@
+DROP TABLE IF EXISTS `payments`;
+
+--
+-- Table structure for table `payments`
+--
+-- Deposits and withdrawals made through an external payment rail.
+-- Funds move between the wallet and the settlement wallet in a
+-- transaction sharing the payment's code. Deposits are only posted
+-- once the rail settles them. Withdrawals hold the wallet's funds
+-- while pending and are rejected if the rail fails them
+--
+CREATE TABLE `payments` (
+  `id` bigint NOT NULL,
+  `payment_code` varchar(25) NOT NULL,
+  `operation` enum('deposit','withdrawal') NOT NULL,
+  `rail` varchar(32) NOT NULL,
+  `wallet_address` varchar(255) NOT NULL,
+  -- Account on the rail, e.g. a phone number or bank account number
+  `external_account` varchar(64) NOT NULL,
+  `amount` decimal(10,2) NOT NULL,
+  `status` enum('pending','settled','failed') NOT NULL DEFAULT 'pending',
+  -- Reference assigned by the rail once the payment is submitted
+  `provider_reference` varchar(255) DEFAULT NULL,
+  `failure_reason` varchar(255) DEFAULT NULL,
+  `initiated_by` bigint NOT NULL,
+  `settled_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Triggers `payments`
+--
+CREATE TRIGGER `verifyPayment` BEFORE INSERT ON `payments`
+FOR EACH ROW BEGIN
+    IF NEW.amount < 1.0 THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Minimum transferable amount is KSH 1.0';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM wallets WHERE wallet_address = NEW.wallet_address) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Wallet does NOT exist';
+    END IF;
+END;
+
+CREATE TRIGGER `verifyPaymentUpdate` BEFORE UPDATE ON `payments`
+FOR EACH ROW BEGIN
+    -- Settled and failed payments are final
+    IF OLD.status <> 'pending' AND NEW.status <> OLD.status THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Payment is no longer pending';
+    END IF;
+
+    IF OLD.status = 'pending' AND NEW.status = 'settled' THEN
+        SET NEW.settled_at = NOW();
+    END IF;
+END;
+
+--
+-- Indexes for table `payments`
+--
+ALTER TABLE `payments`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `payment_code` (`payment_code`),
+  ADD KEY `wallet_address` (`wallet_address`, `created_at`),
+  ADD KEY `rail_provider_reference` (`rail`, `provider_reference`);
+
+ALTER TABLE `payments`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
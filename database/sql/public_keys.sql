@@ -1,9 +1,21 @@
 
 DROP TABLE IF EXISTS `public_keys`;
+
+--
+-- Table structure for table `public_keys`
+--
+-- Keys trusted to sign a user's transactions, one per device.
+-- The first key is registered at sign up; further keys must be
+-- enrolled with a signature from an already trusted key.
+-- Revoked keys can no longer sign or log in
+--
 CREATE TABLE `public_keys` (
   `id` bigint NOT NULL,
   `email` varchar(255) NOT NULL,
   `public_key_hash` varchar(255) NOT NULL,
   `public_key` text NOT NULL,
+  `device_name` varchar(100) DEFAULT NULL,
+  `last_used_at` datetime DEFAULT NULL,
+  `revoked_at` datetime DEFAULT NULL,
   `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -6,7 +6,9 @@ CREATE TABLE
     `transaction_code` varchar(25) CHARACTER
     SET
       utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL,
+      -- Wallet being asked to pay
       `sender` varchar(255) NOT NULL,
+      -- Wallet of the user requesting funds
       `receiver` varchar(255) NOT NULL,
       `amount` decimal(10, 2) NOT NULL,
       `timestamp` varchar(30) CHARACTER
@@ -17,5 +19,29 @@ CREATE TABLE
       -- So no need of placing signature in different table
       `signature` varchar(255) NOT NULL,
       `public_key_hash` varchar(255) NOT NULL,
-      `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
-  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
\ No newline at end of file
+      `status` enum (
+        'pending',
+        'accepted',
+        'declined',
+        'cancelled'
+      ) NOT NULL DEFAULT 'pending',
+      -- Transaction code of the transfer created when
+      -- the request is accepted
+      `payment_transaction_code` varchar(25) DEFAULT NULL,
+      `expires_at` datetime NOT NULL,
+      `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+      `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+  ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
+
+CREATE TRIGGER `verifyRequestFunds` BEFORE INSERT ON `request_funds`
+FOR EACH ROW BEGIN
+    IF NEW.sender = NEW.receiver THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT="You cannot request funds from your own wallet";
+    END IF;
+
+    IF NEW.expires_at <= NOW() THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT="Invalid request funds expiry date time";
+    END IF;
+END;
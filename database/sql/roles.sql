@@ -0,0 +1,58 @@
+DROP TABLE IF EXISTS `role_permissions`;
+
+--
+-- Table structure for table `role_permissions`
+--
+-- Named permissions granted to each user role. Permissions take the
+-- form <resource>:<action>. The system role holds no permissions;
+-- it only signs transfers made by the backend
+--
+CREATE TABLE `role_permissions` (
+  `role` enum('user','admin','agent','system') NOT NULL,
+  `permission` varchar(64) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+ALTER TABLE `role_permissions`
+  ADD PRIMARY KEY (`role`, `permission`);
+
+INSERT INTO `role_permissions` (`role`, `permission`) VALUES
+('admin', 'fees:write'),
+('admin', 'revenue:read'),
+('admin', 'ledger:read'),
+('admin', 'disputes:read'),
+('admin', 'disputes:write'),
+('admin', 'roles:read'),
+('admin', 'roles:write'),
+('admin', 'agents:read'),
+('admin', 'agents:write'),
+('admin', 'security:read'),
+('admin', 'security:write'),
+('agent', 'agent:transact');
+
+DROP TABLE IF EXISTS `role_changes`;
+
+--
+-- Table structure for table `role_changes`
+--
+-- Audit trail of every role granted or revoked by an admin
+--
+CREATE TABLE `role_changes` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  `from_role` enum('user','admin','agent','system') NOT NULL,
+  `to_role` enum('user','admin','agent','system') NOT NULL,
+  `changed_by` bigint NOT NULL,
+  `reason` varchar(255) DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `role_changes`
+--
+ALTER TABLE `role_changes`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `user_id` (`user_id`);
+
+ALTER TABLE `role_changes`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
@@ -1,57 +1,57 @@
 DROP PROCEDURE IF EXISTS `getTotalAmountSpent`;
 
+--
+-- Sums confirmed and pending outflows from a wallet, fees included,
+-- made within the last p_seconds seconds
+--
 CREATE DEFINER=`root`@`localhost` PROCEDURE `getTotalAmountSpent`(
-  IN `p_wallet_address` VARCHAR(32)
+  IN `p_wallet_address` VARCHAR(32),
+  IN `p_seconds` BIGINT
 )
 BEGIN
-    -- Last 7 days
-    SELECT
-        'week' AS period,
-        COALESCE(SUM(amount), 0) AS total_amount
+    SELECT COALESCE(SUM(amount + fee), 0) AS total_amount
     FROM transactions
     WHERE sender = p_wallet_address
-      AND created_at >= NOW() - INTERVAL 7 DAY
-
-    UNION ALL
-
-    -- Last 1 month
-    SELECT
-        'month' AS period,
-        COALESCE(SUM(amount), 0) AS total_amount
-    FROM transactions
-    WHERE sender = p_wallet_address
-      AND created_at >= NOW() - INTERVAL 1 MONTH
-
-    UNION ALL
-
-    -- Last 1 year
-    SELECT
-        'year' AS period,
-        COALESCE(SUM(amount), 0) AS total_amount
-    FROM transactions
-    WHERE sender = p_wallet_address
-      AND created_at >= NOW() - INTERVAL 1 YEAR;
+      AND status IN ('confirmed', 'pending')
+      AND created_at >= NOW() - INTERVAL p_seconds SECOND;
 END;
 
-CREATE DEFINER=`root`@`localhost` PROCEDURE `getTransactionFee`(
+--
+-- Fetches the fee tier currently in effect for an amount.
+-- Tier boundaries are inclusive; where tiers overlap the one with
+-- the lowest min_amount wins.
+-- Both outputs are NULL if no tier matches the amount
+--
+CREATE DEFINER=`root`@`localhost` PROCEDURE `getTransactionFeeTier`(
   IN `p_amount` DECIMAL(10,2),
+  OUT `p_fee_tier_id` BIGINT,
   OUT `p_fee` DECIMAL(10,2)
 )
 BEGIN
-  SELECT COALESCE(fee, 0)
-  INTO p_fee
+  SET p_fee_tier_id = NULL;
+  SET p_fee = NULL;
+
+  SELECT id, fee
+  INTO p_fee_tier_id, p_fee
   FROM transaction_fees
   WHERE p_amount BETWEEN min_amount AND max_amount
-  AND NOW() BETWEEN effective_from AND COALESCE(effective_to, NOW())
+  AND effective_from <= NOW()
+  AND (effective_to > NOW() OR effective_to IS NULL)
+  ORDER BY min_amount, id
   LIMIT 1;
 END;
 
-CREATE DEFINER=`root`@`localhost` PROCEDURE `getWalletBalance`(IN `p_wallet_address` VARCHAR(255), OUT `p_wallet_balance` DECIMAL(10,2))
+CREATE DEFINER=`root`@`localhost` PROCEDURE `getTransactionFee`(
+  IN `p_amount` DECIMAL(10,2),
+  OUT `p_fee` DECIMAL(10,2)
+)
 BEGIN
-  DECLARE var_initial_deposit DECIMAL(10,2) DEFAULT 0;
-  DECLARE var_total_sent DECIMAL(10,2);
-  DECLARE var_total_received DECIMAL(10,2);
+  CALL getTransactionFeeTier(p_amount, @fee_tier_id, @fee);
+  SET p_fee = COALESCE(@fee, 0);
+END;
 
+CREATE DEFINER=`root`@`localhost` PROCEDURE `getWalletBalance`(IN `p_wallet_address` VARCHAR(255), OUT `p_wallet_balance` DECIMAL(10,2))
+BEGIN
   -- Check if wallet exists
   CALL walletExists(p_wallet_address, @wallet_exists, @wallet_active);
 
@@ -60,29 +60,131 @@ BEGIN
     SET MESSAGE_TEXT="Wallet does NOT exist";
   END IF;
 
-  -- Get initial deposit
-  -- Remember to COALESCE NULL values to 0 as the wallet address
-  -- may not be found in wallets table (as is the case with cash pool wallets)
-  SELECT COALESCE(initial_deposit, 0)
-  INTO var_initial_deposit
-  FROM wallets
-  WHERE wallet_address = p_wallet_address
-  LIMIT 1;
+  -- Balances are materialised by postLedgerEntry.
+  -- Funds reserved by pending transactions are not available
+  SELECT COALESCE(MAX(balance - reserved), 0)
+  INTO p_wallet_balance
+  FROM wallet_balances
+  WHERE wallet_address = p_wallet_address;
+
+END;
+
+CREATE DEFINER=`root`@`localhost` PROCEDURE `getSystemWallet`(
+  IN `p_purpose` VARCHAR(20),
+  OUT `p_wallet_address` VARCHAR(255)
+)
+BEGIN
+  SET p_wallet_address = NULL;
+
+  SELECT wallet_address
+  INTO p_wallet_address
+  FROM system_wallets
+  WHERE purpose = p_purpose;
 
-  -- Get total amount sent
-  SELECT COALESCE(SUM(amount), 0)
-  INTO var_total_sent
-  FROM transactions
-  WHERE sender = p_wallet_address;
+  IF p_wallet_address IS NULL THEN
+    SIGNAL SQLSTATE "45000"
+    SET MESSAGE_TEXT="System wallet does NOT exist";
+  END IF;
+END;
 
-  -- Get total amount received
-  SELECT COALESCE(SUM(amount), 0)
-  INTO var_total_received
-  FROM transactions
-  WHERE receiver = p_wallet_address;
+--
+-- Posts a single debit or credit entry into the ledger and applies it
+-- to the wallet's materialised balance.
+-- Must only be called from within the db transaction that changes
+-- the state of the posted transaction
+--
+CREATE DEFINER=`root`@`localhost` PROCEDURE `postLedgerEntry`(
+  IN `p_transaction_id` BIGINT,
+  IN `p_transaction_code` VARCHAR(25),
+  IN `p_wallet_address` VARCHAR(255),
+  IN `p_entry_type` VARCHAR(10),
+  IN `p_amount` DECIMAL(10,2),
+  IN `p_description` VARCHAR(20)
+)
+BEGIN
+  -- Every wallet gets a balance row, even one without postings
+  INSERT IGNORE INTO wallet_balances(wallet_address)
+  VALUES(p_wallet_address);
+
+  IF p_amount > 0 THEN
+    INSERT INTO ledger_entries(
+      transaction_id,
+      transaction_code,
+      wallet_address,
+      entry_type,
+      amount,
+      description
+    ) VALUES(
+      p_transaction_id,
+      p_transaction_code,
+      p_wallet_address,
+      p_entry_type,
+      p_amount,
+      p_description
+    );
+
+    UPDATE wallet_balances
+    SET
+      balance = balance + IF(p_entry_type = 'credit', p_amount, -p_amount),
+      total_received = total_received + IF(p_entry_type = 'credit' AND p_description <> 'opening_balance', p_amount, 0),
+      total_sent = total_sent + IF(p_entry_type = 'debit' AND p_description NOT IN ('fee', 'opening_balance'), p_amount, 0),
+      total_fees = total_fees + IF(p_entry_type = 'debit' AND p_description = 'fee', p_amount, 0)
+    WHERE wallet_address = p_wallet_address;
+  END IF;
+END;
 
-  SET p_wallet_balance = var_initial_deposit + var_total_received - var_total_sent;
+--
+-- Adds amount to a wallet's reserved funds.
+-- Pass a negative amount to release reserved funds.
+-- Releasing more than is reserved means the reservations are out
+-- of step with the pending transactions, so it is an error
+--
+CREATE DEFINER=`root`@`localhost` PROCEDURE `reserveFunds`(
+  IN `p_wallet_address` VARCHAR(255),
+  IN `p_amount` DECIMAL(10,2)
+)
+BEGIN
+  INSERT IGNORE INTO wallet_balances(wallet_address)
+  VALUES(p_wallet_address);
 
+  IF EXISTS (
+    SELECT 1 FROM wallet_balances
+    WHERE wallet_address = p_wallet_address AND reserved + p_amount < 0
+  ) THEN
+    SIGNAL SQLSTATE "45000"
+    SET MESSAGE_TEXT="Cannot release more funds than are reserved";
+  END IF;
+
+  UPDATE wallet_balances
+  SET reserved = reserved + p_amount
+  WHERE wallet_address = p_wallet_address;
+END;
+
+--
+-- Posts a confirmed transaction as balanced debit and credit entries.
+-- Transaction fees are moved from the sender into the fee revenue wallet
+--
+CREATE DEFINER=`root`@`localhost` PROCEDURE `postTransaction`(
+  IN `p_transaction_id` BIGINT,
+  IN `p_transaction_code` VARCHAR(25),
+  IN `p_sender` VARCHAR(255),
+  IN `p_receiver` VARCHAR(255),
+  IN `p_amount` DECIMAL(10,2),
+  IN `p_fee` DECIMAL(10,2),
+  IN `p_transaction_type` VARCHAR(20)
+)
+BEGIN
+  DECLARE var_fee_wallet VARCHAR(255);
+
+  CALL postLedgerEntry(p_transaction_id, p_transaction_code, p_sender, 'debit', p_amount, p_transaction_type);
+  CALL postLedgerEntry(p_transaction_id, p_transaction_code, p_receiver, 'credit', p_amount, p_transaction_type);
+
+  IF p_fee > 0 THEN
+    CALL getSystemWallet('fee_revenue', var_fee_wallet);
+
+    CALL postLedgerEntry(p_transaction_id, p_transaction_code, p_sender, 'debit', p_fee, 'fee');
+    CALL postLedgerEntry(p_transaction_id, p_transaction_code, var_fee_wallet, 'credit', p_fee, 'fee');
+  END IF;
 END;
 
 CREATE DEFINER=`root`@`localhost` PROCEDURE `walletExists`(
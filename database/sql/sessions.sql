@@ -0,0 +1,62 @@
+DROP TABLE IF EXISTS `sessions`;
+
+--
+-- Table structure for table `sessions`
+--
+-- One row per login. Each session belongs to the device whose
+-- public key was registered at login. Revoked sessions can no
+-- longer refresh or use their access tokens
+--
+CREATE TABLE `sessions` (
+  `id` bigint NOT NULL,
+  -- Random id embedded in access tokens
+  `session_id` char(32) NOT NULL,
+  `user_id` bigint NOT NULL,
+  `public_key_id` bigint NOT NULL,
+  `user_agent` varchar(255) DEFAULT NULL,
+  `ip_address` varchar(45) DEFAULT NULL,
+  `expires_at` datetime NOT NULL,
+  `last_used_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `revoked_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `sessions`
+--
+ALTER TABLE `sessions`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `session_id` (`session_id`),
+  ADD KEY `user_id` (`user_id`, `revoked_at`);
+
+ALTER TABLE `sessions`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+DROP TABLE IF EXISTS `refresh_tokens`;
+
+--
+-- Table structure for table `refresh_tokens`
+--
+-- Refresh tokens are single use. Refreshing a session marks its
+-- token used and issues a new one. Presenting a used token again
+-- means it was stolen, so the whole session is revoked.
+-- Only a hash of each token is stored
+--
+CREATE TABLE `refresh_tokens` (
+  `id` bigint NOT NULL,
+  `session_id` char(32) NOT NULL,
+  `token_hash` char(64) NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `refresh_tokens`
+--
+ALTER TABLE `refresh_tokens`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `token_hash` (`token_hash`),
+  ADD KEY `session_id` (`session_id`);
+
+ALTER TABLE `refresh_tokens`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
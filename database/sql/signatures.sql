@@ -15,11 +15,16 @@ CREATE TABLE `signatures` (
 -- Triggers `signatures`
 --
 CREATE TRIGGER `updateSignatureCount` AFTER INSERT ON `signatures` FOR EACH ROW BEGIN
+-- Count each signer once, however many signatures they have made
 UPDATE transactions
 SET
-  signatures_count = signatures_count + 1
+  signatures_count = (
+    SELECT COUNT(DISTINCT user_id)
+    FROM signatures
+    WHERE transaction_id = NEW.transaction_id
+  )
 WHERE
-  transaction_code = NEW.transaction_code;
+  id = NEW.transaction_id;
 
 END;
 
@@ -28,13 +33,37 @@ CREATE TRIGGER `verifySignature` BEFORE INSERT ON `signatures`
     DECLARE var_sender VARCHAR(255);
     DECLARE var_transaction_type VARCHAR(255);
     DECLARE var_transaction_id BIGINT;
+    DECLARE var_status VARCHAR(20);
+    DECLARE var_expires_at DATETIME;
 
     -- Get sender's wallet address
-    SELECT sender, transaction_type
-    INTO var_sender, var_transaction_type
+    SELECT sender, transaction_type, status, expires_at
+    INTO var_sender, var_transaction_type, var_status, var_expires_at
     FROM transactions
     WHERE id = NEW.transaction_id;
 
+    IF var_status <> 'pending' THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Only pending transactions can be signed';
+    END IF;
+
+    IF var_expires_at IS NOT NULL AND var_expires_at <= NOW() THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Transaction approval window has expired';
+    END IF;
+
+    -- Each owner signs a transaction at most once.
+    -- AddSignature matches this message to report a duplicate
+    IF EXISTS (
+        SELECT 1
+        FROM signatures
+        WHERE transaction_id = NEW.transaction_id
+          AND user_id = NEW.user_id
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'You have already signed this transaction';
+    END IF;
+
     IF var_transaction_type <> 'refund' THEN
         -- Check ownership
         IF NOT EXISTS (
@@ -46,6 +75,17 @@ CREATE TRIGGER `verifySignature` BEFORE INSERT ON `signatures`
             SIGNAL SQLSTATE '45000'
             SET MESSAGE_TEXT = 'This wallet does not belong to you';
         END IF;
+
+        -- Owners cannot sign a transaction they have rejected
+        IF EXISTS (
+            SELECT 1
+            FROM transaction_rejections
+            WHERE transaction_id = NEW.transaction_id
+              AND user_id = NEW.user_id
+        ) THEN
+            SIGNAL SQLSTATE '45000'
+            SET MESSAGE_TEXT = 'You have already rejected this transaction';
+        END IF;
     END IF;
 
 END;
@@ -56,7 +96,8 @@ END;
 ALTER TABLE `signatures`
   ADD PRIMARY KEY (`id`),
   ADD KEY `fk_signatures_user_id` (`user_id`),
-  ADD KEY `fk_signatures_transaction_id` (`transaction_id`);
+  ADD KEY `fk_signatures_transaction_id` (`transaction_id`),
+  ADD UNIQUE KEY `transaction_signer` (`transaction_id`, `user_id`);
 
 --
 -- AUTO_INCREMENT for table `signatures`
@@ -0,0 +1,109 @@
+DROP TABLE IF EXISTS `standing_orders`;
+
+--
+-- Table structure for table `standing_orders`
+--
+-- Recurring transfers from a wallet, executed on a schedule using
+-- the signature the user gave when creating the standing order
+--
+CREATE TABLE `standing_orders` (
+  `id` bigint NOT NULL,
+  `order_code` varchar(25) NOT NULL,
+  -- User who created and signed the standing order
+  `user_id` bigint NOT NULL,
+  `sender` varchar(255) NOT NULL,
+  -- Wallet address, phone number or cash pool as entered by the user.
+  -- Phone numbers are resolved on every execution
+  `receiver` varchar(255) NOT NULL,
+  `amount` decimal(10,2) NOT NULL,
+  `frequency` enum('daily','weekly','monthly','cron') NOT NULL,
+  `cron_expression` varchar(100) DEFAULT NULL,
+  `starts_at` datetime NOT NULL,
+  `ends_at` datetime DEFAULT NULL,
+  `next_run_at` datetime NOT NULL,
+  `runs_count` int NOT NULL DEFAULT '0',
+  `status` enum('active','paused','cancelled','completed') NOT NULL DEFAULT 'active',
+  -- Pre-authorised signature over the standing order
+  `timestamp` varchar(30) NOT NULL,
+  `signature` varchar(255) NOT NULL,
+  `public_key_hash` varchar(255) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+DROP TABLE IF EXISTS `standing_order_executions`;
+
+--
+-- Table structure for table `standing_order_executions`
+--
+CREATE TABLE `standing_order_executions` (
+  `id` bigint NOT NULL,
+  `standing_order_id` bigint NOT NULL,
+  -- Empty when the transfer could not be created
+  `transaction_code` varchar(25) DEFAULT NULL,
+  `status` enum('confirmed','pending','failed') NOT NULL,
+  `message` varchar(255) DEFAULT NULL,
+  `executed_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Triggers `standing_orders`
+--
+CREATE TRIGGER `verifyStandingOrder` BEFORE INSERT ON `standing_orders`
+FOR EACH ROW BEGIN
+    IF NEW.amount < 1.0 THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Minimum transferable amount is KSH 1.0';
+    END IF;
+
+    IF NEW.frequency = 'cron' AND NEW.cron_expression IS NULL THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Cron standing orders require a cron expression';
+    END IF;
+
+    IF NEW.ends_at IS NOT NULL AND NEW.ends_at <= NEW.starts_at THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Standing order must end after it starts';
+    END IF;
+
+    IF NOT EXISTS (
+        SELECT 1
+        FROM wallet_owners
+        WHERE wallet_address = NEW.sender
+          AND user_id = NEW.user_id
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'This wallet does not belong to you';
+    END IF;
+END;
+
+CREATE TRIGGER `verifyStandingOrderUpdate` BEFORE UPDATE ON `standing_orders`
+FOR EACH ROW BEGIN
+    -- Cancelled and completed standing orders are final
+    IF OLD.status IN ('cancelled', 'completed') AND NEW.status <> OLD.status THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Standing order has already ended';
+    END IF;
+END;
+
+--
+-- Indexes for table `standing_orders`
+--
+ALTER TABLE `standing_orders`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `order_code` (`order_code`),
+  ADD KEY `sender` (`sender`),
+  ADD KEY `status_next_run_at` (`status`, `next_run_at`);
+
+ALTER TABLE `standing_orders`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+--
+-- Indexes for table `standing_order_executions`
+--
+ALTER TABLE `standing_order_executions`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `standing_order_id` (`standing_order_id`);
+
+ALTER TABLE `standing_order_executions`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
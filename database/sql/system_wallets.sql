@@ -0,0 +1,23 @@
+DROP TABLE IF EXISTS `system_wallets`;
+
+--
+-- Table structure for table `system_wallets`
+--
+-- Wallets owned by the system user, looked up by what they are used for.
+-- Transaction fees are credited into the fee_revenue wallet.
+-- Deposits are paid from, and withdrawals paid into, the settlement
+-- wallet, which mirrors funds held on external payment rails.
+-- Initial deposits are debited from the opening_equity wallet
+--
+CREATE TABLE `system_wallets` (
+  `purpose` enum('fee_revenue','settlement','opening_equity') NOT NULL,
+  `wallet_address` varchar(255) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `system_wallets`
+--
+ALTER TABLE `system_wallets`
+  ADD PRIMARY KEY (`purpose`),
+  ADD UNIQUE KEY `wallet_address` (`wallet_address`);
@@ -0,0 +1,26 @@
+DROP TABLE IF EXISTS `transaction_pins`;
+
+--
+-- Table structure for table `transaction_pins`
+--
+-- PINs users enter to authorise moving money, so a logged in
+-- session alone is not enough. Stored as argon2id hashes
+--
+CREATE TABLE `transaction_pins` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  -- Encoded argon2id key; see encrypt.Argon2Key
+  `pin_hash` varchar(255) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `transaction_pins`
+--
+ALTER TABLE `transaction_pins`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `user_id` (`user_id`);
+
+ALTER TABLE `transaction_pins`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
@@ -0,0 +1,82 @@
+DROP TABLE IF EXISTS `transaction_rejections`;
+
+--
+-- Table structure for table `transaction_rejections`
+--
+-- Co-owners of a multi-signature wallet veto pending transactions
+-- by rejecting them instead of signing
+--
+CREATE TABLE `transaction_rejections` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  `transaction_id` bigint NOT NULL,
+  `transaction_code` varchar(25) NOT NULL,
+  `reason` varchar(255) DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Triggers `transaction_rejections`
+--
+CREATE TRIGGER `verifyTransactionRejection` BEFORE INSERT ON `transaction_rejections`
+FOR EACH ROW BEGIN
+    DECLARE var_sender VARCHAR(255);
+    DECLARE var_status VARCHAR(20);
+
+    SELECT sender, status
+    INTO var_sender, var_status
+    FROM transactions
+    WHERE id = NEW.transaction_id;
+
+    IF var_status <> 'pending' THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Only pending transactions can be rejected';
+    END IF;
+
+    -- Check ownership
+    IF NOT EXISTS (
+        SELECT 1
+        FROM wallet_owners
+        WHERE wallet_address = var_sender
+          AND user_id = NEW.user_id
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'This wallet does not belong to you';
+    END IF;
+
+    -- Owners cannot reject a transaction they have already signed
+    IF EXISTS (
+        SELECT 1
+        FROM signatures
+        WHERE transaction_id = NEW.transaction_id
+          AND user_id = NEW.user_id
+    ) THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'You have already signed this transaction';
+    END IF;
+END;
+
+CREATE TRIGGER `updateRejectionCount` AFTER INSERT ON `transaction_rejections`
+FOR EACH ROW BEGIN
+    UPDATE transactions
+    SET rejections_count = rejections_count + 1
+    WHERE id = NEW.transaction_id;
+END;
+
+--
+-- Indexes for table `transaction_rejections`
+--
+ALTER TABLE `transaction_rejections`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `transaction_user` (`transaction_id`, `user_id`),
+  ADD KEY `fk_transaction_rejections_user_id` (`user_id`);
+
+ALTER TABLE `transaction_rejections`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+--
+-- Constraints for table `transaction_rejections`
+--
+ALTER TABLE `transaction_rejections`
+  ADD CONSTRAINT `fk_transaction_rejections_transaction_id` FOREIGN KEY (`transaction_id`) REFERENCES `transactions` (`id`) ON DELETE RESTRICT ON UPDATE RESTRICT,
+  ADD CONSTRAINT `fk_transaction_rejections_user_id` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`);
@@ -11,8 +11,11 @@ CREATE TABLE `transactions` (
   `fee` decimal(10,2) NOT NULL,
   `timestamp` varchar(30) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL,
   `signatures_count` tinyint NOT NULL DEFAULT '0',
+  `rejections_count` tinyint NOT NULL DEFAULT '0',
   `status` enum('pending','confirmed','rejected') CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT 'pending',
-  `transaction_type` enum('transfer','refund') CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT 'transfer',
+  `transaction_type` enum('transfer','refund','commission','deposit','withdrawal') CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL DEFAULT 'transfer',
+  `fee_tier_id` bigint DEFAULT NULL,
+  `expires_at` datetime DEFAULT NULL,
   `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
@@ -21,20 +24,37 @@ CREATE TRIGGER `updateTransactionStatus` BEFORE UPDATE ON `transactions`
 FOR EACH ROW
 BEGIN
     DECLARE var_required_signatures TINYINT;
+    DECLARE var_total_owners INT;
 
-    IF NEW.transaction_type= 'transfer' THEN
-        -- Get required number of signatures
-        SELECT required_signatures
-        INTO var_required_signatures
-        FROM wallets
-        WHERE wallet_address = NEW.sender;
-
-        -- Check if we have met the required number of signatures
-        IF NEW.signatures_count >= var_required_signatures THEN
+    -- Confirmed and rejected transactions are final
+    IF OLD.status = 'pending' THEN
+        IF NEW.transaction_type= 'transfer' THEN
+            -- Get required number of signatures
+            SELECT required_signatures
+            INTO var_required_signatures
+            FROM wallets
+            WHERE wallet_address = NEW.sender;
+
+            -- Check if we have met the required number of signatures
+            IF NEW.signatures_count >= var_required_signatures THEN
+                SET NEW.status = 'confirmed';
+            ELSEIF NEW.rejections_count > OLD.rejections_count THEN
+                -- Reject once the owners who have not rejected the
+                -- transaction can no longer meet the required signatures
+                SELECT COUNT(*)
+                INTO var_total_owners
+                FROM wallet_owners
+                WHERE wallet_address = NEW.sender;
+
+                IF var_total_owners - NEW.rejections_count < var_required_signatures THEN
+                    SET NEW.status = 'rejected';
+                END IF;
+            END IF;
+        ELSEIF NEW.transaction_type <> 'withdrawal' THEN
             SET NEW.status = 'confirmed';
         END IF;
-    ELSE
-        SET NEW.status = 'confirmed';
+        -- Withdrawals stay pending until the payment rail
+        -- settles or fails them
     END IF;
 END;
 
@@ -45,7 +65,12 @@ BEGIN
     DECLARE var_senders_balance DECIMAL(10,2);
     DECLARE var_amount DECIMAL(10,2);
     DECLARE var_transaction_fee DECIMAL(10,2) DEFAULT 0;
+    DECLARE var_fee_tier_id BIGINT DEFAULT NULL;
+    DECLARE var_approval_window INT;
     DECLARE var_exists BOOLEAN DEFAULT FALSE;
+    DECLARE var_fee_wallet VARCHAR(255);
+    DECLARE var_settlement_wallet VARCHAR(255);
+    DECLARE var_customer_wallet VARCHAR(255);
 
     DECLARE sender_exists BOOLEAN DEFAULT FALSE;
     DECLARE sender_active BOOLEAN DEFAULT FALSE;
@@ -55,6 +80,7 @@ BEGIN
     IF NEW.transaction_type = 'refund' THEN
         -- Drop transaction fees in refunds
         SET NEW.fee = 0.0;
+        SET NEW.fee_tier_id = NULL;
 
         -- Check if refund transaction code present
         IF NEW.refund_transaction_code IS NULL THEN
@@ -90,13 +116,84 @@ BEGIN
                 SET MESSAGE_TEXT = 'Receiver wallet does NOT exist';
         END IF;
 
+    ELSEIF NEW.transaction_type = 'commission' THEN
+        -- Agent commissions are paid from the fee revenue wallet
+        -- into an agent's float, free of charge
+        SET NEW.fee = 0.0;
+        SET NEW.fee_tier_id = NULL;
+
+        CALL getSystemWallet('fee_revenue', var_fee_wallet);
+
+        IF NEW.sender <> var_fee_wallet THEN
+            SIGNAL SQLSTATE '45000'
+                SET MESSAGE_TEXT = 'Commissions are paid from the fee revenue wallet';
+        END IF;
+
+        IF NOT EXISTS (SELECT 1 FROM agents WHERE float_wallet = NEW.receiver) THEN
+            SIGNAL SQLSTATE '45000'
+                SET MESSAGE_TEXT = 'Commissions are paid into agent float wallets';
+        END IF;
+
+        SET var_amount = NEW.amount;
+
+    ELSEIF NEW.transaction_type IN ('deposit', 'withdrawal') THEN
+        -- Deposits are paid from the settlement wallet and withdrawals
+        -- into it, free of charge
+        SET NEW.fee = 0.0;
+        SET NEW.fee_tier_id = NULL;
+
+        CALL getSystemWallet('settlement', var_settlement_wallet);
+
+        IF NEW.transaction_type = 'deposit' THEN
+            SET var_customer_wallet = NEW.receiver;
+
+            IF NEW.sender <> var_settlement_wallet THEN
+                SIGNAL SQLSTATE '45000'
+                    SET MESSAGE_TEXT = 'Deposits are paid from the settlement wallet';
+            END IF;
+        ELSE
+            SET var_customer_wallet = NEW.sender;
+
+            IF NEW.receiver <> var_settlement_wallet THEN
+                SIGNAL SQLSTATE '45000'
+                    SET MESSAGE_TEXT = 'Withdrawals are paid into the settlement wallet';
+            END IF;
+        END IF;
+
+        CALL walletExists(var_customer_wallet, @customer_exists, @customer_active);
+        SELECT @customer_exists, @customer_active INTO sender_exists, sender_active;
+        IF NOT sender_exists OR NOT sender_active OR var_customer_wallet LIKE '33%' THEN
+            SIGNAL SQLSTATE '45000'
+                SET MESSAGE_TEXT = 'Wallet does NOT exist OR is NOT active';
+        END IF;
+
+        -- Withdrawals are signed by a single owner
+        IF NEW.transaction_type = 'withdrawal' AND EXISTS (
+            SELECT 1 FROM wallets
+            WHERE wallet_address = NEW.sender AND required_signatures > 1
+        ) THEN
+            SIGNAL SQLSTATE '45000'
+                SET MESSAGE_TEXT = 'Withdrawals are not available for multi-signature wallets';
+        END IF;
+
+        SET var_amount = NEW.amount;
+
     ELSEIF NEW.transaction_type = 'transfer' THEN
-        -- Verify transaction fees
-        SELECT COALESCE(fee, 0)
-        INTO var_transaction_fee
-        FROM transaction_fees
-        WHERE NEW.amount BETWEEN min_amount AND max_amount
-        LIMIT 1;
+        -- Verify transaction fees against the fee tier currently in effect.
+        -- Transfers without a fee tier are free
+        CALL getTransactionFeeTier(NEW.amount, @fee_tier_id, @fee);
+        SELECT @fee_tier_id, COALESCE(@fee, 0) INTO var_fee_tier_id, var_transaction_fee;
+
+        SET NEW.fee_tier_id = var_fee_tier_id;
+
+        -- Pending transfers are rejected once the sender's approval window passes.
+        -- Cash pools have no approval window; use the default
+        SELECT COALESCE(MAX(approval_window_minutes), 1440)
+        INTO var_approval_window
+        FROM wallets
+        WHERE wallet_address = NEW.sender;
+
+        SET NEW.expires_at = NOW() + INTERVAL var_approval_window MINUTE;
 
         IF NEW.fee <> var_transaction_fee THEN
             SIGNAL SQLSTATE '45000'
@@ -128,14 +225,23 @@ BEGIN
             SET MESSAGE_TEXT = 'Minimum transferable amount is KSH 1.0';
     END IF;
 
-    -- Fetch sender's balance
-    CALL getWalletBalance(NEW.sender, @balance);
-    SELECT @balance INTO var_senders_balance;
-
-    -- Ensure sender has enough funds (including fees)
-    IF var_amount > var_senders_balance THEN
-        SIGNAL SQLSTATE '45000'
-            SET MESSAGE_TEXT = 'Insufficient funds to complete transaction';
+    -- The settlement wallet mirrors funds held on payment rails,
+    -- so deposits are not limited by its balance
+    IF NEW.transaction_type <> 'deposit' THEN
+        -- Fetch sender's available balance, locking the sender's balance
+        -- row until this db transaction ends. Concurrent transfers from
+        -- the same wallet wait here, so they cannot both spend the same funds
+        SELECT COALESCE(MAX(balance - reserved), 0)
+        INTO var_senders_balance
+        FROM wallet_balances
+        WHERE wallet_address = NEW.sender
+        FOR UPDATE;
+
+        -- Ensure sender has enough funds (including fees)
+        IF var_amount > var_senders_balance THEN
+            SIGNAL SQLSTATE '45000'
+                SET MESSAGE_TEXT = 'Insufficient funds to complete transaction';
+        END IF;
     END IF;
 END
 
@@ -180,7 +286,7 @@ FOR EACH ROW BEGIN
             END IF;
         END IF;
 
-        SELECT balance
+        SELECT available_balance
         INTO var_cash_pool_balance
         FROM balances
         WHERE wallet_address= NEW.sender;
@@ -235,4 +341,34 @@ FOR EACH ROW BEGIN
         END IF;
     END IF;
 
-END;
\ No newline at end of file
+END;
+
+CREATE TRIGGER `reservePendingTransaction` AFTER INSERT ON `transactions`
+FOR EACH ROW BEGIN
+    -- Hold funds of pending transactions so they cannot be spent twice
+    IF NEW.status = 'pending' THEN
+        CALL reserveFunds(NEW.sender, NEW.amount + NEW.fee);
+    END IF;
+END;
+
+CREATE TRIGGER `postConfirmedTransaction` AFTER UPDATE ON `transactions`
+FOR EACH ROW BEGIN
+    -- Release held funds once the transaction is confirmed or rejected
+    IF OLD.status = 'pending' AND NEW.status <> 'pending' THEN
+        CALL reserveFunds(NEW.sender, -(OLD.amount + OLD.fee));
+    END IF;
+
+    -- Post ledger entries once, when the transaction gets confirmed.
+    -- Runs within the same db transaction as the status change
+    IF NEW.status = 'confirmed' AND OLD.status <> 'confirmed' THEN
+        CALL postTransaction(
+            NEW.id,
+            NEW.transaction_code,
+            NEW.sender,
+            NEW.receiver,
+            NEW.amount,
+            NEW.fee,
+            NEW.transaction_type
+        );
+    END IF;
+END;
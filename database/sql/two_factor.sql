@@ -0,0 +1,56 @@
+DROP TABLE IF EXISTS `totp_secrets`;
+
+--
+-- Table structure for table `totp_secrets`
+--
+-- Shared secrets for users' authenticator apps. A secret is pending
+-- until the user proves their app generates matching codes.
+-- last_used_step is the most recent 30 second period a code was
+-- accepted for, so each code can only be used once
+--
+CREATE TABLE `totp_secrets` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  -- Base32 encoded secret, encrypted with a key derived from SECRET_KEY.
+  -- Changing SECRET_KEY makes existing secrets unreadable
+  `secret` varchar(255) NOT NULL,
+  `enabled_at` datetime DEFAULT NULL,
+  `last_used_step` bigint NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `totp_secrets`
+--
+ALTER TABLE `totp_secrets`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `user_id` (`user_id`);
+
+ALTER TABLE `totp_secrets`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
+
+DROP TABLE IF EXISTS `backup_codes`;
+
+--
+-- Table structure for table `backup_codes`
+--
+-- Single use codes that stand in for a TOTP code when the user
+-- loses their authenticator app. Only a hash of each code is stored
+--
+CREATE TABLE `backup_codes` (
+  `id` bigint NOT NULL,
+  `user_id` bigint NOT NULL,
+  `code_hash` char(64) NOT NULL,
+  `used_at` datetime DEFAULT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `backup_codes`
+--
+ALTER TABLE `backup_codes`
+  ADD PRIMARY KEY (`id`),
+  ADD UNIQUE KEY `user_code` (`user_id`, `code_hash`);
+
+ALTER TABLE `backup_codes`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
@@ -0,0 +1,22 @@
+DROP TABLE IF EXISTS `used_signatures`;
+
+--
+-- Table structure for table `used_signatures`
+--
+-- Hashes of signed payloads already accepted by the server.
+-- Rows can be deleted once expired as payloads are only accepted
+-- while their client timestamp is fresh
+--
+CREATE TABLE `used_signatures` (
+  `payload_hash` char(64) NOT NULL,
+  `user_id` bigint NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `used_signatures`
+--
+ALTER TABLE `used_signatures`
+  ADD PRIMARY KEY (`payload_hash`),
+  ADD KEY `expires_at` (`expires_at`);
@@ -14,8 +14,15 @@ CREATE TABLE
       `phone_no` varchar(15) CHARACTER
     SET
       utf8mb4 COLLATE utf8mb4_0900_ai_ci DEFAULT NULL,
-      `email_verified` tinyint (1) NOT NULL DEFAULT '1',
-      `role` enum ('user', 'admin', 'agent') NOT NULL DEFAULT 'user'
+      `email_verified` tinyint (1) NOT NULL DEFAULT '0',
+      -- Only verified phone numbers can receive money sent by phone number
+      `phone_verified` tinyint (1) NOT NULL DEFAULT '0',
+      -- Set only once the phone number is verified. Unique in place of
+      -- phone_no so that unverified claims cannot block its real owner
+      `verified_phone_no` varchar(15) CHARACTER
+    SET
+      utf8mb4 COLLATE utf8mb4_0900_ai_ci GENERATED ALWAYS AS (IF(`phone_verified` = 1, `phone_no`, NULL)) VIRTUAL,
+      `role` enum ('user', 'admin', 'agent', 'system') NOT NULL DEFAULT 'user'
   ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
 
 --
@@ -23,7 +30,8 @@ CREATE TABLE
 --
 ALTER TABLE `users` ADD PRIMARY KEY (`id`),
 ADD UNIQUE KEY `email` (`email`),
-ADD UNIQUE KEY `phone_no` (`phone_no`);
+ADD KEY `phone_no` (`phone_no`),
+ADD UNIQUE KEY `verified_phone_no` (`verified_phone_no`);
 
 --
 -- AUTO_INCREMENT for table `users`
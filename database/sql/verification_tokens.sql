@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS `verification_tokens`;
+
+--
+-- Table structure for table `verification_tokens`
+--
+-- One-time codes sent to a user's email or phone number to reset
+-- their password, unlock their account, enroll a new device after
+-- losing every trusted one or verify that they own the email or
+-- phone number.
+-- A user has at most one active code per purpose; requesting a new
+-- one replaces it. Codes are locked after too many wrong attempts
+--
+CREATE TABLE `verification_tokens` (
+  `id` bigint NOT NULL,
+  `purpose` enum('password_reset','email_verification','phone_verification','account_unlock','pin_reset','device_recovery') NOT NULL,
+  `email` varchar(255) NOT NULL,
+  `token` varchar(10) CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci NOT NULL,
+  -- Wrong codes entered against this token
+  `attempts` int NOT NULL DEFAULT '0',
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `expires_at` datetime NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `verification_tokens`
+--
+ALTER TABLE `verification_tokens`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `email_purpose` (`email`, `purpose`);
+
+ALTER TABLE `verification_tokens`
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT;
@@ -1,77 +1,39 @@
 DROP TABLE IF EXISTS `balances`;
 DROP VIEW IF EXISTS `balances`;
 
+--
+-- Balances are read from the materialised wallet_balances table,
+-- which is kept up to date by ledger postings.
+-- See routines postLedgerEntry and postTransaction
+--
 CREATE OR REPLACE VIEW `balances` AS
 SELECT
     w.wallet_address AS wallet_address,
 
     -- Total received by this wallet
-    COALESCE(
-        SUM(
-            CASE
-                WHEN t.receiver = w.wallet_address THEN t.amount
-                ELSE 0
-            END
-        ),
-        0
-    ) AS total_received,
+    COALESCE(wb.total_received, 0) AS total_received,
 
-    -- Total sent by this wallet
-    COALESCE(
-        SUM(
-            CASE
-                WHEN t.sender = w.wallet_address THEN t.amount
-                ELSE 0
-            END
-        ),
-        0
-    ) AS total_sent,
+    -- Total sent by this wallet, excluding fees
+    COALESCE(wb.total_sent, 0) AS total_sent,
 
     -- Initial deposit
     COALESCE(wl.initial_deposit, 0.0) AS initial_deposit,
 
-    -- Current balance calculation
-    (
-        COALESCE(wl.initial_deposit, 0)
-        + COALESCE(
-            SUM(
-                CASE
-                    WHEN t.receiver = w.wallet_address THEN t.amount
-                    ELSE 0
-                END
-            ),
-            0
-        )
-        - COALESCE(
-            SUM(
-                CASE
-                    WHEN t.sender = w.wallet_address THEN t.amount
-                    ELSE 0
-                END
-            ),
-            0
-        )
-        - COALESCE(
-            SUM(
-                CASE
-                    WHEN t.sender = w.wallet_address THEN t.fee
-                    ELSE 0
-                END
-            ),
-            0
-        )
-    ) AS balance
+    -- Current balance
+    COALESCE(wb.balance, 0) AS balance,
+
+    -- Funds held by pending transactions
+    COALESCE(wb.reserved, 0) AS reserved,
+
+    -- Balance that can be spent
+    COALESCE(wb.balance - wb.reserved, 0) AS available_balance
 
 FROM (
-    SELECT DISTINCT wallet_address FROM wallets
+    SELECT wallet_address FROM wallets
     UNION
-    SELECT DISTINCT wallet_address FROM cash_pools
+    SELECT wallet_address FROM cash_pools
 ) w
-LEFT JOIN transactions t
-    ON (t.sender = w.wallet_address OR t.receiver = w.wallet_address)
-    AND t.status = 'confirmed'
+LEFT JOIN wallet_balances wb
+    ON wb.wallet_address = w.wallet_address
 LEFT JOIN wallets wl
-    ON wl.wallet_address = w.wallet_address
-GROUP BY
-    w.wallet_address;
-
+    ON wl.wallet_address = w.wallet_address;
@@ -1,20 +1,44 @@
+--
+-- Structure for view `transaction_details`
+--
+CREATE
+OR REPLACE VIEW `transaction_details` AS
 SELECT
+    `t`.`id`,
     `t`.`transaction_code`,
-    `s`.`username` AS `sender_username`,
-    `s`.`phone_no` AS `sender_phone`,
-    `s`.`wallet_address` AS `sender_wallet_address`,
-    `r`.`username` AS `receiver_username`,
-    `r`.`phone_no` AS `receiver_phone`,
-    `r`.`wallet_address` AS `receiver_wallet_address`,
+    COALESCE(`s`.`username`, '') AS `sender_username`,
+    COALESCE(`s`.`phone_no`, '') AS `sender_phone`,
+    `t`.`sender` AS `sender_wallet_address`,
+    COALESCE(`r`.`username`, '') AS `receiver_username`,
+    COALESCE(`r`.`phone_no`, '') AS `receiver_phone`,
+    `t`.`receiver` AS `receiver_wallet_address`,
     `t`.`amount`,
     `t`.`fee`,
     `t`.`status`,
+    `t`.`transaction_type`,
     `t`.`timestamp`,
     `sig`.`signature`,
     `sig`.`public_key_hash`,
     `t`.`created_at`
 FROM
     `transactions` `t`
-    LEFT JOIN `wallet_details` `s` ON `t`.`sender` = `s`.`wallet_address`
-    LEFT JOIN `wallet_details` `r` ON `t`.`receiver` = `r`.`wallet_address`
-    LEFT JOIN `signatures` `sig` ON `sig`.`transaction_code` = `t`.`transaction_code`;
\ No newline at end of file
+    -- Wallets with multiple owners are represented by their original owner,
+    -- otherwise we would get one row per wallet owner
+    LEFT JOIN `wallet_details` `s` ON `s`.`wallet_address` = `t`.`sender`
+    AND `s`.`user_id` = (
+        SELECT `user_id` FROM `wallet_owners`
+        WHERE `wallet_address` = `t`.`sender`
+        ORDER BY `id` LIMIT 1
+    )
+    LEFT JOIN `wallet_details` `r` ON `r`.`wallet_address` = `t`.`receiver`
+    AND `r`.`user_id` = (
+        SELECT `user_id` FROM `wallet_owners`
+        WHERE `wallet_address` = `t`.`receiver`
+        ORDER BY `id` LIMIT 1
+    )
+    -- First signature belongs to the transaction's initiator.
+    -- Co-signers' signatures are found in the signatures table
+    LEFT JOIN `signatures` `sig` ON `sig`.`id` = (
+        SELECT MIN(`id`) FROM `signatures`
+        WHERE `transaction_code` = `t`.`transaction_code`
+    );
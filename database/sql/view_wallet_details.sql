@@ -2,16 +2,20 @@ DROP TABLE IF EXISTS `wallet_details`;
 
 DROP VIEW IF EXISTS `wallet_details`;
 
+CREATE OR REPLACE VIEW `wallet_details` AS
 SELECT
     u.id AS user_id,
     u.username AS username,
     u.phone_no AS phone_no,
+    u.phone_verified AS phone_verified,
     w.wallet_address AS wallet_address,
     w.wallet_name AS wallet_name,
     w.initial_deposit AS initial_deposit,
     w.is_active AS is_active,
     w.created_at AS created_at,
-    b.balance AS balance
+    b.balance AS balance,
+    COALESCE(b.reserved, 0) AS reserved,
+    COALESCE(b.available_balance, 0) AS available_balance
 FROM (
     SELECT wallet_address, wallet_name, initial_deposit, is_active, created_at
     FROM wallets
@@ -0,0 +1,27 @@
+DROP TABLE IF EXISTS `wallet_balances`;
+
+--
+-- Table structure for table `wallet_balances`
+--
+-- Materialised wallet balances. Rows are only ever updated by the
+-- postLedgerEntry procedure within the same db transaction that
+-- inserts the ledger entries, so balance always equals the sum of
+-- a wallet's postings.
+-- Reserved holds the amount plus fee of the wallet's pending transfers,
+-- which cannot be spent until they are confirmed or rejected.
+--
+CREATE TABLE `wallet_balances` (
+  `wallet_address` varchar(255) NOT NULL,
+  `balance` decimal(12,2) NOT NULL DEFAULT '0.00',
+  `total_received` decimal(12,2) NOT NULL DEFAULT '0.00',
+  `total_sent` decimal(12,2) NOT NULL DEFAULT '0.00',
+  `total_fees` decimal(12,2) NOT NULL DEFAULT '0.00',
+  `reserved` decimal(12,2) NOT NULL DEFAULT '0.00',
+  `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+--
+-- Indexes for table `wallet_balances`
+--
+ALTER TABLE `wallet_balances`
+  ADD PRIMARY KEY (`wallet_address`);
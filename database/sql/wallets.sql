@@ -18,6 +18,7 @@ CREATE TABLE
       `is_active` tinyint NOT NULL DEFAULT '1',
       `total_owners` tinyint NOT NULL DEFAULT '1',
       `required_signatures` tinyint NOT NULL DEFAULT '1',
+      `approval_window_minutes` int NOT NULL DEFAULT '1440',
       `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP
   ) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4 COLLATE = utf8mb4_0900_ai_ci;
 
@@ -30,4 +31,35 @@ FOR EACH ROW BEGIN
     SET MESSAGE_TEXT="Number of signatures required cannot exceed total number of owners";
   END IF;
 
+  -- Pending transactions expire after the approval window
+  IF NEW.approval_window_minutes < 5 OR NEW.approval_window_minutes > 10080 THEN
+    SIGNAL SQLSTATE '45000'
+    SET MESSAGE_TEXT="Approval window must be between 5 minutes and 7 days";
+  END IF;
+
+END;
+
+CREATE TRIGGER `openWalletBalance` AFTER INSERT ON `wallets`
+FOR EACH ROW BEGIN
+  DECLARE var_equity_wallet VARCHAR(255);
+
+  -- Initial deposit is posted as the wallet's opening balance,
+  -- balanced by a debit against the opening equity wallet.
+  -- System wallets open empty so they never need the equity wallet
+  IF NEW.initial_deposit > 0 THEN
+    CALL getSystemWallet('opening_equity', var_equity_wallet);
+    CALL postLedgerEntry(NULL, NULL, var_equity_wallet, 'debit', NEW.initial_deposit, 'opening_balance');
+  END IF;
+
+  CALL postLedgerEntry(NULL, NULL, NEW.wallet_address, 'credit', NEW.initial_deposit, 'opening_balance');
+END;
+
+CREATE TRIGGER `verifyWalletUpdate` BEFORE UPDATE ON `wallets`
+FOR EACH ROW BEGIN
+
+  IF NEW.approval_window_minutes < 5 OR NEW.approval_window_minutes > 10080 THEN
+    SIGNAL SQLSTATE '45000'
+    SET MESSAGE_TEXT="Approval window must be between 5 minutes and 7 days";
+  END IF;
+
 END;
@@ -0,0 +1,476 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
+)
+
+var (
+	ErrStandingOrderState = errors.New("standing order status does not allow this change")
+	ErrNoScheduledRuns    = errors.New("standing order schedule has no runs")
+)
+
+type StandingOrderExecution struct {
+	TransactionCode string `json:"transaction_code,omitempty"` // Empty if transfer was not created
+
+	// One of confirmed, pending or failed
+	Status     string    `json:"status"`
+	Message    string    `json:"message,omitempty"`
+	ExecutedAt time.Time `json:"executed_at"`
+}
+
+type StandingOrder struct {
+	Id        int64  `json:"-"`
+	OrderCode string `json:"order_code"`
+	UserId    int    `json:"-"`
+	Sender    string `json:"sender"`
+
+	// Wallet address, phone number or cash pool
+	Receiver string  `json:"receiver"`
+	Amount   float64 `json:"amount"`
+
+	// One of daily, weekly, monthly or cron
+	Frequency      string     `json:"frequency"`
+	CronExpression string     `json:"cron_expression,omitempty"`
+	StartsAt       time.Time  `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"`
+	NextRunAt      time.Time  `json:"next_run_at"`
+	RunsCount      int        `json:"runs_count"`
+
+	// One of active, paused, cancelled or completed
+	Status string `json:"status"`
+
+	// Pre-authorised signature used on every execution
+	Timestamp   string `json:"timestamp"`
+	Signature   string `json:"signature"`
+	PublicKeyId string `json:"public_key_hash"`
+
+	// Only fetched with a single standing order; most recent first
+	Executions []*StandingOrderExecution `json:"executions,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Adds months to t, clamping the day to the end of shorter months
+// so that monthly runs on the 31st happen on the last day of the month
+func addMonths(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+// Returns the first scheduled run strictly after t.
+// Returns the zero time if the standing order has no more runs
+func (order StandingOrder) nextRunAfter(t time.Time) (time.Time, error) {
+	startsAt := order.StartsAt.UTC()
+	t = t.UTC()
+
+	var next time.Time
+
+	switch order.Frequency {
+	case "daily", "weekly":
+		period := 24 * time.Hour
+		if order.Frequency == "weekly" {
+			period = 7 * period
+		}
+
+		next = startsAt
+		if !t.Before(startsAt) {
+			runs := t.Sub(startsAt)/period + 1
+			next = startsAt.Add(runs * period)
+		}
+
+	case "monthly":
+		// Start close to t instead of counting months from startsAt
+		months := 0
+		if t.After(startsAt) {
+			months = (t.Year()-startsAt.Year())*12 + int(t.Month()-startsAt.Month()) - 1
+			months = max(months, 0)
+		}
+
+		next = addMonths(startsAt, months)
+		for !next.After(t) {
+			months++
+			next = addMonths(startsAt, months)
+		}
+
+	case "cron":
+		expr, err := utils.ParseCronExpression(order.CronExpression)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		from := t
+		if from.Before(startsAt) {
+			from = startsAt.Add(-time.Nanosecond)
+		}
+
+		next = expr.Next(from)
+		if next.IsZero() {
+			return time.Time{}, nil
+		}
+
+	default:
+		return time.Time{}, errors.New("invalid standing order frequency")
+	}
+
+	if order.EndsAt != nil && next.After(*order.EndsAt) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
+
+// Returns [ErrNoScheduledRuns] if the schedule never runs
+// between startsAt and endsAt
+func CreateStandingOrder(
+	userId int,
+	sender, receiver string,
+	amount float64,
+	frequency string,
+	cronExpression string,
+	startsAt time.Time,
+	endsAt *time.Time,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+	payload *SignedPayload,
+) (*StandingOrder, error) {
+	order := StandingOrder{
+		Frequency:      frequency,
+		CronExpression: cronExpression,
+		StartsAt:       startsAt.UTC().Truncate(time.Second),
+		EndsAt:         endsAt,
+	}
+
+	// First run may be at startsAt itself
+	nextRunAt, err := order.nextRunAfter(order.StartsAt.Add(-time.Nanosecond))
+	if err != nil {
+		return nil, err
+	}
+	if nextRunAt.IsZero() {
+		return nil, ErrNoScheduledRuns
+	}
+
+	var ends any
+	if endsAt != nil {
+		ends = endsAt.UTC()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = useSignature(tx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	orderCode := generateTransactionCode(standingOrder)
+
+	query := `
+	INSERT INTO standing_orders(
+		order_code,
+		user_id,
+		sender,
+		receiver,
+		amount,
+		frequency,
+		cron_expression,
+		starts_at,
+		ends_at,
+		next_run_at,
+		timestamp,
+		signature,
+		public_key_hash
+	) VALUES(?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(
+		query,
+		orderCode,
+		userId,
+		sender,
+		receiver,
+		amount,
+		frequency,
+		cronExpression,
+		order.StartsAt,
+		ends,
+		nextRunAt,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetStandingOrder(orderCode)
+}
+
+const standingOrderColumns = `
+	id,
+	order_code,
+	user_id,
+	sender,
+	receiver,
+	amount,
+	frequency,
+	COALESCE(cron_expression, ''),
+	starts_at,
+	ends_at,
+	next_run_at,
+	runs_count,
+	status,
+	timestamp,
+	signature,
+	public_key_hash,
+	created_at
+`
+
+func scanStandingOrder(row interface{ Scan(...any) error }) (*StandingOrder, error) {
+	var (
+		order  StandingOrder
+		endsAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&order.Id,
+		&order.OrderCode,
+		&order.UserId,
+		&order.Sender,
+		&order.Receiver,
+		&order.Amount,
+		&order.Frequency,
+		&order.CronExpression,
+		&order.StartsAt,
+		&endsAt,
+		&order.NextRunAt,
+		&order.RunsCount,
+		&order.Status,
+		&order.Timestamp,
+		&order.Signature,
+		&order.PublicKeyId,
+		&order.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if endsAt.Valid {
+		order.EndsAt = &endsAt.Time
+	}
+	return &order, nil
+}
+
+func getStandingOrdersWhere(condition string, args ...any) ([]*StandingOrder, error) {
+	query := "SELECT " + standingOrderColumns + " FROM standing_orders WHERE " + condition
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []*StandingOrder{}
+
+	for rows.Next() {
+		order, err := scanStandingOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+// Fetches a standing order along with its most recent executions
+func GetStandingOrder(orderCode string) (*StandingOrder, error) {
+	query := "SELECT " + standingOrderColumns + " FROM standing_orders WHERE order_code= ?"
+	order, err := scanStandingOrder(db.QueryRow(query, orderCode))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT COALESCE(transaction_code, ''), status, COALESCE(message, ''), executed_at
+		FROM standing_order_executions
+		WHERE standing_order_id= ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := db.Query(query, order.Id, DEFAULT_PAGE_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	order.Executions = []*StandingOrderExecution{}
+
+	for rows.Next() {
+		var e StandingOrderExecution
+
+		err := rows.Scan(&e.TransactionCode, &e.Status, &e.Message, &e.ExecutedAt)
+		if err != nil {
+			return nil, err
+		}
+		order.Executions = append(order.Executions, &e)
+	}
+	return order, rows.Err()
+}
+
+// Fetches standing orders paying from the user's wallets
+func GetUserStandingOrders(userId int) ([]*StandingOrder, error) {
+	return getStandingOrdersWhere(`
+		sender IN (SELECT wallet_address FROM wallet_owners WHERE user_id= ?)
+		ORDER BY created_at DESC
+	`, userId)
+}
+
+// Fetches active standing orders whose next run is due
+func GetDueStandingOrders() ([]*StandingOrder, error) {
+	return getStandingOrdersWhere("status= 'active' AND next_run_at <= NOW() ORDER BY next_run_at")
+}
+
+// Claims a due run of a standing order by moving its next run forward.
+// Runs missed while the server was down are skipped.
+// Returns false if the run was already claimed or the order is no longer active
+func ClaimStandingOrderRun(order *StandingOrder) (bool, error) {
+	nextRunAt, err := order.nextRunAfter(time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	// Standing order completes once there are no more runs
+	completed := nextRunAt.IsZero()
+	if completed {
+		nextRunAt = order.NextRunAt
+	}
+
+	query := `
+		UPDATE standing_orders
+		SET
+			next_run_at= ?,
+			runs_count= runs_count + 1,
+			status= IF(?, 'completed', status)
+		WHERE id= ?
+		AND status= 'active'
+		AND next_run_at= ?
+	`
+	result, err := db.Exec(query, nextRunAt.UTC(), completed, order.Id, order.NextRunAt)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func RecordStandingOrderExecution(orderId int64, transactionCode, status, message string) (*StandingOrderExecution, error) {
+	execution := StandingOrderExecution{
+		TransactionCode: transactionCode,
+		Status:          status,
+		Message:         message,
+		ExecutedAt:      time.Now().UTC(),
+	}
+
+	query := `
+		INSERT INTO standing_order_executions(standing_order_id, transaction_code, status, message, executed_at)
+		VALUES(?, NULLIF(?, ''), ?, NULLIF(?, ''), ?)
+	`
+	_, err := db.Exec(query, orderId, transactionCode, status, message, execution.ExecutedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &execution, nil
+}
+
+func setStandingOrderStatus(orderCode string, from string, to string, nextRunAt *time.Time) error {
+	query := `
+		UPDATE standing_orders
+		SET status= ?, next_run_at= COALESCE(?, next_run_at)
+		WHERE order_code= ?
+		AND status= ?
+	`
+	var next any
+	if nextRunAt != nil {
+		next = nextRunAt.UTC()
+	}
+
+	result, err := db.Exec(query, to, next, orderCode, from)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrStandingOrderState
+	}
+	return nil
+}
+
+// Returns [ErrStandingOrderState] if standing order is not active
+func PauseStandingOrder(orderCode string) error {
+	return setStandingOrderStatus(orderCode, "active", "paused", nil)
+}
+
+// Runs missed while the standing order was paused are skipped.
+// Returns [ErrStandingOrderState] if standing order is not paused
+// and [ErrNoScheduledRuns] if it has no runs left
+func ResumeStandingOrder(order *StandingOrder) error {
+	nextRunAt := order.NextRunAt
+
+	if nextRunAt.Before(time.Now()) {
+		var err error
+
+		nextRunAt, err = order.nextRunAfter(time.Now())
+		if err != nil {
+			return err
+		}
+		if nextRunAt.IsZero() {
+			return ErrNoScheduledRuns
+		}
+	}
+
+	return setStandingOrderStatus(order.OrderCode, "paused", "active", &nextRunAt)
+}
+
+// Returns [ErrStandingOrderState] if standing order has already ended
+func CancelStandingOrder(orderCode string) error {
+	query := `
+		UPDATE standing_orders
+		SET status= 'cancelled'
+		WHERE order_code= ?
+		AND status IN ('active', 'paused')
+	`
+	result, err := db.Exec(query, orderCode)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrStandingOrderState
+	}
+	return nil
+}
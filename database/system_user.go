@@ -3,6 +3,8 @@ package database
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +16,22 @@ var (
 	mutex        sync.RWMutex = sync.RWMutex{}
 )
 
+// Purpose of a wallet owned by the system user
+type systemWalletPurpose string
+
+const (
+	// Wallet credited with all transaction fees
+	FEE_REVENUE_WALLET systemWalletPurpose = "fee_revenue"
+
+	// Wallet deposits are paid from and withdrawals paid into.
+	// Mirrors funds held on external payment rails
+	SETTLEMENT_WALLET systemWalletPurpose = "settlement"
+
+	// Wallet debited with every initial deposit so that opening
+	// balances are posted as balanced entries
+	OPENING_EQUITY_WALLET systemWalletPurpose = "opening_equity"
+)
+
 // Signs blob of data using system user's SECRET_KEY.
 // Returns HMAC signature, secret key hash and an error if any
 func signPayload(data []byte) ([]byte, []byte, error) {
@@ -29,6 +47,24 @@ func signPayload(data []byte) ([]byte, []byte, error) {
 	return signature, secretKeyHash[:], nil
 }
 
+// Signs a transfer made on behalf of the system user.
+// Returns base64 encoded signature and secret key hash
+func signSystemTransfer(
+	sender, receiver string,
+	amount, fee float64,
+	timestamp string,
+) (string, string, error) {
+	payload := fmt.Sprintf("%s|%s|%.2f|%.2f|%s", sender, receiver, amount, fee, timestamp)
+	payloadHash := sha256.Sum256([]byte(payload))
+
+	signature, secretKeyHash, err := signPayload(payloadHash[:])
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature),
+		base64.StdEncoding.EncodeToString(secretKeyHash), nil
+}
+
 func CreateSystemUser() error {
 	log.Println("Creating system user...")
 
@@ -66,35 +102,26 @@ func CreateSystemUser() error {
 		return err
 	}
 
-	// Create system wallet
-	walletAddress := generateWalletAddress(bankWallet)
+	// Create system wallets
+	err = createSystemWallet(tx, int(userId), "SYSTEM", "")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	query = `
-		INSERT INTO wallets(
-			wallet_address,
-			wallet_name,
-			initial_deposit,
-			total_owners,
-			required_signatures
-		) VALUES(?, ?, ?, ?, ?)`
-	_, err = tx.Exec(
-		query,
-		walletAddress,
-		"SYSTEM",
-		0.0,
-		1,
-		1,
-	)
+	err = createSystemWallet(tx, int(userId), "FEE_REVENUE", FEE_REVENUE_WALLET)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	// Add owner in wallet_owners table
-	query = `
-		INSERT INTO wallet_owners(wallet_address, user_id)
-		VALUES(?, ?)
-	`
-	_, err = tx.Exec(query, walletAddress, userId)
+	err = createSystemWallet(tx, int(userId), "SETTLEMENT", SETTLEMENT_WALLET)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = createSystemWallet(tx, int(userId), "OPENING_EQUITY", OPENING_EQUITY_WALLET)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -124,3 +151,57 @@ func getSystemUserId() (*int, error) {
 
 	return sysUserId, err
 }
+
+// Creates a bank wallet owned by the system user.
+// Wallets with a purpose are registered in system_wallets
+// so they can be looked up from routines
+func createSystemWallet(tx *sql.Tx, userId int, walletName string, purpose systemWalletPurpose) error {
+	walletAddress := generateWalletAddress(bankWallet)
+
+	query := `
+		INSERT INTO wallets(
+			wallet_address,
+			wallet_name,
+			initial_deposit,
+			total_owners,
+			required_signatures
+		) VALUES(?, ?, ?, ?, ?)`
+	_, err := tx.Exec(
+		query,
+		walletAddress,
+		walletName,
+		0.0,
+		1,
+		1,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Add owner in wallet_owners table
+	query = `
+		INSERT INTO wallet_owners(wallet_address, user_id)
+		VALUES(?, ?)
+	`
+	_, err = tx.Exec(query, walletAddress, userId)
+	if err != nil {
+		return err
+	}
+
+	if purpose == "" {
+		return nil
+	}
+
+	query = "INSERT INTO system_wallets(purpose, wallet_address) VALUES(?, ?)"
+	_, err = tx.Exec(query, purpose, walletAddress)
+	return err
+}
+
+// Fetches the address of a system wallet by its purpose
+func GetSystemWallet(purpose systemWalletPurpose) (string, error) {
+	var walletAddress string
+
+	query := "SELECT wallet_address FROM system_wallets WHERE purpose= ?"
+	err := db.QueryRow(query, purpose).Scan(&walletAddress)
+	return walletAddress, err
+}
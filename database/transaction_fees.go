@@ -1,10 +1,15 @@
 package database
 
 import (
+	"database/sql"
 	"time"
 )
 
+// A fee tier. Amounts between MinAmount and MaxAmount (inclusive)
+// are charged Fee. Where tiers overlap, the tier with the
+// lowest MinAmount applies
 type TransactionFee struct {
+	Id        int64   `json:"id"`
 	MinAmount float64 `json:"min_amount"`
 	MaxAmount float64 `json:"max_amount"`
 	Fee       float64 `json:"fee"`
@@ -42,10 +47,11 @@ func CreateTransactionFees(
 
 func GetAllTransactionFees() ([]TransactionFee, error) {
 	query := `
-		SELECT min_amount, max_amount, fee
+		SELECT id, min_amount, max_amount, fee
 		FROM transaction_fees
 		WHERE effective_from <= NOW()
 		AND (effective_to > NOW() OR effective_to IS NULL)
+		ORDER BY min_amount, id
 	`
 	rows, err := db.Query(query)
 	if err != nil {
@@ -57,6 +63,7 @@ func GetAllTransactionFees() ([]TransactionFee, error) {
 	for rows.Next() {
 		var fee TransactionFee
 		err = rows.Scan(
+			&fee.Id,
 			&fee.MinAmount,
 			&fee.MaxAmount,
 			&fee.Fee,
@@ -72,21 +79,97 @@ func GetAllTransactionFees() ([]TransactionFee, error) {
 }
 
 // Fetches transaction fees by amount from database.
+// Uses the same tier lookup as the getTransactionFeeTier routine
+// that verifies fees on new transactions.
 // Error returned might be [sql.ErrNoRows]
 func GetTransactionFees(amount float64) (*TransactionFee, error) {
 	var t TransactionFee
 
 	query := `
-		SELECT min_amount, max_amount, fee
+		SELECT id, min_amount, max_amount, fee
 		FROM transaction_fees
 		WHERE ? BETWEEN min_amount AND max_amount
-		AND NOW() BETWEEN effective_from AND COALESCE(effective_to, NOW())
+		AND effective_from <= NOW()
+		AND (effective_to > NOW() OR effective_to IS NULL)
+		ORDER BY min_amount, id
 		LIMIT 1
 	`
 	err := db.QueryRow(query, amount).Scan(
+		&t.Id,
 		&t.MinAmount,
 		&t.MaxAmount,
 		&t.Fee,
 	)
 	return &t, err
 }
+
+// Fee revenue collected on a single day from a single fee tier
+type FeeRevenue struct {
+	Day              string  `json:"day"`
+	FeeTierId        int64   `json:"fee_tier_id"`
+	MinAmount        float64 `json:"min_amount"`
+	MaxAmount        float64 `json:"max_amount"`
+	TransactionCount int     `json:"transaction_count"`
+	TotalFees        float64 `json:"total_fees"`
+}
+
+// Reports fees credited into the fee revenue wallet between from and to,
+// grouped by day and fee tier. Newest days first
+func GetFeeRevenue(from, to time.Time) ([]*FeeRevenue, error) {
+	query := `
+		SELECT
+			DATE(le.created_at) AS day,
+			t.fee_tier_id,
+			tf.min_amount,
+			tf.max_amount,
+			COUNT(*),
+			SUM(le.amount)
+		FROM ledger_entries le
+		JOIN system_wallets sw ON sw.wallet_address = le.wallet_address
+		JOIN transactions t ON t.id = le.transaction_id
+		LEFT JOIN transaction_fees tf ON tf.id = t.fee_tier_id
+		WHERE sw.purpose = ?
+		AND le.description = 'fee'
+		AND le.entry_type = 'credit'
+		AND le.created_at >= ? AND le.created_at < ?
+		GROUP BY day, t.fee_tier_id, tf.min_amount, tf.max_amount
+		ORDER BY day DESC, tf.min_amount
+	`
+	rows, err := db.Query(query, FEE_REVENUE_WALLET, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revenue := []*FeeRevenue{}
+
+	for rows.Next() {
+		var (
+			r         FeeRevenue
+			day       time.Time
+			feeTierId sql.NullInt64
+			minAmount sql.NullFloat64
+			maxAmount sql.NullFloat64
+		)
+
+		err := rows.Scan(
+			&day,
+			&feeTierId,
+			&minAmount,
+			&maxAmount,
+			&r.TransactionCount,
+			&r.TotalFees,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Day = day.Format(time.DateOnly)
+		r.FeeTierId = feeTierId.Int64
+		r.MinAmount = minAmount.Float64
+		r.MaxAmount = maxAmount.Float64
+		revenue = append(revenue, &r)
+	}
+
+	return revenue, rows.Err()
+}
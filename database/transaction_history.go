@@ -0,0 +1,212 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_PAGE_SIZE int = 20
+	MAX_PAGE_SIZE     int = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Filters applied when fetching a wallet's transaction history.
+// Zero values are ignored
+type TransactionFilter struct {
+	From            *time.Time
+	To              *time.Time
+	Status          string
+	TransactionType string
+
+	// Wallet address or phone number on the other side of the transaction
+	Counterparty string
+	MinAmount    *float64
+	MaxAmount    *float64
+
+	// Opaque cursor returned as next_cursor by a previous page
+	Cursor string
+	Limit  int
+}
+
+type TransactionPage struct {
+	Transactions []*Transaction `json:"transactions"`
+
+	// Empty when there are no more transactions to fetch
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Cursors point at the last transaction of a page using
+// its created_at time and id
+func encodeCursor(createdAt time.Time, id int64) string {
+	value := fmt.Sprintf("%s|%d", createdAt.UTC().Format(time.RFC3339Nano), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeCursor(cursor string) (time.Time, int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	fields := strings.Split(string(data), "|")
+	if len(fields) != 2 {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+
+	id, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return createdAt, id, nil
+}
+
+// Fetches transactions sent or received by a wallet, newest first.
+// Returns [ErrInvalidCursor] if filter has a malformed cursor
+func GetTransactionHistory(walletAddress string, filter TransactionFilter) (*TransactionPage, error) {
+	conditions := []string{"(sender_wallet_address= ? OR receiver_wallet_address= ?)"}
+	args := []any{walletAddress, walletAddress}
+
+	if filter.Cursor != "" {
+		createdAt, id, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "(created_at < ? OR (created_at = ? AND id < ?))")
+		args = append(args, createdAt, createdAt, id)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.To.UTC())
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.TransactionType != "" {
+		conditions = append(conditions, "transaction_type = ?")
+		args = append(args, filter.TransactionType)
+	}
+	if filter.Counterparty != "" {
+		counterparty := filter.Counterparty
+		if phone, ok := formatPhoneNumber(counterparty); ok {
+			counterparty = phone
+		}
+
+		conditions = append(conditions, `(
+			(sender_wallet_address = ? AND (receiver_wallet_address = ? OR receiver_phone = ?))
+			OR (receiver_wallet_address = ? AND (sender_wallet_address = ? OR sender_phone = ?))
+		)`)
+		args = append(args,
+			walletAddress, counterparty, counterparty,
+			walletAddress, counterparty, counterparty,
+		)
+	}
+	if filter.MinAmount != nil {
+		conditions = append(conditions, "amount >= ?")
+		args = append(args, *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		conditions = append(conditions, "amount <= ?")
+		args = append(args, *filter.MaxAmount)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DEFAULT_PAGE_SIZE
+	}
+	if limit > MAX_PAGE_SIZE {
+		limit = MAX_PAGE_SIZE
+	}
+
+	// Fetch one extra row to find out if there is a next page
+	query := fmt.Sprintf(`
+		SELECT
+			id,
+			transaction_code,
+			sender_username,
+			sender_phone,
+			sender_wallet_address,
+			receiver_username,
+			receiver_phone,
+			receiver_wallet_address,
+			amount,
+			fee,
+			status,
+			transaction_type,
+			timestamp,
+			signature,
+			public_key_hash,
+			created_at
+		FROM transaction_details
+		WHERE %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT %d
+	`, strings.Join(conditions, " AND "), limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := TransactionPage{
+		Transactions: []*Transaction{},
+	}
+	var lastCreatedAt time.Time
+
+	for rows.Next() {
+		var t Transaction
+
+		err := rows.Scan(
+			&t.Id,
+			&t.TransactionCode,
+			&t.Sender.Username,
+			&t.Sender.PhoneNo,
+			&t.Sender.WalletAddress,
+			&t.Receiver.Username,
+			&t.Receiver.PhoneNo,
+			&t.Receiver.WalletAddress,
+			&t.Amount,
+			&t.Fee,
+			&t.Status,
+			&t.TransactionType,
+			&t.Timestamp,
+			&t.Signature,
+			&t.PublicKeyId,
+			&lastCreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.CreatedAt = lastCreatedAt.Format(time.RFC3339Nano)
+
+		if len(page.Transactions) == limit {
+			// Extra row; there is another page after this one
+			last := page.Transactions[limit-1]
+			createdAt, _ := time.Parse(time.RFC3339Nano, last.CreatedAt)
+			page.NextCursor = encodeCursor(createdAt, last.Id)
+			break
+		}
+
+		page.Transactions = append(page.Transactions, &t)
+	}
+
+	return &page, rows.Err()
+}
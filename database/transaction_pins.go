@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrPinNotSet     = errors.New("transaction PIN has not been set")
+	ErrPinAlreadySet = errors.New("transaction PIN has already been set")
+	ErrPinInvalid    = errors.New("invalid transaction PIN")
+)
+
+type TransactionPinStatus struct {
+	IsSet     bool   `json:"is_set"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// Saves the user's first transaction PIN.
+// Returns [ErrPinAlreadySet] if the user already has one
+func SetTransactionPin(userId int, pin string) error {
+	pinHash, err := encrypt.HashPassword(pin)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO transaction_pins(user_id, pin_hash) VALUES(?, ?)"
+	_, err = db.Exec(query, userId, pinHash)
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrPinAlreadySet
+		}
+		return err
+	}
+	return nil
+}
+
+// Replaces the user's transaction PIN.
+// Returns [ErrPinNotSet] if the user has no PIN to replace
+func ChangeTransactionPin(userId int, pin string) error {
+	pinHash, err := encrypt.HashPassword(pin)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE transaction_pins SET pin_hash= ? WHERE user_id= ?"
+	result, err := db.Exec(query, pinHash, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrPinNotSet
+	}
+	return nil
+}
+
+// Checks pin against the user's transaction PIN.
+// Returns [ErrPinNotSet] or [ErrPinInvalid] if it does not match
+func VerifyTransactionPin(userId int, pin string) error {
+	var pinHash string
+
+	query := "SELECT pin_hash FROM transaction_pins WHERE user_id= ?"
+	err := db.QueryRow(query, userId).Scan(&pinHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPinNotSet
+		}
+		return err
+	}
+
+	ok, err := encrypt.VerifyPassword(pin, pinHash)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPinInvalid
+	}
+	return nil
+}
+
+func GetTransactionPinStatus(userId int) (*TransactionPinStatus, error) {
+	var status TransactionPinStatus
+
+	query := "SELECT updated_at FROM transaction_pins WHERE user_id= ?"
+	err := db.QueryRow(query, userId).Scan(&status.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &status, nil
+		}
+		return nil, err
+	}
+
+	status.IsSet = true
+	return &status, nil
+}
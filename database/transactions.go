@@ -2,6 +2,8 @@ package database
 
 import (
 	"crypto/sha256"
+	"database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -12,6 +14,10 @@ const (
 	MIN_TRANSACION_CODE_LEN int = 12
 )
 
+var (
+	ErrAlreadyRefunded = errors.New("transaction has already been refunded")
+)
+
 type WalletOwner struct {
 	UserId        int    `json:"-"`
 	Username      string `json:"username"`
@@ -20,12 +26,14 @@ type WalletOwner struct {
 }
 
 type Transaction struct {
+	Id              int64       `json:"-"`
 	TransactionCode string      `json:"transaction_code"`
 	Sender          WalletOwner `json:"sender"`
 	Receiver        WalletOwner `json:"receiver"`
 	Amount          float64     `json:"amount"`
 	Fee             float64     `json:"fee"`
 	Status          string      `json:"status"`
+	TransactionType string      `json:"transaction_type"`
 
 	// Time when transaction was initiated by client - signed by client
 	Timestamp   string `json:"timestamp"`
@@ -54,9 +62,15 @@ func randomChar() string {
 type transactionType string
 
 const (
-	transfer     transactionType = "TX"
-	requestFunds transactionType = "RX"
-	refund       transactionType = "REF"
+	transfer      transactionType = "TX"
+	requestFunds  transactionType = "RX"
+	refund        transactionType = "REF"
+	dispute       transactionType = "DSP"
+	standingOrder transactionType = "SO"
+	agentTransfer transactionType = "AG"
+	commission    transactionType = "COM"
+	deposit       transactionType = "DEP"
+	withdrawal    transactionType = "WDR"
 )
 
 func generateTransactionCode(transactionTyp transactionType) string {
@@ -86,14 +100,54 @@ func CreateTransaction(
 	timestamp string,
 	b64EncodedSignature string,
 	b64EncodedPublicKeyHash string,
+	payload *SignedPayload,
 ) (*Transaction, error) {
-	transactionCode := generateTransactionCode(transfer)
-
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
 
+	err = useSignature(tx, payload)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	transactionCode, err := insertTransaction(
+		tx,
+		userId,
+		sender, receiver,
+		amount, fee,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return GetTransaction(transactionCode)
+}
+
+// Inserts a transfer and its first signature within db transaction tx.
+// Caller is responsible for committing or rolling back tx.
+// Returns the generated transaction code
+func insertTransaction(
+	tx *sql.Tx,
+	userId int,
+	sender, receiver string,
+	amount, fee float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+) (string, error) {
+	transactionCode := generateTransactionCode(transfer)
+
 	query := `
 	INSERT INTO transactions(
 		transaction_code,
@@ -113,14 +167,12 @@ func CreateTransaction(
 		timestamp,
 	)
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return "", err
 	}
 
 	transactionId, err := result.LastInsertId()
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return "", err
 	}
 
 	query = `
@@ -139,6 +191,39 @@ func CreateTransaction(
 		b64EncodedSignature,
 		b64EncodedPublicKeyHash,
 	)
+	if err != nil {
+		return "", err
+	}
+
+	return transactionCode, nil
+}
+
+// Creates a refund of the transaction with code refundTransactionCode.
+// Returns [ErrAlreadyRefunded] if the transaction has been refunded before
+func CreateRefundTransaction(
+	userId int,
+	refundTransactionCode string,
+	sender, receiver string,
+	amount, fee float64,
+	timestamp string,
+	b64EncodedSignature string,
+	b64EncodedPublicKeyHash string,
+) (*Transaction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	transactionCode, err := insertRefundTransaction(
+		tx,
+		userId,
+		refundTransactionCode,
+		sender, receiver,
+		amount, fee,
+		timestamp,
+		b64EncodedSignature,
+		b64EncodedPublicKeyHash,
+	)
 	if err != nil {
 		tx.Rollback()
 		return nil, err
@@ -151,7 +236,11 @@ func CreateTransaction(
 	return GetTransaction(transactionCode)
 }
 
-func CreateRefundTransaction(
+// Inserts a refund and its signature within db transaction tx.
+// Caller is responsible for committing or rolling back tx.
+// Returns the generated transaction code
+func insertRefundTransaction(
+	tx *sql.Tx,
 	userId int,
 	refundTransactionCode string,
 	sender, receiver string,
@@ -159,14 +248,9 @@ func CreateRefundTransaction(
 	timestamp string,
 	b64EncodedSignature string,
 	b64EncodedPublicKeyHash string,
-) (*Transaction, error) {
+) (string, error) {
 	transactionCode := generateTransactionCode(refund)
 
-	tx, err := db.Begin()
-	if err != nil {
-		return nil, err
-	}
-
 	query := `
 	INSERT IGNORE INTO transactions(
 		refund_transaction_code,
@@ -190,14 +274,22 @@ func CreateRefundTransaction(
 		"refund",
 	)
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return "", err
+	}
+
+	// Refund transaction codes are unique; an ignored insert
+	// means the transaction was already refunded
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if affected == 0 {
+		return "", ErrAlreadyRefunded
 	}
 
 	transactionId, err := result.LastInsertId()
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return "", err
 	}
 
 	query = `
@@ -217,15 +309,10 @@ func CreateRefundTransaction(
 		b64EncodedPublicKeyHash,
 	)
 	if err != nil {
-		tx.Rollback()
-		return nil, err
+		return "", err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return nil, err
-	}
-
-	return GetTransaction(transactionCode)
+	return transactionCode, nil
 }
 
 func GetTransaction(transactionCode string) (*Transaction, error) {
@@ -235,6 +322,7 @@ func GetTransaction(transactionCode string) (*Transaction, error) {
 
 	query := `
 		SELECT
+			id,
 			transaction_code,
 			sender_username,
 			sender_phone,
@@ -245,6 +333,7 @@ func GetTransaction(transactionCode string) (*Transaction, error) {
 			amount,
 			fee,
 			status,
+			transaction_type,
 			timestamp,
 			signature,
 			public_key_hash,
@@ -254,6 +343,7 @@ func GetTransaction(transactionCode string) (*Transaction, error) {
 	`
 	row := db.QueryRow(query, transactionCode)
 	err := row.Scan(
+		&t.Id,
 		&t.TransactionCode,
 		&sender.Username,
 		&sender.PhoneNo,
@@ -264,6 +354,7 @@ func GetTransaction(transactionCode string) (*Transaction, error) {
 		&t.Amount,
 		&t.Fee,
 		&t.Status,
+		&t.TransactionType,
 		&t.Timestamp,
 		&t.Signature,
 		&t.PublicKeyId,
@@ -278,115 +369,13 @@ func GetTransaction(transactionCode string) (*Transaction, error) {
 	return &t, nil
 }
 
+// Fetches the 20 most recent transactions sent or received by a wallet
 func GetRecentTransactions(walletAddress string) ([]*Transaction, error) {
-	query := `
-		SELECT
-			transaction_code,
-			sender_username,
-			sender_phone,
-			sender_wallet_address,
-			receiver_username,
-			receiver_phone,
-			receiver_wallet_address,
-			amount,
-			fee,
-			timestamp,
-			signature,
-			public_key_hash,
-			created_at
-		FROM transaction_details
-		WHERE sender_wallet_address= ?
-		LIMIT 20
-	`
-	rows, err := db.Query(query, walletAddress)
+	page, err := GetTransactionHistory(walletAddress, TransactionFilter{Limit: 20})
 	if err != nil {
 		return nil, err
 	}
-
-	transactions := []*Transaction{}
-
-	for rows.Next() {
-		var t Transaction
-
-		err := rows.Scan(
-			&t.TransactionCode,
-			&t.Sender.Username,
-			&t.Sender.PhoneNo,
-			&t.Sender.WalletAddress,
-			&t.Receiver.Username,
-			&t.Receiver.PhoneNo,
-			&t.Receiver.WalletAddress,
-			&t.Amount,
-			&t.Fee,
-			&t.Timestamp,
-			&t.Signature,
-			&t.PublicKeyId,
-			&t.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		transactions = append(transactions, &t)
-	}
-	return transactions, nil
-}
-
-type RequestFundsResult struct {
-	TransactionCode string  `json:"transaction_code"`
-	Sender          string  `json:"sender"`
-	Receiver        string  `json:"receiver"`
-	Amount          float64 `json:"amount"`
-
-	// Time when transaction was initiated by client
-	Timestamp   string `json:"timestamp"`
-	Signature   string `json:"signature"`
-	PublicKeyId string `json:"public_key_hash"`
-
-	// Time when record was saved to database
-	CreatedAt string `json:"created_at"`
-}
-
-func CreateRequestFunds(
-	sender, receiver string,
-	amount float64,
-	timestamp string,
-	b64EncodedSignature string,
-	b64EncodedPublicKeyHash string,
-) (*RequestFundsResult, error) {
-	transactionCode := generateTransactionCode(requestFunds)
-
-	t := RequestFundsResult{
-		TransactionCode: transactionCode,
-		Sender:          sender,
-		Receiver:        receiver,
-		Amount:          amount,
-		Timestamp:       timestamp,
-		Signature:       b64EncodedSignature,
-		PublicKeyId:     b64EncodedPublicKeyHash,
-	}
-
-	query := `
-	INSERT INTO request_funds(
-		transaction_code,
-		sender,
-		receiver,
-		amount,
-		timestamp,
-		signature,
-		public_key_hash
-	) VALUES(?, ?, ?, ?, ?, ?, ?)`
-	_, err := db.Exec(
-		query,
-		t.TransactionCode,
-		t.Sender,
-		t.Receiver,
-		t.Amount,
-		t.Timestamp,
-		t.Signature,
-		t.PublicKeyId,
-	)
-	return &t, err
+	return page.Transactions, nil
 }
 
 func IsSenderOrReceiver(userId int, transactionCode string) bool {
@@ -402,3 +391,64 @@ func IsSenderOrReceiver(userId int, transactionCode string) bool {
 	db.QueryRow(query, userId, transactionCode).Scan(&ok)
 	return ok
 }
+
+// Rejects pending transactions whose approval window has passed,
+// releasing the funds they reserved.
+// Returns the expired transactions
+func ExpirePendingTransactions() ([]*Transaction, error) {
+	query := `
+		SELECT transaction_code
+		FROM transactions
+		WHERE status = 'pending' AND expires_at <= NOW()
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionCodes := []string{}
+
+	for rows.Next() {
+		var transactionCode string
+		if err := rows.Scan(&transactionCode); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		transactionCodes = append(transactionCodes, transactionCode)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	expired := []*Transaction{}
+
+	for _, transactionCode := range transactionCodes {
+		// Transaction may have been signed since it was fetched
+		query = `
+			UPDATE transactions
+			SET status = 'rejected'
+			WHERE transaction_code = ? AND status = 'pending'
+		`
+		result, err := db.Exec(query, transactionCode)
+		if err != nil {
+			return expired, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return expired, err
+		}
+		if affected == 0 {
+			continue
+		}
+
+		t, err := GetTransaction(transactionCode)
+		if err != nil {
+			return expired, err
+		}
+		expired = append(expired, t)
+	}
+
+	return expired, nil
+}
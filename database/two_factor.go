@@ -0,0 +1,321 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+)
+
+const (
+	BACKUP_CODE_COUNT int = 10
+	BACKUP_CODE_BYTES int = 5
+)
+
+var (
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTOTPCodeInvalid    = errors.New("invalid two-factor code")
+	ErrTOTPCodeReused     = errors.New("two-factor code has already been used")
+)
+
+type TwoFactorStatus struct {
+	Enabled              bool   `json:"enabled"`
+	EnabledAt            string `json:"enabled_at,omitempty"`
+	BackupCodesRemaining int    `json:"backup_codes_remaining"`
+}
+
+// TOTP secrets are encrypted at rest with a key derived from
+// SECRET_KEY, so a copy of the database alone cannot generate codes.
+// Each secret is bound to its user so rows cannot be swapped
+func sealTOTPSecret(userId int, secret string) (string, error) {
+	key := encrypt.DeriveKey(SECRET_KEY, "totp_secret")
+	return encrypt.Seal(key, []byte(secret), fmt.Appendf(nil, "user:%d", userId))
+}
+
+func openTOTPSecret(userId int, sealed string) (string, error) {
+	key := encrypt.DeriveKey(SECRET_KEY, "totp_secret")
+	secret, err := encrypt.Open(key, sealed, fmt.Appendf(nil, "user:%d", userId))
+	if err != nil {
+		return "", fmt.Errorf("error decrypting TOTP secret; %v", err)
+	}
+	return string(secret), nil
+}
+
+// Backup codes are formatted as xxxxx-xxxxx for readability.
+// Dashes, spaces and case are ignored when checking them
+func hashBackupCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// Replaces the user's backup codes within db transaction tx.
+// Returns the new codes in plaintext; only their hashes are stored
+func insertBackupCodes(tx *sql.Tx, userId int) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM backup_codes WHERE user_id= ?", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+
+	for range BACKUP_CODE_COUNT {
+		code, err := randomHex(BACKUP_CODE_BYTES)
+		if err != nil {
+			return nil, err
+		}
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+
+		query := "INSERT INTO backup_codes(user_id, code_hash) VALUES(?, ?)"
+		_, err = tx.Exec(query, userId, hashBackupCode(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// Saves a new secret for the user's authenticator app.
+// The secret is not used until confirmed with [EnableTOTP].
+// Returns [ErrTOTPAlreadyEnabled] if the user already has 2FA
+func SetupTOTP(userId int, secret string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var isEnabled bool
+
+	query := "SELECT enabled_at IS NOT NULL FROM totp_secrets WHERE user_id= ? FOR UPDATE"
+	err = tx.QueryRow(query, userId).Scan(&isEnabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if isEnabled {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	sealedSecret, err := sealTOTPSecret(userId, secret)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO totp_secrets(user_id, secret) VALUES(?, ?)
+		ON DUPLICATE KEY UPDATE secret= VALUES(secret), created_at= NOW()
+	`
+	_, err = tx.Exec(query, userId, sealedSecret)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Checks a TOTP code within db transaction tx and records its period
+// as used. enabled selects whether the user's active or pending
+// secret is checked
+func useTOTPCode(tx *sql.Tx, userId int, code string, enabled bool) error {
+	var (
+		sealedSecret string
+		isEnabled    bool
+		lastUsedStep int64
+	)
+
+	query := `
+		SELECT secret, enabled_at IS NOT NULL, last_used_step
+		FROM totp_secrets
+		WHERE user_id= ?
+		FOR UPDATE
+	`
+	err := tx.QueryRow(query, userId).Scan(&sealedSecret, &isEnabled, &lastUsedStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTOTPNotEnabled
+		}
+		return err
+	}
+
+	if isEnabled != enabled {
+		if isEnabled {
+			return ErrTOTPAlreadyEnabled
+		}
+		return ErrTOTPNotEnabled
+	}
+
+	secret, err := openTOTPSecret(userId, sealedSecret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := encrypt.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return ErrTOTPCodeInvalid
+	}
+	if step <= lastUsedStep {
+		return ErrTOTPCodeReused
+	}
+
+	query = "UPDATE totp_secrets SET last_used_step= ? WHERE user_id= ?"
+	_, err = tx.Exec(query, step, userId)
+	return err
+}
+
+// Turns on 2FA once the user proves their authenticator app
+// generates matching codes. Returns the user's backup codes
+func EnableTOTP(userId int, code string) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = useTOTPCode(tx, userId, code, false)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE totp_secrets SET enabled_at= NOW() WHERE user_id= ?"
+	_, err = tx.Exec(query, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := insertBackupCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func IsTOTPEnabled(userId int) (bool, error) {
+	var enabled bool
+
+	query := "SELECT EXISTS(SELECT 1 FROM totp_secrets WHERE user_id= ? AND enabled_at IS NOT NULL)"
+	err := db.QueryRow(query, userId).Scan(&enabled)
+	return enabled, err
+}
+
+func GetTwoFactorStatus(userId int) (*TwoFactorStatus, error) {
+	query := `
+		SELECT
+			COALESCE((
+				SELECT enabled_at FROM totp_secrets
+				WHERE user_id= ? AND enabled_at IS NOT NULL
+			), ''),
+			(
+				SELECT COUNT(*) FROM backup_codes
+				WHERE user_id= ? AND used_at IS NULL
+			)
+	`
+
+	var status TwoFactorStatus
+
+	err := db.QueryRow(query, userId, userId).Scan(
+		&status.EnabledAt,
+		&status.BackupCodesRemaining,
+	)
+	if err != nil {
+		return nil, err
+	}
+	status.Enabled = status.EnabledAt != ""
+	return &status, nil
+}
+
+// Checks a second factor; either a code from the user's authenticator
+// app or one of their unused backup codes. Each code is only accepted once.
+// Returns [ErrTOTPNotEnabled], [ErrTOTPCodeInvalid] or [ErrTOTPCodeReused]
+func VerifySecondFactor(userId int, code string) error {
+	code = strings.TrimSpace(code)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(code) == encrypt.TOTP_DIGITS {
+		err = useTOTPCode(tx, userId, code, true)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	enabled, err := IsTOTPEnabled(userId)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTOTPNotEnabled
+	}
+
+	query := `
+		UPDATE backup_codes
+		SET used_at= NOW()
+		WHERE user_id= ? AND code_hash= ? AND used_at IS NULL
+	`
+	result, err := tx.Exec(query, userId, hashBackupCode(code))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPCodeInvalid
+	}
+	return tx.Commit()
+}
+
+// Invalidates the user's backup codes and issues new ones
+func RegenerateBackupCodes(userId int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := insertBackupCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Turns off 2FA and removes the user's secret and backup codes
+func DisableTOTP(userId int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM totp_secrets WHERE user_id= ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM backup_codes WHERE user_id= ?", userId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
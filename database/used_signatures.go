@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	ErrSignatureReplayed = errors.New("signed payload has already been used")
+)
+
+// A signed payload that authorises a change. It is marked as used
+// within the same db transaction as the change, so requests that
+// fail do not use up the client's signature
+type SignedPayload struct {
+	UserId    int
+	Hash      []byte // Hash that was signed by the client
+	ExpiresAt time.Time
+}
+
+func insertUsedSignature(
+	exec func(query string, args ...any) (sql.Result, error),
+	userId int,
+	payloadHash []byte,
+	expiresAt time.Time,
+) error {
+	query := `
+		INSERT INTO used_signatures(payload_hash, user_id, expires_at)
+		VALUES(?, ?, ?)
+	`
+	_, err := exec(query, hex.EncodeToString(payloadHash), userId, expiresAt.UTC())
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrSignatureReplayed
+		}
+		return err
+	}
+	return nil
+}
+
+// Marks a signed payload as used.
+// Returns [ErrSignatureReplayed] if payload was used before
+func UseSignature(userId int, payloadHash []byte, expiresAt time.Time) error {
+	return insertUsedSignature(db.Exec, userId, payloadHash, expiresAt)
+}
+
+// Marks payload as used within db transaction tx.
+// A nil payload is not recorded, e.g. for payments a standing
+// order authorised up front
+func useSignature(tx *sql.Tx, payload *SignedPayload) error {
+	if payload == nil {
+		return nil
+	}
+	return insertUsedSignature(tx.Exec, payload.UserId, payload.Hash, payload.ExpiresAt)
+}
+
+func IsSignatureUsed(payloadHash []byte) (bool, error) {
+	var used bool
+	query := "SELECT EXISTS(SELECT 1 FROM used_signatures WHERE payload_hash= ?)"
+	err := db.QueryRow(query, hex.EncodeToString(payloadHash)).Scan(&used)
+	return used, err
+}
+
+func DeleteExpiredSignatures() (int64, error) {
+	result, err := db.Exec("DELETE FROM used_signatures WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
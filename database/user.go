@@ -11,9 +11,10 @@ type User struct {
 	Id            int    `json:"id"`
 	Username      string `json:"username"`
 	Email         string `json:"email"`
-	Password      string `json:"password"`
+	Password      string `json:"-"`
 	PhoneNo       string `json:"phone_no"`
 	EmailVerified bool   `json:"email_verified"`
+	PhoneVerified bool   `json:"phone_verified"`
 	Role          string `json:"role"`
 }
 
@@ -78,6 +79,8 @@ func GetUser(email string) (*User, error) {
 			email,
 			password,
 			phone_no,
+			email_verified,
+			phone_verified,
 			role
 		FROM users WHERE email = ?
 	`
@@ -91,6 +94,8 @@ func GetUser(email string) (*User, error) {
 		&user.Email,
 		&user.Password,
 		&user.PhoneNo,
+		&user.EmailVerified,
+		&user.PhoneVerified,
 		&user.Role,
 	)
 	if err != nil {
@@ -124,6 +129,7 @@ func isEmpty(value string) bool {
 	return strings.TrimSpace(value) == ""
 }
 
+// Unverified phone numbers are not matched
 func GetUserIdFromEmailOrPhoneNo(email, phone string) (int, error) {
 	if !isEmpty(phone) {
 		// Format phone number into INTERNATIONAL format.
@@ -139,7 +145,7 @@ func GetUserIdFromEmailOrPhoneNo(email, phone string) (int, error) {
 
 	query := `
 		SELECT id
-		FROM users WHERE email = ? OR phone_no= ?
+		FROM users WHERE email = ? OR (phone_no= ? AND phone_verified= 1)
 		LIMIT 1
 	`
 
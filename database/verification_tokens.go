@@ -0,0 +1,250 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	MIN_token_LEN int = 6
+
+	// Length of tokens that grant access to an account
+	RECOVERY_TOKEN_LEN int = 8
+
+	// Wrong codes allowed before a token is locked
+	MAX_TOKEN_ATTEMPTS int = 5
+
+	// Minimum time between sending two codes for the same purpose
+	TOKEN_RESEND_INTERVAL time.Duration = 1 * time.Minute
+)
+
+const (
+	PASSWORD_RESET     string = "password_reset"
+	EMAIL_VERIFICATION string = "email_verification"
+	PHONE_VERIFICATION string = "phone_verification"
+	ACCOUNT_UNLOCK     string = "account_unlock"
+	PIN_RESET          string = "pin_reset"
+	DEVICE_RECOVERY    string = "device_recovery"
+)
+
+var (
+	ErrTokenInvalid          = errors.New("invalid or expired token")
+	ErrTokenAttemptsExceeded = errors.New("too many wrong attempts; request a new token")
+	ErrTokenThrottled        = errors.New("a token was sent recently; try again later")
+	ErrPhoneNoTaken          = errors.New("phone number is already verified on another account")
+)
+
+type VerificationToken struct {
+	Id        int       `json:"id"`
+	Purpose   string    `json:"purpose"`
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Tokens grant access to accounts, so digits come from crypto/rand
+func generatetoken(length int) (string, error) {
+	token := ""
+	for range length {
+		rand_digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		token += rand_digit.String()
+	}
+	return token, nil
+}
+
+// Tokens that reset passwords or unlock accounts are longer
+// as they are worth guessing
+func tokenLength(purpose string) int {
+	switch purpose {
+	case PASSWORD_RESET, ACCOUNT_UNLOCK, PIN_RESET, DEVICE_RECOVERY:
+		return RECOVERY_TOKEN_LEN
+	default:
+		return MIN_token_LEN
+	}
+}
+
+// Creates a token for the user with the given email, replacing any
+// token they already have for the same purpose.
+// Returns [ErrTokenThrottled] if a token was created less than
+// [TOKEN_RESEND_INTERVAL] ago
+func CreateVerificationToken(purpose, email string, duration time.Duration) (*VerificationToken, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var recentlySent bool
+
+	query := `
+		SELECT COALESCE(MAX(created_at > NOW() - INTERVAL ? SECOND), 0)
+		FROM verification_tokens
+		WHERE email= ? AND purpose= ?
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, int(TOKEN_RESEND_INTERVAL.Seconds()), email, purpose).Scan(&recentlySent)
+	if err != nil {
+		return nil, err
+	}
+	if recentlySent {
+		return nil, ErrTokenThrottled
+	}
+
+	query = "DELETE FROM verification_tokens WHERE email= ? AND purpose= ?"
+	_, err = tx.Exec(query, email, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := generatetoken(tokenLength(purpose))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	token := VerificationToken{
+		Purpose:   purpose,
+		Email:     email,
+		Token:     code,
+		ExpiresAt: now.Add(duration),
+	}
+
+	query = "INSERT INTO verification_tokens(purpose, email, token, expires_at) VALUES(?, ?, ?, ?)"
+	_, err = tx.Exec(
+		query,
+		purpose,
+		email,
+		token.Token,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Checks a token entered by the user and deletes it if it matches,
+// so it can only be used once. Wrong tokens count against the
+// user's active token until it is locked.
+// Returns [ErrTokenInvalid] or [ErrTokenAttemptsExceeded]
+func ConsumeVerificationToken(purpose, email, token string) error {
+	return checkVerificationToken(purpose, email, token, true)
+}
+
+// Checks a token like [ConsumeVerificationToken] but leaves a matching
+// token in place. Used when further checks must pass before the token
+// is consumed, so failing them does not cost the user their token
+func CheckVerificationToken(purpose, email, token string) error {
+	return checkVerificationToken(purpose, email, token, false)
+}
+
+func checkVerificationToken(purpose, email, token string, consume bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		tokenId     int64
+		activeToken string
+		attempts    int
+	)
+
+	query := `
+		SELECT id, token, attempts
+		FROM verification_tokens
+		WHERE email= ? AND purpose= ? AND expires_at > ?
+		ORDER BY id DESC
+		LIMIT 1
+		FOR UPDATE
+	`
+	err = tx.QueryRow(query, email, purpose, time.Now()).Scan(&tokenId, &activeToken, &attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTokenInvalid
+		}
+		return err
+	}
+
+	if attempts >= MAX_TOKEN_ATTEMPTS {
+		return ErrTokenAttemptsExceeded
+	}
+
+	if subtle.ConstantTimeCompare([]byte(activeToken), []byte(token)) != 1 {
+		query = "UPDATE verification_tokens SET attempts= attempts + 1 WHERE id= ?"
+		_, err = tx.Exec(query, tokenId)
+		if err != nil {
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+		return ErrTokenInvalid
+	}
+
+	if !consume {
+		return nil
+	}
+
+	query = "DELETE FROM verification_tokens WHERE id= ?"
+	_, err = tx.Exec(query, tokenId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func CreatePasswordResetToken(email string, duration time.Duration) (*VerificationToken, error) {
+	return CreateVerificationToken(PASSWORD_RESET, email, duration)
+}
+
+// Marks the user's email as verified if token matches the
+// code sent to their email
+func VerifyEmail(email, token string) error {
+	err := ConsumeVerificationToken(EMAIL_VERIFICATION, email, token)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET email_verified= 1 WHERE email= ?"
+	_, err = db.Exec(query, email)
+	return err
+}
+
+// Marks the user's phone number as verified if token matches the
+// code sent to their phone number
+func VerifyPhoneNo(email, token string) error {
+	err := ConsumeVerificationToken(PHONE_VERIFICATION, email, token)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET phone_verified= 1 WHERE email= ?"
+	_, err = db.Exec(query, email)
+	if err != nil {
+		// MySQL error code 1062 ER_DUP_ENTRY on verified_phone_no
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return ErrPhoneNoTaken
+		}
+		return err
+	}
+	return nil
+}
@@ -18,6 +18,10 @@ type Wallet struct {
 	IsActive       bool    `json:"is_active"`
 	CreatedAt      string  `json:"created_at"`
 	Balance        float64 `json:"balance"`
+
+	// Held by pending transactions until they are confirmed or rejected
+	ReservedBalance  float64 `json:"reserved_balance"`
+	AvailableBalance float64 `json:"available_balance"`
 }
 
 type walletType string
@@ -25,10 +29,16 @@ type walletType string
 const (
 	WALLET_ADDR_LEN int = 12
 
+	// Time co-owners have to sign a pending transaction
+	DEFAULT_APPROVAL_WINDOW_MINUTES int = 24 * 60
+	MIN_APPROVAL_WINDOW_MINUTES     int = 5
+	MAX_APPROVAL_WINDOW_MINUTES     int = 7 * 24 * 60
+
 	bankWallet     walletType = "00"
 	individual     walletType = "11"
 	multiSignature walletType = "22"
 	cashPool       walletType = "33"
+	agentFloat     walletType = "44"
 )
 
 func generateWalletAddress(walletTyp walletType) string {
@@ -54,13 +64,22 @@ func generateWalletAddress(walletTyp walletType) string {
 	return strings.Join(str, "")
 }
 
+// Whether walletAddress belongs to a cash pool
+func IsCashPool(walletAddress string) bool {
+	return strings.HasPrefix(walletAddress, string(cashPool))
+}
+
 func CreateWallet(
 	userId int,
 	walletName string,
-	initialDeposit float64,
 	totalOwners uint,
 	requiredSignatures uint,
+	approvalWindowMinutes int,
 ) (*Wallet, error) {
+	if approvalWindowMinutes == 0 {
+		approvalWindowMinutes = DEFAULT_APPROVAL_WINDOW_MINUTES
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
@@ -76,17 +95,17 @@ func CreateWallet(
 		INSERT INTO wallets(
 			wallet_address,
 			wallet_name,
-			initial_deposit,
 			total_owners,
-			required_signatures
+			required_signatures,
+			approval_window_minutes
 		) VALUES(?, ?, ?, ?, ?)`
 	_, err = tx.Exec(
 		query,
 		walletAddress,
 		walletName,
-		initialDeposit,
 		totalOwners,
 		requiredSignatures,
+		approvalWindowMinutes,
 	)
 	if err != nil {
 		return nil, err
@@ -124,7 +143,9 @@ func GetWallet(userId int, walletAddress string) (*Wallet, error) {
 			initial_deposit,
 			is_active,
 			created_at,
-			balance
+			balance,
+			reserved,
+			available_balance
 		FROM wallet_details
 		WHERE user_id= ? AND wallet_address= ?
 	`
@@ -137,10 +158,14 @@ func GetWallet(userId int, walletAddress string) (*Wallet, error) {
 		&wallet.IsActive,
 		&wallet.CreatedAt,
 		&wallet.Balance,
+		&wallet.ReservedBalance,
+		&wallet.AvailableBalance,
 	)
 	return &wallet, err
 }
 
+// Fetches wallets owned by the user with phone number phone.
+// Unverified phone numbers own no wallets
 func GetWalletsOwnedByPhoneNo(phone string, filter func(*Wallet) bool) ([]*Wallet, error) {
 	num, err := phonenumbers.Parse(phone, "KE")
 	if err != nil {
@@ -158,7 +183,7 @@ func GetWalletsOwnedByPhoneNo(phone string, filter func(*Wallet) bool) ([]*Walle
 			created_at,
 			balance
 		FROM wallet_details
-		WHERE phone_no= ?
+		WHERE phone_no= ? AND phone_verified= 1
 	`
 	rows, err := db.Query(query, phone)
 	if err != nil {
@@ -207,7 +232,9 @@ func GetAllWallets(userId int) ([]*Wallet, error) {
 			initial_deposit,
 			is_active,
 			created_at,
-			balance
+			balance,
+			reserved,
+			available_balance
 		FROM wallet_details
 		WHERE user_id= ?
 	`
@@ -231,6 +258,8 @@ func GetAllWallets(userId int) ([]*Wallet, error) {
 			&wallet.IsActive,
 			&wallet.CreatedAt,
 			&wallet.Balance,
+			&wallet.ReservedBalance,
+			&wallet.AvailableBalance,
 		)
 		if err != nil {
 			return nil, err
@@ -256,6 +285,15 @@ func OwnsWallet(userId int, walletAddress string) bool {
 	return exists
 }
 
+// Sets how long pending transactions from a wallet wait for
+// co-owners' signatures before they are rejected.
+// Only applies to transactions created after the change
+func SetApprovalWindow(walletAddress string, minutes int) error {
+	query := "UPDATE wallets SET approval_window_minutes= ? WHERE wallet_address= ?"
+	_, err := db.Exec(query, minutes, walletAddress)
+	return err
+}
+
 func FreezeWallet(walletAddress string) error {
 	query := "UPDATE wallets SET is_active= 0 WHERE wallet_address= ?"
 	_, err := db.Exec(query, walletAddress)
@@ -287,7 +325,7 @@ func formatPhoneNumber(phone string) (string, bool) {
 }
 
 // Aliases must either be valid emails, phone numbers, wallet addresses
-// or a combination of both
+// or a combination of both. Each user is returned once
 func GetUserIds(aliases ...string) ([]int, error) {
 	if len(aliases) == 0 {
 		return nil, nil
@@ -301,16 +339,16 @@ func GetUserIds(aliases ...string) ([]int, error) {
 	query := fmt.Sprintf(`
 		SELECT id AS user_id
 		FROM users
-		WHERE email IN (%s) OR phone_no IN (%s)
+		WHERE email IN (%s) OR verified_phone_no IN (%s)
 
-		UNION ALL
+		UNION
 
 		SELECT user_id
 		FROM wallet_owners
 		WHERE wallet_address IN (%s)
 	`, placeholders, placeholders, placeholders)
 
-	// args = aliases x3 (for email, phone_no and wallet_address)
+	// args = aliases x3 (for email, verified_phone_no and wallet_address)
 	values := append(aliases, append(aliases, aliases...)...)
 	args := []any{}
 
@@ -1,8 +1,15 @@
 package encrypt
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/argon2"
@@ -71,6 +78,141 @@ func generateArgon2Key(password string, salt []byte) (*Argon2Key, error) {
 	)
 }
 
+// Parses a key encoded by Argon2Key.String
+func ParseArgon2Key(encodedKey string) (*Argon2Key, error) {
+	fields := map[string]string{}
+
+	for _, part := range strings.Split(encodedKey, "$") {
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid Argon2Key field '%v'", part)
+		}
+		fields[name] = value
+	}
+
+	if fields["id"] != "argon2id" {
+		return nil, fmt.Errorf("unsupported key id '%v'", fields["id"])
+	}
+	if fields["version"] != strconv.Itoa(argon2.Version) {
+		return nil, fmt.Errorf("unsupported argon2 version '%v'", fields["version"])
+	}
+
+	memory, err := strconv.ParseUint(fields["memory"], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Argon2Key memory; %v", err)
+	}
+
+	timeTaken, err := strconv.ParseUint(fields["time"], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Argon2Key time; %v", err)
+	}
+
+	threads, err := strconv.ParseUint(fields["threads"], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Argon2Key threads; %v", err)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(fields["salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Argon2Key salt; %v", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(fields["hash"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Argon2Key hash; %v", err)
+	}
+
+	return NewArgon2Key(uint32(memory), uint32(timeTaken), uint8(threads), salt, key)
+}
+
+// Hashes a password with a random salt for storage.
+// Returns the encoded Argon2Key
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key, err := generateArgon2Key(password, salt)
+	if err != nil {
+		return "", err
+	}
+	return key.String(), nil
+}
+
+// Checks a password against a hash from HashPassword
+func VerifyPassword(password string, encodedKey string) (bool, error) {
+	key, err := ParseArgon2Key(encodedKey)
+	if err != nil {
+		return false, err
+	}
+
+	derivedKey := argon2.IDKey(
+		[]byte(password), key.Salt, key.Time, key.Memory, key.Threads, uint32(len(key.Key)),
+	)
+	return subtle.ConstantTimeCompare(derivedKey, key.Key) == 1, nil
+}
+
+// Derives a 32 byte key for one purpose from a server secret, so
+// the same secret can key several uses without reusing keys
+func DeriveKey(secret string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Encrypts plaintext with AES-GCM. additionalData is authenticated
+// but not encrypted; the same data must be passed to Open.
+// Returns the base64 encoded nonce and ciphertext
+func Seal(key, plaintext, additionalData []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypts data encrypted by Seal
+func Open(key []byte, encoded string, additionalData []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
 type KeyReader struct {
 	Key []byte
 }
@@ -0,0 +1,575 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// How often unconfirmed cash-outs are checked for expiry
+	CASH_OUT_SWEEP_INTERVAL time.Duration = 1 * time.Minute
+)
+
+type RegisterAgentRequest struct {
+	Email        string `json:"email" validate:"email"`
+	BusinessName string `json:"business_name" validate:"min=4,max=100"`
+}
+
+type AgentCommissionRequest struct {
+	Operation     string     `json:"operation" validate:"agent_operation"`
+	MinAmount     float64    `json:"min_amount" validate:"min=0"`
+	MaxAmount     float64    `json:"max_amount" validate:"min=0"`
+	Commission    float64    `json:"commission" validate:"min=0"`
+	EffectiveFrom time.Time  `json:"effective_from"`
+	EffectiveTo   *time.Time `json:"effective_to,omitempty"`
+}
+
+type CashOutRequest struct {
+	Customer string  `json:"customer" validate:"account"` // Wallet address or phone number
+	Amount   float64 `json:"amount" validate:"amount"`
+}
+
+// Notifies the customer and the agent when a cash-in or
+// cash-out changes status
+func notifyAgentTransaction(event string, t *database.AgentTransaction) {
+	sendNotification(event, *t, t.CustomerWallet, t.FloatWallet)
+}
+
+// Registers a user as an agent and opens their float wallet
+func RegisterAgent(w http.ResponseWriter, r *http.Request) {
+	admin, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req RegisterAgentRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	user, err := database.GetUser(req.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("User '%v' not found", req.Email))
+			return
+		}
+
+		api.Errorf(w, "Error fetching user", err)
+		return
+	}
+
+	agent, err := database.CreateAgent(user.Id, req.BusinessName, admin.Id)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrAgentExists):
+			api.Conflict(w, "User '%v' is already an agent", req.Email)
+		case errors.Is(err, database.ErrNotEligibleAgent):
+			api.Conflict(w, "Only users with the 'user' role can be registered as agents")
+		default:
+			api.Errorf(w, "Error registering agent", err)
+		}
+		return
+	}
+
+	api.OK2(w, agent)
+}
+
+func GetAgents(w http.ResponseWriter, r *http.Request) {
+	agents, err := database.GetAgents()
+	if err != nil {
+		api.Errorf(w, "Error fetching agents", err)
+		return
+	}
+
+	api.OK2(w, agents)
+}
+
+func setAgentStatus(w http.ResponseWriter, r *http.Request, status string) {
+	agentNumber := chi.URLParam(r, "agent_number")
+
+	_, err := database.GetAgentByNumber(agentNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Agent '%v' not found", agentNumber))
+			return
+		}
+
+		api.Errorf(w, "Error fetching agent", err)
+		return
+	}
+
+	err = database.SetAgentStatus(agentNumber, status)
+	if err != nil {
+		api.Errorf(w, "Error updating agent", err)
+		return
+	}
+
+	api.OK(w, fmt.Sprintf("Agent '%v' is now %v", agentNumber, status))
+}
+
+func SuspendAgent(w http.ResponseWriter, r *http.Request) {
+	setAgentStatus(w, r, "suspended")
+}
+
+func ActivateAgent(w http.ResponseWriter, r *http.Request) {
+	setAgentStatus(w, r, "active")
+}
+
+func CreateAgentCommission(w http.ResponseWriter, r *http.Request) {
+	var req AgentCommissionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", nil)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	err = database.CreateAgentCommission(
+		req.Operation,
+		req.MinAmount, req.MaxAmount, req.Commission,
+		req.EffectiveFrom, req.EffectiveTo,
+	)
+	if err != nil {
+		api.Errorf(w, "Error setting agent commission", err)
+		return
+	}
+
+	api.OK(w, "Agent commission setup correctly")
+}
+
+func GetAgentCommissions(w http.ResponseWriter, r *http.Request) {
+	commissions, err := database.GetAgentCommissions()
+	if err != nil {
+		api.Errorf(w, "Error fetching agent commissions", err)
+		return
+	}
+
+	api.OK2(w, commissions)
+}
+
+// Fetches the agent registered to the logged in user.
+// Writes an error response and returns false if the user is not
+// an active agent
+func getAuthAgent(w http.ResponseWriter, r *http.Request) (*database.User, *database.Agent, bool) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return nil, nil, false
+	}
+
+	agent, err := database.GetAgentByUserId(user.Id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.Unauthorized(w, "You are not registered as an agent")
+			return nil, nil, false
+		}
+
+		api.Errorf(w, "Error fetching agent", err)
+		return nil, nil, false
+	}
+
+	if agent.Status != "active" {
+		api.Unauthorized(w, "Your agent account is suspended")
+		return nil, nil, false
+	}
+	return user, agent, true
+}
+
+func GetAgentProfile(w http.ResponseWriter, r *http.Request) {
+	_, agent, ok := getAuthAgent(w, r)
+	if !ok {
+		return
+	}
+
+	api.OK2(w, agent)
+}
+
+// Pays the agent's commission on a completed agent transaction.
+// Failures are logged; the cash-in or cash-out stands either way
+func payAgentCommission(t *database.AgentTransaction) *database.AgentTransaction {
+	paid, err := database.PayAgentCommission(t)
+	if err != nil {
+		log.Printf("Error paying commission on agent transaction '%v'; %v\n", t.AgentTransactionCode, err)
+		return t
+	}
+	return paid
+}
+
+// Deposits e-money into a customer's wallet in exchange for cash.
+// The agent signs a transfer from their float to the customer.
+// Unlike cash-outs, the customer does not confirm it: only the
+// agent's float is debited, and the agents trigger rejects
+// deposits into wallets the agent owns
+func CashIn(w http.ResponseWriter, r *http.Request) {
+	user, agent, ok := getAuthAgent(w, r)
+	if !ok {
+		return
+	}
+
+	var req TransactionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if req.Sender != agent.FloatWallet {
+		api.BadRequest(w, "Cash-ins are paid from your float wallet", nil)
+		return
+	}
+
+	data := req.Hash()
+
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		api.Unauthorized(w, "Error depositing funds. Signature verification failed")
+		return
+	}
+
+	if req.Amount >= TOTP_STEP_UP_AMOUNT && !requireStepUp(w, r, user) {
+		return
+	}
+
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
+	if !ok {
+		return
+	}
+
+	customerWallet, err := resolveWalletAddress(req.Receiver)
+	if err != nil {
+		uncommittedError(w, r, "Customer has no active wallet accounts", err)
+		return
+	}
+
+	// Check amount and fee are within the float's spending limits
+	ok = database.IsWithinSpendingLimits(req.Sender, req.Amount+req.Fee)
+	if !ok {
+		notifyLimitExceeded(req.Sender, req.Amount+req.Fee)
+		api.Conflict(w, "Wallet exceeded spending limits")
+		return
+	}
+
+	ok, err = isValidTransactionFee(req.Amount, req.Fee)
+	if err != nil {
+		uncommittedError(w, r, "Error fetching transaction fees", err)
+		return
+	}
+	if !ok {
+		api.BadRequest(w, "Invalid transaction fees", nil)
+		return
+	}
+
+	t, err := database.CashIn(
+		agent,
+		customerWallet,
+		req.Amount, req.Fee,
+		req.Timestamp, req.Signature,
+		req.PublicKeyHash,
+		payload,
+	)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+
+		api.Errorf(w, "Error depositing funds", err)
+		return
+	}
+
+	t = payAgentCommission(t)
+	go notifyAgentTransaction(AGENT_TRANSACTION_COMPLETED, t)
+
+	api.OK2(w, t)
+}
+
+// Starts a cash-out. The customer confirms it by signing a
+// transfer into the agent's float
+func RequestCashOut(w http.ResponseWriter, r *http.Request) {
+	_, agent, ok := getAuthAgent(w, r)
+	if !ok {
+		return
+	}
+
+	var req CashOutRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	customerWallet, err := resolveWalletAddress(req.Customer)
+	if err != nil {
+		uncommittedError(w, r, "Customer has no active wallet accounts", err)
+		return
+	}
+
+	t, err := database.RequestCashOut(agent, customerWallet, req.Amount)
+	if err != nil {
+		api.Errorf(w, "Error requesting cash-out", err)
+		return
+	}
+
+	go notifyAgentTransaction(CASH_OUT_REQUESTED, t)
+
+	api.Accepted(w, t)
+}
+
+func GetAgentTransactions(w http.ResponseWriter, r *http.Request) {
+	_, agent, ok := getAuthAgent(w, r)
+	if !ok {
+		return
+	}
+
+	transactions, err := database.GetAgentTransactions(agent.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching agent transactions", err)
+		return
+	}
+
+	api.OK2(w, transactions)
+}
+
+// Fetches the agent's statement for a single UTC day
+// given as YYYY-MM-DD
+func GetAgentStatement(w http.ResponseWriter, r *http.Request) {
+	_, agent, ok := getAuthAgent(w, r)
+	if !ok {
+		return
+	}
+
+	day, err := time.Parse(time.DateOnly, chi.URLParam(r, "day"))
+	if err != nil {
+		api.BadRequest(w, "invalid day; expected YYYY-MM-DD", nil)
+		return
+	}
+
+	statement, err := database.GetAgentStatement(agent, day)
+	if err != nil {
+		api.Errorf(w, "Error fetching agent statement", err)
+		return
+	}
+
+	api.OK2(w, statement)
+}
+
+// Fetches cash-outs waiting on the logged in user to confirm them
+func GetPendingCashOuts(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	transactions, err := database.GetPendingCashOuts(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching pending cash-outs", err)
+		return
+	}
+
+	api.OK2(w, transactions)
+}
+
+// Fetches a cash-out from one of the logged in user's wallets.
+// Writes an error response and returns false if not found
+func getUserCashOut(w http.ResponseWriter, r *http.Request) (*database.User, *database.AgentTransaction, bool) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return nil, nil, false
+	}
+
+	code := chi.URLParam(r, "agent_transaction_code")
+
+	t, err := database.GetAgentTransaction(code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Agent transaction '%v' not found", code))
+			return nil, nil, false
+		}
+
+		api.Errorf(w, "Error fetching agent transaction", err)
+		return nil, nil, false
+	}
+
+	if t.Operation != "cash_out" || !database.OwnsWallet(user.Id, t.CustomerWallet) {
+		api.NotFound(w, fmt.Sprintf("Agent transaction '%v' not found", code))
+		return nil, nil, false
+	}
+	return user, t, true
+}
+
+// Confirms a cash-out by signing a transfer of the requested
+// amount from the customer's wallet into the agent's float
+func ConfirmCashOut(w http.ResponseWriter, r *http.Request) {
+	user, cashOut, ok := getUserCashOut(w, r)
+	if !ok {
+		return
+	}
+
+	var req TransactionRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if req.Sender != cashOut.CustomerWallet ||
+		req.Receiver != cashOut.FloatWallet ||
+		req.Amount != cashOut.Amount {
+		api.BadRequest(w, "Transfer does not match the cash-out", nil)
+		return
+	}
+
+	data := req.Hash()
+
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		api.Unauthorized(w, "Error confirming cash-out. Signature verification failed")
+		return
+	}
+
+	if req.Amount >= TOTP_STEP_UP_AMOUNT && !requireStepUp(w, r, user) {
+		return
+	}
+
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
+	if !ok {
+		return
+	}
+
+	// Check amount and fee are within spending limits
+	ok = database.IsWithinSpendingLimits(req.Sender, req.Amount+req.Fee)
+	if !ok {
+		notifyLimitExceeded(req.Sender, req.Amount+req.Fee)
+		api.Conflict(w, "Wallet exceeded spending limits")
+		return
+	}
+
+	ok, err = isValidTransactionFee(req.Amount, req.Fee)
+	if err != nil {
+		uncommittedError(w, r, "Error fetching transaction fees", err)
+		return
+	}
+	if !ok {
+		api.BadRequest(w, "Invalid transaction fees", nil)
+		return
+	}
+
+	t, err := database.ConfirmCashOut(
+		user.Id,
+		cashOut.AgentTransactionCode,
+		req.Fee,
+		req.Timestamp,
+		req.Signature,
+		req.PublicKeyHash,
+		payload,
+	)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+		if errors.Is(err, database.ErrAgentTransactionClosed) {
+			api.Conflict(w, "Cash-out is no longer pending")
+			return
+		}
+
+		api.Errorf(w, "Error confirming cash-out", err)
+		return
+	}
+
+	t = payAgentCommission(t)
+	go notifyAgentTransaction(AGENT_TRANSACTION_COMPLETED, t)
+
+	api.OK2(w, t)
+}
+
+func DeclineCashOut(w http.ResponseWriter, r *http.Request) {
+	_, cashOut, ok := getUserCashOut(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DeclineCashOut(cashOut.AgentTransactionCode)
+	if err != nil {
+		if errors.Is(err, database.ErrAgentTransactionClosed) {
+			api.Conflict(w, "Cash-out is no longer pending")
+			return
+		}
+
+		api.Errorf(w, "Error declining cash-out", err)
+		return
+	}
+
+	cashOut.Status = "declined"
+	go notifyAgentTransaction(CASH_OUT_DECLINED, cashOut)
+
+	api.OK(w, fmt.Sprintf("Cash-out '%v' declined", cashOut.AgentTransactionCode))
+}
+
+// Periodically expires cash-outs that customers did not confirm in time
+func ExpireAgentCashOuts() {
+	for {
+		<-time.After(CASH_OUT_SWEEP_INTERVAL)
+
+		expired, err := database.ExpireCashOuts()
+		if err != nil {
+			log.Printf("Error expiring cash-outs; %v\n", err)
+			continue
+		}
+
+		for _, t := range expired {
+			notifyAgentTransaction(CASH_OUT_EXPIRED, t)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -13,6 +15,8 @@ import (
 
 const (
 	ENCRYPTED_SEED_PHRASE string = "encrypted_seed_phrase"
+
+	PASSWORD_RESET_TTL time.Duration = 10 * time.Minute
 )
 
 // No json tags as request will be multipart/form-data
@@ -65,6 +69,10 @@ type LoginRequest struct {
 	Email     string `json:"email" validate:"email"`
 	Password  string `json:"password" validate:"password"`
 	PublicKey string `json:"public_key" validate:"public_key"` // Base64 encoded public key in PEM format
+
+	// Code from the user's authenticator app or a backup code.
+	// Only required if the user has 2FA enabled
+	TOTPCode string `json:"totp_code,omitempty"`
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
@@ -80,41 +88,74 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_LOGIN, req.Email) {
+		return
+	}
+
 	user, err := database.GetUser(req.Email)
 	if err != nil {
+		recordAuthFailure(r, database.AUTH_ACTION_LOGIN, req.Email, database.EVENT_LOGIN_FAILED, "unknown email")
 		api.BadRequest(w, "Invalid username or password", nil)
 		return
 	}
 
 	passwordMatch := verifyPassword(user.Password, req.Password)
 	if !passwordMatch {
+		recordAuthFailure(r, database.AUTH_ACTION_LOGIN, req.Email, database.EVENT_LOGIN_FAILED, "wrong password")
 		api.BadRequest(w, "Invalid username or password", nil)
 		return
 	}
 
-	err = database.CreatePublicKey(req.Email, req.PublicKey)
+	// New devices must be enrolled from a trusted device
+	// before they can log in
+	err = database.AuthorizeLoginKey(req.Email, req.PublicKey)
 	if err != nil {
-		api.Errorf(w, "Error logging in user", fmt.Errorf("error creating public key; %v", err))
+		if errors.Is(err, database.ErrPublicKeyNotEnrolled) {
+			api.Unauthorized(w, "This device's public key is not enrolled. Please enroll it from one of your trusted devices")
+			return
+		}
+		if errors.Is(err, database.ErrPublicKeyRevoked) {
+			api.Unauthorized(w, "This device's public key has been revoked")
+			return
+		}
+		api.Errorf(w, "Error logging in user", fmt.Errorf("error authorizing public key; %v", err))
 		return
 	}
 
-	accessToken, err := generateToken(*user)
+	err = verifySecondFactor(user.Id, req.TOTPCode)
 	if err != nil {
-		api.Errorf(w, "Error logging in user", fmt.Errorf("error generating JWT; %v", err))
+		if isInvalidSecondFactor(err) {
+			recordAuthFailure(r, database.AUTH_ACTION_LOGIN, req.Email, database.EVENT_LOGIN_FAILED, err.Error())
+		}
+		writeSecondFactorError(w, err)
 		return
 	}
 
-	// Set access token in user's cookies
-	now := time.Now()
-	expires := now.Add(72 * time.Hour)
+	clearAuthFailures(database.AUTH_ACTION_LOGIN, req.Email)
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    LOGIN_COOKIE,
-		Value:   accessToken,
-		Expires: expires,
-	})
+	newDevice, err := database.IsNewLoginDevice(user, req.PublicKey)
+	if err != nil {
+		// Not worth failing the login over
+		log.Printf("Error checking login device; %v\n", err)
+	}
 
-	api.OK(w, "Login successful")
+	session, refreshToken, err := database.CreateSession(
+		user,
+		req.PublicKey,
+		r.UserAgent(),
+		getClientIp(r),
+		SESSION_TTL,
+	)
+	if err != nil {
+		api.Errorf(w, "Error logging in user", fmt.Errorf("error creating session; %v", err))
+		return
+	}
+
+	if newDevice {
+		go sendNotification(NEW_DEVICE_LOGIN, *session, user.Email)
+	}
+
+	issueTokens(w, session, refreshToken)
 }
 
 type forgotPasswordRequest struct {
@@ -135,6 +176,14 @@ func ForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_PASSWORD_RESET, req.Email) {
+		return
+	}
+
+	// Every request sends an email, so count them against the
+	// client to stop it flooding users' inboxes
+	recordIpAttempt(r, database.AUTH_ACTION_PASSWORD_RESET)
+
 	// If user does not exist we still send a 200 OK response.
 	// this is done to prevent people from searching emails registered with
 	// the system via this route
@@ -144,12 +193,19 @@ func ForgotPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resetToken, err := database.CreatePasswordResetToken(req.Email, 72*time.Hour)
+	resetToken, err := database.CreatePasswordResetToken(req.Email, PASSWORD_RESET_TTL)
 	if err != nil {
+		if errors.Is(err, database.ErrTokenThrottled) {
+			// Token from the previous request is still valid
+			api.OK(w, "Password reset token has been sent to your email")
+			return
+		}
 		api.Errorf(w, "Error creating password reset token", err)
 		return
 	}
 
+	emitSecurityEvent(r, database.EVENT_PASSWORD_RESET_REQUESTED, req.Email, "")
+
 	// Launch this in goroutine so it doesn't delay our main request
 	go sendPasswordResetEmail(req.Email, resetToken.Token)
 
@@ -160,6 +216,9 @@ type passwordResetRequest struct {
 	Email    string `json:"email" validate:"email"`
 	Token    string `json:"token"`
 	Password string `json:"password" validate:"password"`
+
+	// Required if the user has 2FA enabled
+	TOTPCode string `json:"totp_code"`
 }
 
 // WARN: This implementation is risky. If user ever loses access to their
@@ -177,13 +236,53 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	passwordResetToken, err := database.GetPasswordResetToken(req.Email, req.Token)
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_PASSWORD_RESET, req.Email) {
+		return
+	}
+
+	// The token is only consumed once every check has passed,
+	// so a mistyped 2FA code does not cost the user their token
+	err = database.CheckVerificationToken(database.PASSWORD_RESET, req.Email, req.Token)
 	if err != nil {
+		recordAuthFailure(
+			r, database.AUTH_ACTION_PASSWORD_RESET, req.Email,
+			database.EVENT_PASSWORD_RESET_FAILED, err.Error(),
+		)
+
+		if errors.Is(err, database.ErrTokenAttemptsExceeded) {
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOKEN_LOCKED,
+				"Too many wrong attempts. Please request a new token",
+			)
+			return
+		}
 		api.NotFound(w, "Invalid or expired token")
 		return
 	}
 
-	if passwordResetToken.Token != req.Token {
+	user, err := database.GetUser(req.Email)
+	if err != nil {
+		api.NotFound(w, "Invalid or expired token")
+		return
+	}
+
+	// Access to the user's email alone is not enough to take
+	// over an account protected by 2FA
+	err = verifySecondFactor(user.Id, req.TOTPCode)
+	if err != nil {
+		if isInvalidSecondFactor(err) {
+			recordAuthFailure(
+				r, database.AUTH_ACTION_PASSWORD_RESET, req.Email,
+				database.EVENT_PASSWORD_RESET_FAILED, err.Error(),
+			)
+		}
+		writeSecondFactorError(w, err)
+		return
+	}
+
+	// Tokens are deleted once used to prevent re-use
+	err = database.ConsumeVerificationToken(database.PASSWORD_RESET, req.Email, req.Token)
+	if err != nil {
 		api.NotFound(w, "Invalid or expired token")
 		return
 	}
@@ -194,8 +293,16 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Invalidate token to prevent re-use
-	go database.DeletePasswordResetToken(req.Email, req.Token)
+	// Log out every device signed in with the old password
+	_, err = database.RevokeAllSessions(user.Id)
+	if err != nil {
+		log.Printf("Error revoking sessions after password reset; %v\n", err)
+	}
+
+	// A new password also lifts any lockout on the account
+	clearAuthFailures(database.AUTH_ACTION_PASSWORD_RESET, req.Email)
+	clearAuthFailures(database.AUTH_ACTION_LOGIN, req.Email)
+	emitSecurityEvent(r, database.EVENT_PASSWORD_RESET, req.Email, "")
 
 	api.OK(w, "Password reset successful")
 }
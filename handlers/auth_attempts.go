@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+const (
+	ACCOUNT_UNLOCK_TTL          time.Duration = 1 * time.Hour
+	AUTH_ATTEMPTS_CLEANUP_AFTER time.Duration = 24 * time.Hour
+
+	ERR_TOO_MANY_ATTEMPTS string = "TOO_MANY_ATTEMPTS"
+	ERR_ACCOUNT_LOCKED    string = "ACCOUNT_LOCKED"
+)
+
+var (
+	// Failed logins against one account. Each failure past the third
+	// doubles the wait and the tenth locks the account until unlocked
+	ACCOUNT_LOGIN_POLICY = database.AttemptPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    5 * time.Second,
+		MaxDelay:     15 * time.Minute,
+		LockoutAfter: 10,
+		Window:       24 * time.Hour,
+	}
+
+	// Wrong reset or unlock tokens entered for one account
+	ACCOUNT_RECOVERY_POLICY = database.AttemptPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     1 * time.Hour,
+		Window:       24 * time.Hour,
+	}
+
+	// Failures from one client across all accounts. Allows more
+	// attempts as many users may share an IP address
+	IP_POLICY = database.AttemptPolicy{
+		FreeAttempts: 20,
+		BaseDelay:    5 * time.Second,
+		MaxDelay:     1 * time.Hour,
+		Window:       1 * time.Hour,
+	}
+)
+
+type AccountUnlockRequest struct {
+	Email string `json:"email" validate:"email"`
+	Token string `json:"token"`
+}
+
+type LockedAccountRequest struct {
+	Email string `json:"email" validate:"email"`
+}
+
+func accountPolicy(action string) database.AttemptPolicy {
+	if action == database.AUTH_ACTION_LOGIN {
+		return ACCOUNT_LOGIN_POLICY
+	}
+	return ACCOUNT_RECOVERY_POLICY
+}
+
+// Records a security event. Errors are logged
+func emitSecurityEvent(r *http.Request, eventType, email, details string) {
+	ip := getClientIp(r)
+	log.Printf("Security event '%v' for '%v' from %v; %v\n", eventType, email, ip, details)
+
+	err := database.CreateSecurityEvent(eventType, email, ip, details)
+	if err != nil {
+		log.Printf("Error saving security event; %v\n", err)
+	}
+}
+
+// Rejects the request if the account or client IP are blocked
+// by previous failures at action
+func checkAuthThrottle(w http.ResponseWriter, r *http.Request, action, email string) bool {
+	subjects := []struct {
+		scope   string
+		subject string
+	}{
+		{database.AUTH_SCOPE_ACCOUNT, email},
+		{database.AUTH_SCOPE_IP, getClientIp(r)},
+	}
+
+	for _, s := range subjects {
+		attempts, err := database.GetAuthAttempts(action, s.scope, s.subject)
+		if err != nil {
+			api.Errorf(w, "Error checking failed attempts", err)
+			return false
+		}
+
+		if attempts.LockedOut {
+			emitSecurityEvent(r, database.EVENT_LOGIN_THROTTLED, email, "account locked out")
+			api.ErrorWithCode(
+				w, http.StatusLocked, ERR_ACCOUNT_LOCKED,
+				"Account locked after too many failed attempts. Check your email to unlock it",
+			)
+			return false
+		}
+
+		if attempts.RetryAfter > 0 {
+			if action == database.AUTH_ACTION_LOGIN {
+				emitSecurityEvent(r, database.EVENT_LOGIN_THROTTLED, email, s.scope+" throttled")
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(attempts.RetryAfter.Seconds())))
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOO_MANY_ATTEMPTS,
+				fmt.Sprintf("Too many failed attempts. Try again in %v", attempts.RetryAfter),
+			)
+			return false
+		}
+	}
+	return true
+}
+
+// Counts a failed attempt at action against the account and the
+// client IP and emits a security event. Accounts locked out by
+// this failure are emailed an unlock code
+func recordAuthFailure(r *http.Request, action, email, eventType, details string) {
+	emitSecurityEvent(r, eventType, email, details)
+
+	_, lockedOut, err := database.RecordAuthFailure(
+		action, database.AUTH_SCOPE_ACCOUNT, email, accountPolicy(action),
+	)
+	if err != nil {
+		log.Printf("Error recording failed attempt; %v\n", err)
+	}
+
+	if lockedOut {
+		emitSecurityEvent(r, database.EVENT_ACCOUNT_LOCKED, email, "too many failed attempts")
+		go sendAccountUnlockEmail(email)
+	}
+
+	recordIpAttempt(r, action)
+}
+
+// Counts a request against the client IP, e.g. requests that send
+// emails, so one client cannot flood users' inboxes
+func recordIpAttempt(r *http.Request, action string) {
+	_, _, err := database.RecordAuthFailure(
+		action, database.AUTH_SCOPE_IP, getClientIp(r), IP_POLICY,
+	)
+	if err != nil {
+		log.Printf("Error recording failed attempt; %v\n", err)
+	}
+}
+
+func clearAuthFailures(action, email string) {
+	_, err := database.ClearAuthFailures(action, database.AUTH_SCOPE_ACCOUNT, email)
+	if err != nil {
+		log.Printf("Error clearing failed attempts; %v\n", err)
+	}
+}
+
+func sendAccountUnlockEmail(email string) {
+	if !database.UserExists(email) {
+		return
+	}
+
+	token, err := database.CreateVerificationToken(database.ACCOUNT_UNLOCK, email, ACCOUNT_UNLOCK_TTL)
+	if err != nil {
+		if !errors.Is(err, database.ErrTokenThrottled) {
+			log.Printf("Error creating account unlock token; %v\n", err)
+		}
+		return
+	}
+
+	sendEmail(
+		email,
+		"Your account has been locked",
+		"<html>"+
+			"<body style='font-family: Arial, sans-serif;'>"+
+			"<h2>Account Locked</h2>"+
+			"<p>Hello, there</p>"+
+			"<p>Your TapGoPay account was locked after too many failed login attempts. If this was you, use the following One-Time Password (token) to unlock it:</p>"+
+			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>"+token.Token+"</div>"+
+			"<p>This code will expire in <b>1 hour</b>.</p>"+
+			"<p>If this wasn't you, someone may be trying to guess your password. Reset your password once your account is unlocked.</p>"+
+			"<br>"+
+			"<p>Best regards,<br>TapGoPay</p>"+
+			"</body>"+
+			"</html>",
+	)
+}
+
+// Resends the unlock code to a locked out account
+func RequestAccountUnlock(w http.ResponseWriter, r *http.Request) {
+	var req LockedAccountRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_PASSWORD_RESET, req.Email) {
+		return
+	}
+	recordIpAttempt(r, database.AUTH_ACTION_PASSWORD_RESET)
+
+	attempts, err := database.GetAuthAttempts(
+		database.AUTH_ACTION_LOGIN, database.AUTH_SCOPE_ACCOUNT, req.Email,
+	)
+	if err != nil {
+		api.Errorf(w, "Error checking account lockout", err)
+		return
+	}
+
+	// Same response whether or not the account is locked out,
+	// so this route cannot be used to probe accounts
+	if attempts.LockedOut {
+		go sendAccountUnlockEmail(req.Email)
+	}
+
+	api.OK(w, "If your account is locked, an unlock code has been sent to your email")
+}
+
+// Unlocks an account with the code emailed when it was locked out
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	var req AccountUnlockRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_PASSWORD_RESET, req.Email) {
+		return
+	}
+
+	err = database.ConsumeVerificationToken(database.ACCOUNT_UNLOCK, req.Email, req.Token)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenInvalid) || errors.Is(err, database.ErrTokenAttemptsExceeded) {
+			recordAuthFailure(
+				r, database.AUTH_ACTION_PASSWORD_RESET, req.Email,
+				database.EVENT_ACCOUNT_UNLOCK_FAILED, err.Error(),
+			)
+			api.NotFound(w, "Invalid or expired token")
+			return
+		}
+		api.Errorf(w, "Error unlocking account", err)
+		return
+	}
+
+	clearAuthFailures(database.AUTH_ACTION_LOGIN, req.Email)
+	emitSecurityEvent(r, database.EVENT_ACCOUNT_UNLOCKED, req.Email, "unlocked by email")
+
+	api.OK(w, "Account unlocked")
+}
+
+// Lifts an account's lockout and backoff on behalf of its user
+func AdminUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	admin, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req LockedAccountRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	unlocked, err := database.ClearAuthFailures(
+		database.AUTH_ACTION_LOGIN, database.AUTH_SCOPE_ACCOUNT, req.Email,
+	)
+	if err != nil {
+		api.Errorf(w, "Error unlocking account", err)
+		return
+	}
+	if !unlocked {
+		api.NotFound(w, fmt.Sprintf("Account '%v' has no failed logins", req.Email))
+		return
+	}
+
+	emitSecurityEvent(r, database.EVENT_ACCOUNT_UNLOCKED, req.Email, "unlocked by "+admin.Email)
+
+	api.OK(w, "Account unlocked")
+}
+
+// Fetches recent security events. Filter by account with
+// the email query parameter
+func GetSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+
+	events, err := database.GetSecurityEvents(email, database.DEFAULT_PAGE_SIZE)
+	if err != nil {
+		api.Errorf(w, "Error fetching security events", err)
+		return
+	}
+
+	api.OK2(w, events)
+}
+
+// Fetches recent security events on the user's own account
+func GetMySecurityEvents(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	events, err := database.GetSecurityEvents(user.Email, database.DEFAULT_PAGE_SIZE)
+	if err != nil {
+		api.Errorf(w, "Error fetching security events", err)
+		return
+	}
+
+	api.OK2(w, events)
+}
+
+// Periodically forgets old failed attempts
+func DeleteStaleAuthAttempts() {
+	for {
+		<-time.After(AUTH_ATTEMPTS_CLEANUP_AFTER)
+
+		_, err := database.DeleteStaleAuthAttempts(AUTH_ATTEMPTS_CLEANUP_AFTER)
+		if err != nil {
+			log.Printf("Error deleting stale auth attempts; %v\n", err)
+		}
+	}
+}
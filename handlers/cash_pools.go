@@ -94,19 +94,43 @@ func RefundExpiredCashPools() {
 
 		for _, cashPool := range expiredCashPools {
 			go func(pool string) {
+				// Fetch contributors before their deposits are reversed
+				contributors, err := database.GetCashPoolContributors(pool)
+				if err != nil {
+					log.Printf("Error fetching cash pool contributors; %v\n", err)
+				}
+
 				failedRefunds, err := database.RefundExpiredCashPool(pool)
 				if err != nil {
 					log.Printf("Error refunding cash pool; %v\n", err)
+					return
 				}
 
 				log.Printf("%v failed refunds\n", len(failedRefunds))
 				log.Println(failedRefunds)
+
+				if len(failedRefunds) > 0 {
+					return
+				}
+
+				cashPool, err := database.GetCashPool(pool)
+				if err != nil {
+					log.Printf("Error fetching cash pool; %v\n", err)
+					return
+				}
+				sendNotification(POOL_REFUNDED, *cashPool, append(contributors, pool)...)
 			}(cashPool)
 		}
 	}
 }
 
 func RemoveCashPool(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
 	walletAddress := chi.URLParam(r, "wallet_address")
 
 	err := validateWalletAddress(walletAddress)
@@ -115,6 +139,11 @@ func RemoveCashPool(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Removing a pool pays out its deposits
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
 	err = database.RemoveCashPool(walletAddress)
 	if err != nil {
 		api.Errorf(w, "Error removing cash pool", err)
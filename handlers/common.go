@@ -1,17 +1,15 @@
 package handlers
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/smtp"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/caleb-mwasikira/tap_gopay_backend/database"
 	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -19,7 +17,9 @@ import (
 type key string
 
 const (
-	USER_CTX_KEY key = "USER_CTX_KEY"
+	USER_CTX_KEY        key = "USER_CTX_KEY"
+	SESSION_CTX_KEY     key = "SESSION_CTX_KEY"
+	IDEMPOTENCY_CTX_KEY key = "IDEMPOTENCY_CTX_KEY"
 )
 
 var (
@@ -38,15 +38,12 @@ func init() {
 	ANDROID_API_KEY = os.Getenv("ANDROID_API_KEY")
 }
 
-func generateToken(user database.User) (string, error) {
-	data, err := json.Marshal(user)
-	if err != nil {
-		return "", err
-	}
-
-	b64EncodedData := base64.StdEncoding.EncodeToString(data)
+// Issues a short-lived access token for a session.
+// The token only carries the user's id and session id;
+// everything else is read from the database
+func generateToken(userId int, sessionId string) (string, error) {
 	now := time.Now()
-	expiry := now.Add(72 * time.Hour)
+	expiry := now.Add(ACCESS_TOKEN_TTL)
 
 	token := jwt.NewWithClaims(
 		jwt.SigningMethodHS256,
@@ -54,16 +51,17 @@ func generateToken(user database.User) (string, error) {
 			"iat": now.Unix(),
 			"exp": expiry.Unix(),
 			"iss": "fusion",
-			"sub": b64EncodedData,
+			"sub": strconv.Itoa(userId),
+			"sid": sessionId,
 		},
 	)
 	tokenString, err := token.SignedString([]byte(SECRET_KEY))
 	return tokenString, err
 }
 
-// Verifies a json web token and returns the object stored
-// in "sub" subject field. expects obj parameter to be a pointer of type T
-func validToken(tokenString string, obj any) bool {
+// Verifies a json web token and returns the user id and
+// session id stored in it
+func validToken(tokenString string) (int, string, bool) {
 	token, err := jwt.Parse(
 		tokenString,
 		func(token *jwt.Token) (interface{}, error) {
@@ -75,33 +73,37 @@ func validToken(tokenString string, obj any) bool {
 	)
 	if err != nil {
 		log.Printf("Error parsing jwt; %v\n", err)
-		return false
+		return 0, "", false
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		// Get subject - stored as base64 data
-		b64EncodedData, ok := claims["sub"].(string)
-		if !ok {
-			log.Println("Unexpected \"sub\" type in jwt")
-			return false
-		}
-
-		data, err := base64.StdEncoding.DecodeString(b64EncodedData)
-		if err != nil {
-			log.Printf("Error decoding \"sub\" value of jwt; %v\n", err)
-			return false
-		}
-
-		// Unmarshal the data into the param object
-		err = json.Unmarshal(data, obj)
-		return err == nil
+	if !ok || !token.Valid {
+		return 0, "", false
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		log.Printf("Unexpected \"sub\" value in jwt; %v\n", err)
+		return 0, "", false
+	}
+
+	userId, err := strconv.Atoi(subject)
+	if err != nil {
+		log.Printf("Unexpected \"sub\" value in jwt; %v\n", err)
+		return 0, "", false
 	}
 
-	return false
+	sessionId, ok := claims["sid"].(string)
+	if !ok || sessionId == "" {
+		log.Println("Missing \"sid\" in jwt")
+		return 0, "", false
+	}
+
+	return userId, sessionId, true
 }
 
-func sendPasswordResetEmail(email, token string) {
+// Sends an html email. Errors are logged
+func sendEmail(email, subject, html string) {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 	from := os.Getenv("SMTP_EMAIL")
@@ -109,22 +111,11 @@ func sendPasswordResetEmail(email, token string) {
 
 	to := []string{email}
 	message := []byte(
-		"Subject: Reset your password\r\n" +
+		"Subject: " + subject + "\r\n" +
 			"MIME-version: 1.0;\r\n" +
 			"Content-Type: text/html; charset=\"UTF-8\";\r\n" +
 			"\r\n" +
-			"<html>" +
-			"<body style='font-family: Arial, sans-serif;'>" +
-			"<h2>Password Reset Request</h2>" +
-			"<p>Hello, there</p>" +
-			"<p>We received a request to reset your password on your TapGoPay account. Use the following One-Time Password (token) to continue:</p>" +
-			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>" + token + "</div>" +
-			"<p>This code will expire in <b>10 minutes</b>.</p>" +
-			"<p>If you didn't request a password reset, you can safely ignore this email.</p>" +
-			"<br>" +
-			"<p>Best regards,<br>TapGoPay</p>" +
-			"</body>" +
-			"</html>",
+			html,
 	)
 
 	auth := smtp.PlainAuth("", from, password, smtpHost)
@@ -134,3 +125,22 @@ func sendPasswordResetEmail(email, token string) {
 		log.Printf("Error sending email; %v\n", err)
 	}
 }
+
+func sendPasswordResetEmail(email, token string) {
+	sendEmail(
+		email,
+		"Reset your password",
+		"<html>"+
+			"<body style='font-family: Arial, sans-serif;'>"+
+			"<h2>Password Reset Request</h2>"+
+			"<p>Hello, there</p>"+
+			"<p>We received a request to reset your password on your TapGoPay account. Use the following One-Time Password (token) to continue:</p>"+
+			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>"+token+"</div>"+
+			"<p>This code will expire in <b>10 minutes</b>.</p>"+
+			"<p>If you didn't request a password reset, you can safely ignore this email.</p>"+
+			"<br>"+
+			"<p>Best regards,<br>TapGoPay</p>"+
+			"</body>"+
+			"</html>",
+	)
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// Notifies owners of both wallets in a disputed transfer
+// as the dispute changes status
+func notifyDisputeParties(event string, d *database.Dispute) {
+	sendNotification(event, *d, d.Sender, d.Receiver)
+}
+
+// Fetches a dispute by its code.
+// Writes an error response and returns false if dispute is not found
+func getDispute(w http.ResponseWriter, disputeCode string) (*database.Dispute, bool) {
+	d, err := database.GetDispute(disputeCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Dispute '%v' not found", disputeCode))
+			return nil, false
+		}
+
+		api.Errorf(w, "Error fetching dispute", err)
+		return nil, false
+	}
+	return d, true
+}
+
+// Writes the response for a failed change in a dispute's status
+func disputeError(w http.ResponseWriter, message string, err error) {
+	switch {
+	case errors.Is(err, database.ErrDisputeClosed):
+		api.Conflict(w, "Dispute has already been closed")
+	case errors.Is(err, database.ErrAlreadyRefunded):
+		api.Conflict(w, "Transaction has already been refunded")
+	default:
+		api.Errorf(w, message, err)
+	}
+}
+
+// Fetches a dispute after its status changed and notifies both parties
+func disputeUpdated(w http.ResponseWriter, event string, disputeCode string) {
+	d, ok := getDispute(w, disputeCode)
+	if !ok {
+		return
+	}
+
+	go notifyDisputeParties(event, d)
+	api.OK2(w, d)
+}
+
+type OpenDisputeRequest struct {
+	Reason string `json:"reason" validate:"min=3,max=255"`
+}
+
+// Disputes a transfer made from one of the logged in user's wallets
+func OpenDispute(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	transactionCode := chi.URLParam(r, "transaction_code")
+
+	var req OpenDisputeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err = validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	t, err := database.GetTransaction(transactionCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Transaction '%v' not found", transactionCode))
+			return
+		}
+
+		api.Errorf(w, "Error fetching transaction", err)
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, t.Sender.WalletAddress) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	d, err := database.OpenDispute(user.Id, transactionCode, req.Reason)
+	if err != nil {
+		api.Errorf(w, "Error opening dispute", err)
+		return
+	}
+
+	go notifyDisputeParties(DISPUTE_OPENED, d)
+	api.OK2(w, d)
+}
+
+// Fetches disputes on transfers sent or received by the logged in user
+func GetDisputes(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	disputes, err := database.GetUserDisputes(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching disputes", err)
+		return
+	}
+
+	api.OK2(w, disputes)
+}
+
+// Disputes can be viewed by owners of either wallet and by admins
+func GetDispute(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	isParty := database.OwnsWallet(user.Id, d.Sender) || database.OwnsWallet(user.Id, d.Receiver)
+	if !isParty && !database.HasPermission(user.Id, database.PERMISSION_DISPUTES_READ) {
+		api.Unauthorized(w, "You are not a party to this dispute")
+		return
+	}
+
+	api.OK2(w, d)
+}
+
+type DisputeEvidenceRequest struct {
+	Note string `json:"note" validate:"min=1,max=2000"`
+}
+
+// Adds an evidence note to an open dispute.
+// Evidence can be added by owners of either wallet
+func AddDisputeEvidence(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req DisputeEvidenceRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err = validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, d.Sender) && !database.OwnsWallet(user.Id, d.Receiver) {
+		api.Unauthorized(w, "You are not a party to this dispute")
+		return
+	}
+
+	err = database.AddDisputeEvidence(d.Id, user.Id, req.Note)
+	if err != nil {
+		api.Errorf(w, "Error adding dispute evidence", err)
+		return
+	}
+
+	d, ok = getDispute(w, d.DisputeCode)
+	if !ok {
+		return
+	}
+	api.OK2(w, d)
+}
+
+type ResolveDisputeRequest struct {
+	Note string `json:"note" validate:"max=255"` // Optional
+}
+
+// Reads the optional note sent when changing a dispute's status.
+// Writes an error response and returns false if request body is invalid
+func parseResolveDisputeRequest(w http.ResponseWriter, r *http.Request) (*ResolveDisputeRequest, bool) {
+	var req ResolveDisputeRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		api.BadRequest(w, "Error parsing request body", err)
+		return nil, false
+	}
+
+	if err = validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return nil, false
+	}
+	return &req, true
+}
+
+// Voluntary reversal of a disputed transfer by the receiver
+func ApproveDispute(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	req, ok := parseResolveDisputeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, d.Receiver) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	_, err := database.ReverseDispute(d.DisputeCode, user.Id, req.Note)
+	if err != nil {
+		disputeError(w, "Error reversing transaction", err)
+		return
+	}
+
+	disputeUpdated(w, DISPUTE_REVERSED, d.DisputeCode)
+}
+
+// Receiver refuses to reverse a disputed transfer.
+// The dispute is escalated to an admin
+func DeclineDispute(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	req, ok := parseResolveDisputeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, d.Receiver) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	err := database.EscalateDispute(d.DisputeCode, user.Id, req.Note)
+	if err != nil {
+		disputeError(w, "Error declining dispute", err)
+		return
+	}
+
+	disputeUpdated(w, DISPUTE_ESCALATED, d.DisputeCode)
+}
+
+// Withdraws a dispute opened on one of the logged in user's transfers
+func CancelDispute(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	req, ok := parseResolveDisputeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, d.Sender) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	err := database.CancelDispute(d.DisputeCode, user.Id, req.Note)
+	if err != nil {
+		disputeError(w, "Error cancelling dispute", err)
+		return
+	}
+
+	disputeUpdated(w, DISPUTE_CANCELLED, d.DisputeCode)
+}
+
+// Fetches disputes for admin review.
+// Filters by ?status= when provided
+func GetAllDisputes(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	allowedStatus := []string{"", "open", "escalated", "reversed", "rejected", "cancelled"}
+	if !slices.Contains(allowedStatus, status) {
+		api.BadRequest(w, "invalid status. Expects status value to be one of ['open', 'escalated', 'reversed', 'rejected', 'cancelled']", nil)
+		return
+	}
+
+	disputes, err := database.GetDisputesByStatus(status)
+	if err != nil {
+		api.Errorf(w, "Error fetching disputes", err)
+		return
+	}
+
+	api.OK2(w, disputes)
+}
+
+// Forced reversal of a disputed transfer by an admin
+func ForceReverseDispute(w http.ResponseWriter, r *http.Request) {
+	admin, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	req, ok := parseResolveDisputeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	_, err := database.ReverseDispute(d.DisputeCode, admin.Id, req.Note)
+	if err != nil {
+		disputeError(w, "Error reversing transaction", err)
+		return
+	}
+
+	disputeUpdated(w, DISPUTE_REVERSED, d.DisputeCode)
+}
+
+// Closes a dispute without reversing the transfer
+func RejectDispute(w http.ResponseWriter, r *http.Request) {
+	admin, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	req, ok := parseResolveDisputeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	d, ok := getDispute(w, chi.URLParam(r, "dispute_code"))
+	if !ok {
+		return
+	}
+
+	err := database.RejectDispute(d.DisputeCode, admin.Id, req.Note)
+	if err != nil {
+		disputeError(w, "Error rejecting dispute", err)
+		return
+	}
+
+	disputeUpdated(w, DISPUTE_REJECTED, d.DisputeCode)
+}
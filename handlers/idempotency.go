@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER string = "Idempotency-Key"
+
+	// Set on responses replayed from a previous request
+	IDEMPOTENT_REPLAYED_HEADER string = "Idempotent-Replayed"
+
+	MAX_IDEMPOTENCY_KEY_LEN int = 255
+
+	// Time a client can safely retry a request with the same key
+	IDEMPOTENCY_KEY_EXPIRY time.Duration = 24 * time.Hour
+)
+
+// Captures the status and body of a response while writing it to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// Set by handlers behind [Idempotent] to report how a request ended
+type idempotencyState struct {
+	uncommitted bool
+}
+
+// Sends a 500 response for a request that failed before any data was
+// saved. [Idempotent] frees the request's key so the client can retry it.
+// Use api.Errorf once data may have been saved; the key then keeps the
+// error response so a retry cannot process the request twice
+func uncommittedError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	if state, ok := r.Context().Value(IDEMPOTENCY_CTX_KEY).(*idempotencyState); ok {
+		state.uncommitted = true
+	}
+	api.Errorf(w, message, err)
+}
+
+// Hash of the parts of a request that must not change between retries
+func hashRequest(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(r.URL.Path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Makes a route safe to retry. Requests carrying an Idempotency-Key
+// header are processed once; retries with the same key and body get
+// the original response back, retries with a different body are
+// rejected with 409 Conflict.
+// Requests without the header are processed as usual.
+// Must be used after [RequireAuthMiddleware]
+func Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > MAX_IDEMPOTENCY_KEY_LEN {
+			api.BadRequest(w, "Idempotency-Key header too long", nil)
+			return
+		}
+
+		user, ok := getAuthUser(r)
+		if !ok {
+			api.Unauthorized(w, "Access to this route requires user login")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			api.BadRequest(w, "Error reading request body", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestHash := hashRequest(r, body)
+
+		existing, reserved, err := database.ReserveIdempotencyKey(
+			user.Id, key, requestHash,
+			time.Now().Add(IDEMPOTENCY_KEY_EXPIRY),
+		)
+		if err != nil {
+			api.Errorf(w, "Error processing Idempotency-Key", err)
+			return
+		}
+
+		if !reserved {
+			if existing.RequestHash != requestHash {
+				api.Conflict(w, "Idempotency-Key has already been used with a different request")
+				return
+			}
+
+			if existing.Status != "completed" {
+				api.Conflict(w, "A request with this Idempotency-Key is still being processed")
+				return
+			}
+
+			// Replay original response
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set(IDEMPOTENT_REPLAYED_HEADER, "true")
+			w.WriteHeader(existing.ResponseStatus)
+			w.Write(existing.ResponseBody)
+			return
+		}
+
+		state := &idempotencyState{}
+		r = r.WithContext(context.WithValue(r.Context(), IDEMPOTENCY_CTX_KEY, state))
+
+		// A panicking handler never completes the key, which would
+		// otherwise stay stuck in processing until it expires
+		defer func() {
+			if p := recover(); p != nil {
+				releaseIdempotencyKey(user.Id, key)
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// Only failures the handler reports as having saved nothing are
+		// safe to retry with the same key
+		if state.uncommitted {
+			releaseIdempotencyKey(user.Id, key)
+			return
+		}
+
+		err = database.CompleteIdempotencyKey(user.Id, key, rec.status, rec.body.Bytes())
+		if err != nil {
+			log.Printf("Error saving idempotent response; %v\n", err)
+		}
+	})
+}
+
+func releaseIdempotencyKey(userId int, key string) {
+	err := database.ReleaseIdempotencyKey(userId, key)
+	if err != nil {
+		log.Printf("Error releasing Idempotency-Key; %v\n", err)
+	}
+}
+
+// Periodically removes expired idempotency keys
+func DeleteExpiredIdempotencyKeys() {
+	for {
+		<-time.After(IDEMPOTENCY_KEY_EXPIRY)
+
+		_, err := database.DeleteExpiredIdempotencyKeys()
+		if err != nil {
+			log.Printf("Error deleting expired idempotency keys; %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	LEDGER_RECONCILIATION_INTERVAL time.Duration = 1 * time.Hour
+)
+
+// Fetches a wallet's most recent debit and credit postings
+func GetLedgerEntries(w http.ResponseWriter, r *http.Request) {
+	walletAddress := chi.URLParam(r, "wallet_address")
+	if err := validateWalletAddress(walletAddress); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	limit := database.DEFAULT_PAGE_SIZE
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			api.BadRequest(w, "invalid limit; expected a positive number", nil)
+			return
+		}
+	}
+
+	entries, err := database.GetLedgerEntries(walletAddress, limit)
+	if err != nil {
+		api.Errorf(w, "Error fetching ledger entries", err)
+		return
+	}
+
+	api.OK2(w, entries)
+}
+
+// Runs the ledger reconciliation on demand
+func ReconcileLedger(w http.ResponseWriter, r *http.Request) {
+	report, err := database.ReconcileLedger()
+	if err != nil {
+		api.Errorf(w, "Error reconciling ledger", err)
+		return
+	}
+
+	api.OK2(w, report)
+}
+
+func logReconciliationReport(report *database.ReconciliationReport) {
+	if report.Ok() {
+		log.Printf("Ledger reconciled; %v wallets checked\n", report.WalletsChecked)
+		return
+	}
+
+	for _, mismatch := range report.Mismatches {
+		log.Printf(
+			"Ledger mismatch on wallet '%v'; balance %v, ledger balance %v\n",
+			mismatch.WalletAddress, mismatch.Balance, mismatch.LedgerBalance,
+		)
+	}
+	for _, mismatch := range report.ReservedMismatches {
+		log.Printf(
+			"Reserved funds mismatch on wallet '%v'; reserved %v, pending %v\n",
+			mismatch.WalletAddress, mismatch.Reserved, mismatch.Pending,
+		)
+	}
+	for _, t := range report.UnbalancedTransactions {
+		log.Printf(
+			"Unbalanced ledger postings on transaction '%v'; debits %v, credits %v\n",
+			t.TransactionCode, t.Debits, t.Credits,
+		)
+	}
+	if len(report.UnpostedTransactions) > 0 {
+		log.Printf("%v confirmed transactions missing ledger postings\n", len(report.UnpostedTransactions))
+		log.Println(report.UnpostedTransactions)
+	}
+}
+
+// Periodically checks that materialised wallet balances
+// match the ledger
+func ReconcileLedgerPeriodically() {
+	for {
+		<-time.After(LEDGER_RECONCILIATION_INTERVAL)
+
+		report, err := database.ReconcileLedger()
+		if err != nil {
+			log.Printf("Error reconciling ledger; %v\n", err)
+			continue
+		}
+
+		logReconciliationReport(report)
+	}
+}
@@ -13,7 +13,8 @@ import (
 )
 
 const (
-	LOGIN_COOKIE string = "LOGIN_COOKIE"
+	LOGIN_COOKIE   string = "LOGIN_COOKIE"
+	REFRESH_COOKIE string = "REFRESH_COOKIE"
 )
 
 // Restricts access to a route unless client request has embedded
@@ -75,48 +76,51 @@ func RequireAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		var user database.User
-
-		if !validToken(cookieToken, &user) && !validToken(headerToken, &user) {
-			api.Unauthorized(w, "Access to this resource requires user login")
-			return
+		userId, sessionId, ok := validToken(cookieToken)
+		if !ok {
+			userId, sessionId, ok = validToken(headerToken)
 		}
-
-		// Embed user into context
-		newCtx := context.WithValue(r.Context(), USER_CTX_KEY, &user)
-		next.ServeHTTP(w, r.WithContext(newCtx))
-	})
-}
-
-func RequireAdmin(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookieToken, err := getAccessTokenFromCookies(r)
-		headerToken, err2 := getAccessTokenFromHeaders(r)
-
-		if err != nil && err2 != nil {
+		if !ok {
 			api.Unauthorized(w, "Access to this resource requires user login")
 			return
 		}
 
-		var user database.User
-
-		if !validToken(cookieToken, &user) && !validToken(headerToken, &user) {
+		// Sessions can be revoked and roles can change after a token
+		// is issued; always read both from the database
+		user, err := database.GetSessionUser(sessionId, userId)
+		if err != nil {
 			api.Unauthorized(w, "Access to this resource requires user login")
 			return
 		}
 
-		// Check users role
-		if user.Role != "admin" {
-			api.Unauthorized(w, "You are not authorized to access this resource")
-			return
-		}
-
 		// Embed user into context
-		newCtx := context.WithValue(r.Context(), USER_CTX_KEY, &user)
+		newCtx := context.WithValue(r.Context(), USER_CTX_KEY, user)
+		newCtx = context.WithValue(newCtx, SESSION_CTX_KEY, sessionId)
 		next.ServeHTTP(w, r.WithContext(newCtx))
 	})
 }
 
+// Restricts access to a route to users whose role grants permission.
+// Expects user to be embedded in request context by RequireAuthMiddleware
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := getAuthUser(r)
+			if !ok {
+				api.Unauthorized(w, "Access to this resource requires user login")
+				return
+			}
+
+			if !database.HasPermission(user.Id, permission) {
+				api.Unauthorized(w, "You are not authorized to access this resource")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func VerifyWalletOwnership(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, ok := getAuthUser(r)
@@ -141,3 +145,10 @@ func getAuthUser(r *http.Request) (*database.User, bool) {
 	user, ok := value.(*database.User)
 	return user, ok
 }
+
+// Returns the id of the session the request was made with
+func getAuthSession(r *http.Request) (string, bool) {
+	value := r.Context().Value(SESSION_CTX_KEY)
+	sessionId, ok := value.(string)
+	return sessionId, ok
+}
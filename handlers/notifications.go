@@ -1,15 +1,107 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/caleb-mwasikira/tap_gopay_backend/api"
 	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// Time allowed to write a message to a subscriber
+	NOTIFICATION_WRITE_WAIT time.Duration = 10 * time.Second
+
+	// Subscribers that do not answer a ping within this time are dropped
+	NOTIFICATION_PONG_WAIT time.Duration = 60 * time.Second
+
+	// How often subscribers are pinged. Must be less than NOTIFICATION_PONG_WAIT
+	NOTIFICATION_PING_PERIOD time.Duration = NOTIFICATION_PONG_WAIT * 9 / 10
+
+	// Notifications queued per connection. Subscribers that fall
+	// this far behind are dropped and can catch up from GET /notifications
+	NOTIFICATION_QUEUE_SIZE int = 32
+
+	NOTIFICATION_RETENTION         time.Duration = 90 * 24 * time.Hour
+	NOTIFICATIONS_CLEANUP_INTERVAL time.Duration = 24 * time.Hour
+
+	// Version of the notification envelope. Bumped whenever the
+	// envelope or the data of an existing type changes shape
+	NOTIFICATION_VERSION int = 1
+)
+
+// Notification catalogue. Each type is listed with the data its
+// envelope carries
+const (
+	// database.Transaction. Sent to the sender's and the receiver's
+	// owners once a transfer is confirmed
+	MONEY_SENT     string = "money_sent"
+	MONEY_RECEIVED string = "money_received"
+
+	// database.Transaction. Sent to co-owners of a multi-signature
+	// wallet as transactions from the wallet collect signatures
+	SIGNATURE_REQUESTED  string = "signature_requested"
+	SIGNATURE_COMPLETED  string = "signature_completed"
+	TRANSACTION_REJECTED string = "transaction_rejected"
+	TRANSACTION_EXPIRED  string = "transaction_expired"
+
+	// database.CashPool. Sent to the pool's owners and its receiver
+	// when the pool reaches its target, and to the pool's owners
+	// and contributors when an expired pool is refunded
+	POOL_FUNDED   string = "pool_funded"
+	POOL_REFUNDED string = "pool_refunded"
+
+	// SplitBillNotification. Sent to each contributor of a split bill
+	SPLIT_BILL_REQUESTED string = "split_bill_requested"
+
+	// LimitExceededNotification. Sent to a wallet's owners when a
+	// transfer from it is refused for going over its spending limits
+	LIMIT_EXCEEDED string = "limit_exceeded"
+
+	// database.Session. Sent to a user when they log in from a
+	// device they have not logged in from before
+	NEW_DEVICE_LOGIN string = "new_device_login"
+
+	// database.RequestFundsResult. RECEIVED is sent to the wallet asked
+	// to pay, DECLINED to the requester and CANCELLED to the wallet
+	// that was asked to pay
+	REQUEST_FUNDS_RECEIVED  string = "request_funds_received"
+	REQUEST_FUNDS_DECLINED  string = "request_funds_declined"
+	REQUEST_FUNDS_CANCELLED string = "request_funds_cancelled"
+
+	// database.AgentTransaction. Sent to the customer and the agent
+	CASH_OUT_REQUESTED          string = "cash_out_requested"
+	CASH_OUT_DECLINED           string = "cash_out_declined"
+	CASH_OUT_EXPIRED            string = "cash_out_expired"
+	AGENT_TRANSACTION_COMPLETED string = "agent_transaction_completed"
+
+	// database.Dispute. Sent to owners of both wallets in a disputed transfer
+	DISPUTE_OPENED    string = "dispute_opened"
+	DISPUTE_ESCALATED string = "dispute_escalated"
+	DISPUTE_REVERSED  string = "dispute_reversed"
+	DISPUTE_REJECTED  string = "dispute_rejected"
+	DISPUTE_CANCELLED string = "dispute_cancelled"
+
+	// database.Payment. Sent to a wallet's owners when one of its
+	// deposits or withdrawals is settled or failed
+	PAYMENT_SETTLED string = "payment_settled"
+	PAYMENT_FAILED  string = "payment_failed"
+
+	// StandingOrderNotification. Sent to owners of a standing
+	// order's wallet every time it runs
+	STANDING_ORDER_EXECUTED string = "standing_order_executed"
+	STANDING_ORDER_FAILED   string = "standing_order_failed"
+)
+
 var (
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -17,11 +109,143 @@ var (
 			return true
 		},
 	}
-	subscribed = map[int]*websocket.Conn{} // Map of user id to *websocket.Conn
-	mutex      = &sync.RWMutex{}           // Protect websocket subscribed
+	subscribed = map[int]map[*subscriber]bool{} // Map of user id to the user's connections
+	mutex      = &sync.RWMutex{}                // Protect websocket subscribed
+
+	notificationTypes = map[string]bool{
+		MONEY_SENT:                  true,
+		MONEY_RECEIVED:              true,
+		SIGNATURE_REQUESTED:         true,
+		SIGNATURE_COMPLETED:         true,
+		TRANSACTION_REJECTED:        true,
+		TRANSACTION_EXPIRED:         true,
+		POOL_FUNDED:                 true,
+		POOL_REFUNDED:               true,
+		SPLIT_BILL_REQUESTED:        true,
+		LIMIT_EXCEEDED:              true,
+		NEW_DEVICE_LOGIN:            true,
+		REQUEST_FUNDS_RECEIVED:      true,
+		REQUEST_FUNDS_DECLINED:      true,
+		REQUEST_FUNDS_CANCELLED:     true,
+		CASH_OUT_REQUESTED:          true,
+		CASH_OUT_DECLINED:           true,
+		CASH_OUT_EXPIRED:            true,
+		AGENT_TRANSACTION_COMPLETED: true,
+		DISPUTE_OPENED:              true,
+		DISPUTE_ESCALATED:           true,
+		DISPUTE_REVERSED:            true,
+		DISPUTE_REJECTED:            true,
+		DISPUTE_CANCELLED:           true,
+		PAYMENT_SETTLED:             true,
+		PAYMENT_FAILED:              true,
+		STANDING_ORDER_EXECUTED:     true,
+		STANDING_ORDER_FAILED:       true,
+	}
 )
 
-// Notifies subscribed users of received transactions
+// A websocket connection from one of a user's devices.
+// Only writePump writes to conn
+type subscriber struct {
+	userId int
+	conn   *websocket.Conn
+	send   chan []byte
+}
+
+type LimitExceededNotification struct {
+	WalletAddress string  `json:"wallet_address"`
+	Amount        float64 `json:"amount"`
+}
+
+type MarkNotificationsReadRequest struct {
+	// Marks notifications up to and including this id as read.
+	// Zero marks all of them
+	UpToId int64 `json:"up_to_id"`
+}
+
+type MarkNotificationsReadResponse struct {
+	Marked int64 `json:"marked"`
+}
+
+func addSubscriber(s *subscriber) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if subscribed[s.userId] == nil {
+		subscribed[s.userId] = map[*subscriber]bool{}
+	}
+	subscribed[s.userId][s] = true
+}
+
+// Forgets a subscriber and stops its writePump.
+// Safe to call more than once
+func removeSubscriber(s *subscriber) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	conns, ok := subscribed[s.userId]
+	if !ok || !conns[s] {
+		return
+	}
+
+	delete(conns, s)
+	if len(conns) == 0 {
+		delete(subscribed, s.userId)
+	}
+	close(s.send)
+}
+
+// Writes queued notifications and pings to the connection
+func (s *subscriber) writePump() {
+	ticker := time.NewTicker(NOTIFICATION_PING_PERIOD)
+	defer func() {
+		ticker.Stop()
+		s.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(NOTIFICATION_WRITE_WAIT))
+			if !ok {
+				s.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			err := s.conn.WriteMessage(websocket.TextMessage, message)
+			if err != nil {
+				log.Printf("Error writing notification to user %v; %v\n", s.userId, err)
+				return
+			}
+
+		case <-ticker.C:
+			s.conn.SetWriteDeadline(time.Now().Add(NOTIFICATION_WRITE_WAIT))
+			if err := s.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Reads from the connection until it closes or stops answering
+// pings. Clients are not expected to send anything but pongs
+func (s *subscriber) readPump() {
+	defer removeSubscriber(s)
+
+	s.conn.SetReadLimit(512)
+	s.conn.SetReadDeadline(time.Now().Add(NOTIFICATION_PONG_WAIT))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(NOTIFICATION_PONG_WAIT))
+	})
+
+	for {
+		if _, _, err := s.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Notifies subscribed users of received transactions.
+// Users may subscribe from several devices at once
 func SubscribeNotifications(w http.ResponseWriter, r *http.Request) {
 	user, ok := getAuthUser(r)
 	if !ok {
@@ -36,37 +260,258 @@ func SubscribeNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mutex.Lock()
-	subscribed[user.Id] = conn
-	mutex.Unlock()
+	s := &subscriber{
+		userId: user.Id,
+		conn:   conn,
+		send:   make(chan []byte, NOTIFICATION_QUEUE_SIZE),
+	}
+	addSubscriber(s)
+
+	go s.writePump()
+	s.readPump()
+}
+
+// Queues a message on all of the users' connections.
+// Connections too far behind to take it are dropped
+func deliverNotification(userIds []int, message []byte) {
+	slow := []*subscriber{}
+
+	mutex.RLock()
+	for _, userId := range userIds {
+		for s := range subscribed[userId] {
+			select {
+			case s.send <- message:
+			default:
+				slow = append(slow, s)
+			}
+		}
+	}
+	mutex.RUnlock()
+
+	for _, s := range slow {
+		log.Printf("Dropping slow notification subscriber for user %v\n", s.userId)
+		removeSubscriber(s)
+	}
 }
 
-// Sends notifications to receivers if they are subscribed to
-// receiving notification messages.
+// Saves a notification of eventType for each receiver and sends it
+// to those that are subscribed. Offline receivers can fetch it later.
+// data must match the type's entry in the notification catalogue.
 // Receivers must either be valid emails, phone numbers, wallet addresses
 // or a combination of both
-func sendNotification[T any](message T, receivers ...string) {
+func sendNotification[T any](eventType string, data T, receivers ...string) {
+	if !notificationTypes[eventType] {
+		log.Printf("Ignoring notification of unknown type '%v'\n", eventType)
+		return
+	}
+
 	userIds, err := database.GetUserIds(receivers...)
 	if err != nil {
 		log.Printf("Error fetching receivers user ids; %v\n", err)
 		return
 	}
 
-	conns := []*websocket.Conn{}
+	payload, err := json.Marshal(&data)
+	if err != nil {
+		log.Printf("Error encoding notification; %v\n", err)
+		return
+	}
 
-	mutex.RLock()
-	for _, userId := range userIds {
-		conn, ok := subscribed[userId]
-		if ok {
-			conns = append(conns, conn)
+	notifications, err := database.CreateNotifications(userIds, NOTIFICATION_VERSION, eventType, payload)
+	if err != nil {
+		// Still deliver to users who are online. Without an id
+		// they cannot mark it as read or resume from it
+		log.Printf("Error saving notification; %v\n", err)
+
+		notifications = nil
+		for range userIds {
+			notifications = append(notifications, &database.Notification{
+				Version:   NOTIFICATION_VERSION,
+				Type:      eventType,
+				CreatedAt: time.Now().UTC().Truncate(time.Second),
+				Data:      payload,
+			})
 		}
 	}
-	mutex.RUnlock()
 
-	for _, conn := range conns {
-		err := conn.WriteJSON(&message)
+	// Each user gets their own copy as ids differ between users
+	for i, n := range notifications {
+		message, err := json.Marshal(n)
+		if err != nil {
+			log.Printf("Error encoding notification; %v\n", err)
+			return
+		}
+		deliverNotification([]int{userIds[i]}, message)
+	}
+}
+
+// Notifies the owners of both wallets in a confirmed transfer.
+// Pending transfers are announced to co-owners as signature
+// requests instead
+func notifyTransfer(t *database.Transaction) {
+	if t.Status != "confirmed" {
+		return
+	}
+
+	sendNotification(MONEY_SENT, *t, t.Sender.WalletAddress)
+	sendNotification(MONEY_RECEIVED, *t, t.Receiver.WalletAddress)
+
+	if !database.IsCashPool(t.Receiver.WalletAddress) {
+		return
+	}
+
+	pool, err := database.GetCashPool(t.Receiver.WalletAddress)
+	if err != nil {
+		log.Printf("Error fetching cash pool '%v'; %v\n", t.Receiver.WalletAddress, err)
+		return
+	}
+
+	// Only the transfer that takes the pool past its target announces it
+	if pool.CollectedAmount >= pool.TargetAmount && pool.CollectedAmount-t.Amount < pool.TargetAmount {
+		sendNotification(POOL_FUNDED, *pool, pool.WalletAddress, pool.Receiver.WalletAddress)
+	}
+}
+
+// Tells a wallet's owners that a transfer was refused for
+// going over the wallet's spending limits
+func notifyLimitExceeded(walletAddress string, amount float64) {
+	notification := LimitExceededNotification{
+		WalletAddress: walletAddress,
+		Amount:        amount,
+	}
+	go sendNotification(LIMIT_EXCEEDED, notification, walletAddress)
+}
+
+func parseNotificationFilter(r *http.Request) (*database.NotificationFilter, error) {
+	query := r.URL.Query()
+	filter := database.NotificationFilter{
+		Type:       query.Get("type"),
+		UnreadOnly: query.Get("unread") == "true",
+	}
+
+	if filter.Type != "" && !notificationTypes[filter.Type] {
+		return nil, fmt.Errorf("invalid type; unknown notification type '%v'", filter.Type)
+	}
+
+	params := []struct {
+		name  string
+		value *int64
+	}{
+		{"since", &filter.Since},
+		{"before", &filter.Before},
+	}
+
+	for _, param := range params {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid %v; expected a notification id", param.name)
+		}
+		*param.value = id
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit; expected a positive number")
+		}
+		filter.Limit = limit
+	}
+	return &filter, nil
+}
+
+// Lists the logged in user's notifications, newest first.
+// Reconnecting clients pass the id of the last notification they
+// saw as ?since= to fetch the ones they missed, oldest first
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	filter, err := parseNotificationFilter(r)
+	if err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	page, err := database.GetNotifications(user.Id, *filter)
+	if err != nil {
+		api.Errorf(w, "Error fetching notifications", err)
+		return
+	}
+
+	api.OK2(w, page)
+}
+
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	notificationId, err := strconv.ParseInt(chi.URLParam(r, "notification_id"), 10, 64)
+	if err != nil {
+		api.BadRequest(w, "Invalid notification id", nil)
+		return
+	}
+
+	err = database.MarkNotificationRead(user.Id, notificationId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, "Notification not found")
+			return
+		}
+		api.Errorf(w, "Error marking notification as read", err)
+		return
+	}
+
+	api.OK(w, "Notification marked as read")
+}
+
+func MarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req MarkNotificationsReadRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	marked, err := database.MarkAllNotificationsRead(user.Id, req.UpToId)
+	if err != nil {
+		api.Errorf(w, "Error marking notifications as read", err)
+		return
+	}
+
+	api.OK2(w, MarkNotificationsReadResponse{Marked: marked})
+}
+
+// Periodically removes old notifications
+func DeleteOldNotifications() {
+	for {
+		<-time.After(NOTIFICATIONS_CLEANUP_INTERVAL)
+
+		_, err := database.DeleteOldNotifications(NOTIFICATION_RETENTION)
 		if err != nil {
-			log.Printf("Error notifying wallet owners of transaction; %v\n", err)
+			log.Printf("Error deleting old notifications; %v\n", err)
 		}
 	}
 }
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/payments"
+	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// Time the fake payment rail takes to settle operations
+	FAKE_RAIL_SETTLEMENT_DELAY time.Duration = 500 * time.Millisecond
+
+	PAYMENT_CALLBACK_QUEUE_SIZE int = 100
+
+	ERR_CALLBACK_QUEUE_FULL string = "CALLBACK_QUEUE_FULL"
+)
+
+var (
+	// Callbacks received from payment rails, waiting to be applied
+	paymentCallbacks = make(chan payments.Callback, PAYMENT_CALLBACK_QUEUE_SIZE)
+)
+
+func init() {
+	utils.LoadDotenv()
+
+	// The fake rail settles deposits without pulling any real money,
+	// so it is only available when explicitly enabled for development
+	if os.Getenv("ENABLE_FAKE_PAYMENT_RAIL") == "true" {
+		log.Println("WARNING: Fake payment rail enabled. Never enable it in production")
+		RegisterFakeRail()
+	}
+}
+
+// Makes the fake payment rail available for deposits and withdrawals.
+// Used in development and tests in place of real payment rails
+func RegisterFakeRail() {
+	payments.Register(payments.NewFakeRail(FAKE_RAIL_SETTLEMENT_DELAY, deliverFakeCallback))
+}
+
+// The fake rail delivers callbacks from its own goroutine and never
+// retries them, so it waits for room in the queue
+func deliverFakeCallback(callback payments.Callback) {
+	paymentCallbacks <- callback
+}
+
+type DepositRequest struct {
+	WalletAddress string  `json:"wallet_address" validate:"wallet_address"`
+	Rail          string  `json:"rail" validate:"min=1,max=32"`
+	Account       string  `json:"account" validate:"min=4,max=64"` // Account on the rail to pull funds from
+	Amount        float64 `json:"amount" validate:"amount"`
+}
+
+type WithdrawalRequest struct {
+	WalletAddress string  `json:"wallet_address" validate:"wallet_address"`
+	Rail          string  `json:"rail" validate:"min=1,max=32"`
+	Account       string  `json:"account" validate:"min=4,max=64"` // Account on the rail to pay funds into
+	Amount        float64 `json:"amount" validate:"amount"`
+	Timestamp     string  `json:"timestamp"` // Time when withdrawal was initiated by the client
+
+	Signature string `json:"signature" validate:"signature"` // Base64 encoded signature
+
+	// Base64 encoded hash of public key
+	// that should be used to verify signature
+	PublicKeyHash string `json:"public_key_hash" validate:"public_key_hash"`
+}
+
+func (req WithdrawalRequest) Hash() []byte {
+	data := fmt.Sprintf("%s|%s|%s|%.2f|%s", req.WalletAddress, req.Rail, req.Account, req.Amount, req.Timestamp)
+	h := sha256.Sum256([]byte(data))
+	return h[:]
+}
+
+// Queues a payment rail callback to be applied by
+// ProcessPaymentCallbacks.
+// Returns false without waiting if the queue is full
+func enqueuePaymentCallback(callback payments.Callback) bool {
+	select {
+	case paymentCallbacks <- callback:
+		return true
+	default:
+		return false
+	}
+}
+
+func getPaymentRail(w http.ResponseWriter, name string) (payments.PaymentRail, bool) {
+	rail, ok := payments.Get(name)
+	if !ok {
+		api.BadRequest(w, fmt.Sprintf("Unsupported payment rail. Expects rail to be one of %v", payments.Names()), nil)
+		return nil, false
+	}
+	return rail, true
+}
+
+// Hands a recorded payment over to its rail.
+// Payments the rail refuses are failed straight away
+func submitPayment(rail payments.PaymentRail, payment *database.Payment) (*database.Payment, error) {
+	providerReference, err := rail.Submit(payments.Request{
+		Reference: payment.PaymentCode,
+		Operation: payment.Operation,
+		Account:   payment.ExternalAccount,
+		Amount:    payment.Amount,
+	})
+	if err != nil {
+		failed, failErr := database.FailPayment(payment.PaymentCode, rail.Name(), err.Error())
+		if failErr != nil {
+			log.Printf("Error failing payment '%v'; %v\n", payment.PaymentCode, failErr)
+		}
+		return failed, err
+	}
+
+	// The callback may already have settled the payment;
+	// the reference is saved either way
+	err = database.SetProviderReference(payment.PaymentCode, providerReference)
+	if err != nil {
+		log.Printf("Error saving provider reference of payment '%v'; %v\n", payment.PaymentCode, err)
+	}
+	payment.ProviderReference = providerReference
+	return payment, nil
+}
+
+// Tops up a wallet from an external account. The wallet is
+// credited once the rail settles the deposit
+func Deposit(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req DepositRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	rail, ok := getPaymentRail(w, req.Rail)
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, req.WalletAddress) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	payment, err := database.CreateDeposit(user.Id, rail.Name(), req.WalletAddress, req.Account, req.Amount)
+	if err != nil {
+		api.Errorf(w, "Error depositing funds", err)
+		return
+	}
+
+	payment, err = submitPayment(rail, payment)
+	if err != nil {
+		api.Errorf(w, "Error depositing funds", err)
+		return
+	}
+
+	api.Accepted(w, payment)
+}
+
+// Pays out funds from a wallet to an external account.
+// The funds are held until the rail settles or fails the withdrawal
+func Withdraw(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req WithdrawalRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	rail, ok := getPaymentRail(w, req.Rail)
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, req.WalletAddress) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	data := req.Hash()
+
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		api.Unauthorized(w, "Error withdrawing funds. Signature verification failed")
+		return
+	}
+
+	if req.Amount >= TOTP_STEP_UP_AMOUNT && !requireStepUp(w, r, user) {
+		return
+	}
+
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
+	if !ok {
+		return
+	}
+
+	ok = database.IsWithinSpendingLimits(req.WalletAddress, req.Amount)
+	if !ok {
+		notifyLimitExceeded(req.WalletAddress, req.Amount)
+		api.Conflict(w, "Wallet exceeded spending limits")
+		return
+	}
+
+	payment, err := database.CreateWithdrawal(
+		user.Id,
+		rail.Name(),
+		req.WalletAddress,
+		req.Account,
+		req.Amount,
+		req.Timestamp,
+		req.Signature,
+		req.PublicKeyHash,
+		payload,
+	)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+
+		api.Errorf(w, "Error withdrawing funds", err)
+		return
+	}
+
+	payment, err = submitPayment(rail, payment)
+	if err != nil {
+		api.Errorf(w, "Error withdrawing funds", err)
+		return
+	}
+
+	api.Accepted(w, payment)
+}
+
+func GetPayments(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	userPayments, err := database.GetPayments(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching payments", err)
+		return
+	}
+
+	api.OK2(w, userPayments)
+}
+
+func GetPayment(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	paymentCode := chi.URLParam(r, "payment_code")
+
+	payment, err := database.GetPayment(paymentCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, "Payment not found")
+			return
+		}
+		api.Errorf(w, "Error fetching payment", err)
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, payment.WalletAddress) {
+		api.NotFound(w, "Payment not found")
+		return
+	}
+
+	api.OK2(w, payment)
+}
+
+// Webhook payment rails post callbacks to. Callbacks are
+// applied asynchronously by ProcessPaymentCallbacks
+func PaymentCallback(w http.ResponseWriter, r *http.Request) {
+	rail, ok := payments.Get(chi.URLParam(r, "rail"))
+	if !ok {
+		api.NotFound(w, "Payment rail not found")
+		return
+	}
+
+	callback, err := rail.ParseCallback(r)
+	if err != nil {
+		api.BadRequest(w, "Invalid payment callback", err)
+		return
+	}
+
+	// Rails cannot settle payments submitted through another rail
+	callback.Rail = rail.Name()
+
+	if err := callback.Validate(); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	// Rails retry callbacks that are not acknowledged, so a full
+	// queue is reported instead of holding the rail's request open
+	if !enqueuePaymentCallback(*callback) {
+		api.ErrorWithCode(w, http.StatusServiceUnavailable, ERR_CALLBACK_QUEUE_FULL, "Too many payment callbacks. Please retry later")
+		return
+	}
+	api.Accepted(w, "Payment callback received")
+}
+
+// Settles or fails the payment a callback refers to and
+// notifies the wallet's owners
+func applyPaymentCallback(callback payments.Callback) {
+	var (
+		payment *database.Payment
+		event   string
+		err     error
+	)
+
+	if err := callback.Validate(); err != nil {
+		log.Printf("Ignoring %v payment callback; %v\n", callback.Rail, err)
+		return
+	}
+
+	if callback.Status == payments.STATUS_SETTLED {
+		event = PAYMENT_SETTLED
+		payment, err = database.SettlePayment(callback.Reference, callback.Rail, callback.ProviderReference)
+	} else {
+		event = PAYMENT_FAILED
+		payment, err = database.FailPayment(callback.Reference, callback.Rail, callback.Reason)
+	}
+	if err != nil {
+		if errors.Is(err, database.ErrPaymentClosed) {
+			// Rails may deliver the same callback more than once
+			return
+		}
+		log.Printf("Error applying %v payment callback for '%v'; %v\n", callback.Rail, callback.Reference, err)
+		return
+	}
+
+	// Tell the wallet's owners their deposit or withdrawal
+	// has been settled or failed
+	sendNotification(event, *payment, payment.WalletAddress)
+}
+
+// Applies payment rail callbacks as they arrive
+func ProcessPaymentCallbacks() {
+	for callback := range paymentCallbacks {
+		applyPaymentCallback(callback)
+	}
+}
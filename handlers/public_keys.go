@@ -0,0 +1,307 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+type EnrollPublicKeyRequest struct {
+	PublicKey  string `json:"public_key" validate:"public_key"` // New device's base64 encoded public key in PEM format
+	DeviceName string `json:"device_name" validate:"max=100"`
+	Timestamp  string `json:"timestamp"` // Time when enrollment was initiated by the client
+
+	// Base64 encoded signature over the new key,
+	// made with an already trusted key
+	Signature string `json:"signature" validate:"signature"`
+
+	// Base64 encoded hash of the trusted public key
+	// that should be used to verify signature
+	PublicKeyHash string `json:"public_key_hash" validate:"public_key_hash"`
+}
+
+func (req EnrollPublicKeyRequest) Hash() []byte {
+	data := fmt.Sprintf("%s|%s|%s", req.PublicKey, req.DeviceName, req.Timestamp)
+	h := sha256.Sum256([]byte(data))
+	return h[:]
+}
+
+const (
+	DEVICE_RECOVERY_TTL time.Duration = 10 * time.Minute
+)
+
+type DeviceRecoveryRequest struct {
+	Email string `json:"email" validate:"email"`
+}
+
+type RecoverDeviceRequest struct {
+	Email      string `json:"email" validate:"email"`
+	Token      string `json:"token"`
+	Password   string `json:"password"`
+	PublicKey  string `json:"public_key" validate:"public_key"` // New device's base64 encoded public key in PEM format
+	DeviceName string `json:"device_name" validate:"max=100"`
+
+	// Required if the user has 2FA enabled
+	TOTPCode string `json:"totp_code"`
+}
+
+type RevokePublicKeyRequest struct {
+	PublicKeyHash string `json:"public_key_hash" validate:"public_key_hash"`
+}
+
+// Lists the user's devices and the keys they sign with
+func GetPublicKeys(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	keys, err := database.GetPublicKeys(user.Id, user.Email)
+	if err != nil {
+		api.Errorf(w, "Error fetching public keys", err)
+		return
+	}
+
+	api.OK2(w, keys)
+}
+
+// Trusts a new device's key. The request must be signed by one
+// of the user's trusted keys, so a stolen password alone cannot
+// add a device
+func EnrollPublicKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req EnrollPublicKeyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	data := req.Hash()
+
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		api.Unauthorized(w, "Error enrolling public key. Signature verification failed")
+		return
+	}
+
+	if !preventReplay(w, user.Id, data, req.Timestamp) {
+		return
+	}
+
+	err = database.EnrollPublicKey(user.Email, req.PublicKey, req.DeviceName)
+	if err != nil {
+		if errors.Is(err, database.ErrPublicKeyExists) {
+			api.Conflict(w, "Public key is already registered")
+			return
+		}
+		api.Errorf(w, "Error enrolling public key", err)
+		return
+	}
+
+	api.OK(w, "Public key enrolled")
+}
+
+// Revokes one of the user's keys, e.g. on a lost device.
+// Signatures made with the key are no longer accepted, standing
+// orders it signed are cancelled and the device's sessions are
+// logged out
+func RevokePublicKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req RevokePublicKeyRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	err = database.RevokePublicKey(user.Id, user.Email, req.PublicKeyHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, "Public key not found")
+			return
+		}
+		if errors.Is(err, database.ErrLastPublicKey) {
+			api.Conflict(w, "Cannot revoke your only trusted public key. Enroll another device first")
+			return
+		}
+		api.Errorf(w, "Error revoking public key", err)
+		return
+	}
+
+	api.OK(w, "Public key revoked")
+}
+
+func sendDeviceRecoveryEmail(email, token string) {
+	sendEmail(
+		email,
+		"Recover your account on a new device",
+		"<html>"+
+			"<body style='font-family: Arial, sans-serif;'>"+
+			"<h2>Device Recovery Request</h2>"+
+			"<p>Hello, there</p>"+
+			"<p>We received a request to sign in to your TapGoPay account on a new device after losing access to your old ones. Use the following One-Time Password (token) to continue:</p>"+
+			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>"+token+"</div>"+
+			"<p>This code will expire in <b>10 minutes</b>.</p>"+
+			"<p>Completing recovery signs out all of your other devices. If you didn't request this, reset your password immediately.</p>"+
+			"<br>"+
+			"<p>Best regards,<br>TapGoPay</p>"+
+			"</body>"+
+			"</html>",
+	)
+}
+
+// Emails a device recovery code to users who lost every
+// trusted device and so can no longer log in or enroll a key
+func RequestDeviceRecovery(w http.ResponseWriter, r *http.Request) {
+	var req DeviceRecoveryRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_PASSWORD_RESET, req.Email) {
+		return
+	}
+	recordIpAttempt(r, database.AUTH_ACTION_PASSWORD_RESET)
+
+	// Same response whether or not the user exists,
+	// so this route cannot be used to probe accounts
+	if !database.UserExists(req.Email) {
+		api.OK(w, "Device recovery token has been sent to your email")
+		return
+	}
+
+	token, err := database.CreateVerificationToken(database.DEVICE_RECOVERY, req.Email, DEVICE_RECOVERY_TTL)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenThrottled) {
+			// Token from the previous request is still valid
+			api.OK(w, "Device recovery token has been sent to your email")
+			return
+		}
+		api.Errorf(w, "Error creating device recovery token", err)
+		return
+	}
+
+	emitSecurityEvent(r, database.EVENT_DEVICE_RECOVERY_REQUESTED, req.Email, "")
+
+	go sendDeviceRecoveryEmail(req.Email, token.Token)
+
+	api.OK(w, "Device recovery token has been sent to your email")
+}
+
+// Enrolls a new device key for a user who lost every trusted
+// device. The user proves ownership with the emailed code, their
+// password and, if enabled, their second factor. All old keys
+// and sessions are revoked so a stolen device cannot be used
+func RecoverDevice(w http.ResponseWriter, r *http.Request) {
+	var req RecoverDeviceRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_PASSWORD_RESET, req.Email) {
+		return
+	}
+
+	// The token is only consumed once every check has passed,
+	// so a mistyped password does not cost the user their token
+	err = database.CheckVerificationToken(database.DEVICE_RECOVERY, req.Email, req.Token)
+	if err != nil {
+		recordAuthFailure(
+			r, database.AUTH_ACTION_PASSWORD_RESET, req.Email,
+			database.EVENT_DEVICE_RECOVERY_FAILED, err.Error(),
+		)
+
+		if errors.Is(err, database.ErrTokenAttemptsExceeded) {
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOKEN_LOCKED,
+				"Too many wrong attempts. Please request a new token",
+			)
+			return
+		}
+		api.NotFound(w, "Invalid or expired token")
+		return
+	}
+
+	user, err := database.GetUser(req.Email)
+	if err != nil {
+		api.NotFound(w, "Invalid or expired token")
+		return
+	}
+
+	// Access to the user's email alone is not enough
+	// to take over their account
+	if !checkPassword(w, r, user, req.Password) {
+		return
+	}
+	if !checkSecondFactor(w, r, user, req.TOTPCode) {
+		return
+	}
+
+	err = database.ConsumeVerificationToken(database.DEVICE_RECOVERY, req.Email, req.Token)
+	if err != nil {
+		api.NotFound(w, "Invalid or expired token")
+		return
+	}
+
+	err = database.RecoverPublicKey(user.Id, user.Email, req.PublicKey, req.DeviceName)
+	if err != nil {
+		if errors.Is(err, database.ErrPublicKeyExists) {
+			api.Conflict(w, "Public key is already registered")
+			return
+		}
+		api.Errorf(w, "Error enrolling public key", err)
+		return
+	}
+
+	clearAuthFailures(database.AUTH_ACTION_PASSWORD_RESET, req.Email)
+	emitSecurityEvent(r, database.EVENT_DEVICE_RECOVERED, req.Email, "")
+
+	api.OK(w, "Device recovered. Log in with your new device")
+}
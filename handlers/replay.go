@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+const (
+	// Maximum difference between a signed payload's client timestamp
+	// and server time, in either direction
+	SIGNATURE_FRESHNESS_WINDOW time.Duration = 5 * time.Minute
+
+	ERR_INVALID_TIMESTAMP  string = "INVALID_TIMESTAMP"
+	ERR_STALE_TIMESTAMP    string = "STALE_TIMESTAMP"
+	ERR_SIGNATURE_REPLAYED string = "SIGNATURE_REPLAYED"
+)
+
+// Parses an RFC3339 client timestamp and checks that it is
+// within [SIGNATURE_FRESHNESS_WINDOW] of server time.
+// Writes an error response and returns false if timestamp is rejected
+func checkTimestampFreshness(w http.ResponseWriter, timestamp string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		api.ErrorWithCode(w, http.StatusBadRequest, ERR_INVALID_TIMESTAMP, "Invalid timestamp; expected RFC3339 format")
+		return time.Time{}, false
+	}
+
+	age := time.Since(t)
+	if age > SIGNATURE_FRESHNESS_WINDOW || age < -SIGNATURE_FRESHNESS_WINDOW {
+		api.ErrorWithCode(w, http.StatusBadRequest, ERR_STALE_TIMESTAMP, "Timestamp is too old or too far in the future. Please sign the request again")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Rejects signed payloads that are not fresh or have been used before.
+// data is the hash that was signed by the client.
+// Payloads are marked as used, so must only be called once the
+// signature has been verified. Requests that move money use
+// [checkReplay] instead so that failures do not use up the signature.
+// Writes an error response and returns false if payload is rejected
+func preventReplay(w http.ResponseWriter, userId int, data []byte, timestamp string) bool {
+	t, ok := checkTimestampFreshness(w, timestamp)
+	if !ok {
+		return false
+	}
+
+	// Payload cannot pass the freshness check after this time
+	expiresAt := t.Add(SIGNATURE_FRESHNESS_WINDOW)
+
+	err := database.UseSignature(userId, data, expiresAt)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return false
+		}
+
+		api.Errorf(w, "Error checking signature", err)
+		return false
+	}
+	return true
+}
+
+// Like [preventReplay] but does not mark the payload as used.
+// The returned payload must be passed to the database call that
+// acts on it, which marks it as used in the same db transaction.
+// Writes an error response and returns false if payload is rejected
+func checkReplay(w http.ResponseWriter, userId int, data []byte, timestamp string) (*database.SignedPayload, bool) {
+	t, ok := checkTimestampFreshness(w, timestamp)
+	if !ok {
+		return nil, false
+	}
+
+	used, err := database.IsSignatureUsed(data)
+	if err != nil {
+		api.Errorf(w, "Error checking signature", err)
+		return nil, false
+	}
+	if used {
+		signatureReplayed(w)
+		return nil, false
+	}
+
+	return &database.SignedPayload{
+		UserId:    userId,
+		Hash:      data,
+		ExpiresAt: t.Add(SIGNATURE_FRESHNESS_WINDOW),
+	}, true
+}
+
+func signatureReplayed(w http.ResponseWriter) {
+	api.ErrorWithCode(w, http.StatusConflict, ERR_SIGNATURE_REPLAYED, "This signed request has already been used")
+}
+
+// Periodically removes used signatures whose payloads can no longer
+// pass the freshness check
+func DeleteExpiredSignatures() {
+	for {
+		<-time.After(SIGNATURE_FRESHNESS_WINDOW)
+
+		_, err := database.DeleteExpiredSignatures()
+		if err != nil {
+			log.Printf("Error deleting expired signatures; %v\n", err)
+		}
+	}
+}
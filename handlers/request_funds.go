@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// Time a request for funds stays payable unless the
+	// requester asks for a different expiry
+	REQUEST_FUNDS_EXPIRY time.Duration = 72 * time.Hour
+
+	MIN_REQUEST_FUNDS_EXPIRY time.Duration = 5 * time.Minute
+	MAX_REQUEST_FUNDS_EXPIRY time.Duration = 7 * 24 * time.Hour
+)
+
+type RequestFundsRequest struct {
+	TransactionRequest
+
+	// Seconds the request stays payable, between
+	// [MIN_REQUEST_FUNDS_EXPIRY] and [MAX_REQUEST_FUNDS_EXPIRY].
+	// Defaults to [REQUEST_FUNDS_EXPIRY] if omitted.
+	// Not part of the signed data
+	ExpiresIn int `json:"expires_in,omitempty" validate:"min=0"`
+}
+
+// Returns how long a request for funds stays payable
+func (req RequestFundsRequest) Expiry() (time.Duration, error) {
+	if req.ExpiresIn == 0 {
+		return REQUEST_FUNDS_EXPIRY, nil
+	}
+
+	expiry := time.Duration(req.ExpiresIn) * time.Second
+	if expiry < MIN_REQUEST_FUNDS_EXPIRY || expiry > MAX_REQUEST_FUNDS_EXPIRY {
+		return 0, fmt.Errorf(
+			"ExpiresIn must be between %.0f and %.0f seconds",
+			MIN_REQUEST_FUNDS_EXPIRY.Seconds(), MAX_REQUEST_FUNDS_EXPIRY.Seconds(),
+		)
+	}
+	return expiry, nil
+}
+
+// A user can request funds from another user.
+// Sender is the wallet being asked to pay, receiver is the wallet
+// of the user requesting funds
+func RequestFunds(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req RequestFundsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req.TransactionRequest); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	expiry, err := req.Expiry()
+	if err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	data := req.Hash()
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		uncommittedError(w, r, "Error requesting funds. Signature verification failed", nil)
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
+	if !ok {
+		return
+	}
+
+	req.Sender, err = resolveWalletAddress(req.Sender)
+	if err != nil {
+		uncommittedError(w, r, "Sender has no active wallet accounts", err)
+		return
+	}
+
+	req.Receiver, err = resolveWalletAddress(req.Receiver)
+	if err != nil {
+		uncommittedError(w, r, "Receiver has no active wallet accounts", err)
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, req.Receiver) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	requestFunds, err := database.CreateRequestFunds(
+		req.Sender, req.Receiver, req.Amount,
+		req.Timestamp, req.Signature,
+		req.PublicKeyHash,
+		time.Now().Add(expiry),
+		payload,
+	)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+
+		api.Errorf(w, "Error requesting funds", err)
+		return
+	}
+
+	go sendNotification(REQUEST_FUNDS_RECEIVED, *requestFunds, requestFunds.Sender)
+
+	api.OK2(w, requestFunds)
+}
+
+// Fetches requests asking the logged in user to pay
+func GetIncomingRequestFunds(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	requests, err := database.GetIncomingRequestFunds(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching incoming fund requests", err)
+		return
+	}
+
+	api.OK2(w, requests)
+}
+
+// Fetches requests made by the logged in user
+func GetOutgoingRequestFunds(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	requests, err := database.GetOutgoingRequestFunds(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching outgoing fund requests", err)
+		return
+	}
+
+	api.OK2(w, requests)
+}
+
+// Fetches a pending request for funds by its transaction code.
+// Writes an error response and returns false if request cannot be acted on
+func getPendingRequestFunds(w http.ResponseWriter, transactionCode string) (*database.RequestFundsResult, bool) {
+	requestFunds, err := database.GetRequestFunds(transactionCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Request for funds '%v' not found", transactionCode))
+			return nil, false
+		}
+
+		api.Errorf(w, "Error fetching request for funds", err)
+		return nil, false
+	}
+
+	if requestFunds.Status != "pending" {
+		api.Conflict(w, "Request for funds is already %v", requestFunds.Status)
+		return nil, false
+	}
+	return requestFunds, true
+}
+
+type AcceptRequestFundsRequest struct {
+	Fee       float64 `json:"fee" validate:"min=0"`
+	Timestamp string  `json:"timestamp"` // Time when payment was initiated by the client
+
+	// Base64 encoded signature of the payment.
+	// Signed data is the same as that of a [TransactionRequest]
+	Signature string `json:"signature" validate:"signature"`
+
+	// Base64 encoded hash of public key
+	// that should be used to verify signature
+	PublicKeyHash string `json:"public_key_hash" validate:"public_key_hash"`
+}
+
+// Pays a request for funds from the wallet it was requested from
+func AcceptRequestFunds(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req AcceptRequestFundsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err = validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	transactionCode := chi.URLParam(r, "transaction_code")
+
+	requestFunds, ok := getPendingRequestFunds(w, transactionCode)
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, requestFunds.Sender) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	payment := TransactionRequest{
+		Sender:        requestFunds.Sender,
+		Receiver:      requestFunds.Receiver,
+		Amount:        requestFunds.Amount,
+		Fee:           req.Fee,
+		Timestamp:     req.Timestamp,
+		Signature:     req.Signature,
+		PublicKeyHash: req.PublicKeyHash,
+	}
+
+	data := payment.Hash()
+
+	err = verifySignature(payment.Signature, data, user.Email, payment.PublicKeyHash)
+	if err != nil {
+		api.Errorf(w, "Error accepting request for funds. Signature verification failed", nil)
+		return
+	}
+
+	if payment.Amount >= TOTP_STEP_UP_AMOUNT && !requireStepUp(w, r, user) {
+		return
+	}
+
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, payment.Timestamp)
+	if !ok {
+		return
+	}
+
+	// Check amount and fee are within spending limits
+	ok = database.IsWithinSpendingLimits(payment.Sender, payment.Amount+payment.Fee)
+	if !ok {
+		notifyLimitExceeded(payment.Sender, payment.Amount+payment.Fee)
+		api.Conflict(w, "Wallet exceeded spending limits")
+		return
+	}
+
+	ok, err = isValidTransactionFee(payment.Amount, payment.Fee)
+	if err != nil {
+		api.Errorf(w, "Error fetching transaction fees", err)
+		return
+	}
+	if !ok {
+		api.BadRequest(w, "Invalid transaction fees", nil)
+		return
+	}
+
+	t, err := database.AcceptRequestFunds(
+		user.Id,
+		transactionCode,
+		payment.Fee,
+		payment.Timestamp,
+		payment.Signature,
+		payment.PublicKeyHash,
+		payload,
+	)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+		if errors.Is(err, database.ErrRequestFundsClosed) {
+			api.Conflict(w, "Request for funds is no longer pending")
+			return
+		}
+
+		api.Errorf(w, "Error paying request for funds", err)
+		return
+	}
+
+	go notifyTransfer(t)
+
+	if t.Status == "pending" {
+		go notifyCoOwners(SIGNATURE_REQUESTED, t)
+	}
+
+	switch t.Status {
+	case "confirmed":
+		api.OK2(w, t)
+	case "pending":
+		api.Accepted(w, t)
+	default:
+		// rejected
+		api.Errorf(w, "Transaction rejected", nil)
+	}
+}
+
+// Declines a request for funds made to one of the logged in user's wallets
+func DeclineRequestFunds(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	transactionCode := chi.URLParam(r, "transaction_code")
+
+	requestFunds, ok := getPendingRequestFunds(w, transactionCode)
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, requestFunds.Sender) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	err := database.DeclineRequestFunds(transactionCode)
+	if err != nil {
+		if errors.Is(err, database.ErrRequestFundsClosed) {
+			api.Conflict(w, "Request for funds is no longer pending")
+			return
+		}
+
+		api.Errorf(w, "Error declining request for funds", err)
+		return
+	}
+
+	requestFunds.Status = "declined"
+	go sendNotification(REQUEST_FUNDS_DECLINED, *requestFunds, requestFunds.Receiver)
+
+	api.OK(w, fmt.Sprintf("Request for funds '%v' declined", transactionCode))
+}
+
+// Cancels a request for funds made by the logged in user
+func CancelRequestFunds(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	transactionCode := chi.URLParam(r, "transaction_code")
+
+	requestFunds, ok := getPendingRequestFunds(w, transactionCode)
+	if !ok {
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, requestFunds.Receiver) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	err := database.CancelRequestFunds(transactionCode)
+	if err != nil {
+		if errors.Is(err, database.ErrRequestFundsClosed) {
+			api.Conflict(w, "Request for funds is no longer pending")
+			return
+		}
+
+		api.Errorf(w, "Error cancelling request for funds", err)
+		return
+	}
+
+	requestFunds.Status = "cancelled"
+	go sendNotification(REQUEST_FUNDS_CANCELLED, *requestFunds, requestFunds.Sender)
+
+	api.OK(w, fmt.Sprintf("Request for funds '%v' cancelled", transactionCode))
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+type RoleChangeRequest struct {
+	Email  string `json:"email" validate:"email"`
+	Role   string `json:"role" validate:"role"`
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+// Fetches every role along with the permissions it grants
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := database.GetRoles()
+	if err != nil {
+		api.Errorf(w, "Error fetching roles", err)
+		return
+	}
+
+	api.OK2(w, roles)
+}
+
+// Fetches the audit trail of granted and revoked roles
+func GetRoleChanges(w http.ResponseWriter, r *http.Request) {
+	changes, err := database.GetRoleChanges(database.DEFAULT_PAGE_SIZE)
+	if err != nil {
+		api.Errorf(w, "Error fetching role changes", err)
+		return
+	}
+
+	api.OK2(w, changes)
+}
+
+// Decodes a role change request and looks up the user it targets.
+// Writes an error response and returns false on failure
+func parseRoleChangeRequest(w http.ResponseWriter, r *http.Request) (*database.User, *database.User, *RoleChangeRequest, bool) {
+	admin, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return nil, nil, nil, false
+	}
+
+	var req RoleChangeRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return nil, nil, nil, false
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return nil, nil, nil, false
+	}
+
+	user, err := database.GetUser(req.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("User '%v' not found", req.Email))
+			return nil, nil, nil, false
+		}
+
+		api.Errorf(w, "Error fetching user", err)
+		return nil, nil, nil, false
+	}
+
+	// Prevents admins from locking themselves out
+	if user.Id == admin.Id {
+		api.BadRequest(w, "You cannot change your own role", nil)
+		return nil, nil, nil, false
+	}
+	return admin, user, &req, true
+}
+
+func changeUserRole(w http.ResponseWriter, admin *database.User, user *database.User, role string, reason string) {
+	change, err := database.ChangeUserRole(user.Id, role, admin.Id, reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRoleUnchanged):
+			api.Conflict(w, "User '%v' already has role '%v'", user.Email, role)
+		case errors.Is(err, database.ErrSystemRole):
+			api.BadRequest(w, "The system role cannot be granted or revoked", nil)
+		default:
+			api.Errorf(w, "Error changing user's role", err)
+		}
+		return
+	}
+
+	api.OK2(w, change)
+}
+
+// Grants a role to a user, replacing their current role
+func GrantRole(w http.ResponseWriter, r *http.Request) {
+	admin, user, req, ok := parseRoleChangeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	changeUserRole(w, admin, user, req.Role, req.Reason)
+}
+
+// Revokes a role from a user, returning them to the 'user' role
+func RevokeRole(w http.ResponseWriter, r *http.Request) {
+	admin, user, req, ok := parseRoleChangeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if user.Role != req.Role {
+		api.Conflict(w, "User '%v' does not have role '%v'", user.Email, req.Role)
+		return
+	}
+
+	changeUserRole(w, admin, user, "user", req.Reason)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -15,17 +16,46 @@ func GetRoutes() *chi.Mux {
 
 		r.Post("/auth/register", Register)
 		r.Post("/auth/login", Login)
+		r.Post("/auth/refresh", RefreshToken)
 		r.Post("/auth/forgot-password", ForgotPassword)
 		r.Post("/auth/reset-password", ResetPassword)
+		r.Post("/auth/unlock/request", RequestAccountUnlock)
+		r.Post("/auth/unlock", UnlockAccount)
+		r.Post("/auth/recover-device/request", RequestDeviceRecovery)
+		r.Post("/auth/recover-device", RecoverDevice)
 
 		r.Get("/all-transaction-fees", GetAllTransactionFees)
 		r.Get("/transaction-fees", GetTransactionFees)
+		r.Get("/agent-commissions", GetAgentCommissions)
+
+		// Payment rails report settled and failed operations here
+		r.Post("/payments/callbacks/{rail}", PaymentCallback)
 
 		// Admin routes
 		r.Group(func(r chi.Router) {
-			r.Use(RequireAdmin)
+			r.Use(RequireAuthMiddleware)
+
+			r.With(RequirePermission(database.PERMISSION_FEES_WRITE)).Post("/transaction-fees", CreateTransactionFees)
+			r.With(RequirePermission(database.PERMISSION_REVENUE_READ)).Get("/fee-revenue", GetFeeRevenue)
+			r.With(RequirePermission(database.PERMISSION_LEDGER_READ)).Get("/ledger/reconcile", ReconcileLedger)
+
+			r.With(RequirePermission(database.PERMISSION_DISPUTES_READ)).Get("/admin/disputes", GetAllDisputes)
+			r.With(RequirePermission(database.PERMISSION_DISPUTES_WRITE)).Post("/admin/disputes/{dispute_code}/reverse", ForceReverseDispute)
+			r.With(RequirePermission(database.PERMISSION_DISPUTES_WRITE)).Post("/admin/disputes/{dispute_code}/reject", RejectDispute)
+
+			r.With(RequirePermission(database.PERMISSION_ROLES_READ)).Get("/admin/roles", GetRoles)
+			r.With(RequirePermission(database.PERMISSION_ROLES_READ)).Get("/admin/role-changes", GetRoleChanges)
+			r.With(RequirePermission(database.PERMISSION_ROLES_WRITE)).Post("/admin/roles/grant", GrantRole)
+			r.With(RequirePermission(database.PERMISSION_ROLES_WRITE)).Post("/admin/roles/revoke", RevokeRole)
 
-			r.Post("/transaction-fees", CreateTransactionFees)
+			r.With(RequirePermission(database.PERMISSION_AGENTS_READ)).Get("/admin/agents", GetAgents)
+			r.With(RequirePermission(database.PERMISSION_AGENTS_WRITE)).Post("/admin/agents", RegisterAgent)
+			r.With(RequirePermission(database.PERMISSION_AGENTS_WRITE)).Post("/admin/agents/{agent_number}/suspend", SuspendAgent)
+			r.With(RequirePermission(database.PERMISSION_AGENTS_WRITE)).Post("/admin/agents/{agent_number}/activate", ActivateAgent)
+			r.With(RequirePermission(database.PERMISSION_AGENTS_WRITE)).Post("/admin/agent-commissions", CreateAgentCommission)
+
+			r.With(RequirePermission(database.PERMISSION_SECURITY_READ)).Get("/admin/security-events", GetSecurityEvents)
+			r.With(RequirePermission(database.PERMISSION_SECURITY_WRITE)).Post("/admin/users/unlock", AdminUnlockAccount)
 		})
 
 		// Protected routes
@@ -33,10 +63,36 @@ func GetRoutes() *chi.Mux {
 			r.Use(RequireAuthMiddleware)
 
 			r.Get("/verify-login", VerifyLogin)
+			r.Post("/auth/logout", Logout)
+			r.Post("/auth/logout-all", LogoutAll)
+			r.Get("/sessions", GetSessions)
+			r.Delete("/sessions/{session_id}", RevokeSession)
+			r.Get("/public-keys", GetPublicKeys)
+			r.Post("/public-keys", EnrollPublicKey)
+			r.Post("/public-keys/revoke", RevokePublicKey)
+			r.Get("/security-events", GetMySecurityEvents)
+			r.Get("/verification", GetVerificationStatus)
+			r.Post("/verification/email", SendEmailVerification)
+			r.Post("/verification/email/confirm", ConfirmEmailVerification)
+			r.Post("/verification/phone", SendPhoneVerification)
+			r.Post("/verification/phone/confirm", ConfirmPhoneVerification)
+			r.Get("/2fa", GetTwoFactorStatus)
+			r.Post("/2fa/setup", SetupTwoFactor)
+			r.Post("/2fa/enable", EnableTwoFactor)
+			r.Post("/2fa/disable", DisableTwoFactor)
+			r.Post("/2fa/backup-codes", RegenerateBackupCodes)
+			r.Get("/pin", GetTransactionPinStatus)
+			r.Post("/pin", SetTransactionPin)
+			r.Post("/pin/change", ChangeTransactionPin)
+			r.Post("/pin/reset/request", RequestPinReset)
+			r.Post("/pin/reset", ResetTransactionPin)
 			r.HandleFunc("/subscribe-notifications", SubscribeNotifications)
+			r.Get("/notifications", GetNotifications)
+			r.Post("/notifications/read-all", MarkAllNotificationsRead)
+			r.Post("/notifications/{notification_id}/read", MarkNotificationRead)
 
 			// Wallets
-			r.Post("/new-wallet", CreateWallet)
+			r.With(Idempotent).Post("/new-wallet", CreateWallet)
 			r.Get("/wallets", GetAllWallets)
 			r.Post("/wallets/owned-by-phone", GetWalletsOwnedByPhoneNo)
 
@@ -44,30 +100,89 @@ func GetRoutes() *chi.Mux {
 				r.Use(VerifyWalletOwnership)
 
 				r.Get("/wallets/{wallet_address}", GetWallet)
+				r.Get("/wallets/{wallet_address}/transactions", GetTransactionHistory)
+				r.Get("/wallets/{wallet_address}/ledger", GetLedgerEntries)
 				r.Post("/wallets/{wallet_address}/freeze", FreezeWallet)
 				r.Post("/wallets/{wallet_address}/activate", ActivateWallet)
 				r.Post("/wallets/{wallet_address}/limit", SetOrUpdateLimit)
+				r.Get("/wallets/{wallet_address}/limits", GetLimits)
+				r.Delete("/wallets/{wallet_address}/limits/{period}", DeleteLimit)
+				r.Post("/wallets/{wallet_address}/approval-window", SetApprovalWindow)
 				r.Post("/wallets/{wallet_address}/add-owner", AddWalletOwner)
 				r.Post("/wallets/{wallet_address}/remove-owner", RemoveWalletOwner)
 			})
 
 			// Transactions
-			r.Post("/send-money", SendMoney)
-			r.Post("/request-funds", RequestFunds)
-			r.Get("/recent-transactions/{wallet_address}", GetRecentTransactions)
+			r.With(Idempotent).Post("/send-money", SendMoney)
+			r.With(Idempotent).Post("/request-funds", RequestFunds)
+			r.Get("/request-funds/incoming", GetIncomingRequestFunds)
+			r.Get("/request-funds/outgoing", GetOutgoingRequestFunds)
+			r.Post("/request-funds/{transaction_code}/accept", AcceptRequestFunds)
+			r.Post("/request-funds/{transaction_code}/decline", DeclineRequestFunds)
+			r.Post("/request-funds/{transaction_code}/cancel", CancelRequestFunds)
+			r.With(VerifyWalletOwnership).Get("/recent-transactions/{wallet_address}", GetRecentTransactions)
+			r.Get("/transactions/pending-signatures", GetPendingSignatures)
 			r.Get("/transactions/{transaction_code}", GetTransaction)
 			r.Post("/transactions/{transaction_code}/sign-transaction", SignTransaction)
+			r.Post("/transactions/{transaction_code}/reject-transaction", RejectTransaction)
+
+			// Disputes
+			r.Post("/transactions/{transaction_code}/dispute", OpenDispute)
+			r.Get("/disputes", GetDisputes)
+			r.Get("/disputes/{dispute_code}", GetDispute)
+			r.Post("/disputes/{dispute_code}/evidence", AddDisputeEvidence)
+			r.With(Idempotent).Post("/disputes/{dispute_code}/approve", ApproveDispute)
+			r.Post("/disputes/{dispute_code}/decline", DeclineDispute)
+			r.Post("/disputes/{dispute_code}/cancel", CancelDispute)
+
+			// Standing Orders
+			r.With(Idempotent).Post("/standing-orders", CreateStandingOrder)
+			r.Get("/standing-orders", GetStandingOrders)
+			r.Get("/standing-orders/{order_code}", GetStandingOrder)
+			r.Post("/standing-orders/{order_code}/pause", PauseStandingOrder)
+			r.Post("/standing-orders/{order_code}/resume", ResumeStandingOrder)
+			r.Post("/standing-orders/{order_code}/cancel", CancelStandingOrder)
+
+			// Agents
+			r.Group(func(r chi.Router) {
+				r.Use(RequirePermission(database.PERMISSION_AGENT_TRANSACT))
+
+				r.Get("/agent", GetAgentProfile)
+				r.With(Idempotent).Post("/agent/cash-in", CashIn)
+				r.With(Idempotent).Post("/agent/cash-out", RequestCashOut)
+				r.Get("/agent/transactions", GetAgentTransactions)
+				r.Get("/agent/statements/{day}", GetAgentStatement)
+			})
+			r.Get("/cash-outs/pending", GetPendingCashOuts)
+			r.With(Idempotent).Post("/cash-outs/{agent_transaction_code}/confirm", ConfirmCashOut)
+			r.Post("/cash-outs/{agent_transaction_code}/decline", DeclineCashOut)
+
+			// Deposits and withdrawals
+			r.With(Idempotent).Post("/deposits", Deposit)
+			r.With(Idempotent).Post("/withdrawals", Withdraw)
+			r.Get("/payments", GetPayments)
+			r.Get("/payments/{payment_code}", GetPayment)
 
 			// Cash Pools
-			r.Post("/new-chama", CreateNewChama)
+			r.With(Idempotent).Post("/new-chama", CreateNewChama)
 			r.Get("/cash-pools/{wallet_address}", GetCashPool)
 
 			r.With(VerifyWalletOwnership).Delete("/cash-pools/{wallet_address}", RemoveCashPool)
 
 			// Split Bills
-			r.Post("/new-split-bill", CreateSplitBill)
+			r.With(Idempotent).Post("/new-split-bill", CreateSplitBill)
 
 			go RefundExpiredCashPools()
+			go ReconcileLedgerPeriodically()
+			go DeleteExpiredIdempotencyKeys()
+			go DeleteExpiredSignatures()
+			go ExpirePendingTransactions()
+			go ExecuteStandingOrders()
+			go ExpireAgentCashOuts()
+			go ProcessPaymentCallbacks()
+			go DeleteExpiredSessions()
+			go DeleteStaleAuthAttempts()
+			go DeleteOldNotifications()
 		})
 	})
 	return r
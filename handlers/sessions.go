@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	ACCESS_TOKEN_TTL time.Duration = 15 * time.Minute
+
+	// Sessions not refreshed within this time expire
+	SESSION_TTL time.Duration = 30 * 24 * time.Hour
+
+	SESSION_CLEANUP_INTERVAL time.Duration = 1 * time.Hour
+)
+
+type TokenResponse struct {
+	SessionId    string `json:"session_id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+}
+
+type RefreshRequest struct {
+	// Optional; read from the refresh cookie if empty
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Active session as listed to its user
+type SessionResponse struct {
+	database.Session
+
+	// Whether the request was made with this session
+	Current bool `json:"current"`
+}
+
+func getClientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Issues an access token for a session and sends it, along with the
+// session's refresh token, in both cookies and the response body
+func issueTokens(w http.ResponseWriter, session *database.Session, refreshToken string) {
+	accessToken, err := generateToken(session.UserId, session.SessionId)
+	if err != nil {
+		api.Errorf(w, "Error issuing access token", err)
+		return
+	}
+
+	now := time.Now()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     LOGIN_COOKIE,
+		Value:    accessToken,
+		Expires:  now.Add(ACCESS_TOKEN_TTL),
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     REFRESH_COOKIE,
+		Value:    refreshToken,
+		Path:     "/auth",
+		Expires:  now.Add(SESSION_TTL),
+		HttpOnly: true,
+	})
+
+	api.OK2(w, TokenResponse{
+		SessionId:    session.SessionId,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(ACCESS_TOKEN_TTL.Seconds()),
+	})
+}
+
+func clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   LOGIN_COOKIE,
+		Value:  "",
+		MaxAge: -1,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:   REFRESH_COOKIE,
+		Value:  "",
+		Path:   "/auth",
+		MaxAge: -1,
+	})
+}
+
+// Exchanges a refresh token for a new access token and refresh token.
+// Each refresh token can only be used once
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			api.BadRequest(w, "Error parsing request body", err)
+			return
+		}
+	}
+
+	if req.RefreshToken == "" {
+		cookie, err := r.Cookie(REFRESH_COOKIE)
+		if err != nil {
+			api.Unauthorized(w, "Missing refresh token")
+			return
+		}
+		req.RefreshToken = cookie.Value
+	}
+
+	session, refreshToken, err := database.RefreshSession(req.RefreshToken, SESSION_TTL)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, database.ErrSessionRevoked) {
+			api.Unauthorized(w, "Invalid or expired refresh token")
+			return
+		}
+		if errors.Is(err, database.ErrRefreshTokenReused) {
+			log.Printf("Refresh token reused; session revoked\n")
+			api.Unauthorized(w, "Refresh token has already been used. Please login again")
+			return
+		}
+		api.Errorf(w, "Error refreshing session", err)
+		return
+	}
+
+	issueTokens(w, session, refreshToken)
+}
+
+// Revokes the session the request was made with
+func Logout(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	sessionId, ok := getAuthSession(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	err := database.RevokeSession(user.Id, sessionId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		api.Errorf(w, "Error logging out", err)
+		return
+	}
+
+	clearTokenCookies(w)
+	api.OK(w, "Logout successful")
+}
+
+// Revokes all of the user's sessions, on every device
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	_, err := database.RevokeAllSessions(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error logging out", err)
+		return
+	}
+
+	clearTokenCookies(w)
+	api.OK(w, "Logged out of all devices")
+}
+
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	currentSessionId, _ := getAuthSession(r)
+
+	sessions, err := database.GetSessions(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching sessions", err)
+		return
+	}
+
+	results := []SessionResponse{}
+	for _, session := range sessions {
+		results = append(results, SessionResponse{
+			Session: *session,
+			Current: session.SessionId == currentSessionId,
+		})
+	}
+
+	api.OK2(w, results)
+}
+
+// Revokes one of the user's sessions, e.g. on a lost device
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	sessionId := chi.URLParam(r, "session_id")
+
+	err := database.RevokeSession(user.Id, sessionId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, "Session not found")
+			return
+		}
+		api.Errorf(w, "Error revoking session", err)
+		return
+	}
+
+	api.OK(w, "Session revoked")
+}
+
+// Periodically removes expired and revoked sessions
+func DeleteExpiredSessions() {
+	for {
+		<-time.After(SESSION_CLEANUP_INTERVAL)
+
+		_, err := database.DeleteExpiredSessions()
+		if err != nil {
+			log.Printf("Error deleting expired sessions; %v\n", err)
+		}
+	}
+}
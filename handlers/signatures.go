@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// How often pending transactions are checked for expiry
+	PENDING_TRANSACTIONS_SWEEP_INTERVAL time.Duration = 1 * time.Minute
+)
+
+// Notifies all owners of the transaction's sender wallet
+// as the transaction collects signatures
+func notifyCoOwners(event string, t *database.Transaction) {
+	sendNotification(event, *t, t.Sender.WalletAddress)
+}
+
+// Fetches pending transactions awaiting the logged in user's signature
+func GetPendingSignatures(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	pending, err := database.GetPendingSignatures(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching transactions pending signature", err)
+		return
+	}
+
+	api.OK2(w, pending)
+}
+
+type RejectTransactionRequest struct {
+	Reason string `json:"reason" validate:"max=255"` // Optional
+}
+
+// Vetoes a pending transaction from a multi-signature wallet
+func RejectTransaction(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	transactionCode := chi.URLParam(r, "transaction_code")
+
+	// Request body is optional
+	var req RejectTransactionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err = validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	err = database.RejectTransaction(user.Id, transactionCode, req.Reason)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Transaction '%v' not found", transactionCode))
+			return
+		}
+
+		message := fmt.Sprintf("Error rejecting transaction '%v'", transactionCode)
+		api.Errorf(w, message, err)
+		return
+	}
+
+	t, err := database.GetTransaction(transactionCode)
+	if err != nil {
+		api.Errorf(w, "Error fetching rejected transaction", err)
+		return
+	}
+
+	if t.Status == "rejected" {
+		go notifyCoOwners(TRANSACTION_REJECTED, t)
+	}
+
+	api.OK2(w, t)
+}
+
+// Rejects pending transactions that were not signed within
+// their wallet's approval window
+func ExpirePendingTransactions() {
+	for {
+		<-time.After(PENDING_TRANSACTIONS_SWEEP_INTERVAL)
+
+		expired, err := database.ExpirePendingTransactions()
+		if err != nil {
+			log.Printf("Error expiring pending transactions; %v\n", err)
+		}
+
+		for _, t := range expired {
+			notifyCoOwners(TRANSACTION_EXPIRED, t)
+		}
+	}
+}
@@ -102,7 +102,7 @@ func CreateSplitBill(w http.ResponseWriter, r *http.Request) {
 
 	for _, c := range req.Contributions {
 		splitBillNotification.ExpectedContribution = c
-		go sendNotification(splitBillNotification, c.Account)
+		go sendNotification(SPLIT_BILL_REQUESTED, splitBillNotification, c.Account)
 	}
 
 	api.OK2(w, cashPool)
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// How often standing orders are checked for due runs
+	STANDING_ORDERS_SWEEP_INTERVAL time.Duration = 1 * time.Minute
+)
+
+type StandingOrderRequest struct {
+	Sender   string  `json:"sender" validate:"account"`
+	Receiver string  `json:"receiver" validate:"account"` // Wallet address, phone number or cash pool
+	Amount   float64 `json:"amount" validate:"amount"`
+
+	// One of daily, weekly, monthly or cron
+	Frequency      string     `json:"frequency" validate:"frequency"`
+	CronExpression string     `json:"cron_expression,omitempty"` // Required when frequency is cron
+	StartsAt       time.Time  `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"` // Optional
+	Timestamp      string     `json:"timestamp"`         // Time when standing order was signed by the client
+
+	// Base64 encoded signature. Pre-authorises every transfer
+	// made by the standing order
+	Signature string `json:"signature" validate:"signature"`
+
+	// Base64 encoded hash of public key
+	// that should be used to verify signature
+	PublicKeyHash string `json:"public_key_hash" validate:"public_key_hash"`
+}
+
+func (req StandingOrderRequest) Hash() []byte {
+	var endsAt string
+	if req.EndsAt != nil {
+		endsAt = req.EndsAt.UTC().Format(time.RFC3339)
+	}
+
+	data := fmt.Sprintf(
+		"%s|%s|%.2f|%s|%s|%s|%s|%s",
+		req.Sender, req.Receiver, req.Amount,
+		req.Frequency, req.CronExpression,
+		req.StartsAt.UTC().Format(time.RFC3339), endsAt,
+		req.Timestamp,
+	)
+	h := sha256.Sum256([]byte(data))
+	return h[:]
+}
+
+// Sent to owners of a standing order's wallet every time it runs
+type StandingOrderNotification struct {
+	StandingOrder database.StandingOrder          `json:"standing_order"`
+	Execution     database.StandingOrderExecution `json:"execution"`
+}
+
+func CreateStandingOrder(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req StandingOrderRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if req.Frequency == "cron" {
+		if _, err := utils.ParseCronExpression(req.CronExpression); err != nil {
+			api.BadRequest(w, err.Error(), nil)
+			return
+		}
+	} else if req.CronExpression != "" {
+		api.BadRequest(w, "cron_expression is only allowed with cron frequency", nil)
+		return
+	}
+
+	data := req.Hash()
+
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		api.Unauthorized(w, "Error creating standing order. Signature verification failed")
+		return
+	}
+
+	// Standing orders authorise every future payment up front
+	if req.Amount >= TOTP_STEP_UP_AMOUNT && !requireStepUp(w, r, user) {
+		return
+	}
+
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
+	if !ok {
+		return
+	}
+
+	// Standing orders without a start time run immediately
+	if req.StartsAt.IsZero() {
+		req.StartsAt = time.Now()
+	}
+
+	sender, err := resolveWalletAddress(req.Sender)
+	if err != nil {
+		uncommittedError(w, r, "Sender has no active wallet accounts", err)
+		return
+	}
+
+	if !database.OwnsWallet(user.Id, sender) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	// Receiver is stored as entered and resolved on every run
+	receiver, err := resolveWalletAddress(req.Receiver)
+	if err != nil {
+		uncommittedError(w, r, "Receiver has no active wallet accounts", err)
+		return
+	}
+
+	if sender == receiver {
+		api.BadRequest(w, "Sender and receiver share the same account", nil)
+		return
+	}
+
+	order, err := database.CreateStandingOrder(
+		user.Id,
+		sender, req.Receiver,
+		req.Amount,
+		req.Frequency, req.CronExpression,
+		req.StartsAt, req.EndsAt,
+		req.Timestamp, req.Signature,
+		req.PublicKeyHash,
+		payload,
+	)
+	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+		if errors.Is(err, database.ErrNoScheduledRuns) {
+			api.BadRequest(w, "Standing order schedule has no runs before it ends", nil)
+			return
+		}
+
+		api.Errorf(w, "Error creating standing order", err)
+		return
+	}
+
+	api.OK2(w, order)
+}
+
+// Fetches standing orders paying from the logged in user's wallets
+func GetStandingOrders(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	orders, err := database.GetUserStandingOrders(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching standing orders", err)
+		return
+	}
+
+	api.OK2(w, orders)
+}
+
+// Fetches a standing order paying from one of the logged in user's wallets.
+// Writes an error response and returns false if not found
+func getUserStandingOrder(w http.ResponseWriter, r *http.Request) (*database.StandingOrder, bool) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return nil, false
+	}
+
+	orderCode := chi.URLParam(r, "order_code")
+
+	order, err := database.GetStandingOrder(orderCode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("Standing order '%v' not found", orderCode))
+			return nil, false
+		}
+
+		api.Errorf(w, "Error fetching standing order", err)
+		return nil, false
+	}
+
+	if !database.OwnsWallet(user.Id, order.Sender) {
+		api.NotFound(w, fmt.Sprintf("Standing order '%v' not found", orderCode))
+		return nil, false
+	}
+	return order, true
+}
+
+func GetStandingOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := getUserStandingOrder(w, r)
+	if !ok {
+		return
+	}
+
+	api.OK2(w, order)
+}
+
+func PauseStandingOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := getUserStandingOrder(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.PauseStandingOrder(order.OrderCode)
+	if err != nil {
+		if errors.Is(err, database.ErrStandingOrderState) {
+			api.Conflict(w, "Only active standing orders can be paused")
+			return
+		}
+
+		api.Errorf(w, "Error pausing standing order", err)
+		return
+	}
+
+	api.OK(w, fmt.Sprintf("Standing order '%v' paused", order.OrderCode))
+}
+
+// Resumes a paused standing order. Runs missed while paused are skipped
+func ResumeStandingOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := getUserStandingOrder(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.ResumeStandingOrder(order)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrStandingOrderState):
+			api.Conflict(w, "Only paused standing orders can be resumed")
+		case errors.Is(err, database.ErrNoScheduledRuns):
+			api.Conflict(w, "Standing order has no runs left")
+		default:
+			api.Errorf(w, "Error resuming standing order", err)
+		}
+		return
+	}
+
+	api.OK(w, fmt.Sprintf("Standing order '%v' resumed", order.OrderCode))
+}
+
+func CancelStandingOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := getUserStandingOrder(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.CancelStandingOrder(order.OrderCode)
+	if err != nil {
+		if errors.Is(err, database.ErrStandingOrderState) {
+			api.Conflict(w, "Standing order has already ended")
+			return
+		}
+
+		api.Errorf(w, "Error cancelling standing order", err)
+		return
+	}
+
+	api.OK(w, fmt.Sprintf("Standing order '%v' cancelled", order.OrderCode))
+}
+
+// Makes a single transfer for a standing order using its
+// pre-authorised signature
+func runStandingOrder(order *database.StandingOrder) (*database.Transaction, error) {
+	// Revoking a key cancels its standing orders; this catches
+	// runs claimed before the revocation
+	trusted, err := database.IsTrustedPublicKey(order.UserId, order.PublicKeyId)
+	if err != nil {
+		return nil, err
+	}
+	if !trusted {
+		return nil, errors.New("standing order was signed with a revoked key")
+	}
+
+	receiver, err := resolveWalletAddress(order.Receiver)
+	if err != nil {
+		return nil, errors.New("receiver has no active wallet accounts")
+	}
+
+	var fee float64
+
+	transactionFee, err := getTransactionFees(order.Amount)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if transactionFee != nil {
+		fee = transactionFee.Fee
+	}
+
+	if !database.IsWithinSpendingLimits(order.Sender, order.Amount+fee) {
+		notifyLimitExceeded(order.Sender, order.Amount+fee)
+		return nil, errors.New("wallet exceeded spending limits")
+	}
+
+	return database.CreateTransaction(
+		order.UserId,
+		order.Sender, receiver,
+		order.Amount, fee,
+		time.Now().UTC().Format(time.RFC3339),
+		order.Signature,
+		order.PublicKeyId,
+		nil,
+	)
+}
+
+// Message recorded for a failed run. Only errors raised by the
+// database's business rules are shown to the user
+func executionMessage(err error) string {
+	var mysqlErr *mysql.MySQLError
+
+	if errors.As(err, &mysqlErr) {
+		// MySQL error code 1644 ER_SIGNAL_EXCEPTION
+		if mysqlErr.Number == 1644 {
+			return mysqlErr.Message
+		}
+		return "Error transferring funds"
+	}
+	return err.Error()
+}
+
+func executeStandingOrder(order *database.StandingOrder) {
+	claimed, err := database.ClaimStandingOrderRun(order)
+	if err != nil {
+		log.Printf("Error claiming standing order '%v' run; %v\n", order.OrderCode, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var (
+		transactionCode string
+		status          string = "failed"
+		message         string
+	)
+
+	t, err := runStandingOrder(order)
+	if err != nil {
+		log.Printf("Error executing standing order '%v'; %v\n", order.OrderCode, err)
+		message = executionMessage(err)
+	} else {
+		transactionCode = t.TransactionCode
+
+		switch t.Status {
+		case "confirmed", "pending":
+			status = t.Status
+		default:
+			message = "Transaction rejected"
+		}
+	}
+
+	execution, err := database.RecordStandingOrderExecution(order.Id, transactionCode, status, message)
+	if err != nil {
+		log.Printf("Error recording standing order '%v' execution; %v\n", order.OrderCode, err)
+		return
+	}
+
+	event := STANDING_ORDER_EXECUTED
+	if status == "failed" {
+		event = STANDING_ORDER_FAILED
+	}
+
+	notification := StandingOrderNotification{
+		StandingOrder: *order,
+		Execution:     *execution,
+	}
+	sendNotification(event, notification, order.Sender)
+
+	if t != nil {
+		notifyTransfer(t)
+
+		if t.Status == "pending" {
+			notifyCoOwners(SIGNATURE_REQUESTED, t)
+		}
+	}
+}
+
+// Periodically runs standing orders that are due
+func ExecuteStandingOrders() {
+	for {
+		<-time.After(STANDING_ORDERS_SWEEP_INTERVAL)
+
+		orders, err := database.GetDueStandingOrders()
+		if err != nil {
+			log.Printf("Error fetching due standing orders; %v\n", err)
+			continue
+		}
+
+		for _, order := range orders {
+			executeStandingOrder(order)
+		}
+	}
+}
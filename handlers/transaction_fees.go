@@ -43,7 +43,7 @@ func CreateTransactionFees(w http.ResponseWriter, r *http.Request) {
 	// Check cache
 	mutex.RLock()
 	for _, t := range transactionFeesCache {
-		if t.MinAmount == req.MinAmount && t.MaxAmount == t.MaxAmount && t.Fee == req.Fee {
+		if t.MinAmount == req.MinAmount && t.MaxAmount == req.MaxAmount && t.Fee == req.Fee {
 			result = &t
 			break
 		}
@@ -64,13 +64,9 @@ func CreateTransactionFees(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update cache
+	// Fee tiers may not be in effect yet; reload cache on next read
 	mutex.Lock()
-	transactionFeesCache = append(transactionFeesCache, database.TransactionFee{
-		MinAmount: req.MinAmount,
-		MaxAmount: req.MaxAmount,
-		Fee:       req.Fee,
-	})
+	transactionFeesCache = nil
 	mutex.Unlock()
 
 	api.OK(w, "Transaction fees setup correctly")
@@ -105,13 +101,13 @@ func GetAllTransactionFees(w http.ResponseWriter, r *http.Request) {
 func getTransactionFees(amount float64) (*database.TransactionFee, error) {
 	var transactionFee *database.TransactionFee
 
-	// Check cache
+	// Check cache.
+	// Tier lookup must match that of the getTransactionFeeTier routine
 	mutex.RLock()
 	for _, t := range transactionFeesCache {
-		withinRange := amount > t.MinAmount && amount <= t.MaxAmount
-		if withinRange {
+		withinRange := amount >= t.MinAmount && amount <= t.MaxAmount
+		if withinRange && (transactionFee == nil || t.MinAmount < transactionFee.MinAmount) {
 			transactionFee = &t
-			break
 		}
 	}
 	mutex.RUnlock()
@@ -164,3 +160,46 @@ func GetTransactionFees(w http.ResponseWriter, r *http.Request) {
 
 	api.OK2(w, transactionFee)
 }
+
+const (
+	// Days covered by the fee revenue report if no range is given
+	FEE_REVENUE_DEFAULT_DAYS int = 30
+)
+
+// Reports fee revenue per day and fee tier.
+// Accepts optional query parameters ?from=YYYY-MM-DD&to=YYYY-MM-DD,
+// both days inclusive
+func GetFeeRevenue(w http.ResponseWriter, r *http.Request) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today
+	from := today.AddDate(0, 0, -FEE_REVENUE_DEFAULT_DAYS)
+
+	query := r.URL.Query()
+
+	for key, dest := range map[string]*time.Time{"from": &from, "to": &to} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			api.BadRequest(w, "Invalid '"+key+"' date; expected format YYYY-MM-DD", nil)
+			return
+		}
+		*dest = day
+	}
+
+	if from.After(to) {
+		api.BadRequest(w, "Expected 'from' date before 'to' date", nil)
+		return
+	}
+
+	revenue, err := database.GetFeeRevenue(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		api.Errorf(w, "Error fetching fee revenue", err)
+		return
+	}
+
+	api.OK2(w, revenue)
+}
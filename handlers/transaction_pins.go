@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+const (
+	// Header carrying the user's transaction PIN on
+	// requests that move money
+	TRANSACTION_PIN_HEADER string = "Transaction-PIN"
+
+	PIN_RESET_TTL time.Duration = 10 * time.Minute
+
+	ERR_PIN_REQUIRED string = "PIN_REQUIRED"
+	ERR_PIN_NOT_SET  string = "PIN_NOT_SET"
+	ERR_INVALID_PIN  string = "INVALID_PIN"
+	ERR_PIN_LOCKED   string = "PIN_LOCKED"
+)
+
+// Wrong PINs entered by one user. The fifth wrong PIN in a row
+// locks the PIN until it is reset
+var TRANSACTION_PIN_POLICY = database.AttemptPolicy{
+	FreeAttempts: 2,
+	BaseDelay:    30 * time.Second,
+	MaxDelay:     15 * time.Minute,
+	LockoutAfter: 5,
+	Window:       24 * time.Hour,
+}
+
+type SetPinRequest struct {
+	Pin      string `json:"pin" validate:"pin"`
+	Password string `json:"password" validate:"min=1"`
+}
+
+type ChangePinRequest struct {
+	CurrentPin string `json:"current_pin" validate:"min=1"`
+	NewPin     string `json:"new_pin" validate:"pin"`
+}
+
+type ResetPinRequest struct {
+	// Code emailed by RequestPinReset
+	Token    string `json:"token" validate:"min=1"`
+	Password string `json:"password" validate:"min=1"`
+	Pin      string `json:"pin" validate:"pin"`
+}
+
+// Checks the user's password before changes to their PIN, so
+// that a logged in session alone cannot set one. Wrong passwords
+// count as failed logins
+func checkPassword(w http.ResponseWriter, r *http.Request, user *database.User, password string) bool {
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_LOGIN, user.Email) {
+		return false
+	}
+
+	dbUser, err := database.GetUser(user.Email)
+	if err != nil {
+		api.Errorf(w, "Error fetching user", err)
+		return false
+	}
+
+	if !verifyPassword(dbUser.Password, password) {
+		recordAuthFailure(r, database.AUTH_ACTION_LOGIN, user.Email, database.EVENT_LOGIN_FAILED, "wrong password for transaction PIN")
+		api.BadRequest(w, "Invalid password", nil)
+		return false
+	}
+	return true
+}
+
+// Checks pin against the user's transaction PIN. Wrong PINs back
+// off and eventually lock the PIN until the user resets it
+func checkTransactionPin(w http.ResponseWriter, r *http.Request, user *database.User, pin string) bool {
+	attempts, err := database.GetAuthAttempts(
+		database.AUTH_ACTION_TRANSACTION_PIN, database.AUTH_SCOPE_ACCOUNT, user.Email,
+	)
+	if err != nil {
+		api.Errorf(w, "Error checking failed attempts", err)
+		return false
+	}
+
+	if attempts.LockedOut {
+		api.ErrorWithCode(
+			w, http.StatusLocked, ERR_PIN_LOCKED,
+			"Transaction PIN locked after too many wrong entries. Reset your PIN to unlock it",
+		)
+		return false
+	}
+
+	if attempts.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(attempts.RetryAfter.Seconds())))
+		api.ErrorWithCode(
+			w, http.StatusTooManyRequests, ERR_TOO_MANY_ATTEMPTS,
+			fmt.Sprintf("Too many wrong PINs. Try again in %v", attempts.RetryAfter),
+		)
+		return false
+	}
+
+	if pin == "" {
+		api.ErrorWithCode(w, http.StatusUnauthorized, ERR_PIN_REQUIRED, "Your transaction PIN is required")
+		return false
+	}
+
+	err = database.VerifyTransactionPin(user.Id, pin)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrPinNotSet):
+			api.ErrorWithCode(
+				w, http.StatusForbidden, ERR_PIN_NOT_SET,
+				"Set a transaction PIN before moving money",
+			)
+		case errors.Is(err, database.ErrPinInvalid):
+			recordPinFailure(r, user)
+			api.ErrorWithCode(w, http.StatusUnauthorized, ERR_INVALID_PIN, "Invalid transaction PIN")
+		default:
+			api.Errorf(w, "Error checking transaction PIN", err)
+		}
+		return false
+	}
+
+	if attempts.Failures > 0 {
+		clearAuthFailures(database.AUTH_ACTION_TRANSACTION_PIN, user.Email)
+	}
+	return true
+}
+
+func recordPinFailure(r *http.Request, user *database.User) {
+	emitSecurityEvent(r, database.EVENT_PIN_FAILED, user.Email, "")
+
+	_, lockedOut, err := database.RecordAuthFailure(
+		database.AUTH_ACTION_TRANSACTION_PIN, database.AUTH_SCOPE_ACCOUNT,
+		user.Email, TRANSACTION_PIN_POLICY,
+	)
+	if err != nil {
+		log.Printf("Error recording failed attempt; %v\n", err)
+	}
+
+	if lockedOut {
+		emitSecurityEvent(r, database.EVENT_PIN_LOCKED, user.Email, "too many wrong PINs")
+	}
+}
+
+// Demands the user's transaction PIN in the Transaction-PIN
+// header before moving money
+func requireTransactionPin(w http.ResponseWriter, r *http.Request, user *database.User) bool {
+	return checkTransactionPin(w, r, user, r.Header.Get(TRANSACTION_PIN_HEADER))
+}
+
+func GetTransactionPinStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	status, err := database.GetTransactionPinStatus(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching transaction PIN status", err)
+		return
+	}
+
+	api.OK2(w, status)
+}
+
+func SetTransactionPin(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req SetPinRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	err = database.SetTransactionPin(user.Id, req.Pin)
+	if err != nil {
+		if errors.Is(err, database.ErrPinAlreadySet) {
+			api.Conflict(w, "Transaction PIN has already been set")
+			return
+		}
+		api.Errorf(w, "Error setting transaction PIN", err)
+		return
+	}
+
+	emitSecurityEvent(r, database.EVENT_PIN_CHANGED, user.Email, "PIN set")
+
+	api.OK(w, "Transaction PIN set")
+}
+
+func ChangeTransactionPin(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req ChangePinRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkTransactionPin(w, r, user, req.CurrentPin) {
+		return
+	}
+
+	err = database.ChangeTransactionPin(user.Id, req.NewPin)
+	if err != nil {
+		api.Errorf(w, "Error changing transaction PIN", err)
+		return
+	}
+
+	emitSecurityEvent(r, database.EVENT_PIN_CHANGED, user.Email, "PIN changed")
+
+	api.OK(w, "Transaction PIN changed")
+}
+
+func sendPinResetEmail(email, token string) {
+	sendEmail(
+		email,
+		"Reset your transaction PIN",
+		"<html>"+
+			"<body style='font-family: Arial, sans-serif;'>"+
+			"<h2>Transaction PIN Reset</h2>"+
+			"<p>Hello, there</p>"+
+			"<p>We received a request to reset your TapGoPay transaction PIN. Use the following One-Time Password (token) to set a new PIN:</p>"+
+			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>"+token+"</div>"+
+			"<p>This code will expire in <b>10 minutes</b>.</p>"+
+			"<p>If you didn't request this, change your password as someone may have access to your account.</p>"+
+			"<br>"+
+			"<p>Best regards,<br>TapGoPay</p>"+
+			"</body>"+
+			"</html>",
+	)
+}
+
+// Emails a code for resetting a forgotten or locked PIN
+func RequestPinReset(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	status, err := database.GetTransactionPinStatus(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching transaction PIN status", err)
+		return
+	}
+	if !status.IsSet {
+		api.Conflict(w, "Transaction PIN has not been set")
+		return
+	}
+
+	token, err := database.CreateVerificationToken(database.PIN_RESET, user.Email, PIN_RESET_TTL)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenThrottled) {
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOKEN_THROTTLED,
+				fmt.Sprintf("A code was sent recently. Please wait %v before requesting another", database.TOKEN_RESEND_INTERVAL),
+			)
+			return
+		}
+		api.Errorf(w, "Error creating PIN reset token", err)
+		return
+	}
+
+	// Launch this in goroutine so it doesn't delay our main request
+	go sendPinResetEmail(user.Email, token.Token)
+
+	api.OK(w, "PIN reset code has been sent to your email")
+}
+
+// Sets a new PIN with the code from RequestPinReset and the
+// user's password. Lifts any lockout on the PIN
+func ResetTransactionPin(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req ResetPinRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	if !checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	err = database.ConsumeVerificationToken(database.PIN_RESET, user.Email, req.Token)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenAttemptsExceeded) {
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOKEN_LOCKED,
+				"Too many wrong attempts. Please request a new code",
+			)
+			return
+		}
+		api.NotFound(w, "Invalid or expired token")
+		return
+	}
+
+	err = database.ChangeTransactionPin(user.Id, req.Pin)
+	if err != nil {
+		api.Errorf(w, "Error resetting transaction PIN", err)
+		return
+	}
+
+	clearAuthFailures(database.AUTH_ACTION_TRANSACTION_PIN, user.Email)
+	emitSecurityEvent(r, database.EVENT_PIN_RESET, user.Email, "")
+
+	api.OK(w, "Transaction PIN reset")
+}
@@ -8,7 +8,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/caleb-mwasikira/tap_gopay_backend/api"
 	"github.com/caleb-mwasikira/tap_gopay_backend/database"
@@ -56,9 +61,48 @@ func verifySignature(
 	if !ok {
 		return fmt.Errorf("invalid signature")
 	}
+
+	err = database.TouchPublicKey(email, b64EncodedPubKeyHash)
+	if err != nil {
+		log.Printf("Error updating public key last used time; %v\n", err)
+	}
 	return nil
 }
 
+// Resolves a phone number into the first active wallet owned by
+// that phone number. Wallet addresses are returned as is
+func resolveWalletAddress(account string) (string, error) {
+	if !isValidPhoneNumber(account) {
+		return account, nil
+	}
+
+	wallets, err := database.GetWalletsOwnedByPhoneNo(
+		account,
+		func(w *database.Wallet) bool {
+			return w.IsActive
+		},
+	)
+	if err != nil || len(wallets) == 0 {
+		return "", fmt.Errorf("error fetching wallets owned by '%v'; %v", account, err)
+	}
+	return wallets[0].WalletAddress, nil
+}
+
+// Checks that fee matches the transaction fee charged on amount
+func isValidTransactionFee(amount, fee float64) (bool, error) {
+	var expectedFee float64
+
+	transactionFee, err := getTransactionFees(amount)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	if transactionFee != nil {
+		expectedFee = transactionFee.Fee
+	}
+	return fee == expectedFee, nil
+}
+
 func SendMoney(w http.ResponseWriter, r *http.Request) {
 	user, ok := getAuthUser(r)
 	if !ok {
@@ -78,62 +122,66 @@ func SendMoney(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if isValidPhoneNumber(req.Sender) {
-		wallets, err := database.GetWalletsOwnedByPhoneNo(
-			req.Sender,
-			func(w *database.Wallet) bool {
-				return w.IsActive
-			},
-		)
-		if err != nil || len(wallets) == 0 {
-			err = fmt.Errorf("error fetching wallets owned by '%v'; %v", req.Sender, err)
-			api.Errorf(w, "Sender has no active wallet accounts", err)
-			return
-		}
-		req.Sender = wallets[0].WalletAddress
-	}
-
-	if isValidPhoneNumber(req.Receiver) {
-		wallets, err := database.GetWalletsOwnedByPhoneNo(
-			req.Receiver,
-			func(w *database.Wallet) bool {
-				return w.IsActive
-			},
-		)
-		if err != nil || len(wallets) == 0 {
-			err = fmt.Errorf("error fetching wallets owned by '%v'; %v", req.Receiver, err)
-			api.Errorf(w, "Receiver has no active wallet accounts", err)
-			return
-		}
-		req.Receiver = wallets[0].WalletAddress
+	// Signature covers the accounts as entered by the client,
+	// so verify it before resolving phone numbers into wallets
+	data := req.Hash()
+
+	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
+	if err != nil {
+		api.Unauthorized(w, "Error transferring funds. Signature verification failed")
+		return
 	}
 
-	if req.Sender == req.Receiver {
-		api.BadRequest(w, "Sender and receiver share the same account", nil)
+	if req.Amount >= TOTP_STEP_UP_AMOUNT && !requireStepUp(w, r, user) {
+		return
+	}
+
+	if !requireTransactionPin(w, r, user) {
 		return
 	}
 
-	// Check amount is within spending limits
-	ok = database.IsWithinSpendingLimits(req.Sender, req.Amount)
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
 	if !ok {
-		api.Conflict(w, "Wallet exceeded spending limits")
 		return
 	}
 
-	// Verify fee amount
-	var expectedFee float64
+	req.Sender, err = resolveWalletAddress(req.Sender)
+	if err != nil {
+		uncommittedError(w, r, "Sender has no active wallet accounts", err)
+		return
+	}
 
-	transactionFee, err := getTransactionFees(req.Amount)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		api.Errorf(w, "Error fetching transaction fees", err)
+	req.Receiver, err = resolveWalletAddress(req.Receiver)
+	if err != nil {
+		uncommittedError(w, r, "Receiver has no active wallet accounts", err)
 		return
 	}
 
-	if transactionFee != nil {
-		expectedFee = transactionFee.Fee
+	if !database.OwnsWallet(user.Id, req.Sender) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	if req.Sender == req.Receiver {
+		api.BadRequest(w, "Sender and receiver share the same account", nil)
+		return
+	}
+
+	// Check amount and fee are within spending limits
+	ok = database.IsWithinSpendingLimits(req.Sender, req.Amount+req.Fee)
+	if !ok {
+		notifyLimitExceeded(req.Sender, req.Amount+req.Fee)
+		api.Conflict(w, "Wallet exceeded spending limits")
+		return
 	}
 
-	if req.Fee != expectedFee {
+	// Verify fee amount
+	ok, err = isValidTransactionFee(req.Amount, req.Fee)
+	if err != nil {
+		uncommittedError(w, r, "Error fetching transaction fees", err)
+		return
+	}
+	if !ok {
 		api.BadRequest(w, "Invalid transaction fees", nil)
 		return
 	}
@@ -144,16 +192,23 @@ func SendMoney(w http.ResponseWriter, r *http.Request) {
 		req.Amount, req.Fee,
 		req.Timestamp, req.Signature,
 		req.PublicKeyHash,
+		payload,
 	)
 	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+
 		api.Errorf(w, "Error transferring funds", err)
 		return
 	}
 
-	receivers := []string{
-		t.Sender.WalletAddress, t.Receiver.WalletAddress,
+	go notifyTransfer(t)
+
+	if t.Status == "pending" {
+		go notifyCoOwners(SIGNATURE_REQUESTED, t)
 	}
-	go sendNotification(*t, receivers...)
 
 	switch t.Status {
 	case "confirmed":
@@ -166,86 +221,141 @@ func SendMoney(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// A user can request funds from another user
-func RequestFunds(w http.ResponseWriter, r *http.Request) {
+func GetTransaction(w http.ResponseWriter, r *http.Request) {
 	user, ok := getAuthUser(r)
 	if !ok {
 		api.Unauthorized(w, "Access to this route requires user login")
 		return
 	}
 
-	var req TransactionRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		api.BadRequest(w, "Error parsing request body", err)
+	transactionCode := chi.URLParam(r, "transaction_code")
+
+	if !database.IsSenderOrReceiver(user.Id, transactionCode) {
+		api.NotFound(w, fmt.Sprintf("Transaction '%v' not found", transactionCode))
 		return
 	}
 
-	if err := validateStruct(req); err != nil {
-		api.BadRequest(w, err.Error(), nil)
+	t, err := database.GetTransaction(transactionCode)
+	if err != nil {
+		api.Errorf(w, "Error fetching transaction", err)
 		return
 	}
 
-	data := req.Hash()
-	err = verifySignature(req.Signature, data, user.Email, req.PublicKeyHash)
-	if err != nil {
-		api.Errorf(w, "Error requesting funds. Signature verification failed", nil)
+	api.OK2(w, t)
+}
+
+func GetRecentTransactions(w http.ResponseWriter, r *http.Request) {
+	walletAddress := chi.URLParam(r, "wallet_address")
+	if err := validateWalletAddress(walletAddress); err != nil {
+		api.BadRequest(w, err.Error(), nil)
 		return
 	}
 
-	transaction, err := database.CreateRequestFunds(
-		req.Sender, req.Receiver, req.Amount,
-		req.Timestamp, req.Signature,
-		req.PublicKeyHash,
-	)
+	transactions, err := database.GetRecentTransactions(walletAddress)
 	if err != nil {
-		api.Errorf(w, "Error requesting funds", err)
+		api.Errorf(w, "Error fetching wallet transactions", err)
 		return
 	}
 
-	api.OK2(w, transaction)
+	api.OK2(w, transactions)
 }
 
-func GetTransaction(w http.ResponseWriter, r *http.Request) {
-	user, ok := getAuthUser(r)
-	if !ok {
-		api.Unauthorized(w, "Access to this route requires user login")
-		return
+// Parses query parameters of the transaction history route into a
+// transaction filter
+func parseTransactionFilter(query url.Values) (*database.TransactionFilter, error) {
+	filter := database.TransactionFilter{
+		Cursor:          query.Get("cursor"),
+		Status:          query.Get("status"),
+		TransactionType: query.Get("type"),
+		Counterparty:    query.Get("counterparty"),
 	}
 
-	transactionCode := chi.URLParam(r, "transaction_code")
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid limit; expected a positive number")
+		}
+		filter.Limit = limit
+	}
 
-	if !database.IsSenderOrReceiver(user.Id, transactionCode) {
-		api.NotFound(w, fmt.Sprintf("Transaction '%v' not found", transactionCode))
-		return
+	for key, dest := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v date; expected RFC3339 string", key)
+		}
+		*dest = &t
 	}
 
-	t, err := database.GetTransaction(transactionCode)
-	if err != nil {
-		api.Errorf(w, "Error fetching transaction", err)
-		return
+	for key, dest := range map[string]**float64{"min_amount": &filter.MinAmount, "max_amount": &filter.MaxAmount} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v; expected a number", key)
+		}
+		*dest = &amount
 	}
 
-	api.OK2(w, t)
+	allowedStatus := []string{"", "pending", "confirmed", "rejected"}
+	if !slices.Contains(allowedStatus, filter.Status) {
+		return nil, fmt.Errorf("invalid status. Expects status value to be one of ['pending', 'confirmed', 'rejected']")
+	}
+
+	allowedTypes := []string{"", "transfer", "refund", "commission", "deposit", "withdrawal"}
+	if !slices.Contains(allowedTypes, filter.TransactionType) {
+		return nil, fmt.Errorf("invalid type. Expects type value to be one of ['transfer', 'refund', 'commission', 'deposit', 'withdrawal']")
+	}
+
+	if filter.Counterparty != "" {
+		if err := validateAccount("counterparty", filter.Counterparty); err != nil {
+			return nil, err
+		}
+	}
+	return &filter, nil
 }
 
-func GetRecentTransactions(w http.ResponseWriter, r *http.Request) {
+// Fetches a page of transactions sent or received by a wallet.
+//
+// Supported query parameters are cursor, limit, from, to, status,
+// type, counterparty, min_amount and max_amount
+func GetTransactionHistory(w http.ResponseWriter, r *http.Request) {
 	walletAddress := chi.URLParam(r, "wallet_address")
 	if err := validateWalletAddress(walletAddress); err != nil {
 		api.BadRequest(w, err.Error(), nil)
 		return
 	}
 
-	transactions, err := database.GetRecentTransactions(walletAddress)
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	page, err := database.GetTransactionHistory(walletAddress, *filter)
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			api.BadRequest(w, "Invalid cursor", nil)
+			return
+		}
+
 		api.Errorf(w, "Error fetching wallet transactions", err)
 		return
 	}
 
-	api.OK2(w, transactions)
+	api.OK2(w, page)
 }
 
 type SignTransactionRequest struct {
+	Timestamp string `json:"timestamp"` // Time when transaction was signed by the client
+
 	Signature string `json:"signature" validate:"signature"` // Base64 encoded signature
 
 	// Base64 encoded hash of public key
@@ -253,6 +363,14 @@ type SignTransactionRequest struct {
 	PublicKeyHash string `json:"public_key_hash" validate:"public_key_hash"`
 }
 
+// Data signed by a co-signer. Binds the transaction being signed
+// to the time it was signed, so that signatures cannot be replayed
+func (req SignTransactionRequest) Hash(t *database.Transaction) []byte {
+	data := fmt.Sprintf("%s|%x|%s", t.TransactionCode, t.Hash(), req.Timestamp)
+	h := sha256.Sum256([]byte(data))
+	return h[:]
+}
+
 func SignTransaction(w http.ResponseWriter, r *http.Request) {
 	user, ok := getAuthUser(r)
 	if !ok {
@@ -281,9 +399,28 @@ func SignTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if t.Status != "pending" {
+		api.Conflict(w, "Only pending transactions can be signed")
+		return
+	}
+
+	// Only the sending wallet's owners may co-sign, and each of them
+	// only once. Refunds are exempt as in the verifySignature trigger
+	if t.TransactionType != "refund" && !database.OwnsWallet(user.Id, t.Sender.WalletAddress) {
+		api.Unauthorized(w, "This wallet does not belong to you")
+		return
+	}
+
+	if database.HasSignedTransaction(user.Id, transactionCode) {
+		api.Conflict(w, "You have already signed this transaction")
+		return
+	}
+
+	data := req.Hash(t)
+
 	err = verifySignature(
 		req.Signature,
-		t.Hash(),
+		data,
 		user.Email,
 		req.PublicKeyHash,
 	)
@@ -292,13 +429,32 @@ func SignTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireTransactionPin(w, r, user) {
+		return
+	}
+
+	payload, ok := checkReplay(w, user.Id, data, req.Timestamp)
+	if !ok {
+		return
+	}
+
 	err = database.AddSignature(
 		user.Id,
 		transactionCode,
 		req.Signature,
 		req.PublicKeyHash,
+		payload,
 	)
 	if err != nil {
+		if errors.Is(err, database.ErrSignatureReplayed) {
+			signatureReplayed(w)
+			return
+		}
+		if errors.Is(err, database.ErrAlreadySigned) {
+			api.Conflict(w, "You have already signed this transaction")
+			return
+		}
+
 		message := fmt.Sprintf("Error signing transaction '%v'", transactionCode)
 		api.Errorf(w, message, err)
 		return
@@ -310,5 +466,10 @@ func SignTransaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if transaction.Status == "confirmed" {
+		go notifyCoOwners(SIGNATURE_COMPLETED, transaction)
+		go notifyTransfer(transaction)
+	}
+
 	api.OK2(w, transaction)
 }
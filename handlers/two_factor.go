@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
+)
+
+const (
+	TOTP_ISSUER string = "TapGoPay"
+
+	// Header carrying a fresh TOTP or backup code for
+	// actions that require step-up authentication
+	TOTP_CODE_HEADER string = "TOTP-Code"
+
+	ERR_TOTP_REQUIRED string = "TOTP_REQUIRED"
+	ERR_INVALID_TOTP  string = "INVALID_TOTP"
+)
+
+// Transfers of at least this amount require a fresh TOTP code
+// from users with 2FA enabled.
+// Set with the TOTP_STEP_UP_AMOUNT environment variable
+var TOTP_STEP_UP_AMOUNT float64 = 10_000
+
+func init() {
+	utils.LoadDotenv()
+
+	value := os.Getenv("TOTP_STEP_UP_AMOUNT")
+	if value == "" {
+		return
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		log.Fatalf("Invalid TOTP_STEP_UP_AMOUNT environment variable '%v'\n", value)
+	}
+	TOTP_STEP_UP_AMOUNT = amount
+}
+
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+
+	// otpauth:// URI to show as a QR code for authenticator apps
+	ProvisioningUri string `json:"provisioning_uri"`
+}
+
+type TwoFactorCodeRequest struct {
+	// Code from the authenticator app or a backup code
+	Code string `json:"code" validate:"min=6,max=20"`
+}
+
+type BackupCodesResponse struct {
+	// Shown once; each code can be used in place of a TOTP code
+	BackupCodes []string `json:"backup_codes"`
+}
+
+var errSecondFactorRequired = errors.New("second factor required")
+
+// Checks a second factor for users with 2FA enabled.
+// Users without 2FA pass without a code
+func verifySecondFactor(userId int, code string) error {
+	enabled, err := database.IsTOTPEnabled(userId)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	if code == "" {
+		return errSecondFactorRequired
+	}
+	return database.VerifySecondFactor(userId, code)
+}
+
+func isInvalidSecondFactor(err error) bool {
+	return errors.Is(err, database.ErrTOTPCodeInvalid) || errors.Is(err, database.ErrTOTPCodeReused)
+}
+
+func writeSecondFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errSecondFactorRequired):
+		api.ErrorWithCode(
+			w, http.StatusUnauthorized, ERR_TOTP_REQUIRED,
+			"A code from your authenticator app is required",
+		)
+	case isInvalidSecondFactor(err):
+		api.ErrorWithCode(w, http.StatusUnauthorized, ERR_INVALID_TOTP, "Invalid or already used two-factor code")
+	default:
+		api.Errorf(w, "Error checking two-factor authentication", err)
+	}
+}
+
+// Checks a second factor from a logged in user. Wrong codes count
+// as failed logins, so codes cannot be guessed through step-up or
+// 2FA management routes any faster than through login
+func checkSecondFactor(w http.ResponseWriter, r *http.Request, user *database.User, code string) bool {
+	if !checkAuthThrottle(w, r, database.AUTH_ACTION_LOGIN, user.Email) {
+		return false
+	}
+
+	err := verifySecondFactor(user.Id, code)
+	if err != nil {
+		if isInvalidSecondFactor(err) {
+			recordAuthFailure(r, database.AUTH_ACTION_LOGIN, user.Email, database.EVENT_LOGIN_FAILED, "wrong two-factor code; "+err.Error())
+		}
+		writeSecondFactorError(w, err)
+		return false
+	}
+	return true
+}
+
+// Demands a fresh TOTP code in the TOTP-Code header
+// before a sensitive action
+func requireStepUp(w http.ResponseWriter, r *http.Request, user *database.User) bool {
+	return checkSecondFactor(w, r, user, r.Header.Get(TOTP_CODE_HEADER))
+}
+
+// Reads a TOTP or backup code from the request body and checks it
+// against the user's enabled 2FA
+func decodeSecondFactor(w http.ResponseWriter, r *http.Request, user *database.User) bool {
+	var req TwoFactorCodeRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return false
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return false
+	}
+
+	enabled, err := database.IsTOTPEnabled(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error checking two-factor authentication", err)
+		return false
+	}
+	if !enabled {
+		api.Conflict(w, "Two-factor authentication is not enabled")
+		return false
+	}
+
+	return checkSecondFactor(w, r, user, req.Code)
+}
+
+func GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	status, err := database.GetTwoFactorStatus(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error fetching two-factor status", err)
+		return
+	}
+
+	api.OK2(w, status)
+}
+
+// Starts 2FA enrollment by issuing a new secret for the user's
+// authenticator app. 2FA is only enabled once a code generated
+// from the secret is confirmed
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	secret, err := encrypt.GenerateTOTPSecret()
+	if err != nil {
+		api.Errorf(w, "Error generating two-factor secret", err)
+		return
+	}
+
+	err = database.SetupTOTP(user.Id, secret)
+	if err != nil {
+		if errors.Is(err, database.ErrTOTPAlreadyEnabled) {
+			api.Conflict(w, "Two-factor authentication is already enabled")
+			return
+		}
+		api.Errorf(w, "Error setting up two-factor authentication", err)
+		return
+	}
+
+	api.OK2(w, TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningUri: encrypt.TOTPProvisioningURI(secret, TOTP_ISSUER, user.Email),
+	})
+}
+
+// Confirms the authenticator app generates matching codes and
+// turns on 2FA. Responds with the user's backup codes
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	backupCodes, err := database.EnableTOTP(user.Id, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrTOTPAlreadyEnabled):
+			api.Conflict(w, "Two-factor authentication is already enabled")
+		case errors.Is(err, database.ErrTOTPNotEnabled):
+			api.Conflict(w, "Two-factor authentication has not been set up")
+		case isInvalidSecondFactor(err):
+			writeSecondFactorError(w, err)
+		default:
+			api.Errorf(w, "Error enabling two-factor authentication", err)
+		}
+		return
+	}
+
+	api.OK2(w, BackupCodesResponse{BackupCodes: backupCodes})
+}
+
+// Turns off 2FA. Requires a TOTP or backup code, so users who lost
+// their authenticator app can recover with a backup code
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	if !decodeSecondFactor(w, r, user) {
+		return
+	}
+
+	err := database.DisableTOTP(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error disabling two-factor authentication", err)
+		return
+	}
+
+	api.OK(w, "Two-factor authentication disabled")
+}
+
+// Replaces the user's backup codes, invalidating the old ones
+func RegenerateBackupCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	if !decodeSecondFactor(w, r, user) {
+		return
+	}
+
+	backupCodes, err := database.RegenerateBackupCodes(user.Id)
+	if err != nil {
+		api.Errorf(w, "Error generating backup codes", err)
+		return
+	}
+
+	api.OK2(w, BackupCodesResponse{BackupCodes: backupCodes})
+}
@@ -19,6 +19,8 @@ import (
 const (
 	MIN_NAME_LEN             = 4
 	MIN_PASSWORD_LEN         = 8
+	MIN_PIN_LEN              = 4
+	MAX_PIN_LEN              = 6
 	MIN_AMOUNT       float64 = 1.0
 	CURRENCY_CODE    string  = "KES"
 )
@@ -115,6 +117,50 @@ func validateStruct(obj any) error {
 					return err
 				}
 			}
+			if rule == "pin" {
+				str, _ := fieldValue.(string)
+				if err := validatePin(str); err != nil {
+					return err
+				}
+			}
+			if rule == "role" {
+				str, _ := fieldValue.(string)
+				if err := validateRole(str); err != nil {
+					return err
+				}
+			}
+			if rule == "agent_operation" {
+				str, _ := fieldValue.(string)
+				if err := validateAgentOperation(str); err != nil {
+					return err
+				}
+			}
+			if rule == "limit_window" {
+				str, _ := fieldValue.(string)
+
+				// Optional field; we only validate if value is provided
+				if !isEmpty(str) {
+					if err := validateLimitWindow(str); err != nil {
+						return err
+					}
+				}
+			}
+			if rule == "timezone" {
+				str, _ := fieldValue.(string)
+
+				// Optional field; we only validate if value is provided
+				if !isEmpty(str) {
+					if _, err := time.LoadLocation(str); err != nil {
+						return fmt.Errorf("invalid timezone '%v'; expected IANA timezone name such as 'Africa/Nairobi'", str)
+					}
+				}
+			}
+			if rule == "frequency" {
+				str, _ := fieldValue.(string)
+				if err := validateFrequency(str); err != nil {
+					return err
+				}
+			}
 			if rule == "expires_at" {
 				str, _ := fieldValue.(string)
 
@@ -278,11 +324,66 @@ func validateWalletAddress(walletAddress string) error {
 }
 
 // Used for setting spending limits on wallets.
-// Expects period value to be one of ['week', 'month', 'year']
+// Expects period value to be one of ['transaction', 'day', 'week', 'month', 'year']
 func validatePeriod(period string) error {
-	allowedPeriods := []string{"week", "month", "year"}
+	allowedPeriods := []string{"transaction", "day", "week", "month", "year"}
 	if !slices.Contains(allowedPeriods, period) {
-		return fmt.Errorf("invalid period. Expects period value to be one of ['week', 'month', 'year']")
+		return fmt.Errorf("invalid period. Expects period value to be one of ['transaction', 'day', 'week', 'month', 'year']")
+	}
+	return nil
+}
+
+// Transaction PINs are 4-6 ASCII digits
+func validatePin(pin string) error {
+	for _, char := range pin {
+		if char < '0' || char > '9' {
+			return errors.New("PIN must only contain digits 0-9")
+		}
+	}
+
+	// Only ASCII digits are left, so bytes and digits are the same length
+	if len(pin) < MIN_PIN_LEN || len(pin) > MAX_PIN_LEN {
+		return fmt.Errorf("PIN must be %d-%d digits long", MIN_PIN_LEN, MAX_PIN_LEN)
+	}
+	return nil
+}
+
+// Used for granting and revoking roles.
+// Expects role value to be one of ['user', 'admin', 'agent']
+func validateRole(role string) error {
+	allowedRoles := []string{"user", "admin", "agent"}
+	if !slices.Contains(allowedRoles, role) {
+		return fmt.Errorf("invalid role. Expects role value to be one of ['user', 'admin', 'agent']")
+	}
+	return nil
+}
+
+// Used for setting agent commissions.
+// Expects operation value to be one of ['cash_in', 'cash_out']
+func validateAgentOperation(operation string) error {
+	allowedOperations := []string{"cash_in", "cash_out"}
+	if !slices.Contains(allowedOperations, operation) {
+		return fmt.Errorf("invalid operation. Expects operation value to be one of ['cash_in', 'cash_out']")
+	}
+	return nil
+}
+
+// Used for setting spending limits on wallets.
+// Expects window value to be one of ['rolling', 'calendar']
+func validateLimitWindow(window string) error {
+	allowedWindows := []string{"rolling", "calendar"}
+	if !slices.Contains(allowedWindows, window) {
+		return fmt.Errorf("invalid window. Expects window value to be one of ['rolling', 'calendar']")
+	}
+	return nil
+}
+
+// Used for scheduling standing orders.
+// Expects frequency value to be one of ['daily', 'weekly', 'monthly', 'cron']
+func validateFrequency(frequency string) error {
+	allowedFrequencies := []string{"daily", "weekly", "monthly", "cron"}
+	if !slices.Contains(allowedFrequencies, frequency) {
+		return fmt.Errorf("invalid frequency. Expects frequency value to be one of ['daily', 'weekly', 'monthly', 'cron']")
 	}
 	return nil
 }
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/api"
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+const (
+	EMAIL_VERIFICATION_TTL time.Duration = 24 * time.Hour
+	PHONE_VERIFICATION_TTL time.Duration = 10 * time.Minute
+
+	ERR_TOKEN_THROTTLED string = "TOKEN_THROTTLED"
+	ERR_TOKEN_LOCKED    string = "TOKEN_LOCKED"
+)
+
+// Delivers text messages to phone numbers. Nil until an SMS
+// gateway is set with [SetSmsSender]; verification codes are
+// never sent, or logged, without one
+var sendSms func(phoneNo, message string) error
+
+func SetSmsSender(sender func(phoneNo, message string) error) {
+	sendSms = sender
+}
+
+type VerificationStatus struct {
+	EmailVerified bool `json:"email_verified"`
+	PhoneVerified bool `json:"phone_verified"`
+}
+
+type ConfirmVerificationRequest struct {
+	Token string `json:"token" validate:"min=1,max=10"`
+}
+
+func sendVerificationEmail(email, token string) {
+	sendEmail(
+		email,
+		"Verify your email",
+		"<html>"+
+			"<body style='font-family: Arial, sans-serif;'>"+
+			"<h2>Verify your email</h2>"+
+			"<p>Hello, there</p>"+
+			"<p>Use the following One-Time Password (token) to verify the email on your TapGoPay account:</p>"+
+			"<div style='font-size: 24px; font-weight: bold; background:#f4f4f4; padding:10px; border-radius:5px; display:inline-block;'>"+token+"</div>"+
+			"<p>This code will expire in <b>24 hours</b>.</p>"+
+			"<p>If you didn't create a TapGoPay account, you can safely ignore this email.</p>"+
+			"<br>"+
+			"<p>Best regards,<br>TapGoPay</p>"+
+			"</body>"+
+			"</html>",
+	)
+}
+
+func sendVerificationSms(phoneNo, token string) {
+	message := fmt.Sprintf(
+		"Your TapGoPay verification code is %v. It expires in %v minutes",
+		token, int(PHONE_VERIFICATION_TTL.Minutes()),
+	)
+
+	err := sendSms(phoneNo, message)
+	if err != nil {
+		log.Printf("Error sending SMS; %v\n", err)
+	}
+}
+
+func GetVerificationStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	api.OK2(w, VerificationStatus{
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+	})
+}
+
+// Creates a verification token for purpose and reports
+// whether it can be sent
+func createVerificationToken(
+	w http.ResponseWriter,
+	purpose string,
+	email string,
+	duration time.Duration,
+) (*database.VerificationToken, bool) {
+	token, err := database.CreateVerificationToken(purpose, email, duration)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenThrottled) {
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOKEN_THROTTLED,
+				fmt.Sprintf("A code was sent recently. Please wait %v before requesting another", database.TOKEN_RESEND_INTERVAL),
+			)
+			return nil, false
+		}
+		api.Errorf(w, "Error creating verification token", err)
+		return nil, false
+	}
+	return token, true
+}
+
+// Checks a token submitted with the request using verify
+func confirmVerification(w http.ResponseWriter, r *http.Request, verify func(email, token string) error) bool {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return false
+	}
+
+	var req ConfirmVerificationRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return false
+	}
+
+	if err := validateStruct(req); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return false
+	}
+
+	err = verify(user.Email, req.Token)
+	if err != nil {
+		if errors.Is(err, database.ErrTokenInvalid) {
+			api.NotFound(w, "Invalid or expired token")
+			return false
+		}
+		if errors.Is(err, database.ErrTokenAttemptsExceeded) {
+			api.ErrorWithCode(
+				w, http.StatusTooManyRequests, ERR_TOKEN_LOCKED,
+				"Too many wrong attempts. Please request a new code",
+			)
+			return false
+		}
+		if errors.Is(err, database.ErrPhoneNoTaken) {
+			api.Conflict(w, "Phone number is already verified on another account")
+			return false
+		}
+		api.Errorf(w, "Error verifying token", err)
+		return false
+	}
+	return true
+}
+
+// Sends a code to the user's email to prove they own it
+func SendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	if user.EmailVerified {
+		api.Conflict(w, "Email is already verified")
+		return
+	}
+
+	token, ok := createVerificationToken(w, database.EMAIL_VERIFICATION, user.Email, EMAIL_VERIFICATION_TTL)
+	if !ok {
+		return
+	}
+
+	// Launch this in goroutine so it doesn't delay our main request
+	go sendVerificationEmail(user.Email, token.Token)
+
+	api.OK(w, "Verification code has been sent to your email")
+}
+
+func ConfirmEmailVerification(w http.ResponseWriter, r *http.Request) {
+	if !confirmVerification(w, r, database.VerifyEmail) {
+		return
+	}
+	api.OK(w, "Email verified")
+}
+
+// Sends a code to the user's phone number to prove they own it.
+// Money sent to a phone number only reaches verified numbers
+func SendPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	user, ok := getAuthUser(r)
+	if !ok {
+		api.Unauthorized(w, "Access to this route requires user login")
+		return
+	}
+
+	if user.PhoneVerified {
+		api.Conflict(w, "Phone number is already verified")
+		return
+	}
+
+	if sendSms == nil {
+		api.Errorf(w, "Error sending verification code. SMS delivery is not configured", nil)
+		return
+	}
+
+	token, ok := createVerificationToken(w, database.PHONE_VERIFICATION, user.Email, PHONE_VERIFICATION_TTL)
+	if !ok {
+		return
+	}
+
+	go sendVerificationSms(user.PhoneNo, token.Token)
+
+	api.OK(w, "Verification code has been sent to your phone number")
+}
+
+func ConfirmPhoneVerification(w http.ResponseWriter, r *http.Request) {
+	if !confirmVerification(w, r, database.VerifyPhoneNo) {
+		return
+	}
+	api.OK(w, "Phone number verified")
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -10,10 +12,6 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-const (
-	INITIAL_DEPOSIT float64 = 100
-)
-
 type CreateWalletRequest struct {
 	WalletName string `json:"wallet_name" validate:"min=4"`
 
@@ -21,6 +19,20 @@ type CreateWalletRequest struct {
 
 	// Number of signatures required for wallet to complete transaction
 	NumSignatures uint `json:"num_signatures" validate:"min=1,max=10"`
+
+	// Minutes co-owners have to sign a pending transaction.
+	// Optional; defaults to 24 hours
+	ApprovalWindowMinutes int `json:"approval_window_minutes,omitempty"`
+}
+
+func validateApprovalWindow(minutes int) error {
+	if minutes < database.MIN_APPROVAL_WINDOW_MINUTES || minutes > database.MAX_APPROVAL_WINDOW_MINUTES {
+		return fmt.Errorf(
+			"approval window must be between %d and %d minutes",
+			database.MIN_APPROVAL_WINDOW_MINUTES, database.MAX_APPROVAL_WINDOW_MINUTES,
+		)
+	}
+	return nil
 }
 
 func CreateWallet(w http.ResponseWriter, r *http.Request) {
@@ -48,12 +60,19 @@ func CreateWallet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.ApprovalWindowMinutes != 0 {
+		if err := validateApprovalWindow(req.ApprovalWindowMinutes); err != nil {
+			api.BadRequest(w, err.Error(), nil)
+			return
+		}
+	}
+
 	wallet, err := database.CreateWallet(
 		user.Id,
 		req.WalletName,
-		INITIAL_DEPOSIT,
 		req.TotalOwners,
 		req.NumSignatures,
+		req.ApprovalWindowMinutes,
 	)
 	if err != nil {
 		api.Errorf(w, "Error creating wallet", err)
@@ -169,6 +188,13 @@ func ActivateWallet(w http.ResponseWriter, r *http.Request) {
 type SetupLimitRequest struct {
 	Period string  `json:"period" validate:"period"`
 	Amount float64 `json:"amount" validate:"amount"`
+
+	// Optional. One of rolling or calendar; defaults to rolling
+	Window string `json:"window" validate:"limit_window"`
+
+	// Optional IANA timezone name such as Africa/Nairobi used to
+	// align calendar windows; defaults to UTC
+	Timezone string `json:"timezone" validate:"timezone"`
 }
 
 func SetOrUpdateLimit(w http.ResponseWriter, r *http.Request) {
@@ -197,7 +223,21 @@ func SetOrUpdateLimit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.SetOrUpdateLimit(user.Id, walletAddress, req.Period, req.Amount)
+	if req.Window == "" {
+		req.Window = "rolling"
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+
+	err = database.SetOrUpdateLimit(
+		user.Id,
+		walletAddress,
+		req.Period,
+		req.Amount,
+		req.Window,
+		req.Timezone,
+	)
 	if err != nil {
 		api.Errorf(w, "Error setting spending limits", err)
 		return
@@ -206,6 +246,84 @@ func SetOrUpdateLimit(w http.ResponseWriter, r *http.Request) {
 	api.OK(w, "Successfully setup new spending limit")
 }
 
+// Fetches spending limits on a wallet and the allowance
+// remaining on each period
+func GetLimits(w http.ResponseWriter, r *http.Request) {
+	walletAddress := chi.URLParam(r, "wallet_address")
+	if err := validateWalletAddress(walletAddress); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	limits, err := database.GetLimits(walletAddress)
+	if err != nil {
+		api.Errorf(w, "Error fetching spending limits", err)
+		return
+	}
+
+	api.OK2(w, limits)
+}
+
+func DeleteLimit(w http.ResponseWriter, r *http.Request) {
+	walletAddress := chi.URLParam(r, "wallet_address")
+	if err := validateWalletAddress(walletAddress); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	period := chi.URLParam(r, "period")
+	if err := validatePeriod(period); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	err := database.DeleteLimit(walletAddress, period)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			api.NotFound(w, fmt.Sprintf("No %v spending limit set on wallet", period))
+			return
+		}
+
+		api.Errorf(w, "Error removing spending limit", err)
+		return
+	}
+
+	api.OK(w, "Successfully removed spending limit")
+}
+
+type ApprovalWindowRequest struct {
+	Minutes int `json:"minutes"`
+}
+
+func SetApprovalWindow(w http.ResponseWriter, r *http.Request) {
+	walletAddress := chi.URLParam(r, "wallet_address")
+	if err := validateWalletAddress(walletAddress); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	var req ApprovalWindowRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		api.BadRequest(w, "Error parsing request body", err)
+		return
+	}
+
+	if err := validateApprovalWindow(req.Minutes); err != nil {
+		api.BadRequest(w, err.Error(), nil)
+		return
+	}
+
+	err = database.SetApprovalWindow(walletAddress, req.Minutes)
+	if err != nil {
+		api.Errorf(w, "Error setting approval window", err)
+		return
+	}
+
+	api.OK(w, "Successfully updated approval window")
+}
+
 // A user can add a wallet owner by providing the
 // counterparts email or phone number
 type WalletOwnerRequest struct {
@@ -243,6 +361,11 @@ func AddWalletOwner(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// New owners can spend from the wallet
+	if !requireStepUp(w, r, loggedInUser) {
+		return
+	}
+
 	newUserId, err := database.GetUserIdFromEmailOrPhoneNo(req.Email, req.PhoneNo)
 	if err != nil {
 		message := fmt.Sprintf("User '%v' or '%v' not found", req.Email, req.PhoneNo)
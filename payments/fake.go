@@ -0,0 +1,65 @@
+package payments
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+const (
+	FAKE_RAIL string = "fake"
+
+	// Operations on this account always fail
+	FAKE_FAILING_ACCOUNT string = "0000000000"
+)
+
+// Local stand-in for an external provider, used in development
+// and tests. Every operation settles after a short delay, except
+// those on FAKE_FAILING_ACCOUNT which fail.
+// Callbacks are handed straight to deliver instead of being
+// posted to a webhook
+type FakeRail struct {
+	delay   time.Duration
+	deliver func(Callback)
+}
+
+func NewFakeRail(delay time.Duration, deliver func(Callback)) *FakeRail {
+	return &FakeRail{
+		delay:   delay,
+		deliver: deliver,
+	}
+}
+
+func (rail *FakeRail) Name() string {
+	return FAKE_RAIL
+}
+
+func (rail *FakeRail) Submit(req Request) (string, error) {
+	providerReference := fmt.Sprintf("FAKE%010d", rand.IntN(1e10))
+
+	go func() {
+		<-time.After(rail.delay)
+
+		callback := Callback{
+			Rail:              rail.Name(),
+			Reference:         req.Reference,
+			ProviderReference: providerReference,
+			Status:            STATUS_SETTLED,
+		}
+		if req.Account == FAKE_FAILING_ACCOUNT {
+			callback.Status = STATUS_FAILED
+			callback.Reason = fmt.Sprintf("Account '%v' declined the %v", req.Account, req.Operation)
+		}
+
+		rail.deliver(callback)
+	}()
+
+	return providerReference, nil
+}
+
+// The fake rail does not accept webhooks; anyone could
+// settle payments through them
+func (rail *FakeRail) ParseCallback(r *http.Request) (*Callback, error) {
+	return nil, fmt.Errorf("%v rail does not accept webhook callbacks", rail.Name())
+}
@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+)
+
+const (
+	DEPOSIT    string = "deposit"
+	WITHDRAWAL string = "withdrawal"
+
+	STATUS_SETTLED string = "settled"
+	STATUS_FAILED  string = "failed"
+)
+
+var (
+	rails = map[string]PaymentRail{}
+	mutex = sync.RWMutex{}
+)
+
+// Operation submitted to a payment rail
+type Request struct {
+	// Our payment code. Rails echo it back in callbacks
+	Reference string
+	Operation string // One of deposit or withdrawal
+
+	// Account on the rail, e.g. a phone number or bank account number
+	Account string
+	Amount  float64
+}
+
+// Outcome of an operation, delivered asynchronously by the rail
+// once the external provider settles or fails it
+type Callback struct {
+	Rail              string `json:"rail"`
+	Reference         string `json:"reference"`
+	ProviderReference string `json:"provider_reference"`
+	Status            string `json:"status"` // One of settled or failed
+	Reason            string `json:"reason,omitempty"`
+}
+
+func (callback Callback) Validate() error {
+	if callback.Reference == "" {
+		return fmt.Errorf("callback is missing payment reference")
+	}
+
+	if !slices.Contains([]string{STATUS_SETTLED, STATUS_FAILED}, callback.Status) {
+		return fmt.Errorf("invalid callback status '%v'", callback.Status)
+	}
+	return nil
+}
+
+// External source or destination of funds, e.g. mobile money or a bank.
+// Deposits top up a wallet from the rail and withdrawals pay out to it
+type PaymentRail interface {
+	// Unique name clients use to pick the rail
+	Name() string
+
+	// Submits an operation to the external provider and returns the
+	// provider's reference for it. The outcome is delivered later
+	// as a Callback
+	Submit(req Request) (string, error)
+
+	// Parses and authenticates a callback posted to the
+	// rail's webhook
+	ParseCallback(r *http.Request) (*Callback, error)
+}
+
+// Makes a rail available for deposits and withdrawals.
+// Registering a rail with the same name replaces the existing one
+func Register(rail PaymentRail) {
+	mutex.Lock()
+	rails[rail.Name()] = rail
+	mutex.Unlock()
+}
+
+func Get(name string) (PaymentRail, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	rail, ok := rails[name]
+	return rail, ok
+}
+
+// Names of all registered rails, sorted
+func Names() []string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	names := []string{}
+	for name := range rails {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func registerAgent(user User) (*http.Response, error) {
+	// Note: Make sure to setup tommy as an admin in the
+	// database for this request to work
+	requireLogin(tommy)
+
+	req := handlers.RegisterAgentRequest{
+		Email:        user.Email,
+		BusinessName: user.Username + " Agency",
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(testServer.URL+"/admin/agents", jsonContentType, bytes.NewBuffer(body))
+}
+
+func confirmCashOut(loginUser User, cashOut database.AgentTransaction) (*http.Response, error) {
+	requireLogin(loginUser)
+
+	fee, err := getTransactionFee(cashOut.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transaction fees; %v", err)
+	}
+
+	req := handlers.TransactionRequest{
+		Sender:    cashOut.CustomerWallet,
+		Receiver:  cashOut.FloatWallet,
+		Amount:    cashOut.Amount,
+		Fee:       fee,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	signature, pubKeyHash, err := signPayload(loginUser.Email, req.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("Error signing data; %v", err)
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash)
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	url := testServer.URL + fmt.Sprintf("/cash-outs/%v/confirm", cashOut.AgentTransactionCode)
+	return postWithPin(url, body, testPin)
+}
+
+func TestAgentCashOut(t *testing.T) {
+	// A fresh user is registered as the agent so that other
+	// tests relying on lee's role are not affected
+	agentUser := NewRandomUser()
+
+	resp, err := createAccount(agentUser)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	resp, err = registerAgent(agentUser)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var agent database.Agent
+
+	err = json.Unmarshal(body, &agent)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	// Test: Users cannot be registered as agents twice
+	resp, err = registerAgent(agentUser)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+
+	// Test: Agents cannot earn commission on their own wallets
+	agentsWallet, err := createWallet(agentUser)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(agentUser)
+
+	amount := 100.0
+	reqBody, err := json.Marshal(&handlers.CashOutRequest{
+		Customer: agentsWallet.WalletAddress,
+		Amount:   amount,
+	})
+	if err != nil {
+		t.Fatalf("Error marshalling request; %v\n", err)
+	}
+
+	resp, err = http.Post(testServer.URL+"/agent/cash-out", jsonContentType, bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusInternalServerError)
+	resp.Body.Close()
+
+	// Test: Agent requests a cash-out on behalf of tommy
+	req := handlers.CashOutRequest{
+		Customer: tommysWallet.WalletAddress,
+		Amount:   amount,
+	}
+	reqBody, err = json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Error marshalling request; %v\n", err)
+	}
+
+	resp, err = http.Post(testServer.URL+"/agent/cash-out", jsonContentType, bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusAccepted)
+	resp.Body.Close()
+
+	var cashOut database.AgentTransaction
+
+	err = json.Unmarshal(body, &cashOut)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if cashOut.Status != "pending" || cashOut.FloatWallet != agent.FloatWallet {
+		t.Fatalf("Expected pending cash-out into agent's float but got %+v\n", cashOut)
+	}
+
+	// Test: Customer confirms the cash-out by signing the transfer
+	resp, err = confirmCashOut(tommy, cashOut)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	err = json.Unmarshal(body, &cashOut)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if cashOut.Status != "completed" || cashOut.TransactionCode == "" {
+		t.Fatalf("Expected completed cash-out but got %+v\n", cashOut)
+	}
+
+	// Test: A completed cash-out cannot be confirmed again
+	resp, err = confirmCashOut(tommy, cashOut)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+
+	// Test: Cash-out shows up on the agent's daily statement
+	requireLogin(agentUser)
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	resp, err = http.Get(testServer.URL + "/agent/statements/" + today)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var statement database.AgentStatement
+
+	err = json.Unmarshal(body, &statement)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if statement.CashOutCount != 1 || statement.CashOutAmount != amount {
+		t.Fatalf("Expected 1 cash-out of KSH %.2f but got %+v\n", amount, statement)
+	}
+
+	// Test: Suspended agents cannot transact
+	requireLogin(tommy)
+
+	resp, err = http.Post(testServer.URL+"/admin/agents/"+agent.AgentNumber+"/suspend", jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	requireLogin(agentUser)
+
+	resp, err = http.Post(testServer.URL+"/agent/cash-out", jsonContentType, bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func adminUnlockAccount(email string) (*http.Response, error) {
+	requireLogin(tommy)
+
+	body, err := json.Marshal(&handlers.LockedAccountRequest{Email: email})
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(testServer.URL+"/admin/users/unlock", jsonContentType, bytes.NewBuffer(body))
+}
+
+func TestLoginThrottling(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	wrongPassword := user
+	wrongPassword.Password = user.Password + "x"
+
+	// Test: Failures past the free attempts are answered with
+	// the usual error, then the account backs off
+	for range handlers.ACCOUNT_LOGIN_POLICY.FreeAttempts + 1 {
+		resp, err = loginWithTOTP(wrongPassword, "")
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+		expectStatus(t, resp, http.StatusBadRequest)
+		resp.Body.Close()
+	}
+
+	// Test: Even the right password is refused during backoff
+	resp, err = loginWithTOTP(user, "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	resp.Body.Close()
+
+	if resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected Retry-After header on throttled login\n")
+	}
+
+	// Test: Admins can lift the backoff
+	resp, err = adminUnlockAccount(user.Email)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = loginWithTOTP(user, "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Successful logins clear failures, so there is
+	// nothing left to unlock
+	resp, err = adminUnlockAccount(user.Email)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusNotFound)
+	resp.Body.Close()
+
+	// Test: Failed logins are recorded as security events
+	resp, err = http.Get(testServer.URL + "/admin/security-events?email=" + user.Email)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var events []database.SecurityEvent
+
+	err = json.Unmarshal(body, &events)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	failures := 0
+	for _, event := range events {
+		if event.EventType == database.EVENT_LOGIN_FAILED {
+			failures++
+		}
+	}
+
+	if failures != handlers.ACCOUNT_LOGIN_POLICY.FreeAttempts+1 {
+		t.Errorf("Expected %v login_failed events but got %v\n", handlers.ACCOUNT_LOGIN_POLICY.FreeAttempts+1, failures)
+	}
+}
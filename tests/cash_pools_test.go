@@ -91,7 +91,7 @@ func fundCashPool(cashPool *database.CashPool, targetAmount float64) (float64, [
 			break
 		}
 
-		amount := utils.RoundFloat(handlers.INITIAL_DEPOSIT*rand.Float64(), 2)
+		amount := utils.RoundFloat(TEST_DEPOSIT*rand.Float64(), 2)
 		user := randomChoice(users)
 
 		wallet, err := createWallet(*user)
@@ -259,7 +259,7 @@ func TestChamaWithdrawal(t *testing.T) {
 		t.Fatalf("Error transferring funds; %v\n", err)
 	}
 
-	expectStatus(t, resp, http.StatusInternalServerError)
+	expectStatus(t, resp, http.StatusUnauthorized)
 	resp.Body.Close()
 
 	// For other cash pools apart from chama,
@@ -360,6 +360,7 @@ func TestRemoveCashPool(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating request; %v\n", err)
 	}
+	req.Header.Set(handlers.TRANSACTION_PIN_HEADER, testPin)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
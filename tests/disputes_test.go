@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+// Sends money from tommy to lee and returns the confirmed transfer
+func sendMoneyToLee(t *testing.T, amount float64) (*database.Wallet, *database.Wallet, *database.Transaction) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	resp, err := sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, amount)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var transaction database.Transaction
+
+	err = json.Unmarshal(body, &transaction)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+	return tommysWallet, leesWallet, &transaction
+}
+
+func openDispute(user User, transactionCode string, reason string) (*http.Response, error) {
+	requireLogin(user)
+
+	req := handlers.OpenDisputeRequest{
+		Reason: reason,
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(
+		testServer.URL+"/transactions/"+transactionCode+"/dispute",
+		jsonContentType,
+		bytes.NewBuffer(body),
+	)
+}
+
+// Posts an empty request body to a dispute route
+func resolveDispute(user User, rawUrl string) (*http.Response, error) {
+	requireLogin(user)
+	return http.Post(rawUrl, jsonContentType, nil)
+}
+
+func expectDispute(t *testing.T, resp *http.Response, expectedStatus string) database.Dispute {
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var dispute database.Dispute
+
+	err := json.Unmarshal(body, &dispute)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if dispute.Status != expectedStatus {
+		t.Fatalf("Expected dispute status '%v' but got '%v'\n", expectedStatus, dispute.Status)
+	}
+	return dispute
+}
+
+func TestDisputeReversal(t *testing.T) {
+	amount := 10.0
+	tommysWallet, _, transaction := sendMoneyToLee(t, amount)
+
+	// Test: Only the sender can dispute a transfer
+	resp, err := openDispute(lee, transaction.TransactionCode, "Wrong number")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	resp, err = openDispute(tommy, transaction.TransactionCode, "Wrong number")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	dispute := expectDispute(t, resp, "open")
+
+	wallet, err := getWallet(tommy, tommysWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+	balanceBeforeReversal := wallet.Balance
+
+	// Test: Receiver voluntarily reverses the transfer
+	disputeUrl := testServer.URL + "/disputes/" + dispute.DisputeCode
+
+	resp, err = resolveDispute(lee, disputeUrl+"/approve")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	dispute = expectDispute(t, resp, "reversed")
+
+	if dispute.RefundTransactionCode == "" {
+		t.Fatalf("Expected refund transaction code on reversed dispute\n")
+	}
+
+	// Opening the dispute and reversing it are both audited
+	if len(dispute.Events) != 2 {
+		t.Fatalf("Expected 2 dispute events but got %v\n", len(dispute.Events))
+	}
+
+	wallet, err = getWallet(tommy, tommysWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+
+	if wallet.Balance != balanceBeforeReversal+amount {
+		t.Fatalf("Expected sender's balance %v but got %v\n", balanceBeforeReversal+amount, wallet.Balance)
+	}
+
+	// Test: Disputes are reversed only once
+	resp, err = resolveDispute(lee, disputeUrl+"/approve")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestDisputeEscalation(t *testing.T) {
+	_, _, transaction := sendMoneyToLee(t, 10)
+
+	resp, err := openDispute(tommy, transaction.TransactionCode, "Wrong number")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	dispute := expectDispute(t, resp, "open")
+
+	// Test: Receiver declines; dispute waits on an admin
+	resp, err = resolveDispute(lee, testServer.URL+"/disputes/"+dispute.DisputeCode+"/decline")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectDispute(t, resp, "escalated")
+
+	// Test: Non-admins cannot force a reversal
+	adminUrl := testServer.URL + "/admin/disputes/" + dispute.DisputeCode
+
+	resp, err = resolveDispute(lee, adminUrl+"/reverse")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Note: Make sure to setup tommy as an admin in the
+	// database for this request to work
+	resp, err = resolveDispute(tommy, adminUrl+"/reverse")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectDispute(t, resp, "reversed")
+}
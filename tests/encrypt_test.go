@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
 )
@@ -57,3 +58,35 @@ func TestGenerateKeys(t *testing.T) {
 		t.Errorf("Expected 2 private keys generated with the same seed phrase to be equal")
 	}
 }
+
+// Test TOTP codes against the RFC 6238 test vectors,
+// truncated to 6 digits
+func TestTOTPCode(t *testing.T) {
+	// Base32 encoding of "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		step := encrypt.TOTPStep(time.Unix(unix, 0))
+
+		code, err := encrypt.TOTPCode(secret, step)
+		if err != nil {
+			t.Fatalf("Unexpected error generating TOTP code; %v\n", err)
+		}
+		if code != expected {
+			t.Errorf("Expected TOTP code %v at %v but got %v\n", expected, unix, code)
+		}
+
+		// Codes from the previous period are still accepted
+		matchedStep, ok := encrypt.VerifyTOTP(secret, code, time.Unix(unix, 0).Add(encrypt.TOTP_PERIOD))
+		if !ok || matchedStep != step {
+			t.Errorf("Expected TOTP code %v to verify within clock skew\n", code)
+		}
+	}
+}
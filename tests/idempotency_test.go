@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func sendMoneyWithIdempotencyKey(key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+"/send-money", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", jsonContentType)
+	req.Header.Set(handlers.IDEMPOTENCY_KEY_HEADER, key)
+	req.Header.Set(handlers.TRANSACTION_PIN_HEADER, testPin)
+
+	return http.DefaultClient.Do(req)
+}
+
+func TestIdempotentSendMoney(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(tommy)
+
+	key := randomString(32)
+	body, err := newSendMoneyRequest(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 1)
+	if err != nil {
+		t.Fatalf("Error creating send money request; %v\n", err)
+	}
+
+	resp, err := sendMoneyWithIdempotencyKey(key, body)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	respBody := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var original database.Transaction
+	if err = json.Unmarshal(respBody, &original); err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	// Test: Retrying the request should return the original transaction
+	resp, err = sendMoneyWithIdempotencyKey(key, body)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	respBody = expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	if resp.Header.Get(handlers.IDEMPOTENT_REPLAYED_HEADER) != "true" {
+		t.Errorf("Expected retried response to be marked as replayed\n")
+	}
+
+	var replayed database.Transaction
+	if err = json.Unmarshal(respBody, &replayed); err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if replayed.TransactionCode != original.TransactionCode {
+		t.Fatalf("Expected transaction '%v' but got '%v'\n", original.TransactionCode, replayed.TransactionCode)
+	}
+
+	// Test: Reusing the key for a different request should fail
+	body, err = newSendMoneyRequest(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 2)
+	if err != nil {
+		t.Fatalf("Error creating send money request; %v\n", err)
+	}
+
+	resp, err = sendMoneyWithIdempotencyKey(key, body)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestIdempotentFailedSendMoney(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(tommy)
+
+	key := randomString(32)
+	body, err := newSendMoneyRequest(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, TEST_DEPOSIT*2)
+	if err != nil {
+		t.Fatalf("Error creating send money request; %v\n", err)
+	}
+
+	resp, err := sendMoneyWithIdempotencyKey(key, body)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusInternalServerError)
+	resp.Body.Close()
+
+	// Test: A transfer that may have been saved keeps its key, so
+	// retries get the original error instead of running it again
+	resp, err = sendMoneyWithIdempotencyKey(key, body)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusInternalServerError)
+	resp.Body.Close()
+
+	if resp.Header.Get(handlers.IDEMPOTENT_REPLAYED_HEADER) != "true" {
+		t.Errorf("Expected retried response to be marked as replayed\n")
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+)
+
+func getLedgerEntries(user User, walletAddress string) ([]database.LedgerEntry, error) {
+	requireLogin(user)
+
+	resp, err := http.Get(testServer.URL + "/wallets/" + walletAddress + "/ledger")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var entries []database.LedgerEntry
+	err = json.NewDecoder(resp.Body).Decode(&entries)
+	return entries, err
+}
+
+func TestLedgerPostings(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	amount := 100.0
+	fee, err := getTransactionFee(amount)
+	if err != nil {
+		t.Fatalf("Error fetching transaction fees; %v\n", err)
+	}
+
+	resp, err := sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, amount)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Balances should reflect the transfer and fee
+	wallet, err := getWallet(tommy, tommysWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+
+	expectedBalance := tommysWallet.Balance - amount - fee
+	if wallet.Balance != expectedBalance {
+		t.Fatalf("Expected sender's balance %v but got %v\n", expectedBalance, wallet.Balance)
+	}
+
+	wallet, err = getWallet(lee, leesWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+
+	expectedBalance = leesWallet.Balance + amount
+	if wallet.Balance != expectedBalance {
+		t.Fatalf("Expected receiver's balance %v but got %v\n", expectedBalance, wallet.Balance)
+	}
+
+	// Sender's ledger should hold the transfer and fee debits
+	entries, err := getLedgerEntries(tommy, tommysWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching ledger entries; %v\n", err)
+	}
+
+	debits := 0.0
+	for _, entry := range entries {
+		if entry.EntryType == "debit" {
+			debits += entry.Amount
+		}
+	}
+	if debits != amount+fee {
+		t.Fatalf("Expected sender's debits to total %v but got %v\n", amount+fee, debits)
+	}
+
+	// Materialised balances should equal the sum of postings
+	report, err := database.ReconcileLedger()
+	if err != nil {
+		t.Fatalf("Error reconciling ledger; %v\n", err)
+	}
+
+	if !report.Ok() {
+		t.Fatalf("Expected reconciled ledger but got %+v\n", report)
+	}
+}
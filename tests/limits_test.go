@@ -2,12 +2,15 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand/v2"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
 	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
 	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
 )
@@ -21,7 +24,7 @@ func TestSetOrUpdateLimit(t *testing.T) {
 	}
 
 	// Setup a spending limit on tommy's wallet
-	limit := handlers.INITIAL_DEPOSIT * rand.Float64()
+	limit := TEST_DEPOSIT * rand.Float64()
 
 	req := handlers.SetupLimitRequest{
 		Period: "week",
@@ -50,6 +53,15 @@ func TestSetOrUpdateLimit(t *testing.T) {
 		t.Fatalf("Error creating wallet; %v\n", err)
 	}
 
+	// Tommy waits for notice of refused transfers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limitNotifications, err := waitForNotifications[handlers.LimitExceededNotification](ctx, tommy, handlers.LIMIT_EXCEEDED)
+	if err != nil {
+		t.Fatalf("Error establishing notifications channel; %v\n", err)
+	}
+
 	// Test spending limit is not exceeded by sending amount > limit
 	resp, err = sendMoney(
 		tommysWallet.WalletAddress,
@@ -64,12 +76,31 @@ func TestSetOrUpdateLimit(t *testing.T) {
 	expectStatus(t, resp, http.StatusConflict)
 	resp.Body.Close()
 
-	// Test spending limit is not exceeded by sending small amounts that are > limit
+	// Test: Owners are told when a transfer goes over the limit
+	select {
+	case <-time.After(10 * time.Second):
+		t.Errorf("Tired of waiting for limit exceeded notification")
+
+	case notification := <-limitNotifications:
+		if notification.WalletAddress != tommysWallet.WalletAddress {
+			t.Errorf("Expected limit exceeded notification for wallet '%v' but got '%v'\n",
+				tommysWallet.WalletAddress, notification.WalletAddress)
+		}
+	}
+	cancel()
+
+	// Test spending limit is not exceeded by sending small amounts that are > limit.
+	// Fees count towards the limit
 	var totalAmountSpent float64 = 0
 
 	for totalAmountSpent < limit {
 		amount := 1 + utils.RoundFloat(10*rand.Float64(), 2)
 
+		fee, err := getTransactionFee(amount)
+		if err != nil {
+			t.Fatalf("Error fetching transaction fee; %v\n", err)
+		}
+
 		resp, err = sendMoney(
 			tommysWallet.WalletAddress,
 			leesWallet.WalletAddress,
@@ -80,13 +111,192 @@ func TestSetOrUpdateLimit(t *testing.T) {
 			t.Fatalf("Error transferring funds; %v\n", err)
 		}
 
-		if (totalAmountSpent + amount) > limit {
+		if (totalAmountSpent + amount + fee) > limit {
 			expectStatus(t, resp, http.StatusConflict)
 			break
 		} else {
 			expectStatus(t, resp, http.StatusOK)
-			totalAmountSpent += amount
+			totalAmountSpent += amount + fee
 		}
 		resp.Body.Close()
 	}
 }
+
+func setLimit(walletAddress string, req handlers.SetupLimitRequest) (*http.Response, error) {
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(
+		testServer.URL+fmt.Sprintf("/wallets/%v/limit", walletAddress),
+		jsonContentType,
+		bytes.NewBuffer(body),
+	)
+}
+
+func getLimits(t *testing.T, walletAddress string) map[string]database.Limit {
+	resp, err := http.Get(testServer.URL + fmt.Sprintf("/wallets/%v/limits", walletAddress))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var limits []database.Limit
+
+	err = json.Unmarshal(body, &limits)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	limitsByPeriod := map[string]database.Limit{}
+	for _, limit := range limits {
+		limitsByPeriod[limit.Period] = limit
+	}
+	return limitsByPeriod
+}
+
+func TestMultipleLimits(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Cap each transfer at KSH 20 and weekly spending at KSH 50
+	requireLogin(tommy)
+
+	for period, amount := range map[string]float64{"transaction": 20, "week": 50} {
+		resp, err := setLimit(tommysWallet.WalletAddress, handlers.SetupLimitRequest{
+			Period: period,
+			Amount: amount,
+		})
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+
+		expectStatus(t, resp, http.StatusOK)
+		resp.Body.Close()
+	}
+
+	// Test: Transfers above the per-transaction limit are rejected
+	resp, err := sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 25)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+
+	fee, err := getTransactionFee(10)
+	if err != nil {
+		t.Fatalf("Error fetching transaction fee; %v\n", err)
+	}
+
+	resp, err = sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 10)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Remaining allowance is reported for each period
+	requireLogin(tommy)
+	limits := getLimits(t, tommysWallet.WalletAddress)
+
+	if len(limits) != 2 {
+		t.Fatalf("Expected 2 spending limits but got %v\n", len(limits))
+	}
+	expectedRemaining := utils.RoundFloat(50-10-fee, 2)
+
+	if remaining := limits["week"].Remaining; remaining != expectedRemaining {
+		t.Fatalf("Expected weekly allowance of %v but got %v\n", expectedRemaining, remaining)
+	}
+	if remaining := limits["transaction"].Remaining; remaining != 20 {
+		t.Fatalf("Expected per-transaction allowance of 20 but got %v\n", remaining)
+	}
+
+	// Test: Removing the per-transaction limit allows larger transfers
+	deleteUrl := testServer.URL + fmt.Sprintf("/wallets/%v/limits/transaction", tommysWallet.WalletAddress)
+
+	req, err := http.NewRequest(http.MethodDelete, deleteUrl, nil)
+	if err != nil {
+		t.Fatalf("Error creating request; %v\n", err)
+	}
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusNotFound)
+	resp.Body.Close()
+
+	resp, err = sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 25)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+}
+
+func TestCalendarLimit(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(tommy)
+
+	// Test: Unknown timezones are rejected
+	resp, err := setLimit(tommysWallet.WalletAddress, handlers.SetupLimitRequest{
+		Period:   "day",
+		Amount:   50,
+		Window:   "calendar",
+		Timezone: "Mars/Olympus_Mons",
+	})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	resp, err = setLimit(tommysWallet.WalletAddress, handlers.SetupLimitRequest{
+		Period:   "day",
+		Amount:   50,
+		Window:   "calendar",
+		Timezone: "Africa/Nairobi",
+	})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	limit := getLimits(t, tommysWallet.WalletAddress)["day"]
+
+	if limit.Window != "calendar" || limit.Timezone != "Africa/Nairobi" {
+		t.Fatalf("Expected calendar limit in Africa/Nairobi but got %v limit in %v\n", limit.Window, limit.Timezone)
+	}
+	if limit.Remaining != 50 {
+		t.Fatalf("Expected daily allowance of 50 but got %v\n", limit.Remaining)
+	}
+}
@@ -41,6 +41,13 @@ func init() {
 	http.DefaultClient.Jar = jar
 
 	r = handlers.GetRoutes()
+
+	// Deposits and withdrawals in tests settle through the fake rail
+	handlers.RegisterFakeRail()
+
+	// SMS codes cannot be read in tests; verification tokens are
+	// created directly in the database instead
+	handlers.SetSmsSender(func(phoneNo, message string) error { return nil })
 }
 
 func printResponse(resp *http.Response, expectedStatusCode int) []byte {
@@ -133,6 +140,16 @@ func TestMain(m *testing.M) {
 			log.Fatalf("Error creating test accounts; %v\n", err)
 		}
 		resp.Body.Close()
+
+		err = verifyPhoneNo(user)
+		if err != nil {
+			log.Fatalf("Error verifying test account phone numbers; %v\n", err)
+		}
+
+		err = setTransactionPin(user, testPin)
+		if err != nil {
+			log.Fatalf("Error setting test account transaction PINs; %v\n", err)
+		}
 	}
 
 	fees, err := getAllTransactionFees(testServer.URL)
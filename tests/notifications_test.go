@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,12 +11,16 @@ import (
 	"time"
 
 	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
 	"github.com/gorilla/websocket"
 )
 
+// Subscribes to the user's notifications and passes on the data
+// of those of eventType. Other notifications are skipped
 func waitForNotifications[T any](
 	ctx context.Context,
 	user User,
+	eventType string,
 ) (<-chan T, error) {
 	accessToken := requireLogin(user)
 
@@ -50,11 +55,20 @@ func waitForNotifications[T any](
 				return
 
 			default:
-				var message T
-				if err := conn.ReadJSON(&message); err != nil {
+				var envelope database.Notification
+				if err := conn.ReadJSON(&envelope); err != nil {
 					log.Printf("error reading notification message; %v\n", err)
 					return
 				}
+				if envelope.Type != eventType {
+					continue
+				}
+
+				var message T
+				if err := json.Unmarshal(envelope.Data, &message); err != nil {
+					log.Printf("error decoding %v notification; %v\n", eventType, err)
+					return
+				}
 				select {
 				case notifications <- message:
 				case <-ctx.Done(): // allow exit if caller cancels while sending
@@ -84,7 +98,13 @@ func TestNotifications(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	notifications, err := waitForNotifications[database.Transaction](ctx, lee)
+	notifications, err := waitForNotifications[database.Transaction](ctx, lee, handlers.MONEY_RECEIVED)
+	if err != nil {
+		log.Fatalf("Error establishing notifications channel; %v\n", err)
+	}
+
+	// Lee is also subscribed from a second device
+	otherDevice, err := waitForNotifications[database.Transaction](ctx, lee, handlers.MONEY_RECEIVED)
 	if err != nil {
 		log.Fatalf("Error establishing notifications channel; %v\n", err)
 	}
@@ -103,13 +123,134 @@ func TestNotifications(t *testing.T) {
 	expectStatus(t, resp, http.StatusOK)
 	resp.Body.Close()
 
-	// Lee should receive notification of transaction
-	select {
-	case <-time.After(10 * time.Second):
-		cancel()
-		t.Errorf("Tired of waiting for transaction notification")
+	// Lee should receive notification of transaction on both devices
+	for _, device := range []<-chan database.Transaction{notifications, otherDevice} {
+		select {
+		case <-time.After(10 * time.Second):
+			cancel()
+			t.Errorf("Tired of waiting for transaction notification")
+
+		case <-device:
+			log.Println("Received transaction notification from server")
+		}
+	}
+}
+
+func getNotifications(t *testing.T, query string) database.NotificationPage {
+	resp, err := http.Get(testServer.URL + "/notifications" + query)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var page database.NotificationPage
 
-	case <-notifications:
-		log.Println("Received transaction notification from server")
+	err = json.Unmarshal(body, &page)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+	return page
+}
+
+func TestMissedNotifications(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Remember the last notification lee saw
+	requireLogin(lee)
+
+	var cursor int64
+	if page := getNotifications(t, "?limit=1"); len(page.Notifications) > 0 {
+		cursor = page.Notifications[0].Id
 	}
+
+	// Tommy sends money to lee while lee is not listening
+	resp, err := sendMoney(
+		tommysWallet.WalletAddress,
+		leesWallet.WalletAddress,
+		tommy,
+		1,
+	)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var transaction database.Transaction
+
+	err = json.Unmarshal(body, &transaction)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	// Test: Lee catches up on notifications since the cursor
+	requireLogin(lee)
+
+	var missed *database.Notification
+
+	// Notifications are sent in the background, so allow them time to be saved
+	for range 10 {
+		page := getNotifications(t, fmt.Sprintf("?since=%v&unread=true&type=%v", cursor, handlers.MONEY_RECEIVED))
+
+		for _, n := range page.Notifications {
+			if n.Version != handlers.NOTIFICATION_VERSION || n.Type != handlers.MONEY_RECEIVED {
+				t.Fatalf("Expected version %v %v notification but got version %v %v\n",
+					handlers.NOTIFICATION_VERSION, handlers.MONEY_RECEIVED, n.Version, n.Type)
+			}
+
+			var data database.Transaction
+			if err := json.Unmarshal(n.Data, &data); err != nil {
+				continue
+			}
+			if data.TransactionCode == transaction.TransactionCode {
+				missed = n
+			}
+		}
+
+		if missed != nil {
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	if missed == nil {
+		t.Fatalf("Expected notification of transaction '%v' since cursor %v\n", transaction.TransactionCode, cursor)
+	}
+
+	// Test: Read notifications are left out of unread ones
+	resp, err = http.Post(testServer.URL+fmt.Sprintf("/notifications/%v/read", missed.Id), jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	page := getNotifications(t, fmt.Sprintf("?since=%v&unread=true", cursor))
+
+	for _, n := range page.Notifications {
+		if n.Id == missed.Id {
+			t.Errorf("Expected notification %v to be read\n", missed.Id)
+		}
+	}
+
+	// Test: Users cannot mark other users' notifications
+	requireLogin(bob)
+
+	resp, err = http.Post(testServer.URL+fmt.Sprintf("/notifications/%v/read", missed.Id), jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusNotFound)
+	resp.Body.Close()
 }
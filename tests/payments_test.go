@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+	"github.com/caleb-mwasikira/tap_gopay_backend/payments"
+)
+
+const (
+	// Amount deposited into every wallet created in tests
+	TEST_DEPOSIT float64 = 100
+)
+
+func deposit(user User, walletAddress string, account string, amount float64) (*http.Response, error) {
+	requireLogin(user)
+
+	req := handlers.DepositRequest{
+		WalletAddress: walletAddress,
+		Rail:          payments.FAKE_RAIL,
+		Account:       account,
+		Amount:        amount,
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(testServer.URL+"/deposits", jsonContentType, bytes.NewBuffer(body))
+}
+
+func withdraw(user User, walletAddress string, account string, amount float64) (*http.Response, error) {
+	requireLogin(user)
+
+	req := handlers.WithdrawalRequest{
+		WalletAddress: walletAddress,
+		Rail:          payments.FAKE_RAIL,
+		Account:       account,
+		Amount:        amount,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+	}
+
+	signature, pubKeyHash, err := signPayload(user.Email, req.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("Error signing data; %v", err)
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash)
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return postWithPin(testServer.URL+"/withdrawals", body, testPin)
+}
+
+// Polls a payment until the rail settles or fails it
+func waitForPayment(user User, paymentCode string) (*database.Payment, error) {
+	requireLogin(user)
+
+	timeout := time.After(10 * time.Second)
+
+	for {
+		resp, err := http.Get(testServer.URL + "/payments/" + paymentCode)
+		if err != nil {
+			return nil, err
+		}
+
+		var payment database.Payment
+
+		err = json.NewDecoder(resp.Body).Decode(&payment)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if payment.Status != "pending" {
+			return &payment, nil
+		}
+
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf("timed out waiting for payment '%v'", paymentCode)
+		case <-time.After(handlers.FAKE_RAIL_SETTLEMENT_DELAY / 2):
+		}
+	}
+}
+
+// Starts a payment and waits for the rail to settle or fail it
+func waitForPaymentResponse(user User, resp *http.Response, err error) (*database.Payment, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("expected status code %v but got %v", http.StatusAccepted, resp.StatusCode)
+	}
+
+	var payment database.Payment
+
+	err = json.NewDecoder(resp.Body).Decode(&payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return waitForPayment(user, payment.PaymentCode)
+}
+
+func depositAndWait(user User, walletAddress string, account string, amount float64) (*database.Payment, error) {
+	resp, err := deposit(user, walletAddress, account, amount)
+	return waitForPaymentResponse(user, resp, err)
+}
+
+func withdrawAndWait(user User, walletAddress string, account string, amount float64) (*database.Payment, error) {
+	resp, err := withdraw(user, walletAddress, account, amount)
+	return waitForPaymentResponse(user, resp, err)
+}
+
+func expectBalance(t *testing.T, user User, walletAddress string, expected float64) {
+	wallet, err := getWallet(user, walletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+
+	if wallet.Balance != expected {
+		t.Fatalf("Expected wallet balance KSH %.2f but got KSH %.2f\n", expected, wallet.Balance)
+	}
+}
+
+func TestDeposit(t *testing.T) {
+	wallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: Wallets are only funded through deposits
+	expectBalance(t, tommy, wallet.WalletAddress, TEST_DEPOSIT)
+
+	// Test: Deposits through unsupported rails are rejected
+	requireLogin(tommy)
+
+	body, err := json.Marshal(&handlers.DepositRequest{
+		WalletAddress: wallet.WalletAddress,
+		Rail:          "carrier-pigeon",
+		Account:       randomPhoneNo(),
+		Amount:        10,
+	})
+	if err != nil {
+		t.Fatalf("Error marshalling request; %v\n", err)
+	}
+
+	resp, err := http.Post(testServer.URL+"/deposits", jsonContentType, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	// Test: Failed deposits are not credited
+	payment, err := depositAndWait(tommy, wallet.WalletAddress, payments.FAKE_FAILING_ACCOUNT, 50)
+	if err != nil {
+		t.Fatalf("Error depositing funds; %v\n", err)
+	}
+
+	if payment.Status != "failed" || payment.FailureReason == "" {
+		t.Fatalf("Expected failed deposit but got %+v\n", payment)
+	}
+
+	expectBalance(t, tommy, wallet.WalletAddress, TEST_DEPOSIT)
+
+	// Test: Users cannot deposit into wallets they do not own
+	resp, err = deposit(lee, wallet.WalletAddress, randomPhoneNo(), 10)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+}
+
+func TestWithdrawal(t *testing.T) {
+	wallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: Failed withdrawals release the held funds
+	payment, err := withdrawAndWait(tommy, wallet.WalletAddress, payments.FAKE_FAILING_ACCOUNT, 40)
+	if err != nil {
+		t.Fatalf("Error withdrawing funds; %v\n", err)
+	}
+
+	if payment.Status != "failed" {
+		t.Fatalf("Expected failed withdrawal but got %+v\n", payment)
+	}
+
+	expectBalance(t, tommy, wallet.WalletAddress, TEST_DEPOSIT)
+
+	payment, err = withdrawAndWait(tommy, wallet.WalletAddress, randomPhoneNo(), 40)
+	if err != nil {
+		t.Fatalf("Error withdrawing funds; %v\n", err)
+	}
+
+	if payment.Status != "settled" || payment.ProviderReference == "" {
+		t.Fatalf("Expected settled withdrawal but got %+v\n", payment)
+	}
+
+	expectBalance(t, tommy, wallet.WalletAddress, TEST_DEPOSIT-40)
+
+	// Test: Withdrawals cannot exceed the wallet's balance
+	resp, err := withdraw(tommy, wallet.WalletAddress, randomPhoneNo(), TEST_DEPOSIT)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	if resp.StatusCode == http.StatusAccepted {
+		t.Fatalf("Expected withdrawal exceeding balance to be rejected\n")
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,361 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func encodePublicKey(privKey *ecdsa.PrivateKey) (string, []byte, error) {
+	pubKeyBytes, err := encrypt.PemEncodePublicKey(&privKey.PublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pubKeyHash := sha256.Sum256(pubKeyBytes)
+	return base64.StdEncoding.EncodeToString(pubKeyBytes), pubKeyHash[:], nil
+}
+
+// Logs in with a specific device key
+func loginWithKey(user User, privKey *ecdsa.PrivateKey) (*http.Response, error) {
+	pubKey, _, err := encodePublicKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&handlers.LoginRequest{
+		Email:     user.Email,
+		Password:  user.Password,
+		PublicKey: pubKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenClient.Post(testServer.URL+"/auth/login", jsonContentType, bytes.NewBuffer(body))
+}
+
+func postWithToken(path string, accessToken string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, testServer.URL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", jsonContentType)
+	req.Header.Set("AuthToken", fmt.Sprintf("Bearer %v", accessToken))
+
+	return tokenClient.Do(req)
+}
+
+// Enrolls newKey, signing the request with signingKey
+func enrollPublicKey(
+	accessToken string,
+	signingKey *ecdsa.PrivateKey,
+	newKey *ecdsa.PrivateKey,
+	deviceName string,
+) (*http.Response, error) {
+	pubKey, _, err := encodePublicKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, signingKeyHash, err := encodePublicKey(signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	req := handlers.EnrollPublicKeyRequest{
+		PublicKey:     pubKey,
+		DeviceName:    deviceName,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339),
+		PublicKeyHash: base64.StdEncoding.EncodeToString(signingKeyHash),
+	}
+
+	signature, err := ecdsa.SignASN1(rand.Reader, signingKey, req.Hash())
+	if err != nil {
+		return nil, err
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return postWithToken("/public-keys", accessToken, body)
+}
+
+func revokePublicKey(accessToken string, privKey *ecdsa.PrivateKey) (*http.Response, error) {
+	_, pubKeyHash, err := encodePublicKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&handlers.RevokePublicKeyRequest{
+		PublicKeyHash: base64.StdEncoding.EncodeToString(pubKeyHash),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return postWithToken("/public-keys/revoke", accessToken, body)
+}
+
+func TestPublicKeyEnrollment(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	trustedKey, err := getPrivateKey(user.Email)
+	if err != nil {
+		t.Fatalf("Error loading private key; %v\n", err)
+	}
+
+	newKey, _, err := encrypt.GenerateKeyPair(user.Password + randomString(8))
+	if err != nil {
+		t.Fatalf("Error generating key pair; %v\n", err)
+	}
+
+	tokens, err := loginSession(user)
+	if err != nil {
+		t.Fatalf("Error logging in; %v\n", err)
+	}
+
+	// Test: Login with a key that has not been enrolled fails
+	resp, err = loginWithKey(user, newKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: A key cannot vouch for itself
+	resp, err = enrollPublicKey(tokens.AccessToken, newKey, newKey, "New phone")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Keys signed by a trusted key are enrolled
+	resp, err = enrollPublicKey(tokens.AccessToken, trustedKey, newKey, "New phone")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = loginWithKey(user, newKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = requestWithToken(http.MethodGet, "/public-keys", tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var keys []database.PublicKey
+
+	err = json.Unmarshal(body, &keys)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 public keys but got %v\n", len(keys))
+	}
+
+	for _, key := range keys {
+		if key.LastUsedAt == "" || key.ActiveSessions != 1 {
+			t.Fatalf("Unexpected public key details %+v\n", key)
+		}
+	}
+
+	// Test: Revoked keys can neither log in nor sign
+	resp, err = revokePublicKey(tokens.AccessToken, newKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = loginWithKey(user, newKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	otherKey, _, err := encrypt.GenerateKeyPair(user.Password + randomString(8))
+	if err != nil {
+		t.Fatalf("Error generating key pair; %v\n", err)
+	}
+
+	resp, err = enrollPublicKey(tokens.AccessToken, newKey, otherKey, "Another phone")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: The only trusted key cannot be revoked
+	resp, err = revokePublicKey(tokens.AccessToken, trustedKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestDeviceRecovery(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	oldKey, err := getPrivateKey(user.Email)
+	if err != nil {
+		t.Fatalf("Error loading private key; %v\n", err)
+	}
+
+	// Enrolls the old key as the user's only trusted device
+	tokens, err := loginSession(user)
+	if err != nil {
+		t.Fatalf("Error logging in; %v\n", err)
+	}
+
+	// A standing order pre-authorised with the old key
+	err = setTransactionPin(user, testPin)
+	if err != nil {
+		t.Fatalf("Error setting transaction PIN; %v\n", err)
+	}
+
+	wallet, err := createWallet(user)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	resp, err = createStandingOrder(wallet.WalletAddress, leesWallet.WalletAddress, user, 1, "daily", "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var order database.StandingOrder
+
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	newKey, _, err := encrypt.GenerateKeyPair(user.Password + randomString(8))
+	if err != nil {
+		t.Fatalf("Error generating key pair; %v\n", err)
+	}
+
+	newPubKey, _, err := encodePublicKey(newKey)
+	if err != nil {
+		t.Fatalf("Error encoding public key; %v\n", err)
+	}
+
+	// The recovery token is created directly as emails cannot be read in tests
+	token, err := database.CreateVerificationToken(database.DEVICE_RECOVERY, user.Email, handlers.DEVICE_RECOVERY_TTL)
+	if err != nil {
+		t.Fatalf("Error creating device recovery token; %v\n", err)
+	}
+
+	recoverDevice := func(password string) *http.Response {
+		body, _ := json.Marshal(&handlers.RecoverDeviceRequest{
+			Email:      user.Email,
+			Token:      token.Token,
+			Password:   password,
+			PublicKey:  newPubKey,
+			DeviceName: "Replacement phone",
+		})
+
+		resp, err := http.Post(testServer.URL+"/auth/recover-device", jsonContentType, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+		return resp
+	}
+
+	// Test: The emailed token alone is not enough
+	resp = recoverDevice(user.Password + "wrong")
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	resp = recoverDevice(user.Password)
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: The token cannot be used twice
+	resp = recoverDevice(user.Password)
+	expectStatus(t, resp, http.StatusNotFound)
+	resp.Body.Close()
+
+	// Test: The new key logs in and the lost one no longer can
+	resp, err = loginWithKey(user, newKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = loginWithKey(user, oldKey)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Sessions from the lost device are logged out
+	resp, err = requestWithToken(http.MethodGet, "/public-keys", tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Standing orders signed with the lost key stop running
+	cancelled, err := database.GetStandingOrder(order.OrderCode)
+	if err != nil {
+		t.Fatalf("Error fetching standing order; %v\n", err)
+	}
+
+	if cancelled.Status != "cancelled" {
+		t.Fatalf("Expected standing order to be cancelled but got '%v'\n", cancelled.Status)
+	}
+}
@@ -0,0 +1,293 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+// Requests amount from sender wallet into receiver wallet as loginUser
+func requestFunds(
+	sender string,
+	receiver string,
+	loginUser User,
+	amount float64,
+) (*http.Response, error) {
+	return requestFundsExpiringIn(sender, receiver, loginUser, amount, 0)
+}
+
+// Same as requestFunds but with an expiry in seconds.
+// Zero uses the server's default expiry
+func requestFundsExpiringIn(
+	sender string,
+	receiver string,
+	loginUser User,
+	amount float64,
+	expiresIn int,
+) (*http.Response, error) {
+	requireLogin(loginUser)
+
+	req := handlers.RequestFundsRequest{
+		TransactionRequest: handlers.TransactionRequest{
+			Sender:    sender,
+			Receiver:  receiver,
+			Amount:    amount,
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+		},
+		ExpiresIn: expiresIn,
+	}
+
+	signature, pubKeyHash, err := signPayload(loginUser.Email, req.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("Error signing data; %v", err)
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash)
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(testServer.URL+"/request-funds", jsonContentType, bytes.NewBuffer(body))
+}
+
+func acceptRequestFunds(loginUser User, requestFunds database.RequestFundsResult) (*http.Response, error) {
+	requireLogin(loginUser)
+
+	fee, err := getTransactionFee(requestFunds.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transaction fees; %v", err)
+	}
+
+	payment := handlers.TransactionRequest{
+		Sender:    requestFunds.Sender,
+		Receiver:  requestFunds.Receiver,
+		Amount:    requestFunds.Amount,
+		Fee:       fee,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	signature, pubKeyHash, err := signPayload(loginUser.Email, payment.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("Error signing data; %v", err)
+	}
+
+	req := handlers.AcceptRequestFundsRequest{
+		Fee:           payment.Fee,
+		Timestamp:     payment.Timestamp,
+		Signature:     base64.StdEncoding.EncodeToString(signature),
+		PublicKeyHash: base64.StdEncoding.EncodeToString(pubKeyHash),
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return postWithPin(
+		testServer.URL+"/request-funds/"+requestFunds.TransactionCode+"/accept",
+		body,
+		testPin,
+	)
+}
+
+func getIncomingRequestFunds(user User) ([]database.RequestFundsResult, error) {
+	requireLogin(user)
+
+	resp, err := http.Get(testServer.URL + "/request-funds/incoming")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var requests []database.RequestFundsResult
+	err = json.NewDecoder(resp.Body).Decode(&requests)
+	return requests, err
+}
+
+func TestAcceptRequestFunds(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Lee requests funds from tommy
+	resp, err := requestFunds(tommysWallet.WalletAddress, leesWallet.WalletAddress, lee, 5)
+	if err != nil {
+		t.Fatalf("Error requesting funds; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var requestFunds database.RequestFundsResult
+	err = json.Unmarshal(body, &requestFunds)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if requestFunds.Status != "pending" {
+		t.Fatalf("Expected 'pending' request status but got '%v'\n", requestFunds.Status)
+	}
+
+	// Tommy should see the request in his incoming requests
+	incoming, err := getIncomingRequestFunds(tommy)
+	if err != nil {
+		t.Fatalf("Error fetching incoming requests; %v\n", err)
+	}
+
+	found := slices.ContainsFunc(incoming, func(rf database.RequestFundsResult) bool {
+		return rf.TransactionCode == requestFunds.TransactionCode
+	})
+	if !found {
+		t.Fatalf("Expected request '%v' in tommy's incoming requests\n", requestFunds.TransactionCode)
+	}
+
+	// Test: Lee cannot pay his own request from tommy's wallet
+	resp, err = acceptRequestFunds(lee, requestFunds)
+	if err != nil {
+		t.Fatalf("Error accepting request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Tommy accepts the request
+	resp, err = acceptRequestFunds(tommy, requestFunds)
+	if err != nil {
+		t.Fatalf("Error accepting request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Paying the same request twice should fail
+	resp, err = acceptRequestFunds(tommy, requestFunds)
+	if err != nil {
+		t.Fatalf("Error accepting request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestDeclineRequestFunds(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	resp, err := requestFunds(tommysWallet.WalletAddress, leesWallet.WalletAddress, lee, 5)
+	if err != nil {
+		t.Fatalf("Error requesting funds; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var requestFunds database.RequestFundsResult
+	err = json.Unmarshal(body, &requestFunds)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	// Tommy declines the request
+	requireLogin(tommy)
+
+	resp, err = http.Post(
+		testServer.URL+"/request-funds/"+requestFunds.TransactionCode+"/decline",
+		jsonContentType,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: A declined request can no longer be paid
+	resp, err = acceptRequestFunds(tommy, requestFunds)
+	if err != nil {
+		t.Fatalf("Error accepting request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+
+	// Test: Nor cancelled by the requester
+	requireLogin(lee)
+
+	resp, err = http.Post(
+		testServer.URL+"/request-funds/"+requestFunds.TransactionCode+"/cancel",
+		jsonContentType,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestRequestFundsExpiry(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: Expiry cannot exceed the maximum
+	tooLong := int((handlers.MAX_REQUEST_FUNDS_EXPIRY + time.Hour).Seconds())
+
+	resp, err := requestFundsExpiringIn(tommysWallet.WalletAddress, leesWallet.WalletAddress, lee, 5, tooLong)
+	if err != nil {
+		t.Fatalf("Error requesting funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	// Test: Request expires when asked to
+	resp, err = requestFundsExpiringIn(tommysWallet.WalletAddress, leesWallet.WalletAddress, lee, 5, 3600)
+	if err != nil {
+		t.Fatalf("Error requesting funds; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var requestFunds database.RequestFundsResult
+	err = json.Unmarshal(body, &requestFunds)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	expiresIn := time.Until(requestFunds.ExpiresAt)
+	if expiresIn < 59*time.Minute || expiresIn > time.Hour {
+		t.Fatalf("Expected request to expire in an hour but got %v\n", expiresIn)
+	}
+}
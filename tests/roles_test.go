@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func changeRole(action string, email string, role string) (*http.Response, error) {
+	req := handlers.RoleChangeRequest{
+		Email:  email,
+		Role:   role,
+		Reason: "Testing role changes",
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(
+		testServer.URL+"/admin/roles/"+action,
+		jsonContentType,
+		bytes.NewBuffer(body),
+	)
+}
+
+func TestRolePermissions(t *testing.T) {
+	// Test: Users without the roles:read permission are denied
+	requireLogin(lee)
+
+	resp, err := http.Get(testServer.URL + "/admin/roles")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Note: Make sure to setup tommy as an admin in the
+	// database for these requests to work
+	requireLogin(tommy)
+
+	// Test: Admins cannot change their own role
+	resp, err = changeRole("revoke", tommy.Email, "admin")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	resp, err = changeRole("grant", lee.Email, "admin")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Role is re-read from the database, so lee's existing
+	// login picks up the new role
+	requireLogin(lee)
+
+	resp, err = http.Get(testServer.URL + "/admin/roles")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	requireLogin(tommy)
+
+	resp, err = changeRole("revoke", lee.Email, "admin")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Both changes are audited
+	resp, err = http.Get(testServer.URL + "/admin/role-changes")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var changes []database.RoleChange
+
+	err = json.Unmarshal(body, &changes)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if len(changes) < 2 {
+		t.Fatalf("Expected at least 2 role changes but got %v\n", len(changes))
+	}
+
+	latest := changes[0]
+	if latest.Email != lee.Email || latest.FromRole != "admin" || latest.ToRole != "user" {
+		t.Fatalf("Expected revoke of lee's admin role but got %+v\n", latest)
+	}
+
+	requireLogin(lee)
+
+	resp, err = http.Get(testServer.URL + "/admin/roles")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+}
@@ -0,0 +1,259 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+// Client without a cookie jar, so requests are only
+// authenticated by the tokens passed to them
+var tokenClient = &http.Client{}
+
+// Logs in without touching the shared cookie jar.
+// Returns the issued tokens
+func loginSession(user User) (*handlers.TokenResponse, error) {
+	privKey, err := getPrivateKey(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyBytes, err := encrypt.PemEncodePublicKey(&privKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&handlers.LoginRequest{
+		Email:     user.Email,
+		Password:  user.Password,
+		PublicKey: base64.StdEncoding.EncodeToString(pubKeyBytes),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := tokenClient.Post(testServer.URL+"/auth/login", jsonContentType, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected status code %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	var tokens handlers.TokenResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&tokens)
+	return &tokens, err
+}
+
+func refreshSession(refreshToken string) (*http.Response, error) {
+	body, err := json.Marshal(&handlers.RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenClient.Post(testServer.URL+"/auth/refresh", jsonContentType, bytes.NewBuffer(body))
+}
+
+// Makes a request authenticated by an access token
+func requestWithToken(method string, path string, accessToken string) (*http.Response, error) {
+	req, err := http.NewRequest(method, testServer.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("AuthToken", fmt.Sprintf("Bearer %v", accessToken))
+
+	return tokenClient.Do(req)
+}
+
+func TestSessions(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	first, err := loginSession(user)
+	if err != nil {
+		t.Fatalf("Error logging in; %v\n", err)
+	}
+
+	second, err := loginSession(user)
+	if err != nil {
+		t.Fatalf("Error logging in; %v\n", err)
+	}
+
+	// Test: Each login is listed as a separate session
+	resp, err = requestWithToken(http.MethodGet, "/sessions", first.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var sessions []handlers.SessionResponse
+
+	err = json.Unmarshal(body, &sessions)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions but got %v\n", len(sessions))
+	}
+
+	for _, session := range sessions {
+		isCurrent := session.SessionId == first.SessionId
+		if session.Current != isCurrent || session.PublicKeyHash == "" {
+			t.Fatalf("Unexpected session details %+v\n", session)
+		}
+	}
+
+	// Test: Refresh tokens are rotated
+	resp, err = refreshSession(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var refreshed handlers.TokenResponse
+
+	err = json.Unmarshal(body, &refreshed)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if refreshed.SessionId != first.SessionId || refreshed.RefreshToken == first.RefreshToken {
+		t.Fatalf("Expected new refresh token for the same session but got %+v\n", refreshed)
+	}
+
+	// Test: Reusing a refresh token revokes the session
+	resp, err = refreshSession(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	resp, err = requestWithToken(http.MethodGet, "/verify-login", refreshed.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Other sessions are unaffected
+	resp, err = requestWithToken(http.MethodGet, "/verify-login", second.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Logged out sessions can neither be used nor refreshed
+	resp, err = requestWithToken(http.MethodPost, "/auth/logout", second.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = requestWithToken(http.MethodGet, "/verify-login", second.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	resp, err = refreshSession(second.RefreshToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+}
+
+func TestLogoutAll(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	tokens := []*handlers.TokenResponse{}
+
+	for range 3 {
+		token, err := loginSession(user)
+		if err != nil {
+			t.Fatalf("Error logging in; %v\n", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	resp, err = requestWithToken(http.MethodPost, "/auth/logout-all", tokens[0].AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Every session is revoked
+	for _, token := range tokens {
+		resp, err = requestWithToken(http.MethodGet, "/verify-login", token.AccessToken)
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+
+		expectStatus(t, resp, http.StatusUnauthorized)
+		resp.Body.Close()
+	}
+
+	fresh, err := loginSession(user)
+	if err != nil {
+		t.Fatalf("Error logging in; %v\n", err)
+	}
+
+	resp, err = requestWithToken(http.MethodGet, "/sessions", fresh.AccessToken)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var sessions []database.Session
+
+	err = json.Unmarshal(body, &sessions)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if len(sessions) != 1 {
+		t.Fatalf("Expected only the new session but got %v sessions\n", len(sessions))
+	}
+}
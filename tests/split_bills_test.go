@@ -28,7 +28,7 @@ func waitForSplitBillNotification(
 	user User,
 	notificationsChan chan<- notificationResult,
 ) {
-	notifications, err := waitForNotifications[handlers.SplitBillNotification](ctx, user)
+	notifications, err := waitForNotifications[handlers.SplitBillNotification](ctx, user, handlers.SPLIT_BILL_REQUESTED)
 	if err != nil {
 		notificationsChan <- notificationResult{
 			receiver: user.Username,
@@ -130,7 +130,7 @@ func TestCreateSplitBill(t *testing.T) {
 		t.Fatalf("Error creating wallet; %v\n", err)
 	}
 
-	halfBakedAmount := rand.Float64() * handlers.INITIAL_DEPOSIT
+	halfBakedAmount := rand.Float64() * TEST_DEPOSIT
 	resp, err := createSplitBill(
 		tommy,
 		"Broken bills",
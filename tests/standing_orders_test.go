@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+	"github.com/caleb-mwasikira/tap_gopay_backend/utils"
+)
+
+func createStandingOrder(
+	sender string,
+	receiver string,
+	loginUser User,
+	amount float64,
+	frequency string,
+	cronExpression string,
+) (*http.Response, error) {
+	requireLogin(loginUser)
+
+	req := handlers.StandingOrderRequest{
+		Sender:         sender,
+		Receiver:       receiver,
+		Amount:         amount,
+		Frequency:      frequency,
+		CronExpression: cronExpression,
+		StartsAt:       time.Now().Add(1 * time.Hour).UTC().Truncate(time.Second),
+		Timestamp:      time.Now().UTC().Format(time.RFC3339),
+	}
+
+	signature, pubKeyHash, err := signPayload(loginUser.Email, req.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("Error signing data; %v", err)
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash)
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return postWithPin(testServer.URL+"/standing-orders", body, testPin)
+}
+
+func TestCronExpression(t *testing.T) {
+	cron, err := utils.ParseCronExpression("0 9 1 * *")
+	if err != nil {
+		t.Fatalf("Error parsing cron expression; %v\n", err)
+	}
+
+	from := time.Date(2025, time.January, 15, 12, 0, 0, 0, time.UTC)
+	expected := time.Date(2025, time.February, 1, 9, 0, 0, 0, time.UTC)
+
+	if next := cron.Next(from); !next.Equal(expected) {
+		t.Fatalf("Expected next run at %v but got %v\n", expected, next)
+	}
+
+	// Test: Invalid expressions are rejected
+	for _, expr := range []string{"* * *", "60 * * * *", "*/0 * * * *", "0 9 1-x * *"} {
+		if _, err := utils.ParseCronExpression(expr); err == nil {
+			t.Fatalf("Expected error parsing cron expression '%v'\n", expr)
+		}
+	}
+}
+
+func TestStandingOrder(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: Invalid cron expressions are rejected
+	resp, err := createStandingOrder(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 10, "cron", "0 25 * * *")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	resp, err = createStandingOrder(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 10, "weekly", "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var order database.StandingOrder
+
+	err = json.Unmarshal(body, &order)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if order.Status != "active" {
+		t.Fatalf("Expected standing order status 'active' but got '%v'\n", order.Status)
+	}
+
+	orderUrl := testServer.URL + "/standing-orders/" + order.OrderCode
+
+	// Test: Standing orders are private to the sender's wallet owners
+	requireLogin(lee)
+	resp, err = http.Get(orderUrl)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusNotFound)
+	resp.Body.Close()
+
+	requireLogin(tommy)
+
+	// Test: Pause, resume and cancel a standing order
+	for _, action := range []string{"pause", "resume", "cancel"} {
+		resp, err = http.Post(orderUrl+"/"+action, jsonContentType, nil)
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+
+		expectStatus(t, resp, http.StatusOK)
+		resp.Body.Close()
+	}
+
+	// Test: Cancelled standing orders cannot be cancelled again
+	resp, err = http.Post(orderUrl+"/cancel", jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
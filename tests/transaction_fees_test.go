@@ -110,3 +110,86 @@ func TestGetAllTransactionFees(t *testing.T) {
 		t.Error("Expected a list of transaction fees from GetAllTransactionFees")
 	}
 }
+
+// Fetches today's fee revenue across all fee tiers as an admin
+func getTodaysFeeRevenue() (float64, error) {
+	requireLogin(tommy)
+
+	resp, err := http.Get(testServer.URL + "/fee-revenue")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("expected status %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	var revenue []database.FeeRevenue
+	err = json.NewDecoder(resp.Body).Decode(&revenue)
+	if err != nil {
+		return 0, err
+	}
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	total := 0.0
+
+	for _, r := range revenue {
+		if r.Day == today {
+			total += r.TotalFees
+		}
+	}
+	return total, nil
+}
+
+func TestFeeRevenue(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	amount := 200.0
+	fee, err := getTransactionFee(amount)
+	if err != nil {
+		t.Fatalf("Error fetching transaction fees; %v\n", err)
+	}
+
+	revenueBefore, err := getTodaysFeeRevenue()
+	if err != nil {
+		t.Fatalf("Error fetching fee revenue; %v\n", err)
+	}
+
+	resp, err := sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, amount)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Fee charged should be credited into the fee revenue wallet
+	revenueAfter, err := getTodaysFeeRevenue()
+	if err != nil {
+		t.Fatalf("Error fetching fee revenue; %v\n", err)
+	}
+
+	if revenueAfter-revenueBefore != fee {
+		t.Fatalf("Expected fee revenue to grow by %v but got %v\n", fee, revenueAfter-revenueBefore)
+	}
+
+	// Test: Non-admins cannot view fee revenue
+	requireLogin(lee)
+
+	resp, err = http.Get(testServer.URL + "/fee-revenue")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+}
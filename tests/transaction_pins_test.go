@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+// Transaction PIN set for the test accounts in TestMain
+const testPin string = "1234"
+
+func postWithPin(url string, body []byte, pin string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", jsonContentType)
+	if pin != "" {
+		req.Header.Set(handlers.TRANSACTION_PIN_HEADER, pin)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func postPinRequest(path string, req any) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(testServer.URL+path, jsonContentType, bytes.NewBuffer(body))
+}
+
+func setTransactionPin(user User, pin string) error {
+	requireLogin(user)
+
+	resp, err := postPinRequest("/pin", &handlers.SetPinRequest{
+		Pin:      pin,
+		Password: user.Password,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status code %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+	return nil
+}
+
+func TestTransactionPin(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	wallet, err := createWallet(user)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	sendWithPin := func(pin string) *http.Response {
+		requireLogin(user)
+
+		body, err := newSendMoneyRequest(wallet.WalletAddress, leesWallet.WalletAddress, user, 1)
+		if err != nil {
+			t.Fatalf("Error creating send money request; %v\n", err)
+		}
+
+		resp, err := postWithPin(testServer.URL+"/send-money", body, pin)
+		if err != nil {
+			t.Fatalf("Error transferring funds; %v\n", err)
+		}
+		return resp
+	}
+
+	// Test: Money cannot be moved before a PIN is set
+	resp = sendWithPin(testPin)
+	body := expectStatus(t, resp, http.StatusForbidden)
+	resp.Body.Close()
+	expectErrorCode(t, body, handlers.ERR_PIN_NOT_SET)
+
+	// Test: Setting a PIN requires the user's password
+	resp, err = postPinRequest("/pin", &handlers.SetPinRequest{Pin: testPin, Password: "wrong password"})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	// Test: PINs must be ASCII digits; "١٢" is 4 bytes of Arabic-Indic digits
+	resp, err = postPinRequest("/pin", &handlers.SetPinRequest{Pin: "١٢", Password: user.Password})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+
+	err = setTransactionPin(user, testPin)
+	if err != nil {
+		t.Fatalf("Error setting transaction PIN; %v\n", err)
+	}
+
+	// Test: Existing PINs cannot be overwritten
+	resp, err = postPinRequest("/pin", &handlers.SetPinRequest{Pin: "5678", Password: user.Password})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+
+	// Test: Transfers without the PIN are refused
+	resp = sendWithPin("")
+	body = expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+	expectErrorCode(t, body, handlers.ERR_PIN_REQUIRED)
+
+	// Test: Wrong PINs back off after the free attempts,
+	// even if the right PIN is entered next
+	for range handlers.TRANSACTION_PIN_POLICY.FreeAttempts + 1 {
+		resp = sendWithPin("0000")
+		body = expectStatus(t, resp, http.StatusUnauthorized)
+		resp.Body.Close()
+		expectErrorCode(t, body, handlers.ERR_INVALID_PIN)
+	}
+
+	resp = sendWithPin(testPin)
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	resp.Body.Close()
+
+	// Test: Resetting the PIN lifts the backoff. The reset code
+	// is created directly as emails cannot be read in tests
+	token, err := database.CreateVerificationToken(database.PIN_RESET, user.Email, handlers.PIN_RESET_TTL)
+	if err != nil {
+		t.Fatalf("Error creating PIN reset token; %v\n", err)
+	}
+
+	resp, err = postPinRequest("/pin/reset", &handlers.ResetPinRequest{
+		Token:    token.Token,
+		Password: user.Password,
+		Pin:      "5678",
+	})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: The old PIN no longer works after a reset
+	resp, err = postPinRequest("/pin/change", &handlers.ChangePinRequest{CurrentPin: testPin, NewPin: "2468"})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: PIN can be changed with the current PIN
+	resp, err = postPinRequest("/pin/change", &handlers.ChangePinRequest{CurrentPin: "5678", NewPin: "2468"})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: PINs must be 4-6 digits
+	resp, err = postPinRequest("/pin/change", &handlers.ChangePinRequest{CurrentPin: "2468", NewPin: "12ab"})
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+}
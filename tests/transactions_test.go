@@ -1,7 +1,6 @@
 package tests
 
 import (
-	"bytes"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
@@ -10,7 +9,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -46,14 +47,13 @@ func signPayload(email string, data []byte) ([]byte, []byte, error) {
 	return signature, pubKeyHash[:], nil
 }
 
-func sendMoney(
+// Builds a signed send money request body
+func newSendMoneyRequest(
 	sender string,
 	receiver string,
 	loginUser User,
 	amount float64,
-) (*http.Response, error) {
-	requireLogin(loginUser)
-
+) ([]byte, error) {
 	fee, err := getTransactionFee(amount)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching transaction fees; %v", err)
@@ -77,13 +77,24 @@ func sendMoney(
 	req.Signature = base64.StdEncoding.EncodeToString(signature)
 	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash)
 
+	return json.Marshal(&req)
+}
+
+func sendMoney(
+	sender string,
+	receiver string,
+	loginUser User,
+	amount float64,
+) (*http.Response, error) {
+	requireLogin(loginUser)
+
 	// Send sends fund request to server
-	body, err := json.Marshal(&req)
+	body, err := newSendMoneyRequest(sender, receiver, loginUser, amount)
 	if err != nil {
 		return nil, err
 	}
 
-	return http.Post(testServer.URL+"/send-money", jsonContentType, bytes.NewBuffer(body))
+	return postWithPin(testServer.URL+"/send-money", body, testPin)
 }
 
 func TestSendMoney(t *testing.T) {
@@ -112,6 +123,63 @@ func TestSendMoney(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestConcurrentSendMoney(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: Concurrent transfers cannot overdraw a wallet.
+	// Each transfer spends over half the wallet's balance, so
+	// only one of them can go through. Amounts differ so that
+	// the transfers are not rejected as replays
+	const numTransfers = 5
+
+	wg := sync.WaitGroup{}
+	statusCodes := make(chan int, numTransfers)
+
+	for i := range numTransfers {
+		wg.Add(1)
+		go func(amount float64) {
+			defer wg.Done()
+
+			resp, err := sendMoney(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, amount)
+			if err != nil {
+				t.Errorf("Error transferring funds; %v\n", err)
+				return
+			}
+			resp.Body.Close()
+			statusCodes <- resp.StatusCode
+		}(TEST_DEPOSIT*0.6 + float64(i))
+	}
+
+	wg.Wait()
+	close(statusCodes)
+
+	succeeded := 0
+	for statusCode := range statusCodes {
+		if statusCode == http.StatusOK {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("Expected 1 of %v concurrent transfers to succeed but %v did\n", numTransfers, succeeded)
+	}
+
+	wallet, err := getWallet(tommy, tommysWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+	if wallet.Balance < 0 {
+		t.Fatalf("Expected wallet not to be overdrawn but balance is KSH %.2f\n", wallet.Balance)
+	}
+}
+
 func TestSendMoneyViaPhoneNo(t *testing.T) {
 	tommysWallet, err := createWallet(tommy)
 	if err != nil {
@@ -164,6 +232,16 @@ func TestGetRecentTransactions(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error fetching wallet transactions; %v\n", err)
 	}
+
+	// Test: Users cannot read the history of wallets they do not own
+	requireLogin(lee)
+
+	resp, err := http.Get(testServer.URL + fmt.Sprintf("/recent-transactions/%v", tommysWallet.WalletAddress))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
 }
 
 func TestGetTransaction(t *testing.T) {
@@ -245,7 +323,7 @@ func TestSendingInvalidAmount(t *testing.T) {
 		tommysWallet.WalletAddress,
 		leesWallet.WalletAddress,
 		tommy,
-		handlers.INITIAL_DEPOSIT+1,
+		TEST_DEPOSIT+1,
 	)
 	if err != nil {
 		t.Fatalf("Error transferring funds; %v\n", err)
@@ -268,3 +346,256 @@ func TestSendingInvalidAmount(t *testing.T) {
 
 	expectStatus(t, resp, http.StatusBadRequest)
 }
+
+func getTransactionHistory(walletAddress string, query string) (*database.TransactionPage, error) {
+	resp, err := http.Get(
+		testServer.URL + fmt.Sprintf("/wallets/%v/transactions?%v", walletAddress, query),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected status %v but got %v", http.StatusOK, resp.Status)
+	}
+
+	var page database.TransactionPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	return &page, err
+}
+
+func TestGetTransactionHistory(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	const numTransactions = 3
+
+	for range numTransactions {
+		resp, err := sendMoney(
+			tommysWallet.WalletAddress,
+			leesWallet.WalletAddress,
+			tommy,
+			1,
+		)
+		if err != nil {
+			t.Fatalf("Error transferring funds; %v\n", err)
+		}
+
+		expectStatus(t, resp, http.StatusOK)
+		resp.Body.Close()
+	}
+
+	// Paginate through tommy's sent transactions
+	requireLogin(tommy)
+
+	page, err := getTransactionHistory(tommysWallet.WalletAddress, "limit=2")
+	if err != nil {
+		t.Fatalf("Error fetching transaction history; %v\n", err)
+	}
+
+	if len(page.Transactions) != 2 || page.NextCursor == "" {
+		t.Fatalf("Expected 2 transactions and a next cursor but got %v transactions\n", len(page.Transactions))
+	}
+
+	for _, transaction := range page.Transactions {
+		if transaction.Status != "confirmed" {
+			t.Errorf("Expected 'confirmed' transaction status but got '%v'\n", transaction.Status)
+		}
+	}
+
+	nextPage, err := getTransactionHistory(
+		tommysWallet.WalletAddress,
+		"limit=2&cursor="+page.NextCursor,
+	)
+	if err != nil {
+		t.Fatalf("Error fetching transaction history; %v\n", err)
+	}
+
+	if len(nextPage.Transactions) != numTransactions-2 || nextPage.NextCursor != "" {
+		t.Fatalf("Expected last page with %v transactions but got %v\n", numTransactions-2, len(nextPage.Transactions))
+	}
+
+	// Received transactions are part of lee's history
+	requireLogin(lee)
+
+	page, err = getTransactionHistory(
+		leesWallet.WalletAddress,
+		"counterparty="+url.QueryEscape(tommysWallet.WalletAddress),
+	)
+	if err != nil {
+		t.Fatalf("Error fetching transaction history; %v\n", err)
+	}
+
+	if len(page.Transactions) != numTransactions {
+		t.Fatalf("Expected %v received transactions but got %v\n", numTransactions, len(page.Transactions))
+	}
+}
+
+func expectErrorCode(t *testing.T, body []byte, code string) {
+	var resp map[string]string
+
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if resp["code"] != code {
+		t.Fatalf("Expected error code '%v' but got '%v'\n", code, resp["code"])
+	}
+}
+
+func TestReplayedTransaction(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(tommy)
+
+	body, err := newSendMoneyRequest(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 1)
+	if err != nil {
+		t.Fatalf("Error creating send money request; %v\n", err)
+	}
+
+	resp, err := postWithPin(testServer.URL+"/send-money", body, testPin)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Resubmitting the same signed payload should fail
+	resp, err = postWithPin(testServer.URL+"/send-money", body, testPin)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	respBody := expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+	expectErrorCode(t, respBody, handlers.ERR_SIGNATURE_REPLAYED)
+
+	// Test: Payloads signed outside the freshness window should fail
+	req := handlers.TransactionRequest{
+		Sender:    tommysWallet.WalletAddress,
+		Receiver:  leesWallet.WalletAddress,
+		Amount:    1,
+		Timestamp: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+	}
+
+	signature, pubKeyHash, err := signPayload(tommy.Email, req.Hash())
+	if err != nil {
+		t.Fatalf("Error signing data; %v\n", err)
+	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash)
+
+	body, err = json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Error marshalling request; %v\n", err)
+	}
+
+	resp, err = postWithPin(testServer.URL+"/send-money", body, testPin)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	respBody = expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
+	expectErrorCode(t, respBody, handlers.ERR_STALE_TIMESTAMP)
+}
+
+func TestSendMoneyInvalidSignature(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(tommy)
+
+	body, err := newSendMoneyRequest(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, 1)
+	if err != nil {
+		t.Fatalf("Error creating send money request; %v\n", err)
+	}
+
+	// Tamper with the signed amount
+	var req handlers.TransactionRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("Error unmarshalling request; %v\n", err)
+	}
+	req.Amount = 2
+
+	body, err = json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Error marshalling request; %v\n", err)
+	}
+
+	resp, err := postWithPin(testServer.URL+"/send-money", body, testPin)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+}
+
+func TestFailedTransactionKeepsSignature(t *testing.T) {
+	tommysWallet, err := createWallet(tommy)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	requireLogin(tommy)
+
+	body, err := newSendMoneyRequest(tommysWallet.WalletAddress, leesWallet.WalletAddress, tommy, TEST_DEPOSIT*1.5)
+	if err != nil {
+		t.Fatalf("Error creating send money request; %v\n", err)
+	}
+
+	resp, err := postWithPin(testServer.URL+"/send-money", body, testPin)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusInternalServerError)
+	resp.Body.Close()
+
+	_, err = depositAndWait(tommy, tommysWallet.WalletAddress, randomPhoneNo(), TEST_DEPOSIT)
+	if err != nil {
+		t.Fatalf("Error depositing funds; %v\n", err)
+	}
+
+	// Test: A signed payload is only used up once the transfer goes through
+	requireLogin(tommy)
+
+	resp, err = postWithPin(testServer.URL+"/send-money", body, testPin)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+}
@@ -0,0 +1,300 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func loginWithTOTP(user User, totpCode string) (*http.Response, error) {
+	privKey, err := getPrivateKey(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKeyBytes, err := encrypt.PemEncodePublicKey(&privKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&handlers.LoginRequest{
+		Email:     user.Email,
+		Password:  user.Password,
+		PublicKey: base64.StdEncoding.EncodeToString(pubKeyBytes),
+		TOTPCode:  totpCode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenClient.Post(testServer.URL+"/auth/login", jsonContentType, bytes.NewBuffer(body))
+}
+
+// Returns the code an authenticator app shows offset periods from now
+func totpCode(t *testing.T, secret string, offset int64) string {
+	code, err := encrypt.TOTPCode(secret, encrypt.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("Error generating TOTP code; %v\n", err)
+	}
+	return code
+}
+
+func addWalletOwner(walletAddress string, email string, totpCode string) (*http.Response, error) {
+	body, err := json.Marshal(&handlers.WalletOwnerRequest{Email: email})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		testServer.URL+"/wallets/"+walletAddress+"/add-owner",
+		bytes.NewBuffer(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", jsonContentType)
+	if totpCode != "" {
+		req.Header.Set(handlers.TOTP_CODE_HEADER, totpCode)
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// Sets up and enables 2FA for the logged in user.
+// Returns the TOTP secret and backup codes
+func enableTwoFactor(t *testing.T, user User) (handlers.TwoFactorSetupResponse, handlers.BackupCodesResponse) {
+	requireLogin(user)
+
+	resp, err := http.Post(testServer.URL+"/2fa/setup", jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var setup handlers.TwoFactorSetupResponse
+
+	err = json.Unmarshal(body, &setup)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	reqBody, _ := json.Marshal(&handlers.TwoFactorCodeRequest{Code: totpCode(t, setup.Secret, 0)})
+
+	resp, err = http.Post(testServer.URL+"/2fa/enable", jsonContentType, bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var backupCodes handlers.BackupCodesResponse
+
+	err = json.Unmarshal(body, &backupCodes)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if len(backupCodes.BackupCodes) == 0 {
+		t.Fatalf("Expected backup codes after enabling 2FA\n")
+	}
+	return setup, backupCodes
+}
+
+func TestTwoFactorAuth(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	requireLogin(user)
+
+	wallet, err := createWallet(user)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: 2FA is enabled with a code from the new secret
+	setup, backupCodes := enableTwoFactor(t, user)
+
+	// Test: Login requires a second factor
+	resp, err = loginWithTOTP(user, "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Codes cannot be reused
+	resp, err = loginWithTOTP(user, totpCode(t, setup.Secret, 0))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Backup codes work once
+	resp, err = loginWithTOTP(user, backupCodes.BackupCodes[0])
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = loginWithTOTP(user, backupCodes.BackupCodes[0])
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// Test: Adding a wallet owner requires a fresh code
+	resp, err = addWalletOwner(wallet.WalletAddress, lee.Email, "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	// The code for the current period was used to enable 2FA,
+	// so use the next one; codes within one period of the
+	// server clock are accepted
+	resp, err = addWalletOwner(wallet.WalletAddress, lee.Email, totpCode(t, setup.Secret, 1))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: 2FA can be disabled with a backup code
+	reqBody, _ := json.Marshal(&handlers.TwoFactorCodeRequest{Code: backupCodes.BackupCodes[1]})
+
+	resp, err = http.Post(testServer.URL+"/2fa/disable", jsonContentType, bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	resp, err = loginWithTOTP(user, "")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+}
+
+func TestSecondFactorThrottling(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	_, backupCodes := enableTwoFactor(t, user)
+
+	disableTwoFactor := func(code string) *http.Response {
+		reqBody, _ := json.Marshal(&handlers.TwoFactorCodeRequest{Code: code})
+
+		resp, err := http.Post(testServer.URL+"/2fa/disable", jsonContentType, bytes.NewBuffer(reqBody))
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+		return resp
+	}
+
+	// Test: Wrong codes from a logged in session back off like
+	// failed logins, so they cannot be brute-forced
+	for range handlers.ACCOUNT_LOGIN_POLICY.FreeAttempts + 1 {
+		resp = disableTwoFactor("000000")
+		body := expectStatus(t, resp, http.StatusUnauthorized)
+		resp.Body.Close()
+		expectErrorCode(t, body, handlers.ERR_INVALID_TOTP)
+	}
+
+	// Test: Even a valid code is refused during backoff
+	resp = disableTwoFactor(backupCodes.BackupCodes[0])
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	resp.Body.Close()
+
+	// Test: Admins can lift the backoff
+	resp, err = adminUnlockAccount(user.Email)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	requireLogin(user)
+
+	resp = disableTwoFactor(backupCodes.BackupCodes[0])
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+}
+
+func TestResetPasswordWithTwoFactor(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	setup, _ := enableTwoFactor(t, user)
+
+	// The reset token is created directly as emails cannot be read in tests
+	token, err := database.CreatePasswordResetToken(user.Email, handlers.PASSWORD_RESET_TTL)
+	if err != nil {
+		t.Fatalf("Error creating password reset token; %v\n", err)
+	}
+
+	resetPassword := func(totpCode string) *http.Response {
+		body, _ := json.Marshal(map[string]string{
+			"email":     user.Email,
+			"token":     token.Token,
+			"password":  user.Password + "New1!",
+			"totp_code": totpCode,
+		})
+
+		resp, err := http.Post(testServer.URL+"/auth/reset-password", jsonContentType, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+		return resp
+	}
+
+	// Test: A wrong 2FA code is refused without using up the token
+	resp = resetPassword("000000")
+	body := expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+	expectErrorCode(t, body, handlers.ERR_INVALID_TOTP)
+
+	// The code for the current period was used to enable 2FA
+	resp = resetPassword(totpCode(t, setup.Secret, 1))
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: The token cannot be used twice
+	resp = resetPassword(totpCode(t, setup.Secret, 1))
+	expectStatus(t, resp, http.StatusNotFound)
+	resp.Body.Close()
+}
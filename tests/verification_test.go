@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay_backend/database"
+	"github.com/caleb-mwasikira/tap_gopay_backend/handlers"
+)
+
+func confirmPhoneVerification(user User, token string) (*http.Response, error) {
+	requireLogin(user)
+
+	body, err := json.Marshal(&handlers.ConfirmVerificationRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+
+	return http.Post(testServer.URL+"/verification/phone/confirm", jsonContentType, bytes.NewBuffer(body))
+}
+
+// Verifies a user's phone number so it can receive money.
+// SMS codes cannot be read in tests, so the token is
+// created directly in the database
+func verifyPhoneNo(user User) error {
+	token, err := database.CreateVerificationToken(
+		database.PHONE_VERIFICATION,
+		user.Email,
+		handlers.PHONE_VERIFICATION_TTL,
+	)
+	if err != nil {
+		return err
+	}
+
+	resp, err := confirmPhoneVerification(user, token.Token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status code %v but got %v", http.StatusOK, resp.StatusCode)
+	}
+	return nil
+}
+
+func getVerificationStatus(t *testing.T, user User) handlers.VerificationStatus {
+	requireLogin(user)
+
+	resp, err := http.Get(testServer.URL + "/verification")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	var status handlers.VerificationStatus
+
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+	return status
+}
+
+func TestPhoneVerification(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	wallet, err := createWallet(user)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	// Test: Unverified phone numbers do not resolve to wallets
+	wallets, err := database.GetWalletsOwnedByPhoneNo(user.PhoneNo, nil)
+	if err != nil {
+		t.Fatalf("Error fetching wallets owned by phone number; %v\n", err)
+	}
+
+	if len(wallets) != 0 {
+		t.Fatalf("Expected no wallets for unverified phone number but got %v\n", len(wallets))
+	}
+
+	status := getVerificationStatus(t, user)
+	if status.EmailVerified || status.PhoneVerified {
+		t.Fatalf("Expected new account to be unverified but got %+v\n", status)
+	}
+
+	err = verifyPhoneNo(user)
+	if err != nil {
+		t.Fatalf("Error verifying phone number; %v\n", err)
+	}
+
+	// Test: Verified phone numbers resolve to their wallets
+	wallets, err = database.GetWalletsOwnedByPhoneNo(user.PhoneNo, nil)
+	if err != nil {
+		t.Fatalf("Error fetching wallets owned by phone number; %v\n", err)
+	}
+
+	if len(wallets) != 1 || wallets[0].WalletAddress != wallet.WalletAddress {
+		t.Fatalf("Expected wallet %v for verified phone number but got %v\n", wallet.WalletAddress, wallets)
+	}
+
+	status = getVerificationStatus(t, user)
+	if !status.PhoneVerified {
+		t.Fatalf("Expected phone number to be verified\n")
+	}
+
+	resp, err = http.Post(testServer.URL+"/verification/phone", jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestVerificationTokenLimits(t *testing.T) {
+	user := NewRandomUser()
+
+	resp, err := createAccount(user)
+	if err != nil {
+		t.Fatalf("Error creating account; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	requireLogin(user)
+
+	resp, err = http.Post(testServer.URL+"/verification/phone", jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	// Test: Codes cannot be resent straight away
+	resp, err = http.Post(testServer.URL+"/verification/phone", jsonContentType, nil)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	resp.Body.Close()
+
+	// Test: Codes are locked after too many wrong attempts.
+	// Codes are numeric, so "x" never matches
+	for range database.MAX_TOKEN_ATTEMPTS {
+		resp, err = confirmPhoneVerification(user, "x")
+		if err != nil {
+			t.Fatalf("Error making request; %v\n", err)
+		}
+		expectStatus(t, resp, http.StatusNotFound)
+		resp.Body.Close()
+	}
+
+	resp, err = confirmPhoneVerification(user, "x")
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	resp.Body.Close()
+
+	status := getVerificationStatus(t, user)
+	if status.PhoneVerified {
+		t.Fatalf("Expected phone number to remain unverified\n")
+	}
+}
+
+func TestUnverifiedPhoneNoClaims(t *testing.T) {
+	owner := NewRandomUser()
+
+	// Someone else registers with the owner's phone number first
+	squatter := NewRandomUser()
+	squatter.PhoneNo = owner.PhoneNo
+
+	for _, user := range []User{squatter, owner} {
+		resp, err := createAccount(user)
+		if err != nil {
+			t.Fatalf("Error creating account; %v\n", err)
+		}
+		expectStatus(t, resp, http.StatusOK)
+		resp.Body.Close()
+	}
+
+	// Test: An unverified claim does not stop the owner verifying their number
+	err := verifyPhoneNo(owner)
+	if err != nil {
+		t.Fatalf("Error verifying phone number; %v\n", err)
+	}
+
+	// Test: The number cannot be verified on a second account
+	token, err := database.CreateVerificationToken(
+		database.PHONE_VERIFICATION,
+		squatter.Email,
+		handlers.PHONE_VERIFICATION_TTL,
+	)
+	if err != nil {
+		t.Fatalf("Error creating verification token; %v\n", err)
+	}
+
+	resp, err := confirmPhoneVerification(squatter, token.Token)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
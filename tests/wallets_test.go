@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	mrand "math/rand/v2"
@@ -14,6 +15,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/caleb-mwasikira/tap_gopay_backend/database"
 	"github.com/caleb-mwasikira/tap_gopay_backend/encrypt"
@@ -46,7 +48,20 @@ func createWallet(user User) (*database.Wallet, error) {
 	var wallet database.Wallet
 
 	err = json.NewDecoder(resp.Body).Decode(&wallet)
-	return &wallet, err
+	if err != nil {
+		return nil, err
+	}
+
+	// Wallets start out empty; top them up through the fake payment rail
+	payment, err := depositAndWait(user, wallet.WalletAddress, randomPhoneNo(), TEST_DEPOSIT)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != "settled" {
+		return nil, fmt.Errorf("expected deposit to settle but got '%v'", payment.Status)
+	}
+
+	return getWallet(user, wallet.WalletAddress)
 }
 
 func freezeWallet(user User, walletAddress string) error {
@@ -328,6 +343,11 @@ func TestGetWalletsOwnedByPhoneNo(t *testing.T) {
 		t.Fatalf("Error creating wallet; %v\n", err)
 	}
 
+	err = verifyPhoneNo(user)
+	if err != nil {
+		t.Fatalf("Error verifying phone number; %v\n", err)
+	}
+
 	// Get wallet tied to user's phone number
 	fetchedWallets, err := database.GetWalletsOwnedByPhoneNo(user.PhoneNo, nil)
 	if err != nil {
@@ -442,8 +462,12 @@ func signTransaction(
 		return nil, err
 	}
 
+	req := handlers.SignTransactionRequest{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+
 	// Sign transaction
-	signature, err := ecdsa.SignASN1(rand.Reader, privKey, transaction.Hash())
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, req.Hash(&transaction))
 	if err != nil {
 		return nil, err
 	}
@@ -455,19 +479,17 @@ func signTransaction(
 	}
 	pubKeyHash := sha256.Sum256(pubKeyBytes)
 
-	req := handlers.SignTransactionRequest{
-		Signature:     base64.StdEncoding.EncodeToString(signature),
-		PublicKeyHash: base64.StdEncoding.EncodeToString(pubKeyHash[:]),
-	}
+	req.Signature = base64.StdEncoding.EncodeToString(signature)
+	req.PublicKeyHash = base64.StdEncoding.EncodeToString(pubKeyHash[:])
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	return http.Post(
+	return postWithPin(
 		serverUrl+"/transactions/"+transaction.TransactionCode+"/sign-transaction",
-		jsonContentType,
-		bytes.NewBuffer(body),
+		body,
+		testPin,
 	)
 }
 
@@ -613,7 +635,7 @@ func TestRemoveWalletOwner(t *testing.T) {
 		t.Fatalf("Error transferring funds; %v\n", err)
 	}
 
-	expectStatus(t, resp, http.StatusInternalServerError)
+	expectStatus(t, resp, http.StatusUnauthorized)
 	resp.Body.Close()
 }
 
@@ -641,6 +663,273 @@ func TestWalletOwnership(t *testing.T) {
 	}
 	defer resp.Body.Close()
 
-	expectStatus(t, resp, http.StatusInternalServerError)
+	expectStatus(t, resp, http.StatusUnauthorized)
+
+}
+
+func getPendingSignatures(user User) ([]database.PendingSignature, error) {
+	requireLogin(user)
+
+	resp, err := http.Get(testServer.URL + "/transactions/pending-signatures")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var pending []database.PendingSignature
+	err = json.NewDecoder(resp.Body).Decode(&pending)
+	return pending, err
+}
+
+func TestRejectTransaction(t *testing.T) {
+	const totalOwners uint = 2
+	const numSignatures uint = 2
+	tommysWallet, err := createMultiSigWallet(
+		testServer.URL,
+		tommy,
+		totalOwners,
+		numSignatures,
+	)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	// Add lee as owner of tommy's wallet
+	rawUrl := testServer.URL + "/wallets/" + tommysWallet.WalletAddress + "/add-owner"
+	req := handlers.WalletOwnerRequest{
+		Email: lee.Email,
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		t.Fatalf("Error marshalling request body; %v\n", err)
+	}
+
+	resp, err := http.Post(rawUrl, jsonContentType, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	resp, err = sendMoney(
+		tommysWallet.WalletAddress,
+		leesWallet.WalletAddress,
+		lee,
+		1,
+	)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusAccepted)
+	resp.Body.Close()
+
+	var transaction database.Transaction
+
+	err = json.Unmarshal(body, &transaction)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	// Test: Transaction should be waiting on tommy's signature
+	pending, err := getPendingSignatures(tommy)
+	if err != nil {
+		t.Fatalf("Error fetching transactions pending signature; %v\n", err)
+	}
+
+	found := slices.ContainsFunc(pending, func(p database.PendingSignature) bool {
+		return p.TransactionCode == transaction.TransactionCode
+	})
+	if !found {
+		t.Fatalf("Expected transaction '%v' in tommy's pending signatures\n", transaction.TransactionCode)
+	}
+
+	// Test: Tommy rejects the transaction. Threshold can no longer be met
+	resp, err = http.Post(
+		testServer.URL+"/transactions/"+transaction.TransactionCode+"/reject-transaction",
+		jsonContentType,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	body = expectStatus(t, resp, http.StatusOK)
+	resp.Body.Close()
+
+	err = json.Unmarshal(body, &transaction)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	if transaction.Status != "rejected" {
+		t.Fatalf("Expected rejected transaction status but got '%v' status", transaction.Status)
+	}
+
+	// Test: Rejected transactions cannot be signed
+	resp, err = signTransaction(testServer.URL, tommy, transaction)
+	if err != nil {
+		t.Fatalf("Error signing transaction; %v", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+}
+
+func TestPendingTransactionReservesFunds(t *testing.T) {
+	const totalOwners uint = 2
+	const numSignatures uint = 2
+	tommysWallet, err := createMultiSigWallet(
+		testServer.URL,
+		tommy,
+		totalOwners,
+		numSignatures,
+	)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	// Test: Approval window outside allowed range is rejected
+	rawUrl := testServer.URL + "/wallets/" + tommysWallet.WalletAddress + "/approval-window"
+	body, err := json.Marshal(&handlers.ApprovalWindowRequest{
+		Minutes: database.MAX_APPROVAL_WINDOW_MINUTES + 1,
+	})
+	if err != nil {
+		t.Fatalf("Error marshalling request body; %v\n", err)
+	}
+
+	resp, err := http.Post(rawUrl, jsonContentType, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp.Body.Close()
 
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	amount := 1.0
+	fee, err := getTransactionFee(amount)
+	if err != nil {
+		t.Fatalf("Error fetching transaction fees; %v\n", err)
+	}
+
+	resp, err = sendMoney(
+		tommysWallet.WalletAddress,
+		leesWallet.WalletAddress,
+		tommy,
+		amount,
+	)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	expectStatus(t, resp, http.StatusAccepted)
+	resp.Body.Close()
+
+	// Test: Pending transaction holds the amount and fee
+	// without touching the wallet's balance
+	wallet, err := getWallet(tommy, tommysWallet.WalletAddress)
+	if err != nil {
+		t.Fatalf("Error fetching wallet; %v\n", err)
+	}
+
+	if wallet.Balance != tommysWallet.Balance {
+		t.Fatalf("Expected balance %v but got %v\n", tommysWallet.Balance, wallet.Balance)
+	}
+	if wallet.ReservedBalance != amount+fee {
+		t.Fatalf("Expected reserved balance %v but got %v\n", amount+fee, wallet.ReservedBalance)
+	}
+
+	expectedAvailable := wallet.Balance - wallet.ReservedBalance
+	if wallet.AvailableBalance != expectedAvailable {
+		t.Fatalf("Expected available balance %v but got %v\n", expectedAvailable, wallet.AvailableBalance)
+	}
+}
+
+func TestDuplicateSignature(t *testing.T) {
+	const totalOwners uint = 2
+	const numSignatures uint = 2
+	tommysWallet, err := createMultiSigWallet(
+		testServer.URL,
+		tommy,
+		totalOwners,
+		numSignatures,
+	)
+	if err != nil {
+		t.Fatalf("Error making request; %v\n", err)
+	}
+
+	leesWallet, err := createWallet(lee)
+	if err != nil {
+		t.Fatalf("Error creating wallet; %v\n", err)
+	}
+
+	resp, err := sendMoney(
+		tommysWallet.WalletAddress,
+		leesWallet.WalletAddress,
+		tommy,
+		1,
+	)
+	if err != nil {
+		t.Fatalf("Error transferring funds; %v\n", err)
+	}
+
+	body := expectStatus(t, resp, http.StatusAccepted)
+	resp.Body.Close()
+
+	var transaction database.Transaction
+
+	err = json.Unmarshal(body, &transaction)
+	if err != nil {
+		t.Fatalf("Error unmarshalling response body; %v\n", err)
+	}
+
+	// Test: Tommy cannot sign his own transaction a second time
+	resp, err = signTransaction(testServer.URL, tommy, transaction)
+	if err != nil {
+		t.Fatalf("Error signing transaction; %v", err)
+	}
+
+	expectStatus(t, resp, http.StatusConflict)
+	resp.Body.Close()
+
+	// Test: A signing that races past the API check is still
+	// reported as a duplicate when the trigger rejects it
+	tommyUser, err := database.GetUser(tommy.Email)
+	if err != nil {
+		t.Fatalf("Error fetching user; %v\n", err)
+	}
+
+	err = database.AddSignature(tommyUser.Id, transaction.TransactionCode, "signature", "public key hash", nil)
+	if !errors.Is(err, database.ErrAlreadySigned) {
+		t.Fatalf("Expected error '%v' but got '%v'\n", database.ErrAlreadySigned, err)
+	}
+
+	// Test: Lee is not an owner of tommy's wallet
+	resp, err = signTransaction(testServer.URL, lee, transaction)
+	if err != nil {
+		t.Fatalf("Error signing transaction; %v", err)
+	}
+
+	expectStatus(t, resp, http.StatusUnauthorized)
+	resp.Body.Close()
+
+	t2, err := database.GetTransaction(transaction.TransactionCode)
+	if err != nil {
+		t.Fatalf("Error fetching transaction; %v\n", err)
+	}
+
+	if t2.Status != "pending" {
+		t.Fatalf("Expected pending transaction status but got '%v' status", t2.Status)
+	}
 }
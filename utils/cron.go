@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Furthest a cron schedule is searched for its next run
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Five field cron expression:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Fields accept *, single values, ranges (1-5), lists (1,15) and
+// steps (*/15, 0-30/10). Day of week runs from 0 (Sunday) to 6;
+// 7 is also accepted as Sunday
+type CronExpression struct {
+	minutes     []bool
+	hours       []bool
+	daysOfMonth []bool
+	months      []bool
+	daysOfWeek  []bool
+
+	// As in standard cron, when both day fields are restricted
+	// a day matches if either field matches
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+func ParseCronExpression(expr string) (*CronExpression, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression; expected %v fields but got %v", len(cronFields), len(fields))
+	}
+
+	values := make([][]bool, len(cronFields))
+
+	for i, field := range cronFields {
+		value := fields[i]
+
+		// Accept 7 as Sunday
+		if field.name == "day of week" {
+			field.max = 7
+		}
+
+		allowed, err := parseCronField(value, field)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = allowed
+	}
+
+	daysOfWeek := values[4]
+	if daysOfWeek[7] {
+		daysOfWeek[0] = true
+	}
+
+	return &CronExpression{
+		minutes:       values[0],
+		hours:         values[1],
+		daysOfMonth:   values[2],
+		months:        values[3],
+		daysOfWeek:    daysOfWeek[:7],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// Returns values allowed by a single cron field, indexed by value
+func parseCronField(value string, field cronField) ([]bool, error) {
+	allowed := make([]bool, field.max+1)
+
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step '%v' in cron %v field", stepPart, field.name)
+			}
+		}
+
+		start, end := field.min, field.max
+
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+
+			start, err = strconv.Atoi(from)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%v' in cron %v field", from, field.name)
+			}
+
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(to)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value '%v' in cron %v field", to, field.name)
+				}
+			} else if hasStep {
+				// 5/15 is short for 5-max/15
+				end = field.max
+			}
+		}
+
+		if start < field.min || end > field.max || start > end {
+			return nil, fmt.Errorf("cron %v field out of range %v-%v", field.name, field.min, field.max)
+		}
+
+		for v := start; v <= end; v += step {
+			allowed[v] = true
+		}
+	}
+	return allowed, nil
+}
+
+func (c *CronExpression) matchesDay(t time.Time) bool {
+	dayOfMonth := c.daysOfMonth[t.Day()]
+	dayOfWeek := c.daysOfWeek[int(t.Weekday())]
+
+	switch {
+	case c.anyDayOfMonth && c.anyDayOfWeek:
+		return true
+	case c.anyDayOfMonth:
+		return dayOfWeek
+	case c.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// Returns the first time after t that matches the expression,
+// in t's location. Returns the zero time if the expression never
+// matches, such as on February 30th
+func (c *CronExpression) Next(t time.Time) time.Time {
+	limit := t.Add(maxCronSearch)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}